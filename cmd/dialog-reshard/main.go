@@ -0,0 +1,141 @@
+// Command dialog-reshard moves the dialog messages to a new set of shards
+// while the app replicas keep serving them. Shard 0 is the main database,
+// DIALOG_SHARD_DSNS are shards 1 and on.
+//
+//	dialog-reshard status
+//	dialog-reshard start -shards 0,1,2 [-current 0,1]
+//	dialog-reshard resume
+//
+// The first resharding needs -current, the shards the replicas route over
+// before any ring is recorded.
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	_ "github.com/go-sql-driver/mysql"
+
+	"github.com/niklod/highload-social-network/config"
+	"github.com/niklod/highload-social-network/internal/dialog"
+	"github.com/niklod/highload-social-network/internal/dialog/shard"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	cmd := flag.NewFlagSet(os.Args[1], flag.ExitOnError)
+	target := cmd.String("shards", "", "comma separated shard IDs of the target ring")
+	current := cmd.String("current", "", "comma separated shard IDs the replicas route over, for the first resharding")
+	batch := cmd.Int("batch", 500, "messages copied at once")
+	if err := cmd.Parse(os.Args[2:]); err != nil {
+		log.Fatal(err)
+	}
+
+	cfg, err := config.New()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	db, err := sql.Open("mysql", cfg.DB.ConnectionString())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	shards := map[int]*sql.DB{0: db}
+	for i, dsn := range cfg.Dialog.ShardDSNs {
+		shardDB, err := sql.Open("mysql", dsn)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer shardDB.Close()
+
+		shards[i+1] = shardDB
+	}
+
+	// The interrupted resharding is continued by resume
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigCh
+		cancel()
+	}()
+
+	store := dialog.NewShardStore(db)
+	resharder := shard.NewResharder(store, shards, cfg.Dialog.VirtualNodes, dialog.NewMessageCopier(*batch), cfg.Dialog.ReplicaTimeout)
+
+	switch os.Args[1] {
+	case "status":
+		err = status(ctx, store, cfg.Dialog)
+	case "start":
+		var targetIDs, currentIDs []int
+		if targetIDs, err = shard.ParseShards(*target); err != nil || len(targetIDs) == 0 {
+			log.Fatalf("-shards: the target shards are needed: %v", err)
+		}
+		if *current != "" {
+			if currentIDs, err = shard.ParseShards(*current); err != nil {
+				log.Fatalf("-current: %v", err)
+			}
+		}
+		err = resharder.Start(ctx, currentIDs, targetIDs)
+	case "resume":
+		err = resharder.Resume(ctx)
+	default:
+		usage()
+	}
+
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func usage() {
+	log.Fatal("usage: dialog-reshard status|start|resume [-shards ids] [-current ids] [-batch size]")
+}
+
+func status(ctx context.Context, store shard.Store, cfg *config.DialogConfig) error {
+	s, err := store.Load(ctx)
+	if err != nil {
+		return err
+	}
+
+	ring := "all the configured shards, not recorded yet"
+	if s.Ring != nil {
+		ring = shard.FormatShards(s.Ring)
+	}
+	fmt.Printf("version: %d\nring: %s\n", s.Version, ring)
+
+	if s.Target == nil {
+		fmt.Println("no resharding in progress")
+	} else {
+		switched := 0
+		for _, rs := range s.Ranges {
+			if rs.Switched {
+				switched++
+			}
+		}
+		fmt.Printf("target: %s\nranges switched: %d of %d\n", shard.FormatShards(s.Target), switched, len(s.Ranges))
+	}
+
+	lagging, err := store.Lagging(ctx, s.Version, cfg.ReplicaTimeout)
+	if err != nil {
+		return err
+	}
+	for _, r := range lagging {
+		fmt.Printf("replica %s routes by an older version\n", r)
+	}
+
+	return nil
+}
@@ -0,0 +1,174 @@
+// Command feed-dlq inspects and replays the feed dead letter queue.
+//
+//	feed-dlq list [-n 20]
+//	feed-dlq replay [-n 0]
+//	feed-dlq purge
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/streadway/amqp"
+
+	"github.com/niklod/highload-social-network/config"
+	"github.com/niklod/highload-social-network/internal/queue/feed"
+)
+
+const maxBodyLen = 200
+
+func main() {
+	log.SetFlags(0)
+
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	cmd := flag.NewFlagSet(os.Args[1], flag.ExitOnError)
+	n := cmd.Int("n", 0, "number of messages, 0 is all")
+	if err := cmd.Parse(os.Args[2:]); err != nil {
+		log.Fatal(err)
+	}
+
+	cfg, err := config.New()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	conn, err := amqp.Dial(cfg.RabbitMQ.ConnectionString())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer conn.Close()
+
+	ch, err := feed.NewQueueChannel(conn, cfg.RabbitMQ)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer ch.Close()
+
+	queue := feed.DeadLetterQueueName(cfg.RabbitMQ)
+
+	switch os.Args[1] {
+	case "list":
+		if *n == 0 {
+			*n = 20
+		}
+		err = list(ch, queue, *n)
+	case "replay":
+		err = replay(ch, cfg.RabbitMQ, queue, *n)
+	case "purge":
+		var count int
+		count, err = ch.QueuePurge(queue, false)
+		if err == nil {
+			fmt.Printf("%d messages dropped\n", count)
+		}
+	default:
+		usage()
+	}
+
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func usage() {
+	log.Fatal("usage: feed-dlq list|replay|purge [-n count]")
+}
+
+// list prints up to n messages and returns them to the queue
+func list(ch *amqp.Channel, queue string, n int) error {
+	q, err := ch.QueueInspect(queue)
+	if err != nil {
+		return fmt.Errorf("inspecting %q: %v", queue, err)
+	}
+
+	fmt.Printf("%d messages parked in %q\n", q.Messages, queue)
+
+	var last uint64
+	for i := 0; i < n; i++ {
+		m, ok, err := ch.Get(queue, false)
+		if err != nil {
+			return fmt.Errorf("getting message: %v", err)
+		}
+		if !ok {
+			break
+		}
+		last = m.DeliveryTag
+
+		body := string(m.Body)
+		if len(body) > maxBodyLen {
+			body = body[:maxBodyLen] + "..."
+		}
+
+		fmt.Printf("\nid: %s\ntype: %s\nretries: %d\nerror: %v\nbody: %s\n",
+			m.MessageId, m.Type, feed.RetryCount(m.Headers), m.Headers[feed.HeaderError], body)
+	}
+
+	if last == 0 {
+		return nil
+	}
+
+	// Requeue them all at once, or the next Get returns them again
+	return ch.Nack(last, true, true)
+}
+
+// replay sends up to n parked messages, all for zero, back to the feed
+// exchange. Each is acked only after the broker confirms its copy.
+func replay(ch *amqp.Channel, cfg *config.RabbitMQConfig, queue string, n int) error {
+	if err := ch.Confirm(false); err != nil {
+		return fmt.Errorf("putting channel into confirm mode: %v", err)
+	}
+	confirms := ch.NotifyPublish(make(chan amqp.Confirmation, 1))
+
+	q, err := ch.QueueInspect(queue)
+	if err != nil {
+		return fmt.Errorf("inspecting %q: %v", queue, err)
+	}
+	if n == 0 || n > q.Messages {
+		n = q.Messages
+	}
+
+	replayed := 0
+	for ; replayed < n; replayed++ {
+		m, ok, err := ch.Get(queue, false)
+		if err != nil {
+			return fmt.Errorf("getting message: %v", err)
+		}
+		if !ok {
+			break
+		}
+
+		err = ch.Publish(cfg.FeedExchangeName, cfg.FeedRoutingKey, false, false, amqp.Publishing{
+			Headers:      feed.ReplayHeaders(m.Headers),
+			ContentType:  m.ContentType,
+			DeliveryMode: amqp.Persistent,
+			MessageId:    m.MessageId,
+			Type:         m.Type,
+			Body:         m.Body,
+		})
+		if err != nil {
+			m.Nack(false, true)
+			return fmt.Errorf("replaying message id [%s]: %v", m.MessageId, err)
+		}
+
+		confirm, ok := <-confirms
+		if !ok {
+			return fmt.Errorf("replaying message id [%s]: channel closed before the confirm", m.MessageId)
+		}
+		if !confirm.Ack {
+			m.Nack(false, true)
+			return fmt.Errorf("replaying message id [%s]: nacked by the broker", m.MessageId)
+		}
+
+		if err := m.Ack(false); err != nil {
+			return fmt.Errorf("acking message id [%s]: %v", m.MessageId, err)
+		}
+	}
+
+	fmt.Printf("%d messages replayed\n", replayed)
+
+	return nil
+}
@@ -0,0 +1,113 @@
+// Command feed-warmup reads the feeds of the active users into the redis
+// feed cache, or publishes them as rebuild jobs for the app with -publish.
+// Each job goes to one replica, so the memory cache of several replicas is
+// warmed up with FEED_WARMUP_ON_START instead.
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"log"
+
+	_ "github.com/go-sql-driver/mysql"
+	"github.com/streadway/amqp"
+
+	"github.com/niklod/highload-social-network/config"
+	"github.com/niklod/highload-social-network/internal/cache"
+	"github.com/niklod/highload-social-network/internal/cache/resp"
+	"github.com/niklod/highload-social-network/internal/cluster"
+	"github.com/niklod/highload-social-network/internal/queue"
+	"github.com/niklod/highload-social-network/internal/queue/feed"
+	"github.com/niklod/highload-social-network/internal/queue/feed/producer"
+	"github.com/niklod/highload-social-network/internal/queue/kafka"
+	"github.com/niklod/highload-social-network/internal/user"
+	"github.com/niklod/highload-social-network/internal/user/post"
+	"github.com/niklod/highload-social-network/internal/user/post/warmup"
+)
+
+func main() {
+	cfg, err := config.New()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	publish := flag.Bool("publish", false, "publish the rebuild jobs to the feed queue instead of rebuilding in process, each job is run by one app replica")
+	flag.DurationVar(&cfg.Warmup.ActiveWithin, "active-within", cfg.Warmup.ActiveWithin, "warm up the users seen within the duration")
+	flag.IntVar(&cfg.Warmup.BatchSize, "batch", cfg.Warmup.BatchSize, "users read at once")
+	flag.Float64Var(&cfg.Warmup.Rate, "rate", cfg.Warmup.Rate, "feeds per second, 0 is unlimited")
+	flag.Parse()
+
+	// Each replica takes a part of the rebuild jobs into its own cache
+	if !*publish && cfg.FeedCache.Backend != cache.BackendRedis {
+		log.Fatalf("the %s feed cache is the app's own, warm it up with FEED_WARMUP_ON_START, or with -publish if the app runs a single replica", cfg.FeedCache.Backend)
+	}
+	if *publish && cfg.FeedCache.Backend != cache.BackendRedis {
+		log.Printf("the %s feed cache is the app's own, the feeds are warmed up on the replica which takes the job", cfg.FeedCache.Backend)
+	}
+	if *publish && cfg.Feed.QueueBackend == queue.BackendMemory {
+		log.Fatalf("the %s feed queue is the app's own, warm it up with FEED_WARMUP_ON_START", cfg.Feed.QueueBackend)
+	}
+
+	db, err := sql.Open("mysql", cfg.DB.ConnectionString())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := db.Ping(); err != nil {
+		log.Fatal(err)
+	}
+
+	var replicas []*sql.DB
+	for _, dsn := range cfg.DB.ReplicaDSNs {
+		replica, err := sql.Open("mysql", dsn)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer replica.Close()
+
+		replicas = append(replicas, replica)
+	}
+	dbCluster := cluster.New(db, replicas, cfg.DB.StickyTTL)
+
+	var rebuild warmup.RebuildFunc
+	if *publish && cfg.Feed.QueueBackend == queue.BackendKafka {
+		client := kafka.NewClient(cfg.Kafka)
+		defer client.Close()
+
+		rebuild = producer.NewFeedProducer(feed.NewKafkaPublisher(client, cfg.Kafka)).SendFeedRebuild
+	} else if *publish {
+		conn, err := amqp.Dial(cfg.RabbitMQ.ConnectionString())
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer conn.Close()
+
+		ch, err := feed.NewQueueChannel(conn, cfg.RabbitMQ)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer ch.Close()
+
+		rebuild = producer.NewFeedProducer(feed.NewPublisher(conn, cfg.RabbitMQ, cfg.Outbox.ConfirmTimeout)).SendFeedRebuild
+	} else {
+		redisClient := resp.NewClient(cfg.Redis)
+		defer redisClient.Close()
+
+		feedStore := post.NewFeedStore(cache.NewRedis(redisClient, post.RefsCodec{}, cfg.Redis.KeyPrefix, post.FeedLength, cfg.FeedCache.TTL))
+		postStore := post.NewPostStore(cache.NewRedis(redisClient, post.PostCodec{}, cfg.Redis.PostKeyPrefix, 1, cfg.FeedCache.TTL))
+
+		postService := post.NewService(post.NewRepository(dbCluster), feedStore, postStore, nil)
+		rebuild = func(userId int) error {
+			return postService.RebuildFeed(context.Background(), userId)
+		}
+	}
+
+	w := warmup.New(user.NewRepository(dbCluster), rebuild, cfg.Warmup)
+
+	p, err := w.Run()
+	if err != nil {
+		log.Fatalf("feed warm-up stopped at %s: %v", p, err)
+	}
+}
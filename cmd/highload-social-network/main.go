@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"encoding/gob"
 	"fmt"
@@ -14,18 +15,29 @@ import (
 	"github.com/gin-gonic/gin"
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/gorilla/sessions"
-	"github.com/streadway/amqp"
 
 	"github.com/niklod/highload-social-network/config"
+	"github.com/niklod/highload-social-network/internal/auth"
 	"github.com/niklod/highload-social-network/internal/cache"
+	"github.com/niklod/highload-social-network/internal/cache/resp"
+	"github.com/niklod/highload-social-network/internal/cluster"
+	"github.com/niklod/highload-social-network/internal/dialog"
+	"github.com/niklod/highload-social-network/internal/dialog/shard"
+	"github.com/niklod/highload-social-network/internal/queue"
 	"github.com/niklod/highload-social-network/internal/queue/feed"
 	"github.com/niklod/highload-social-network/internal/queue/feed/producer"
 	"github.com/niklod/highload-social-network/internal/queue/feed/receiver"
+	"github.com/niklod/highload-social-network/internal/queue/kafka"
+	"github.com/niklod/highload-social-network/internal/queue/memory"
 	"github.com/niklod/highload-social-network/internal/server"
+	"github.com/niklod/highload-social-network/internal/session"
 	"github.com/niklod/highload-social-network/internal/user"
 	"github.com/niklod/highload-social-network/internal/user/city"
 	"github.com/niklod/highload-social-network/internal/user/interest"
 	"github.com/niklod/highload-social-network/internal/user/post"
+	"github.com/niklod/highload-social-network/internal/user/post/comment"
+	"github.com/niklod/highload-social-network/internal/user/post/reaction"
+	"github.com/niklod/highload-social-network/internal/user/post/warmup"
 	"github.com/niklod/highload-social-network/internal/websocket"
 )
 
@@ -40,22 +52,105 @@ func main() {
 	if err != nil {
 		log.Fatal(err)
 	}
-	conn, err := amqp.Dial(cfg.RabbitMQ.ConnectionString())
+	var replicas []*sql.DB
+	for _, dsn := range cfg.DB.ReplicaDSNs {
+		replica, err := dbConnect("mysql", dsn)
+		if err != nil {
+			log.Fatal(err)
+		}
+		replicas = append(replicas, replica)
+	}
+	dbCluster := cluster.New(db, replicas, cfg.DB.StickyTTL)
+	go dbCluster.RunHealthCheck(cfg.DB.HealthCheckInterval)
+
+	// The router closes the shards dropped from the ring, so the main
+	// database has its own handle as shard 0
+	mainShard, err := dbConnect("mysql", cfg.DB.ConnectionString())
 	if err != nil {
 		log.Fatal(err)
 	}
+	shards := map[int]*sql.DB{0: mainShard}
+	for i, dsn := range cfg.Dialog.ShardDSNs {
+		shardDB, err := dbConnect("mysql", dsn)
+		if err != nil {
+			log.Fatal(err)
+		}
+		shards[i+1] = shardDB
+	}
+
+	var rabbit *feed.Manager
+	var publisher queue.Publisher
+	var subscriber queue.Subscriber
+	switch cfg.Feed.QueueBackend {
+	case queue.BackendRabbitMQ:
+		rabbit, err = feed.Dial(cfg.RabbitMQ)
+		if err != nil {
+			log.Fatal(err)
+		}
+		publisher = feed.NewPublisher(rabbit, cfg.RabbitMQ, cfg.Outbox.ConfirmTimeout)
+		subscriber = feed.NewSubscriber(rabbit, cfg.RabbitMQ)
+	case queue.BackendMemory:
+		broker := memory.NewBroker(cfg.RabbitMQ)
+		publisher, subscriber = broker, broker
+	case queue.BackendKafka:
+		kafkaClient := kafka.NewClient(cfg.Kafka)
+		defer kafkaClient.Close()
+
+		publisher = feed.NewKafkaPublisher(kafkaClient, cfg.Kafka)
+		subscriber = feed.NewKafkaPartitionSubscriber(kafkaClient, cfg.Kafka, cfg.RabbitMQ)
+	default:
+		log.Fatalf("unknown feed queue backend %q", cfg.Feed.QueueBackend)
+	}
 
 	// Repositories
-	userRepo := user.NewRepository(db)
+	userRepo := user.NewRepository(dbCluster)
 	cityRepo := city.NewRepository(db)
 	interestRepo := interest.NewRepository(db)
-	postRepo := post.NewRepository(db)
-
-	feedCache := cache.NewFeedCache()
-	ch, err := feed.NewQueueChannel(conn, cfg.RabbitMQ)
-	if err != nil {
+	postRepo := post.NewRepository(dbCluster)
+	commentRepo := comment.NewRepository(dbCluster)
+	reactionRepo := reaction.NewRepository(dbCluster)
+	tokenRepo := auth.NewRepository(db)
+	dialogRepo := dialog.NewRepository(db)
+	shardRouter := shard.NewRouter(shards, cfg.Dialog.VirtualNodes, dialog.NewShardStore(db), cfg.Dialog.ReplicaTimeout)
+	replica := replicaName()
+	if err := shardRouter.Sync(context.Background(), replica); err != nil {
 		log.Fatal(err)
 	}
+	if err := dialog.ClaimIDNode(context.Background(), db, replica, cfg.Dialog.NodeID, cfg.Dialog.ReplicaTimeout); err != nil {
+		log.Fatalf("message ID node %d: %v", cfg.Dialog.NodeID, err)
+	}
+	go shardRouter.Run(replica, cfg.Dialog.SyncInterval)
+	messageRepo := dialog.NewMessageRepository(shardRouter, cfg.Dialog.NodeID)
+
+	var feedCache, postCache, seenCache cache.Cache
+	switch cfg.FeedCache.Backend {
+	case cache.BackendMemory:
+		lru := cache.NewLRU(cfg.FeedCache)
+		go lru.RunStatsLog(cfg.FeedCache.StatsInterval)
+		feedCache = lru
+
+		postCache = cache.NewLRU(&config.FeedCacheConfig{
+			Shards:     cfg.FeedCache.Shards,
+			MaxEntries: cfg.PostCache.MaxEntries,
+			MaxBytes:   cfg.PostCache.MaxBytes,
+			TTL:        cfg.FeedCache.TTL,
+		})
+		seenCache = cache.NewLRU(&config.FeedCacheConfig{
+			Shards:     cfg.FeedCache.Shards,
+			MaxEntries: cfg.PostCache.MaxEntries,
+			TTL:        cfg.Outbox.SeenTTL,
+		})
+	case cache.BackendRedis:
+		redisClient := resp.NewClient(cfg.Redis)
+		defer redisClient.Close()
+		feedCache = cache.NewRedis(redisClient, post.RefsCodec{}, cfg.Redis.KeyPrefix, post.FeedLength, cfg.FeedCache.TTL)
+		postCache = cache.NewRedis(redisClient, post.PostCodec{}, cfg.Redis.PostKeyPrefix, 1, cfg.FeedCache.TTL)
+		seenCache = cache.NewRedis(redisClient, receiver.SeenCodec{}, cfg.Redis.SeenKeyPrefix, 1, cfg.Outbox.SeenTTL)
+	default:
+		log.Fatalf("unknown feed cache backend %q", cfg.FeedCache.Backend)
+	}
+	feedStore := post.NewFeedStore(feedCache)
+	postStore := post.NewPostStore(postCache)
 
 	// WebSockets pool
 	wsPool := websocket.NewPool()
@@ -64,17 +159,61 @@ func main() {
 	// Services
 	cityService := city.NewService(cityRepo)
 	interestService := interest.NewService(interestRepo)
-	userService := user.NewService(userRepo, cityService, interestService)
-	feedProducer := producer.NewFeedProducer(ch, cfg.RabbitMQ, feedCache)
-	postService := post.NewService(postRepo, feedCache, feedProducer)
-	feedReceiver := receiver.NewFeedReceiver(ch, cfg.RabbitMQ, feedCache, postService, userService, wsPool)
+	userService := user.NewService(userRepo, cityService, interestService, wsPool, feedStore)
+	celebrities := user.NewCelebrities(userRepo, feedStore, cfg.Feed.CelebrityThreshold)
+	go celebrities.Run(cfg.Feed.CelebrityRefresh)
+	postService := post.NewService(postRepo, feedStore, postStore, celebrities)
+	commentService := comment.NewService(commentRepo, postService, wsPool)
+	reactionCounter := reaction.NewCounter(reactionRepo, cfg.Reaction.CounterSlots)
+	go reactionCounter.Run(cfg.Reaction.FlushInterval)
+	reactionService := reaction.NewService(reactionRepo, postService, reactionCounter)
+	dialogService := dialog.NewService(dialogRepo, messageRepo, wsPool)
+	feedReceiver := receiver.NewFeedReceiver(subscriber, cfg.RabbitMQ, feedStore, postStore, receiver.NewSeenStore(seenCache), postService, userService, celebrities, wsPool)
+
+	relay := producer.NewRelay(publisher, cfg.Outbox, postRepo)
+	relayCtx, stopRelay := context.WithCancel(context.Background())
+	relayDone := make(chan struct{})
+	go func() {
+		relay.Run(relayCtx)
+		close(relayDone)
+	}()
 
 	// Starting feed update receivers
-	for i := 0; i < cfg.RabbitMQ.ReceiversCount; i++ {
-		go feedReceiver.Run()
+	receiverCtx, stopReceivers := context.WithCancel(context.Background())
+	receiverDone := make(chan error, 1)
+	go func() {
+		receiverDone <- feedReceiver.Run(receiverCtx, cfg.RabbitMQ.ReceiversCount)
+	}()
+
+	if cfg.Warmup.OnStart {
+		go func() {
+			rebuild := func(userId int) error {
+				return postService.RebuildFeed(context.Background(), userId)
+			}
+
+			w := warmup.New(userService, rebuild, cfg.Warmup)
+			if p, err := w.Run(); err != nil {
+				log.Printf("feed warm-up stopped at %s: %v", p, err)
+			}
+		}()
 	}
 
+	tokenManager := auth.NewManager(cfg.Token, tokenRepo)
+
+	var sessionRepo session.Store
+	switch cfg.Session.Store {
+	case session.StoreMemory:
+		sessionRepo = session.NewMemoryStore()
+	case session.StoreMySQL:
+		sessionRepo = session.NewRepository(db)
+	default:
+		log.Fatalf("unknown session store %q", cfg.Session.Store)
+	}
+	sessionManager := session.NewManager(cfg.Session, sessionRepo)
+	go sessionManager.RunCleanup(time.Hour)
+
 	cookieStore := sessions.NewCookieStore([]byte(cfg.SecretKey))
+	// Cookies issued before server side sessions still carry user.User
 	gob.Register(user.User{})
 
 	// Handlers
@@ -84,10 +223,16 @@ func main() {
 		postService,
 		cookieStore,
 		interestService,
+		sessionManager,
+		tokenManager,
 	)
+	commentHandler := comment.NewHandler(commentService, postService)
+	reactionHandler := reaction.NewHandler(reactionService)
+	dialogHandler := dialog.NewHandler(dialogService, userService)
 	wsHandler := websocket.NewWebsocketHandler(wsPool, userService)
 
 	srv := server.NewHTTPServer(cfg.Server)
+	srv.BaseRouterGroup.Use(dbCluster.Middleware)
 	srv.BaseRouterGroup.Use(userHandler.AuthMiddleware)
 
 	// Главная
@@ -103,6 +248,11 @@ func main() {
 	srv.BaseRouterGroup.GET("/login", userHandler.HandleUserLogin)
 	srv.BaseRouterGroup.POST("/login", userHandler.HandleUserLoginSubmit)
 	srv.BaseRouterGroup.GET("/logout", userHandler.HandleUserLogout)
+	srv.BaseRouterGroup.POST("/logout_all", userHandler.HandleLogoutAll)
+
+	// Активные сессии
+	srv.BaseRouterGroup.GET("/sessions", userHandler.HandleSessions)
+	srv.BaseRouterGroup.POST("/sessions/:id/delete", userHandler.HandleDeleteSession)
 
 	// User detail page
 	srv.BaseRouterGroup.GET("/user/:login", userHandler.HandleUserDetail)
@@ -110,35 +260,132 @@ func main() {
 	// Добавление Удаление из друзей
 	srv.BaseRouterGroup.POST("/user/:login/add_friend", userHandler.HandleAddFriend)
 	srv.BaseRouterGroup.POST("/user/:login/delete_friend", userHandler.HandleDeleteFriend)
+	srv.BaseRouterGroup.POST("/user/:login/follow", userHandler.HandleFollow)
+	srv.BaseRouterGroup.POST("/user/:login/unfollow", userHandler.HandleUnfollow)
+	srv.BaseRouterGroup.GET("/friend_requests", userHandler.HandleFriendRequests)
+	srv.BaseRouterGroup.POST("/friend_requests/:id/accept", userHandler.HandleAcceptFriendRequest)
+	srv.BaseRouterGroup.POST("/friend_requests/:id/decline", userHandler.HandleDeclineFriendRequest)
+	srv.BaseRouterGroup.POST("/friend_requests/:id/cancel", userHandler.HandleCancelFriendRequest)
 
 	srv.BaseRouterGroup.POST("/user/:login/add_post", userHandler.HandleAddPost)
+	srv.BaseRouterGroup.POST("/user/:login/posts/:id/edit", userHandler.HandleEditPost)
+	srv.BaseRouterGroup.POST("/user/:login/posts/:id/delete", userHandler.HandleDeletePost)
+	srv.BaseRouterGroup.GET("/user/:login/posts/:id", commentHandler.HandlePost)
+	srv.BaseRouterGroup.POST("/user/:login/posts/:id/comments", commentHandler.HandleAddComment)
+	srv.BaseRouterGroup.POST("/user/:login/posts/:id/comments/:commentId/edit", commentHandler.HandleEditComment)
+	srv.BaseRouterGroup.POST("/user/:login/posts/:id/comments/:commentId/delete", commentHandler.HandleDeleteComment)
+	srv.BaseRouterGroup.POST("/user/:login/posts/:id/reactions", reactionHandler.HandleReact)
 
 	// Список пользователей
 	srv.BaseRouterGroup.GET("/users", userHandler.HandleUsersList)
 
 	srv.BaseRouterGroup.GET("/feed", userHandler.HandleFeed)
 
+	// Личные сообщения
+	srv.BaseRouterGroup.GET("/dialogs", dialogHandler.HandleDialogs)
+	srv.BaseRouterGroup.GET("/dialogs/:login", dialogHandler.HandleDialog)
+	srv.BaseRouterGroup.POST("/dialogs/:login", dialogHandler.HandleSendMessage)
+
 	// Static
 	srv.BaseRouterGroup.Static("/public/", "./static")
 
-	srv.BaseRouterGroup.GET("/ws/feed/:login", wsHandler.HandleWS)
+	srv.BaseRouterGroup.GET(user.FeedSocketRoute, wsHandler.HandleWS)
+
+	// JSON API
+	apiV1 := srv.BaseRouterGroup.Group("/api/v1")
+
+	apiV1.POST("/users", userHandler.HandleAPIRegistrate)
+	apiV1.POST("/login", userHandler.HandleAPILogin)
+	apiV1.POST("/logout", userHandler.HandleAPILogout)
+	apiV1.POST("/logout_all", userHandler.HandleAPILogoutAll)
+	apiV1.GET("/sessions", userHandler.HandleAPISessions)
+	apiV1.DELETE("/sessions/:id", userHandler.HandleAPIDeleteSession)
+	apiV1.GET("/me", userHandler.HandleAPIMe)
+
+	apiV1.POST("/auth/token", userHandler.HandleAPIToken)
+	apiV1.POST("/auth/refresh", userHandler.HandleAPIRefreshToken)
+	apiV1.POST("/auth/revoke", userHandler.HandleAPIRevokeToken)
+	apiV1.POST("/auth/revoke_all", userHandler.HandleAPIRevokeAllTokens)
+
+	apiV1.GET("/users", userHandler.HandleAPIUsersList)
+	apiV1.GET("/users/:login", userHandler.HandleAPIUserDetail)
+	apiV1.GET("/users/:login/friends", userHandler.HandleAPIUserFriends)
+	apiV1.POST("/users/:login/friends", userHandler.HandleAPIAddFriend)
+	apiV1.DELETE("/users/:login/friends", userHandler.HandleAPIDeleteFriend)
+	apiV1.GET("/users/:login/followers", userHandler.HandleAPIFollowers)
+	apiV1.GET("/users/:login/following", userHandler.HandleAPIFollowing)
+	apiV1.PUT("/users/:login/follow", userHandler.HandleAPIFollow)
+	apiV1.DELETE("/users/:login/follow", userHandler.HandleAPIUnfollow)
+	apiV1.GET("/friend_requests", userHandler.HandleAPIFriendRequests)
+	apiV1.POST("/friend_requests/:id/accept", userHandler.HandleAPIAcceptFriendRequest)
+	apiV1.POST("/friend_requests/:id/decline", userHandler.HandleAPIDeclineFriendRequest)
+	apiV1.POST("/friend_requests/:id/cancel", userHandler.HandleAPICancelFriendRequest)
+	apiV1.GET("/users/:login/posts", userHandler.HandleAPIUserPosts)
+
+	apiV1.POST("/posts", userHandler.HandleAPIAddPost)
+	apiV1.PUT("/posts/:id", userHandler.HandleAPIUpdatePost)
+	apiV1.DELETE("/posts/:id", userHandler.HandleAPIDeletePost)
+	apiV1.GET("/posts/:id/comments", commentHandler.HandleAPIComments)
+	apiV1.POST("/posts/:id/comments", commentHandler.HandleAPIAddComment)
+	apiV1.PUT("/comments/:id", commentHandler.HandleAPIUpdateComment)
+	apiV1.DELETE("/comments/:id", commentHandler.HandleAPIDeleteComment)
+	apiV1.PUT("/posts/:id/reaction", reactionHandler.HandleAPIReact)
+	apiV1.DELETE("/posts/:id/reaction", reactionHandler.HandleAPIUnreact)
+	apiV1.GET("/feed", userHandler.HandleAPIFeed)
+
+	apiV1.GET("/dialogs", dialogHandler.HandleAPIDialogs)
+	apiV1.GET("/dialogs/:login/messages", dialogHandler.HandleAPIMessages)
+	apiV1.POST("/dialogs/:login/messages", dialogHandler.HandleAPISendMessage)
+	apiV1.POST("/dialogs/:login/read", dialogHandler.HandleAPIMarkRead)
+
+	apiV1.GET("/citys", userHandler.HandleAPICitys)
+	apiV1.GET("/interests", userHandler.HandleAPIInterests)
 
 	srv.Start()
 
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
 
-	sig := <-sigCh
-	log.Printf("received signal %s, stopping program...", sig)
+	select {
+	case sig := <-sigCh:
+		log.Printf("received signal %s, stopping program...", sig)
+	case err := <-receiverDone:
+		log.Printf("feed receivers stopped: %v, stopping program...", err)
+		receiverDone <- err
+	}
 
 	srv.Shutdown()
-	ch.Close()
-	conn.Close()
+	stopReceivers()
+	if err := <-receiverDone; err != nil {
+		log.Printf("stopping feed receivers: %v", err)
+	}
+	for _, w := range feedReceiver.Workers() {
+		log.Printf("feed receiver %s", w)
+	}
+	if err := reactionCounter.Flush(); err != nil {
+		log.Printf("flushing reaction counters: %v", err)
+	}
+	// The relay publishes through the broker, so it stops first
+	stopRelay()
+	<-relayDone
+	if rabbit != nil {
+		rabbit.Close()
+	}
 	signal.Stop(sigCh)
 
 	log.Println("program stopped")
 }
 
+// replicaName tells the app replica in the shard routing acks
+func replicaName() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+
+	return fmt.Sprintf("%s:%d", host, os.Getpid())
+}
+
 func dbConnect(driver, connectionString string) (*sql.DB, error) {
 	var connErr error
 
@@ -2,6 +2,7 @@ package config
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/kelseyhightower/envconfig"
 )
@@ -12,6 +13,17 @@ type Config struct {
 	DB        *DBConfig
 	Server    *HTTPServerConfig
 	RabbitMQ  *RabbitMQConfig
+	Token     *TokenConfig
+	Session   *SessionConfig
+	Dialog    *DialogConfig
+	Reaction  *ReactionConfig
+	Feed      *FeedConfig
+	FeedCache *FeedCacheConfig
+	PostCache *PostCacheConfig
+	Warmup    *FeedWarmupConfig
+	Outbox    *OutboxConfig
+	Redis     *RedisConfig
+	Kafka     *KafkaConfig
 	SecretKey string `envconfig:"SESSION_SECRET_KEY" default:"verysecretkey"`
 }
 
@@ -21,10 +33,16 @@ type DBConfig struct {
 	Login    string `envconfig:"DB_USER" default:""`
 	Password string `envconfig:"DB_PASSWORD" default:""`
 	DBName   string `envconfig:"DB_NAME" default:"hsn"`
+	// Replicas are read only copies of the primary above
+	ReplicaDSNs         []string      `envconfig:"DB_REPLICA_DSNS"`
+	StickyTTL           time.Duration `envconfig:"DB_STICKY_TTL" default:"5s"`
+	HealthCheckInterval time.Duration `envconfig:"DB_HEALTH_CHECK_INTERVAL" default:"5s"`
 }
 
+// ConnectionString sets UTC for both the driver and the session, so the DB
+// defaults match the times written by the app.
 func (d *DBConfig) ConnectionString() string {
-	return fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?parseTime=true", d.Login, d.Password, d.Host, d.Port, d.DBName)
+	return fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?parseTime=true&loc=UTC&time_zone=%%27%%2B00%%3A00%%27", d.Login, d.Password, d.Host, d.Port, d.DBName)
 }
 
 type RabbitMQConfig struct {
@@ -36,12 +54,125 @@ type RabbitMQConfig struct {
 	FeedExchangeName string `envconfig:"RABBITMQ_FEED_EXCHANGE_NAME" default:"feedExchange"`
 	FeedRoutingKey   string `envconfig:"RABBITMQ_FEED_ROUTING_KEY" default:"feedUpdate"`
 	ReceiversCount   int    `envconfig:"RABBITMQ_FEED_RECEIVERS_COUNT" default:"2"`
+	// Failed messages are retried with exponential back-off from
+	// RetryDelay, then parked
+	MaxRetries int           `envconfig:"RABBITMQ_FEED_MAX_RETRIES" default:"5"`
+	RetryDelay time.Duration `envconfig:"RABBITMQ_FEED_RETRY_DELAY" default:"1s"`
+	// ShutdownTimeout bounds the receivers finishing their messages
+	ShutdownTimeout time.Duration `envconfig:"RABBITMQ_FEED_SHUTDOWN_TIMEOUT" default:"10s"`
+	// Reconnects back off exponentially from ReconnectDelay
+	ReconnectDelay    time.Duration `envconfig:"RABBITMQ_RECONNECT_DELAY" default:"1s"`
+	ReconnectMaxDelay time.Duration `envconfig:"RABBITMQ_RECONNECT_MAX_DELAY" default:"30s"`
+}
+
+// OutboxConfig tunes producer.Relay. SeenTTL is how long the receivers
+// remember the processed messages to skip duplicates.
+type OutboxConfig struct {
+	BatchSize      int           `envconfig:"OUTBOX_BATCH_SIZE" default:"100"`
+	PollInterval   time.Duration `envconfig:"OUTBOX_POLL_INTERVAL" default:"500ms"`
+	ConfirmTimeout time.Duration `envconfig:"OUTBOX_CONFIRM_TIMEOUT" default:"5s"`
+	SeenTTL        time.Duration `envconfig:"OUTBOX_SEEN_TTL" default:"24h"`
 }
 
 func (r *RabbitMQConfig) ConnectionString() string {
 	return fmt.Sprintf("amqp://%s:%s@%s:%s/", r.Login, r.Password, r.Host, r.Port)
 }
 
+type TokenConfig struct {
+	SecretKey  string        `envconfig:"TOKEN_SECRET_KEY" default:"verysecrettokenkey"`
+	AccessTTL  time.Duration `envconfig:"TOKEN_ACCESS_TTL" default:"15m"`
+	RefreshTTL time.Duration `envconfig:"TOKEN_REFRESH_TTL" default:"720h"`
+}
+
+type SessionConfig struct {
+	Store           string        `envconfig:"SESSION_STORE" default:"mysql"`
+	IdleTimeout     time.Duration `envconfig:"SESSION_IDLE_TIMEOUT" default:"72h"`
+	AbsoluteTimeout time.Duration `envconfig:"SESSION_ABSOLUTE_TIMEOUT" default:"720h"`
+}
+
+// DialogConfig lists the message shards after the main database, which is
+// shard 0. See cmd/dialog-reshard.
+type DialogConfig struct {
+	ShardDSNs      []string      `envconfig:"DIALOG_SHARD_DSNS"`
+	VirtualNodes   int           `envconfig:"DIALOG_SHARD_VNODES" default:"64"`
+	SyncInterval   time.Duration `envconfig:"DIALOG_SHARD_SYNC_INTERVAL" default:"5s"`
+	ReplicaTimeout time.Duration `envconfig:"DIALOG_SHARD_REPLICA_TIMEOUT" default:"30s"`
+	// NodeID goes into the message IDs, 0-1023 unique among the replicas
+	NodeID int `envconfig:"DIALOG_NODE_ID" default:"0"`
+}
+
+// ReactionConfig tunes reaction.Counter.
+type ReactionConfig struct {
+	CounterSlots  int           `envconfig:"REACTION_COUNTER_SLOTS" default:"8"`
+	FlushInterval time.Duration `envconfig:"REACTION_FLUSH_INTERVAL" default:"1s"`
+}
+
+// FeedConfig sets the celebrities, see user.Celebrities. Zero threshold
+// turns them off.
+type FeedConfig struct {
+	CelebrityThreshold int           `envconfig:"FEED_CELEBRITY_THRESHOLD" default:"10000"`
+	CelebrityRefresh   time.Duration `envconfig:"FEED_CELEBRITY_REFRESH" default:"1m"`
+	// QueueBackend is one of queue.Backend*, all take the retry settings
+	// of RabbitMQConfig
+	QueueBackend string `envconfig:"FEED_QUEUE_BACKEND" default:"rabbitmq"`
+}
+
+// FeedCacheConfig bounds the feed cache, see cache.FeedCache. Only TTL
+// applies to the redis backend.
+type FeedCacheConfig struct {
+	Backend       string        `envconfig:"FEED_CACHE_BACKEND" default:"memory"`
+	Shards        int           `envconfig:"FEED_CACHE_SHARDS" default:"16"`
+	MaxEntries    int           `envconfig:"FEED_CACHE_MAX_ENTRIES" default:"100000"`
+	MaxBytes      int64         `envconfig:"FEED_CACHE_MAX_BYTES" default:"536870912"`
+	TTL           time.Duration `envconfig:"FEED_CACHE_TTL" default:"1h"`
+	StatsInterval time.Duration `envconfig:"FEED_CACHE_STATS_INTERVAL" default:"1m"`
+}
+
+// PostCacheConfig bounds the memory cache of the feed posts, the shards
+// and TTL are the feed cache ones.
+type PostCacheConfig struct {
+	MaxEntries int   `envconfig:"POST_CACHE_MAX_ENTRIES" default:"200000"`
+	MaxBytes   int64 `envconfig:"POST_CACHE_MAX_BYTES" default:"268435456"`
+}
+
+// FeedWarmupConfig tunes the feed cache warm-up, see cmd/feed-warmup. Zero
+// Rate is unlimited.
+type FeedWarmupConfig struct {
+	OnStart          bool          `envconfig:"FEED_WARMUP_ON_START" default:"false"`
+	ActiveWithin     time.Duration `envconfig:"FEED_WARMUP_ACTIVE_WITHIN" default:"72h"`
+	BatchSize        int           `envconfig:"FEED_WARMUP_BATCH_SIZE" default:"100"`
+	Rate             float64       `envconfig:"FEED_WARMUP_RATE" default:"5"`
+	ProgressInterval time.Duration `envconfig:"FEED_WARMUP_PROGRESS_INTERVAL" default:"10s"`
+}
+
+// RedisConfig is the Redis compatible server of the redis feed cache
+// backend.
+type RedisConfig struct {
+	Addr          string        `envconfig:"REDIS_ADDR" default:"localhost:6379"`
+	Password      string        `envconfig:"REDIS_PASSWORD" default:""`
+	DB            int           `envconfig:"REDIS_DB" default:"0"`
+	PoolSize      int           `envconfig:"REDIS_POOL_SIZE" default:"16"`
+	Timeout       time.Duration `envconfig:"REDIS_TIMEOUT" default:"1s"`
+	KeyPrefix     string        `envconfig:"REDIS_FEED_KEY_PREFIX" default:"feed:"`
+	PostKeyPrefix string        `envconfig:"REDIS_POST_KEY_PREFIX" default:"post:"`
+	SeenKeyPrefix string        `envconfig:"REDIS_SEEN_KEY_PREFIX" default:"feed-seen:"`
+}
+
+// KafkaConfig is the Kafka feed backend. The replicas' Partitions must
+// cover the topic without overlapping, see feed.KafkaPartitionSubscriber.
+type KafkaConfig struct {
+	Brokers       []string      `envconfig:"KAFKA_BROKERS" default:"localhost:9092"`
+	ClientID      string        `envconfig:"KAFKA_CLIENT_ID" default:"highload-social-network"`
+	Topic         string        `envconfig:"KAFKA_FEED_TOPIC" default:"feed-events"`
+	DeadTopic     string        `envconfig:"KAFKA_FEED_DEAD_TOPIC" default:"feed-events-dead"`
+	Group         string        `envconfig:"KAFKA_FEED_GROUP" default:"feed-receivers"`
+	Partitions    []int32       `envconfig:"KAFKA_FEED_PARTITIONS"`
+	PoolSize      int           `envconfig:"KAFKA_POOL_SIZE" default:"8"`
+	Timeout       time.Duration `envconfig:"KAFKA_TIMEOUT" default:"5s"`
+	FetchWait     time.Duration `envconfig:"KAFKA_FETCH_WAIT" default:"500ms"`
+	FetchMaxBytes int           `envconfig:"KAFKA_FETCH_MAX_BYTES" default:"1048576"`
+}
+
 type HTTPServerConfig struct {
 	Port int `envconfig:"HTTP_SERVER_PORT" default:"8080"`
 }
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS auth_tokens (
+    jti char(32) NOT NULL,
+    user_id int NOT NULL,
+    token_type varchar(16) NOT NULL,
+    expires_at datetime NOT NULL,
+    revoked_at datetime NULL DEFAULT NULL,
+    created_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id)
+        REFERENCES  users(id)
+        ON UPDATE CASCADE ON DELETE CASCADE,
+    PRIMARY KEY (jti),
+    INDEX auth_tokens_user_id_idx (user_id)
+);
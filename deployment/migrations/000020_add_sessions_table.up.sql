@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS sessions (
+    id char(64) NOT NULL,
+    user_id int NOT NULL,
+    created_at datetime NOT NULL,
+    last_seen_at datetime NOT NULL,
+    expires_at datetime NOT NULL,
+    user_agent varchar(255) NOT NULL DEFAULT '',
+    ip varchar(45) NOT NULL DEFAULT '',
+    FOREIGN KEY (user_id)
+        REFERENCES  users(id)
+        ON UPDATE CASCADE ON DELETE CASCADE,
+    PRIMARY KEY (id),
+    INDEX sessions_user_id_idx (user_id),
+    INDEX sessions_last_seen_at_idx (last_seen_at)
+);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS messages;
+DROP TABLE IF EXISTS dialog_members;
+DROP TABLE IF EXISTS dialogs;
\ No newline at end of file
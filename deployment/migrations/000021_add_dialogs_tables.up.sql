@@ -0,0 +1,43 @@
+CREATE TABLE IF NOT EXISTS dialogs (
+    id int NOT NULL AUTO_INCREMENT,
+    user_one_id int NOT NULL,
+    user_two_id int NOT NULL,
+    created_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_message_at datetime NULL DEFAULT NULL,
+    last_message_body varchar(255) NOT NULL DEFAULT '',
+    last_sender_id int NULL DEFAULT NULL,
+    FOREIGN KEY (user_one_id)
+        REFERENCES  users(id)
+        ON UPDATE CASCADE ON DELETE RESTRICT,
+    FOREIGN KEY (user_two_id)
+        REFERENCES  users(id)
+        ON UPDATE CASCADE ON DELETE RESTRICT,
+    PRIMARY KEY (id),
+    UNIQUE (user_one_id, user_two_id)
+) CHARACTER SET utf8mb4;
+
+CREATE TABLE IF NOT EXISTS dialog_members (
+    dialog_id int NOT NULL,
+    user_id int NOT NULL,
+    unread_count int NOT NULL DEFAULT 0,
+    last_read_message_id bigint NOT NULL DEFAULT 0,
+    FOREIGN KEY (dialog_id)
+        REFERENCES  dialogs(id)
+        ON UPDATE CASCADE ON DELETE CASCADE,
+    FOREIGN KEY (user_id)
+        REFERENCES  users(id)
+        ON UPDATE CASCADE ON DELETE RESTRICT,
+    PRIMARY KEY (dialog_id, user_id),
+    INDEX dialog_members_user_id_idx (user_id)
+);
+
+CREATE TABLE IF NOT EXISTS messages (
+    id bigint NOT NULL AUTO_INCREMENT,
+    dialog_id int NOT NULL,
+    sender_id int NOT NULL,
+    body text NOT NULL,
+    created_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    read_at datetime NULL DEFAULT NULL,
+    PRIMARY KEY (dialog_id, id), -- for clustered index, a dialog is read as a whole
+    INDEX messages_id_idx (id)
+) CHARACTER SET utf8mb4;
@@ -0,0 +1,4 @@
+ALTER TABLE messages
+    DROP INDEX messages_key_hash_idx,
+    DROP COLUMN key_hash,
+    MODIFY id bigint NOT NULL AUTO_INCREMENT;
@@ -0,0 +1,11 @@
+-- Message IDs are generated by the application, so the rows keep their IDs
+-- when moved between shards
+ALTER TABLE messages
+    MODIFY id bigint NOT NULL,
+    ADD COLUMN key_hash int unsigned NOT NULL DEFAULT 0,
+    ADD INDEX messages_key_hash_idx (key_hash, dialog_id, id);
+
+-- CRC32 is the shard ring hash of the "low:high" conversation key
+UPDATE messages m
+    JOIN dialogs d ON d.id = m.dialog_id
+SET m.key_hash = CRC32(CONCAT(d.user_one_id, ':', d.user_two_id));
@@ -0,0 +1 @@
+ALTER TABLE posts DROP INDEX posts_user_created_idx;
@@ -0,0 +1,2 @@
+-- Keyset pagination of user posts and feeds by (created_at, id)
+ALTER TABLE posts ADD INDEX posts_user_created_idx (user_id, created_at, id);
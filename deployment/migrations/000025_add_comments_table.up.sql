@@ -0,0 +1,21 @@
+CREATE TABLE IF NOT EXISTS comments (
+    id int NOT NULL AUTO_INCREMENT,
+    post_id int NOT NULL,
+    user_id int NOT NULL,
+    parent_id int NULL DEFAULT NULL,
+    body text NOT NULL,
+    created_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    deleted_at datetime NULL DEFAULT NULL,
+    FOREIGN KEY (post_id)
+        REFERENCES  posts(id)
+        ON UPDATE CASCADE ON DELETE CASCADE,
+    FOREIGN KEY (user_id)
+        REFERENCES  users(id)
+        ON UPDATE CASCADE ON DELETE RESTRICT,
+    FOREIGN KEY (parent_id)
+        REFERENCES  comments(id)
+        ON UPDATE CASCADE ON DELETE CASCADE,
+    PRIMARY KEY (id),
+    INDEX comments_post_id_created_at_idx (post_id, created_at, id)
+) CHARACTER SET utf8mb4;
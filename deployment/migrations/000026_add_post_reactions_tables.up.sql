@@ -0,0 +1,23 @@
+CREATE TABLE IF NOT EXISTS post_reactions (
+    post_id int NOT NULL,
+    user_id int NOT NULL,
+    reaction varchar(16) NOT NULL,
+    created_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (post_id)
+        REFERENCES  posts(id)
+        ON UPDATE CASCADE ON DELETE CASCADE,
+    FOREIGN KEY (user_id)
+        REFERENCES  users(id)
+        ON UPDATE CASCADE ON DELETE CASCADE,
+    PRIMARY KEY (post_id, user_id),
+    INDEX post_reactions_user_id_idx (user_id, post_id)
+);
+
+-- A counter is the sum of its slots, see reaction.Counter
+CREATE TABLE IF NOT EXISTS post_reaction_counters (
+    post_id int NOT NULL,
+    reaction varchar(16) NOT NULL,
+    slot tinyint NOT NULL,
+    count int NOT NULL DEFAULT 0,
+    PRIMARY KEY (post_id, reaction, slot)
+);
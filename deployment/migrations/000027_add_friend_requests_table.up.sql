@@ -0,0 +1,20 @@
+-- A pair of users has one request per direction, sending it again after
+-- it's closed reopens the row, see user.FriendRequestStatus
+CREATE TABLE IF NOT EXISTS friend_requests (
+    id int NOT NULL AUTO_INCREMENT,
+    from_user_id int NOT NULL,
+    to_user_id int NOT NULL,
+    status varchar(16) NOT NULL DEFAULT 'sent',
+    created_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    FOREIGN KEY (from_user_id)
+        REFERENCES  users(id)
+        ON UPDATE CASCADE ON DELETE CASCADE,
+    FOREIGN KEY (to_user_id)
+        REFERENCES  users(id)
+        ON UPDATE CASCADE ON DELETE CASCADE,
+    PRIMARY KEY (id),
+    UNIQUE KEY friend_requests_pair_idx (from_user_id, to_user_id),
+    INDEX friend_requests_to_user_id_idx (to_user_id, status, created_at),
+    INDEX friend_requests_from_user_id_idx (from_user_id, status, created_at)
+);
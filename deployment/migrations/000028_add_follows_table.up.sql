@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS follows (
+    follower_id int NOT NULL,
+    followee_id int NOT NULL,
+    created_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (follower_id)
+        REFERENCES  users(id)
+        ON UPDATE CASCADE ON DELETE CASCADE,
+    FOREIGN KEY (followee_id)
+        REFERENCES  users(id)
+        ON UPDATE CASCADE ON DELETE CASCADE,
+    PRIMARY KEY (follower_id, followee_id),
+    INDEX follows_followee_id_idx (followee_id, follower_id)
+);
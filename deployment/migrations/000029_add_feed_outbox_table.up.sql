@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS feed_outbox (
+    id bigint NOT NULL AUTO_INCREMENT,
+    post_id int NOT NULL,
+    type varchar(32) NOT NULL,
+    payload mediumtext NOT NULL,
+    created_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (id)
+);
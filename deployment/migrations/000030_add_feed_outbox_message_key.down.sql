@@ -0,0 +1 @@
+ALTER TABLE feed_outbox DROP COLUMN message_key;
@@ -0,0 +1 @@
+ALTER TABLE feed_outbox ADD COLUMN message_key varchar(64) NOT NULL DEFAULT '' AFTER type;
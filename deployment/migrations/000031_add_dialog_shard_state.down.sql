@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS dialog_shard_replicas;
+DROP TABLE IF EXISTS dialog_shard_moves;
+DROP TABLE IF EXISTS dialog_shard_state;
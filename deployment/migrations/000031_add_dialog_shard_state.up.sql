@@ -0,0 +1,30 @@
+-- The routing of the dialog message shards shared by the app replicas,
+-- see cmd/dialog-reshard. NULL ring is all the configured shards.
+CREATE TABLE IF NOT EXISTS dialog_shard_state (
+    id tinyint NOT NULL,
+    version bigint NOT NULL DEFAULT 0,
+    ring varchar(255) NULL DEFAULT NULL,
+    target varchar(255) NULL DEFAULT NULL,
+    PRIMARY KEY (id)
+);
+
+INSERT IGNORE INTO dialog_shard_state (id) VALUES (1);
+
+-- The ranges of the resharding in progress, dirty counts the failed dual
+-- writes since the last copy pass
+CREATE TABLE IF NOT EXISTS dialog_shard_moves (
+    range_start int unsigned NOT NULL,
+    range_end int unsigned NOT NULL,
+    from_shard int NOT NULL,
+    to_shard int NOT NULL,
+    switched tinyint(1) NOT NULL DEFAULT 0,
+    dirty int unsigned NOT NULL DEFAULT 0,
+    PRIMARY KEY (range_start)
+);
+
+CREATE TABLE IF NOT EXISTS dialog_shard_replicas (
+    replica varchar(255) NOT NULL,
+    version bigint NOT NULL,
+    seen_at datetime NOT NULL,
+    PRIMARY KEY (replica)
+);
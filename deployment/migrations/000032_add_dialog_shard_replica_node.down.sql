@@ -0,0 +1 @@
+ALTER TABLE dialog_shard_replicas DROP INDEX node_id, DROP COLUMN node_id;
@@ -0,0 +1 @@
+ALTER TABLE dialog_shard_replicas ADD COLUMN node_id smallint NULL DEFAULT NULL, ADD UNIQUE KEY node_id (node_id);
@@ -0,0 +1,14 @@
+-- Schema of the messages table on the extra dialog shards, see DIALOG_SHARD_DSNS.
+-- The main database gets it from the migrations.
+CREATE TABLE IF NOT EXISTS messages (
+    id bigint NOT NULL,
+    dialog_id int NOT NULL,
+    sender_id int NOT NULL,
+    body text NOT NULL,
+    created_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    read_at datetime NULL DEFAULT NULL,
+    key_hash int unsigned NOT NULL DEFAULT 0,
+    PRIMARY KEY (dialog_id, id),
+    INDEX messages_id_idx (id),
+    INDEX messages_key_hash_idx (key_hash, dialog_id, id)
+) CHARACTER SET utf8mb4;
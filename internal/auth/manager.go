@@ -0,0 +1,163 @@
+package auth
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/niklod/highload-social-network/config"
+)
+
+// Store keeps track of issued refresh tokens and of revoked token IDs.
+type Store interface {
+	Save(c Claims) error
+	Revoke(c Claims) error
+	RevokeUser(userId int) error
+	IsRevoked(jti string) (bool, error)
+	// Use revokes the active token once, so it can't be used twice
+	Use(jti string) (bool, error)
+}
+
+type Manager struct {
+	secret     []byte
+	accessTTL  time.Duration
+	refreshTTL time.Duration
+	store      Store
+	now        func() time.Time
+}
+
+func NewManager(cfg *config.TokenConfig, store Store) *Manager {
+	return &Manager{
+		secret:     []byte(cfg.SecretKey),
+		accessTTL:  cfg.AccessTTL,
+		refreshTTL: cfg.RefreshTTL,
+		store:      store,
+		now:        time.Now,
+	}
+}
+
+// Issue creates a new access/refresh pair for the user. Only the refresh
+// token is persisted, access tokens are short lived and stateless.
+func (m *Manager) Issue(userId int, login string) (*Pair, error) {
+	now := m.now().UTC()
+
+	access, accessToken, err := m.newToken(TokenTypeAccess, userId, login, now, m.accessTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	refresh, refreshToken, err := m.newToken(TokenTypeRefresh, userId, login, now, m.refreshTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := m.store.Save(*refresh); err != nil {
+		return nil, fmt.Errorf("auth.Issue - saving refresh token: %v", err)
+	}
+
+	return &Pair{
+		AccessToken:      accessToken,
+		AccessExpiresAt:  access.ExpiresAtTime(),
+		RefreshToken:     refreshToken,
+		RefreshExpiresAt: refresh.ExpiresAtTime(),
+		TokenType:        "Bearer",
+	}, nil
+}
+
+// Authenticate validates an access token and returns its claims.
+func (m *Manager) Authenticate(token string) (*Claims, error) {
+	c, err := m.parse(token, TokenTypeAccess)
+	if err != nil {
+		return nil, err
+	}
+
+	revoked, err := m.store.IsRevoked(c.ID)
+	if err != nil {
+		return nil, fmt.Errorf("auth.Authenticate - checking revocation: %v", err)
+	}
+	if revoked {
+		return nil, ErrTokenRevoked
+	}
+
+	return c, nil
+}
+
+// Refresh exchanges a single use refresh token for a new pair.
+func (m *Manager) Refresh(token string) (*Pair, error) {
+	c, err := m.parse(token, TokenTypeRefresh)
+	if err != nil {
+		return nil, err
+	}
+
+	used, err := m.store.Use(c.ID)
+	if err != nil {
+		return nil, fmt.Errorf("auth.Refresh - using refresh token: %v", err)
+	}
+	if !used {
+		return nil, ErrTokenRevoked
+	}
+
+	return m.Issue(c.UserID, c.Login)
+}
+
+// Revoke invalidates a single access or refresh token.
+func (m *Manager) Revoke(token string) error {
+	c, err := parse(token, m.secret, m.now())
+	if err != nil {
+		// Expired tokens are already unusable, nothing to revoke
+		if err == ErrTokenExpired {
+			return nil
+		}
+		return err
+	}
+
+	if err := m.store.Revoke(*c); err != nil {
+		return fmt.Errorf("auth.Revoke: %v", err)
+	}
+
+	return nil
+}
+
+// RevokeUser invalidates all refresh tokens of the user. Already issued
+// access tokens stay valid until they expire.
+func (m *Manager) RevokeUser(userId int) error {
+	if err := m.store.RevokeUser(userId); err != nil {
+		return fmt.Errorf("auth.RevokeUser: %v", err)
+	}
+
+	return nil
+}
+
+func (m *Manager) parse(token, tokenType string) (*Claims, error) {
+	c, err := parse(token, m.secret, m.now())
+	if err != nil {
+		return nil, err
+	}
+	if c.Type != tokenType {
+		return nil, ErrWrongTokenType
+	}
+
+	return c, nil
+}
+
+func (m *Manager) newToken(tokenType string, userId int, login string, now time.Time, ttl time.Duration) (*Claims, string, error) {
+	id, err := newTokenID()
+	if err != nil {
+		return nil, "", err
+	}
+
+	c := &Claims{
+		ID:        id,
+		UserID:    userId,
+		Login:     login,
+		Type:      tokenType,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(ttl).Unix(),
+	}
+
+	token, err := sign(*c, m.secret)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return c, token, nil
+}
@@ -0,0 +1,202 @@
+package auth
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/niklod/highload-social-network/config"
+)
+
+type fakeStore struct {
+	mu      sync.Mutex
+	active  map[string]int
+	revoked map[string]bool
+}
+
+func newFakeStore() *fakeStore {
+	return &fakeStore{
+		active:  make(map[string]int),
+		revoked: make(map[string]bool),
+	}
+}
+
+func (f *fakeStore) Save(c Claims) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.active[c.ID] = c.UserID
+	return nil
+}
+
+func (f *fakeStore) Revoke(c Claims) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	delete(f.active, c.ID)
+	f.revoked[c.ID] = true
+	return nil
+}
+
+func (f *fakeStore) RevokeUser(userId int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for jti, id := range f.active {
+		if id == userId {
+			delete(f.active, jti)
+			f.revoked[jti] = true
+		}
+	}
+	return nil
+}
+
+func (f *fakeStore) IsRevoked(jti string) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.revoked[jti], nil
+}
+
+func (f *fakeStore) Use(jti string) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.active[jti]; !ok {
+		return false, nil
+	}
+	delete(f.active, jti)
+	f.revoked[jti] = true
+
+	return true, nil
+}
+
+var testConfig = &config.TokenConfig{
+	SecretKey:  "testsecret",
+	AccessTTL:  time.Minute,
+	RefreshTTL: time.Hour,
+}
+
+func TestManager_IssueAuthenticate(t *testing.T) {
+	m := NewManager(testConfig, newFakeStore())
+
+	pair, err := m.Issue(10, "testlogin")
+	assert.Nil(t, err)
+
+	claims, err := m.Authenticate(pair.AccessToken)
+	assert.Nil(t, err)
+	assert.Equal(t, 10, claims.UserID)
+	assert.Equal(t, "testlogin", claims.Login)
+}
+
+func TestManager_Authenticate_RefreshTokenRejected(t *testing.T) {
+	m := NewManager(testConfig, newFakeStore())
+
+	pair, err := m.Issue(10, "testlogin")
+	assert.Nil(t, err)
+
+	_, err = m.Authenticate(pair.RefreshToken)
+	assert.Equal(t, ErrWrongTokenType, err)
+}
+
+func TestManager_Authenticate_Expired(t *testing.T) {
+	m := NewManager(testConfig, newFakeStore())
+
+	pair, err := m.Issue(10, "testlogin")
+	assert.Nil(t, err)
+
+	m.now = func() time.Time { return time.Now().Add(2 * time.Minute) }
+
+	_, err = m.Authenticate(pair.AccessToken)
+	assert.Equal(t, ErrTokenExpired, err)
+}
+
+func TestManager_Authenticate_TamperedSignature(t *testing.T) {
+	m := NewManager(testConfig, newFakeStore())
+
+	pair, err := m.Issue(10, "testlogin")
+	assert.Nil(t, err)
+
+	parts := strings.Split(pair.AccessToken, ".")
+	other := NewManager(testConfig, newFakeStore())
+	other.secret = []byte("othersecret")
+	forged, err := other.Issue(11, "attacker")
+	assert.Nil(t, err)
+
+	_, err = m.Authenticate(parts[0] + "." + strings.Split(forged.AccessToken, ".")[1] + "." + parts[2])
+	assert.Equal(t, ErrInvalidSignature, err)
+}
+
+func TestManager_Revoke(t *testing.T) {
+	m := NewManager(testConfig, newFakeStore())
+
+	pair, err := m.Issue(10, "testlogin")
+	assert.Nil(t, err)
+
+	assert.Nil(t, m.Revoke(pair.AccessToken))
+
+	_, err = m.Authenticate(pair.AccessToken)
+	assert.Equal(t, ErrTokenRevoked, err)
+}
+
+func TestManager_Refresh_Rotation(t *testing.T) {
+	m := NewManager(testConfig, newFakeStore())
+
+	pair, err := m.Issue(10, "testlogin")
+	assert.Nil(t, err)
+
+	newPair, err := m.Refresh(pair.RefreshToken)
+	assert.Nil(t, err)
+	assert.NotEqual(t, pair.RefreshToken, newPair.RefreshToken)
+
+	// Used refresh token can't be presented twice
+	_, err = m.Refresh(pair.RefreshToken)
+	assert.Equal(t, ErrTokenRevoked, err)
+}
+
+func TestManager_Refresh_Concurrent(t *testing.T) {
+	m := NewManager(testConfig, newFakeStore())
+
+	pair, err := m.Issue(10, "testlogin")
+	assert.Nil(t, err)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := m.Refresh(pair.RefreshToken)
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	var failed []error
+	for err := range errs {
+		if err != nil {
+			failed = append(failed, err)
+		}
+	}
+	assert.Equal(t, []error{ErrTokenRevoked}, failed)
+}
+
+func TestManager_RevokeUser(t *testing.T) {
+	m := NewManager(testConfig, newFakeStore())
+
+	first, err := m.Issue(10, "testlogin")
+	assert.Nil(t, err)
+	second, err := m.Issue(10, "testlogin")
+	assert.Nil(t, err)
+
+	assert.Nil(t, m.RevokeUser(10))
+
+	_, err = m.Refresh(first.RefreshToken)
+	assert.Equal(t, ErrTokenRevoked, err)
+	_, err = m.Refresh(second.RefreshToken)
+	assert.Equal(t, ErrTokenRevoked, err)
+}
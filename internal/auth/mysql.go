@@ -0,0 +1,91 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+type mysql struct {
+	db *sql.DB
+}
+
+func NewRepository(db *sql.DB) Store {
+	return &mysql{db: db}
+}
+
+func (m *mysql) Save(c Claims) error {
+	query := queryMap[saveToken]
+	ctx, cancel := context.WithTimeout(context.Background(), query.Timeout)
+	defer cancel()
+
+	_, err := m.db.ExecContext(ctx, query.SQL, c.ID, c.UserID, c.Type, c.ExpiresAtTime())
+	if err != nil {
+		return fmt.Errorf("saving token %s: %v", c.ID, err)
+	}
+
+	return nil
+}
+
+func (m *mysql) Revoke(c Claims) error {
+	query := queryMap[revokeToken]
+	ctx, cancel := context.WithTimeout(context.Background(), query.Timeout)
+	defer cancel()
+
+	_, err := m.db.ExecContext(ctx, query.SQL, c.ID, c.UserID, c.Type, c.ExpiresAtTime())
+	if err != nil {
+		return fmt.Errorf("revoking token %s: %v", c.ID, err)
+	}
+
+	return nil
+}
+
+func (m *mysql) RevokeUser(userId int) error {
+	query := queryMap[revokeUserTokens]
+	ctx, cancel := context.WithTimeout(context.Background(), query.Timeout)
+	defer cancel()
+
+	_, err := m.db.ExecContext(ctx, query.SQL, userId)
+	if err != nil {
+		return fmt.Errorf("revoking tokens of user %d: %v", userId, err)
+	}
+
+	return nil
+}
+
+func (m *mysql) IsRevoked(jti string) (bool, error) {
+	return m.exists(queryMap[isTokenRevoked], jti)
+}
+
+// Use revokes the active token and reports whether it was active.
+func (m *mysql) Use(jti string) (bool, error) {
+	query := queryMap[useToken]
+	ctx, cancel := context.WithTimeout(context.Background(), query.Timeout)
+	defer cancel()
+
+	res, err := m.db.ExecContext(ctx, query.SQL, jti)
+	if err != nil {
+		return false, fmt.Errorf("using token %s: %v", jti, err)
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("using token %s: %v", jti, err)
+	}
+
+	return affected == 1, nil
+}
+
+func (m *mysql) exists(query Query, jti string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), query.Timeout)
+	defer cancel()
+
+	var count int
+
+	err := m.db.QueryRowContext(ctx, query.SQL, jti).Scan(&count)
+	if err != nil {
+		return false, fmt.Errorf("checking token %s: %v", jti, err)
+	}
+
+	return count > 0, nil
+}
@@ -0,0 +1,99 @@
+package auth
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_mysql_Save(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	repo := NewRepository(db)
+
+	c := Claims{ID: "abc", UserID: 1, Type: TokenTypeRefresh, ExpiresAt: time.Now().Unix()}
+
+	mock.ExpectExec("INSERT INTO auth_tokens").
+		WithArgs(c.ID, c.UserID, c.Type, c.ExpiresAtTime()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	assert.Nil(t, repo.Save(c))
+}
+
+func Test_mysql_RevokeUser(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	repo := NewRepository(db)
+
+	mock.ExpectExec("UPDATE auth_tokens").WithArgs(5).WillReturnResult(sqlmock.NewResult(0, 3))
+
+	assert.Nil(t, repo.RevokeUser(5))
+}
+
+func Test_mysql_IsRevoked(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	repo := NewRepository(db)
+
+	mock.ExpectQuery("SELECT COUNT").WithArgs("abc").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+
+	revoked, err := repo.IsRevoked("abc")
+
+	assert.Nil(t, err)
+	assert.True(t, revoked)
+}
+
+func Test_mysql_Use(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	repo := NewRepository(db)
+
+	mock.ExpectExec("UPDATE auth_tokens").WithArgs("abc").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE auth_tokens").WithArgs("abc").WillReturnResult(sqlmock.NewResult(0, 0))
+
+	// The second use finds the token revoked by the first one
+	used, err := repo.Use("abc")
+	assert.Nil(t, err)
+	assert.True(t, used)
+
+	used, err = repo.Use("abc")
+	assert.Nil(t, err)
+	assert.False(t, used)
+}
+
+func Test_mysql_Use_Error(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	repo := NewRepository(db)
+	testErr := fmt.Errorf("test error")
+
+	mock.ExpectExec("UPDATE auth_tokens").WithArgs("abc").WillReturnError(testErr)
+
+	used, err := repo.Use("abc")
+
+	assert.False(t, used)
+	assert.Contains(t, err.Error(), testErr.Error())
+}
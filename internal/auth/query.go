@@ -0,0 +1,60 @@
+package auth
+
+import "time"
+
+const (
+	saveToken = iota
+	revokeToken
+	revokeUserTokens
+	isTokenRevoked
+	useToken
+)
+
+type Query struct {
+	SQL     string
+	Timeout time.Duration
+}
+
+var queryMap map[int]Query
+
+func init() {
+	queryMap = make(map[int]Query)
+
+	queryMap[saveToken] = Query{
+		SQL: `INSERT INTO auth_tokens (jti, user_id, token_type, expires_at)
+				VALUES (?, ?, ?, ?);`,
+		Timeout: 5 * time.Second,
+	}
+
+	queryMap[revokeToken] = Query{
+		SQL: `INSERT INTO auth_tokens (jti, user_id, token_type, expires_at, revoked_at)
+				VALUES (?, ?, ?, ?, UTC_TIMESTAMP())
+				ON DUPLICATE KEY UPDATE revoked_at = COALESCE(revoked_at, UTC_TIMESTAMP());`,
+		Timeout: 5 * time.Second,
+	}
+
+	queryMap[revokeUserTokens] = Query{
+		SQL: `UPDATE auth_tokens
+				SET revoked_at = UTC_TIMESTAMP()
+				WHERE user_id = ?
+				AND revoked_at IS NULL`,
+		Timeout: 10 * time.Second,
+	}
+
+	queryMap[isTokenRevoked] = Query{
+		SQL: `SELECT COUNT(*)
+				FROM auth_tokens
+				WHERE jti = ?
+				AND revoked_at IS NOT NULL`,
+		Timeout: 2 * time.Second,
+	}
+
+	queryMap[useToken] = Query{
+		SQL: `UPDATE auth_tokens
+				SET revoked_at = UTC_TIMESTAMP()
+				WHERE jti = ?
+				AND revoked_at IS NULL
+				AND expires_at > UTC_TIMESTAMP()`,
+		Timeout: 2 * time.Second,
+	}
+}
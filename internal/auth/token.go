@@ -0,0 +1,123 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	TokenTypeAccess  = "access"
+	TokenTypeRefresh = "refresh"
+)
+
+var (
+	ErrMalformedToken   = fmt.Errorf("malformed token")
+	ErrInvalidSignature = fmt.Errorf("invalid token signature")
+	ErrTokenExpired     = fmt.Errorf("token expired")
+	ErrTokenRevoked     = fmt.Errorf("token revoked")
+	ErrWrongTokenType   = fmt.Errorf("wrong token type")
+)
+
+// header is the only JOSE header we issue and accept.
+var header = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+type Claims struct {
+	ID        string `json:"jti"`
+	UserID    int    `json:"uid"`
+	Login     string `json:"login"`
+	Type      string `json:"typ"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+func (c *Claims) ExpiresAtTime() time.Time {
+	return time.Unix(c.ExpiresAt, 0).UTC()
+}
+
+// Pair is returned to the client on login and on refresh.
+type Pair struct {
+	AccessToken      string    `json:"accessToken"`
+	AccessExpiresAt  time.Time `json:"accessExpiresAt"`
+	RefreshToken     string    `json:"refreshToken"`
+	RefreshExpiresAt time.Time `json:"refreshExpiresAt"`
+	TokenType        string    `json:"tokenType"`
+}
+
+func sign(c Claims, secret []byte) (string, error) {
+	payload, err := json.Marshal(c)
+	if err != nil {
+		return "", fmt.Errorf("auth.sign - marshal claims: %v", err)
+	}
+
+	unsigned := header + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	return unsigned + "." + signature(unsigned, secret), nil
+}
+
+// parse checks the token signature and expiry and returns its claims.
+// Revocation is checked by the Manager.
+func parse(token string, secret []byte, now time.Time) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || parts[0] != header {
+		return nil, ErrMalformedToken
+	}
+
+	expected := signature(parts[0]+"."+parts[1], secret)
+	if !hmac.Equal([]byte(expected), []byte(parts[2])) {
+		return nil, ErrInvalidSignature
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrMalformedToken
+	}
+
+	var c Claims
+	if err := json.Unmarshal(payload, &c); err != nil {
+		return nil, ErrMalformedToken
+	}
+
+	if c.ID == "" || c.UserID <= 0 {
+		return nil, ErrMalformedToken
+	}
+
+	if now.Unix() >= c.ExpiresAt {
+		return nil, ErrTokenExpired
+	}
+
+	return &c, nil
+}
+
+func signature(unsigned string, secret []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(unsigned))
+
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func newTokenID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("auth.newTokenID: %v", err)
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+// IsInvalidToken reports whether err means the client presented a token
+// that can't be used, as opposed to an internal failure.
+func IsInvalidToken(err error) bool {
+	switch err {
+	case ErrMalformedToken, ErrInvalidSignature, ErrTokenExpired, ErrTokenRevoked, ErrWrongTokenType:
+		return true
+	}
+
+	return false
+}
@@ -8,7 +8,30 @@ type CacheWriter interface {
 	Write(k int, v interface{})
 }
 
+type CacheDeleter interface {
+	Delete(k int)
+}
+
+// CacheUpdater changes the value of the key atomically. fn returns the new
+// value, or false to keep the old one, and may be called more than once.
+type CacheUpdater interface {
+	Update(k int, fn func(v interface{}, ok bool) (interface{}, bool))
+}
+
 type Cache interface {
 	CacheReader
 	CacheWriter
+	CacheDeleter
+	CacheUpdater
+}
+
+// ListUpdater changes list values one item at a time instead of writing
+// them whole, see RedisCache. Items are single values as the Codec decodes
+// them.
+type ListUpdater interface {
+	// InsertItem puts the item before the first one cmp returns negative
+	// for, zero meaning it's there already. False if the list isn't cached
+	InsertItem(k int, item interface{}, cmp func(cached interface{}) int) bool
+	// RemoveItem drops the first cached item which match returns true for.
+	RemoveItem(k int, match func(cached interface{}) bool)
 }
@@ -1,31 +1,245 @@
 package cache
 
 import (
+	"container/list"
+	"log"
 	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/niklod/highload-social-network/config"
 )
 
+// defaultItemSize is the size of the values which aren't a Sizer
+const defaultItemSize = 64
+
+// Sizer is implemented by the values which know their approximate size in
+// bytes, like post.Feed.
+type Sizer interface {
+	Size() int
+}
+
+// Stats are the cache counters, Entries and Bytes are current values.
+type Stats struct {
+	Hits        uint64
+	Misses      uint64
+	Evictions   uint64
+	Expirations uint64
+	Entries     int
+	Bytes       int64
+}
+
+// FeedCache is an LRU cache sharded by key to cut lock contention. Each
+// shard evicts within its part of the entries and bytes budget.
 type FeedCache struct {
-	mu    sync.RWMutex
-	items map[int]interface{}
+	shards []*shard
+	ttl    time.Duration
+	now    func() time.Time
+
+	hits        uint64
+	misses      uint64
+	evictions   uint64
+	expirations uint64
+}
+
+type shard struct {
+	mu         sync.Mutex
+	items      map[int]*list.Element
+	order      *list.List // front is the most recently used
+	bytes      int64
+	maxEntries int
+	maxBytes   int64
 }
 
+type entry struct {
+	key     int
+	value   interface{}
+	size    int64
+	expires time.Time
+}
+
+var defaultConfig = config.FeedCacheConfig{
+	Shards:     16,
+	MaxEntries: 100000,
+	MaxBytes:   512 << 20,
+	TTL:        time.Hour,
+}
+
+// NewFeedCache creates the cache with the default limits.
 func NewFeedCache() Cache {
-	return &FeedCache{
-		items: make(map[int]interface{}),
+	return NewLRU(&defaultConfig)
+}
+
+// NewLRU creates the cache, zero MaxBytes or TTL means no such limit.
+func NewLRU(cfg *config.FeedCacheConfig) *FeedCache {
+	shards := cfg.Shards
+	if shards <= 0 {
+		shards = 1
+	}
+
+	maxEntries := cfg.MaxEntries / shards
+	if maxEntries <= 0 {
+		maxEntries = 1
+	}
+
+	c := &FeedCache{
+		shards: make([]*shard, shards),
+		ttl:    cfg.TTL,
+		now:    time.Now,
+	}
+
+	for i := range c.shards {
+		c.shards[i] = &shard{
+			items:      make(map[int]*list.Element),
+			order:      list.New(),
+			maxEntries: maxEntries,
+			maxBytes:   cfg.MaxBytes / int64(shards),
+		}
 	}
+
+	return c
+}
+
+func (f *FeedCache) shard(k int) *shard {
+	i := k % len(f.shards)
+	if i < 0 {
+		i = -i
+	}
+
+	return f.shards[i]
 }
 
 func (f *FeedCache) Read(k int) (interface{}, bool) {
-	f.mu.RLock()
-	defer f.mu.RUnlock()
+	s := f.shard(k)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	v, ok := f.items[k]
-	return v, ok
+	return f.get(s, k)
 }
 
+// get returns the live value of the key, the shard is locked
+func (f *FeedCache) get(s *shard, k int) (interface{}, bool) {
+	el, ok := s.items[k]
+	if !ok {
+		atomic.AddUint64(&f.misses, 1)
+		return nil, false
+	}
+
+	e := el.Value.(*entry)
+	if !e.expires.IsZero() && !f.now().Before(e.expires) {
+		s.remove(el)
+		atomic.AddUint64(&f.expirations, 1)
+		atomic.AddUint64(&f.misses, 1)
+		return nil, false
+	}
+
+	s.order.MoveToFront(el)
+	atomic.AddUint64(&f.hits, 1)
+
+	return e.value, true
+}
+
+// Write puts the value, evicting the shard's LRU entries over budget.
 func (f *FeedCache) Write(k int, v interface{}) {
-	f.mu.Lock()
-	defer f.mu.Unlock()
+	s := f.shard(k)
 
-	f.items[k] = v
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f.put(s, k, v)
+}
+
+// Update runs fn under the lock of the key's shard.
+func (f *FeedCache) Update(k int, fn func(v interface{}, ok bool) (interface{}, bool)) {
+	s := f.shard(k)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	v, ok := f.get(s, k)
+	if v, changed := fn(v, ok); changed {
+		f.put(s, k, v)
+	}
+}
+
+// put is Write with the shard locked
+func (f *FeedCache) put(s *shard, k int, v interface{}) {
+	e := &entry{key: k, value: v, size: sizeOf(v)}
+	if f.ttl > 0 {
+		e.expires = f.now().Add(f.ttl)
+	}
+
+	if el, ok := s.items[k]; ok {
+		s.remove(el)
+	}
+
+	if s.maxBytes > 0 && e.size > s.maxBytes {
+		atomic.AddUint64(&f.evictions, 1)
+		return
+	}
+
+	s.items[k] = s.order.PushFront(e)
+	s.bytes += e.size
+
+	for len(s.items) > s.maxEntries || (s.maxBytes > 0 && s.bytes > s.maxBytes) {
+		s.remove(s.order.Back())
+		atomic.AddUint64(&f.evictions, 1)
+	}
+}
+
+func (f *FeedCache) Delete(k int) {
+	s := f.shard(k)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if el, ok := s.items[k]; ok {
+		s.remove(el)
+	}
+}
+
+func (s *shard) remove(el *list.Element) {
+	e := s.order.Remove(el).(*entry)
+	delete(s.items, e.key)
+	s.bytes -= e.size
+}
+
+func sizeOf(v interface{}) int64 {
+	if s, ok := v.(Sizer); ok {
+		return int64(s.Size())
+	}
+
+	return defaultItemSize
+}
+
+func (f *FeedCache) Stats() Stats {
+	st := Stats{
+		Hits:        atomic.LoadUint64(&f.hits),
+		Misses:      atomic.LoadUint64(&f.misses),
+		Evictions:   atomic.LoadUint64(&f.evictions),
+		Expirations: atomic.LoadUint64(&f.expirations),
+	}
+
+	for _, s := range f.shards {
+		s.mu.Lock()
+		st.Entries += len(s.items)
+		st.Bytes += s.bytes
+		s.mu.Unlock()
+	}
+
+	return st
+}
+
+// RunStatsLog logs the stats every interval. It blocks, so run it in a
+// goroutine.
+func (f *FeedCache) RunStatsLog(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		st := f.Stats()
+		log.Printf("feed cache: %d entries, %d bytes, %d hits, %d misses, %d evictions, %d expirations",
+			st.Entries, st.Bytes, st.Hits, st.Misses, st.Evictions, st.Expirations)
+	}
 }
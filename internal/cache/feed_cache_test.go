@@ -1,9 +1,13 @@
 package cache
 
 import (
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+
+	"github.com/niklod/highload-social-network/config"
 )
 
 func TestFeedCache_WriteRead(t *testing.T) {
@@ -19,6 +23,53 @@ func TestFeedCache_WriteRead(t *testing.T) {
 	assert.Equal(t, v, testValue)
 }
 
+func TestFeedCache_Delete(t *testing.T) {
+	cache := NewLRU(&config.FeedCacheConfig{Shards: 1, MaxEntries: 10, MaxBytes: 1 << 20})
+
+	cache.Write(1, "value")
+	cache.Delete(1)
+	cache.Delete(2)
+
+	_, ok := cache.Read(1)
+	assert.False(t, ok)
+	assert.Equal(t, 0, cache.Stats().Entries)
+}
+
+func TestFeedCache_Update_Concurrent(t *testing.T) {
+	cache := NewFeedCache()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for j := 0; j < 20; j++ {
+				cache.Update(1, func(v interface{}, ok bool) (interface{}, bool) {
+					if !ok {
+						return 1, true
+					}
+
+					return v.(int) + 1, true
+				})
+			}
+		}()
+	}
+	wg.Wait()
+
+	v, ok := cache.Read(1)
+	assert.True(t, ok)
+	assert.Equal(t, 1000, v)
+
+	// Unchanged value is left as is
+	cache.Update(2, func(v interface{}, ok bool) (interface{}, bool) {
+		return nil, false
+	})
+
+	_, ok = cache.Read(2)
+	assert.False(t, ok)
+}
+
 func TestFeedCache_WriteRead_ReplacePrevValue(t *testing.T) {
 	cache := NewFeedCache()
 
@@ -56,3 +107,92 @@ func TestFeedCache_Read_EmptyResult(t *testing.T) {
 	assert.Equal(t, false, ok)
 	assert.Nil(t, v)
 }
+
+type sized int
+
+func (s sized) Size() int {
+	return int(s)
+}
+
+func TestFeedCache_EvictsLeastRecentlyUsed(t *testing.T) {
+	cache := NewLRU(&config.FeedCacheConfig{Shards: 1, MaxEntries: 2})
+
+	cache.Write(1, "first")
+	cache.Write(2, "second")
+
+	// Reading makes 1 the most recently used one
+	_, ok := cache.Read(1)
+	assert.True(t, ok)
+
+	cache.Write(3, "third")
+
+	_, ok = cache.Read(2)
+	assert.False(t, ok)
+	_, ok = cache.Read(1)
+	assert.True(t, ok)
+	_, ok = cache.Read(3)
+	assert.True(t, ok)
+
+	st := cache.Stats()
+	assert.Equal(t, uint64(1), st.Evictions)
+	assert.Equal(t, uint64(3), st.Hits)
+	assert.Equal(t, uint64(1), st.Misses)
+	assert.Equal(t, 2, st.Entries)
+}
+
+func TestFeedCache_EvictsAboveBytesBudget(t *testing.T) {
+	cache := NewLRU(&config.FeedCacheConfig{Shards: 1, MaxEntries: 100, MaxBytes: 100})
+
+	cache.Write(1, sized(40))
+	cache.Write(2, sized(40))
+	cache.Write(3, sized(40))
+
+	_, ok := cache.Read(1)
+	assert.False(t, ok)
+	assert.Equal(t, int64(80), cache.Stats().Bytes)
+
+	// Rewriting the key replaces its size
+	cache.Write(2, sized(10))
+	assert.Equal(t, int64(50), cache.Stats().Bytes)
+
+	// The value bigger than the budget isn't kept
+	cache.Write(4, sized(101))
+	_, ok = cache.Read(4)
+	assert.False(t, ok)
+	assert.Equal(t, 2, cache.Stats().Entries)
+	assert.Equal(t, uint64(2), cache.Stats().Evictions)
+}
+
+func TestFeedCache_ExpiresAfterTTL(t *testing.T) {
+	cache := NewLRU(&config.FeedCacheConfig{Shards: 1, MaxEntries: 10, TTL: time.Minute})
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+
+	cache.Write(1, "value")
+
+	now = now.Add(59 * time.Second)
+	_, ok := cache.Read(1)
+	assert.True(t, ok)
+
+	now = now.Add(time.Second)
+	_, ok = cache.Read(1)
+	assert.False(t, ok)
+
+	st := cache.Stats()
+	assert.Equal(t, uint64(1), st.Expirations)
+	assert.Equal(t, 0, st.Entries)
+}
+
+func TestFeedCache_ShardsKeepTheirBudget(t *testing.T) {
+	cache := NewLRU(&config.FeedCacheConfig{Shards: 4, MaxEntries: 8})
+
+	for k := 0; k < 100; k++ {
+		cache.Write(k, k)
+	}
+
+	assert.Equal(t, 8, cache.Stats().Entries)
+
+	v, ok := cache.Read(99)
+	assert.True(t, ok)
+	assert.Equal(t, 99, v)
+}
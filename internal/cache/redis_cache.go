@@ -0,0 +1,415 @@
+package cache
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/niklod/highload-social-network/internal/cache/resp"
+)
+
+// Feed cache backends, see config.FeedCacheConfig
+const (
+	BackendMemory = "memory"
+	BackendRedis  = "redis"
+)
+
+// updateRetries bounds the optimistic transaction attempts
+const updateRetries = 10
+
+// updateLocks is the number of lock stripes of the updates
+const updateLocks = 256
+
+// listHeader heads every list, so empty values are cached too
+var listHeader = []byte("feed:v1")
+
+// Codec converts the cached values to the list items and back, like
+// post.RefsCodec and post.PostCodec. The items are kept in order.
+type Codec interface {
+	Encode(v interface{}) ([][]byte, error)
+	Decode(items [][]byte) (interface{}, error)
+}
+
+// RedisCache keeps the values as lists capped at maxLen items in a Redis
+// compatible server shared by the app replicas. Server errors are logged
+// and taken for misses.
+type RedisCache struct {
+	client  *resp.Client
+	codec   Codec
+	prefix  string
+	maxLen  int
+	ttl     time.Duration
+	retries int
+
+	// locks serialize the updates of a key within the process, so only
+	// other replicas conflict
+	locks [updateLocks]sync.Mutex
+}
+
+func NewRedis(client *resp.Client, codec Codec, prefix string, maxLen int, ttl time.Duration) *RedisCache {
+	return &RedisCache{
+		client:  client,
+		codec:   codec,
+		prefix:  prefix,
+		maxLen:  maxLen,
+		ttl:     ttl,
+		retries: updateRetries,
+	}
+}
+
+func (r *RedisCache) key(k int) string {
+	return r.prefix + strconv.Itoa(k)
+}
+
+func (r *RedisCache) Read(k int) (interface{}, bool) {
+	reply, err := r.client.Do("LRANGE", r.key(k), 0, -1)
+	if err != nil {
+		log.Printf("redis cache, reading key %d: %v", k, err)
+		return nil, false
+	}
+
+	return r.decode(k, reply)
+}
+
+func (r *RedisCache) decode(k int, reply interface{}) (interface{}, bool) {
+	items, err := resp.Bulks(reply)
+	if err != nil {
+		log.Printf("redis cache, reading key %d: %v", k, err)
+		return nil, false
+	}
+	if len(items) == 0 || !bytes.Equal(items[0], listHeader) {
+		return nil, false
+	}
+
+	v, err := r.codec.Decode(items[1:])
+	if err != nil {
+		log.Printf("redis cache, decoding key %d: %v", k, err)
+		return nil, false
+	}
+
+	return v, true
+}
+
+// Write replaces the list in a transaction, the readers see either the
+// old or the new one.
+func (r *RedisCache) Write(k int, v interface{}) {
+	cmds, err := r.writeCommands(k, v)
+	if err != nil {
+		log.Printf("redis cache, encoding key %d: %v", k, err)
+		return
+	}
+
+	replies, err := r.client.Pipeline(cmds)
+	if err != nil {
+		log.Printf("redis cache, writing key %d: %v", k, err)
+		return
+	}
+
+	if err := firstError(replies); err != nil {
+		log.Printf("redis cache, writing key %d: %v", k, err)
+	}
+}
+
+// Update rewrites the list in a WATCH transaction, see transact.
+func (r *RedisCache) Update(k int, fn func(v interface{}, ok bool) (interface{}, bool)) {
+	r.transact(k, "updating", func(cn *resp.Conn) (bool, error) {
+		return r.update(cn, k, fn)
+	})
+}
+
+// InsertItem puts the item into the list with LINSERT, see ListUpdater.
+func (r *RedisCache) InsertItem(k int, item interface{}, cmp func(cached interface{}) int) bool {
+	items, err := r.codec.Encode(item)
+	if err == nil && len(items) != 1 {
+		err = fmt.Errorf("%d items instead of one", len(items))
+	}
+	if err != nil {
+		log.Printf("redis cache, encoding key %d: %v", k, err)
+		return false
+	}
+
+	cached := false
+	r.transact(k, "inserting into", func(cn *resp.Conn) (bool, error) {
+		var (
+			done bool
+			err  error
+		)
+		done, cached, err = r.insert(cn, k, items[0], cmp)
+		return done, err
+	})
+
+	return cached
+}
+
+// RemoveItem drops the item from the list with LREM, see ListUpdater.
+func (r *RedisCache) RemoveItem(k int, match func(cached interface{}) bool) {
+	r.transact(k, "removing from", func(cn *resp.Conn) (bool, error) {
+		return r.remove(cn, k, match)
+	})
+}
+
+// transact retries the optimistic transaction until it isn't aborted. The
+// key still busy after updateRetries is dropped to be read from DB.
+func (r *RedisCache) transact(k int, what string, attempt func(cn *resp.Conn) (bool, error)) {
+	mu := &r.locks[r.stripe(k)]
+	mu.Lock()
+	defer mu.Unlock()
+
+	for i := 0; i < r.retries; i++ {
+		done, err := r.attempt(attempt)
+		if err != nil {
+			log.Printf("redis cache, %s key %d: %v", what, k, err)
+			r.Delete(k)
+			return
+		}
+		if done {
+			return
+		}
+	}
+
+	log.Printf("redis cache, %s key %d: dropped after %d conflicts", what, k, r.retries)
+	r.Delete(k)
+}
+
+func (r *RedisCache) attempt(fn func(cn *resp.Conn) (bool, error)) (bool, error) {
+	cn, err := r.client.Conn()
+	if err != nil {
+		return false, err
+	}
+	defer cn.Close()
+
+	return fn(cn)
+}
+
+func (r *RedisCache) stripe(k int) int {
+	i := k % updateLocks
+	if i < 0 {
+		i = -i
+	}
+
+	return i
+}
+
+// update is one attempt of Update, false if it's aborted
+func (r *RedisCache) update(cn *resp.Conn, k int, fn func(v interface{}, ok bool) (interface{}, bool)) (bool, error) {
+	key := r.key(k)
+
+	replies, err := cn.Pipeline([][]interface{}{
+		{"WATCH", key},
+		{"LRANGE", key, 0, -1},
+	})
+	if err != nil {
+		return false, err
+	}
+	if err := firstError(replies); err != nil {
+		cn.Do("UNWATCH")
+		return false, err
+	}
+
+	v, ok := r.decode(k, replies[1])
+
+	v, changed := fn(v, ok)
+	if !changed {
+		_, err := cn.Do("UNWATCH")
+		return true, err
+	}
+
+	cmds, err := r.writeCommands(k, v)
+	if err != nil {
+		cn.Do("UNWATCH")
+		return false, err
+	}
+
+	return exec(cn, cmds)
+}
+
+// insert is one attempt of InsertItem, it reports whether the list is
+// cached too
+func (r *RedisCache) insert(cn *resp.Conn, k int, item []byte, cmp func(cached interface{}) int) (bool, bool, error) {
+	key := r.key(k)
+
+	head, err := r.watch(cn, key, 1)
+	if err != nil || head == nil {
+		return err == nil, false, err
+	}
+
+	// New items mostly go first, the rest of the list is read if not
+	i, found, err := r.position(head, cmp)
+	if err == nil && i == len(head) && !found && len(head) > 0 {
+		if head, err = r.watched(cn, key, -1); err == nil {
+			i, found, err = r.position(head, cmp)
+		}
+	}
+	if err != nil || found || (r.maxLen > 0 && i >= r.maxLen) {
+		cn.Do("UNWATCH")
+		return err == nil, true, err
+	}
+
+	insert := []interface{}{"RPUSH", key, item}
+	switch {
+	case i == 0:
+		insert = []interface{}{"LINSERT", key, "AFTER", listHeader, item}
+	case i < len(head):
+		insert = []interface{}{"LINSERT", key, "BEFORE", head[i], item}
+	}
+
+	cmds := [][]interface{}{{"MULTI"}, insert}
+	if r.maxLen > 0 {
+		cmds = append(cmds, []interface{}{"LTRIM", key, 0, r.maxLen})
+	}
+
+	done, err := exec(cn, r.withTTL(key, cmds))
+
+	return done, true, err
+}
+
+// remove is one attempt of RemoveItem
+func (r *RedisCache) remove(cn *resp.Conn, k int, match func(cached interface{}) bool) (bool, error) {
+	key := r.key(k)
+
+	items, err := r.watch(cn, key, -1)
+	if err != nil || items == nil {
+		return err == nil, err
+	}
+
+	for _, item := range items {
+		v, err := r.codec.Decode([][]byte{item})
+		if err != nil {
+			cn.Do("UNWATCH")
+			return false, err
+		}
+		if !match(v) {
+			continue
+		}
+
+		return exec(cn, [][]interface{}{{"MULTI"}, {"LREM", key, 1, item}, {"EXEC"}})
+	}
+
+	_, err = cn.Do("UNWATCH")
+	return true, err
+}
+
+// watch watches the key and returns the list items after the header up to
+// the LRANGE stop, nil and unwatched if the list isn't cached.
+func (r *RedisCache) watch(cn *resp.Conn, key string, stop int) ([][]byte, error) {
+	if _, err := cn.Do("WATCH", key); err != nil {
+		return nil, err
+	}
+
+	items, err := r.watched(cn, key, stop)
+	if err != nil || items == nil {
+		cn.Do("UNWATCH")
+	}
+
+	return items, err
+}
+
+func (r *RedisCache) watched(cn *resp.Conn, key string, stop int) ([][]byte, error) {
+	reply, err := cn.Do("LRANGE", key, 0, stop)
+	if err != nil {
+		return nil, err
+	}
+
+	items, err := resp.Bulks(reply)
+	if err != nil {
+		return nil, err
+	}
+	if len(items) == 0 || !bytes.Equal(items[0], listHeader) {
+		return nil, nil
+	}
+
+	return items[1:], nil
+}
+
+// position returns the index of the first item the new one goes before,
+// the length if none, and whether the new one is among the items already
+func (r *RedisCache) position(items [][]byte, cmp func(cached interface{}) int) (int, bool, error) {
+	for i, item := range items {
+		v, err := r.codec.Decode([][]byte{item})
+		if err != nil {
+			return 0, false, err
+		}
+
+		switch c := cmp(v); {
+		case c == 0:
+			return i, true, nil
+		case c < 0:
+			return i, false, nil
+		}
+	}
+
+	return len(items), false, nil
+}
+
+// exec sends the transaction, false if it's aborted
+func exec(cn *resp.Conn, cmds [][]interface{}) (bool, error) {
+	replies, err := cn.Pipeline(cmds)
+	if err != nil {
+		return false, err
+	}
+	if err := firstError(replies); err != nil {
+		return false, err
+	}
+
+	// EXEC replies null to an aborted transaction
+	return replies[len(replies)-1] != nil, nil
+}
+
+// writeCommands returns the transaction replacing the list with the value
+func (r *RedisCache) writeCommands(k int, v interface{}) ([][]interface{}, error) {
+	items, err := r.codec.Encode(v)
+	if err != nil {
+		return nil, err
+	}
+	if r.maxLen > 0 && len(items) > r.maxLen {
+		items = items[:r.maxLen]
+	}
+
+	key := r.key(k)
+
+	push := make([]interface{}, 0, len(items)+3)
+	push = append(push, "RPUSH", key, listHeader)
+	for _, item := range items {
+		push = append(push, item)
+	}
+
+	return r.withTTL(key, [][]interface{}{
+		{"MULTI"},
+		{"DEL", key},
+		push,
+	}), nil
+}
+
+// withTTL ends the transaction, expiring the list ttl after the change
+func (r *RedisCache) withTTL(key string, cmds [][]interface{}) [][]interface{} {
+	if r.ttl > 0 {
+		cmds = append(cmds, []interface{}{"EXPIRE", key, int(r.ttl / time.Second)})
+	}
+
+	return append(cmds, []interface{}{"EXEC"})
+}
+
+// firstError returns the first error reply, the replies of EXEC included
+func firstError(replies []interface{}) error {
+	for _, reply := range replies {
+		switch v := reply.(type) {
+		case error:
+			return v
+		case []interface{}:
+			if err := firstError(v); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (r *RedisCache) Delete(k int) {
+	if _, err := r.client.Do("DEL", r.key(k)); err != nil {
+		log.Printf("redis cache, deleting key %d: %v", k, err)
+	}
+}
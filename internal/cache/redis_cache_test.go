@@ -0,0 +1,237 @@
+package cache
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/niklod/highload-social-network/config"
+	"github.com/niklod/highload-social-network/internal/cache/resp"
+)
+
+type stringsCodec struct{}
+
+func (stringsCodec) Encode(v interface{}) ([][]byte, error) {
+	items, ok := v.([]string)
+	if !ok {
+		return nil, fmt.Errorf("%T isn't []string", v)
+	}
+
+	res := make([][]byte, len(items))
+	for i, item := range items {
+		res[i] = []byte(item)
+	}
+
+	return res, nil
+}
+
+func (stringsCodec) Decode(items [][]byte) (interface{}, error) {
+	res := make([]string, len(items))
+	for i, item := range items {
+		res[i] = string(item)
+	}
+
+	return res, nil
+}
+
+func newTestRedis(t *testing.T, maxLen int, ttl time.Duration) (*RedisCache, *resp.Server) {
+	srv, err := resp.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := resp.NewClient(&config.RedisConfig{Addr: srv.Addr(), PoolSize: 2, Timeout: time.Second})
+
+	t.Cleanup(func() {
+		client.Close()
+		srv.Close()
+	})
+
+	return NewRedis(client, stringsCodec{}, "feed:", maxLen, ttl), srv
+}
+
+func TestRedisCache_WriteRead(t *testing.T) {
+	cache, _ := newTestRedis(t, 10, 0)
+
+	_, ok := cache.Read(1)
+	assert.False(t, ok)
+
+	cache.Write(1, []string{"new", "old"})
+	cache.Write(1, []string{"newer", "new", "old"})
+
+	v, ok := cache.Read(1)
+	assert.True(t, ok)
+	assert.Equal(t, []string{"newer", "new", "old"}, v)
+
+	// The empty value is a hit, not a miss
+	cache.Write(2, []string{})
+
+	v, ok = cache.Read(2)
+	assert.True(t, ok)
+	assert.Equal(t, []string{}, v)
+}
+
+func TestRedisCache_Delete(t *testing.T) {
+	cache, _ := newTestRedis(t, 10, 0)
+
+	cache.Write(1, []string{"a"})
+	cache.Delete(1)
+
+	_, ok := cache.Read(1)
+	assert.False(t, ok)
+}
+
+func TestRedisCache_Update_Concurrent(t *testing.T) {
+	cache, _ := newTestRedis(t, 1000, time.Minute)
+
+	// Two replicas conflict in the transactions, much more than the feeds
+	// do
+	replicas := []*RedisCache{cache, NewRedis(cache.client, stringsCodec{}, "feed:", 1000, time.Minute)}
+	for _, r := range replicas {
+		r.retries = 1000
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			for j := 0; j < 10; j++ {
+				item := strconv.Itoa(i*10 + j)
+				replicas[i%2].Update(1, func(v interface{}, ok bool) (interface{}, bool) {
+					var items []string
+					if ok {
+						items = v.([]string)
+					}
+
+					return append([]string{item}, items...), true
+				})
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	v, ok := cache.Read(1)
+	assert.True(t, ok)
+	assert.Len(t, v, 40)
+}
+
+func TestRedisCache_Update_DropsBusyKey(t *testing.T) {
+	cache, _ := newTestRedis(t, 10, 0)
+	cache.retries = 2
+
+	cache.Write(1, []string{"a"})
+
+	// Every attempt conflicts with a write of another replica
+	cache.Update(1, func(v interface{}, ok bool) (interface{}, bool) {
+		cache.client.Do("EXPIRE", "feed:1", 60)
+		return []string{"b"}, true
+	})
+
+	_, ok := cache.Read(1)
+	assert.False(t, ok)
+}
+
+func TestRedisCache_CapsList(t *testing.T) {
+	cache, _ := newTestRedis(t, 3, 0)
+
+	cache.Write(1, strings.Split("a b c d e", " "))
+
+	v, ok := cache.Read(1)
+	assert.True(t, ok)
+	assert.Equal(t, []string{"a", "b", "c"}, v)
+}
+
+func TestRedisCache_InsertItem(t *testing.T) {
+	cache, _ := newTestRedis(t, 4, 0)
+
+	// The items are kept in the descending order
+	insert := func(item string) bool {
+		return cache.InsertItem(1, []string{item}, func(v interface{}) int {
+			return strings.Compare(v.([]string)[0], item)
+		})
+	}
+
+	assert.False(t, insert("a"))
+
+	cache.Write(1, []string{})
+	assert.True(t, insert("c"))
+	assert.True(t, insert("e"))
+	assert.True(t, insert("a"))
+	assert.True(t, insert("d"))
+	assert.True(t, insert("d"))
+	assert.True(t, insert("b"))
+
+	v, ok := cache.Read(1)
+	assert.True(t, ok)
+	assert.Equal(t, []string{"e", "d", "c", "b"}, v)
+
+	// The item older than the capped list is left out
+	assert.True(t, insert("a"))
+
+	v, _ = cache.Read(1)
+	assert.Equal(t, []string{"e", "d", "c", "b"}, v)
+}
+
+func TestRedisCache_RemoveItem(t *testing.T) {
+	cache, _ := newTestRedis(t, 10, 0)
+
+	remove := func(item string) {
+		cache.RemoveItem(1, func(v interface{}) bool {
+			return v.([]string)[0] == item
+		})
+	}
+
+	remove("a")
+	_, ok := cache.Read(1)
+	assert.False(t, ok)
+
+	cache.Write(1, []string{"c", "b", "a"})
+	remove("b")
+	remove("x")
+
+	v, ok := cache.Read(1)
+	assert.True(t, ok)
+	assert.Equal(t, []string{"c", "a"}, v)
+
+	// The emptied list is cached still
+	remove("c")
+	remove("a")
+
+	v, ok = cache.Read(1)
+	assert.True(t, ok)
+	assert.Equal(t, []string{}, v)
+}
+
+func TestRedisCache_ExpiresAfterTTL(t *testing.T) {
+	cache, srv := newTestRedis(t, 10, time.Minute)
+
+	cache.Write(1, []string{"a"})
+
+	srv.Advance(59 * time.Second)
+	_, ok := cache.Read(1)
+	assert.True(t, ok)
+
+	srv.Advance(time.Minute)
+	_, ok = cache.Read(1)
+	assert.False(t, ok)
+}
+
+func TestRedisCache_ServerDownIsMiss(t *testing.T) {
+	cache, srv := newTestRedis(t, 10, 0)
+	cache.Write(1, []string{"a"})
+
+	cache.client.Close()
+	srv.Close()
+
+	cache.Write(1, []string{"b"})
+	_, ok := cache.Read(1)
+
+	assert.False(t, ok)
+}
@@ -0,0 +1,345 @@
+// Package resp is a minimal Redis (RESP2) client and an in-memory test
+// server.
+package resp
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/niklod/highload-social-network/config"
+)
+
+// Error is the error reply of the server
+type Error string
+
+func (e Error) Error() string {
+	return "resp: " + string(e)
+}
+
+// Client sends the commands over a pool of connections. Replies are
+// []byte, string, int64, []interface{} or nil.
+type Client struct {
+	addr     string
+	password string
+	db       int
+	timeout  time.Duration
+	idle     chan *conn
+}
+
+type conn struct {
+	net.Conn
+	r *bufio.Reader
+	w *bufio.Writer
+}
+
+func NewClient(cfg *config.RedisConfig) *Client {
+	poolSize := cfg.PoolSize
+	if poolSize <= 0 {
+		poolSize = 1
+	}
+
+	return &Client{
+		addr:     cfg.Addr,
+		password: cfg.Password,
+		db:       cfg.DB,
+		timeout:  cfg.Timeout,
+		idle:     make(chan *conn, poolSize),
+	}
+}
+
+// Do sends the command and returns its reply, the arguments are strings,
+// []byte or ints.
+func (c *Client) Do(args ...interface{}) (interface{}, error) {
+	replies, err := c.Pipeline([][]interface{}{args})
+	if err != nil {
+		return nil, err
+	}
+
+	if err, ok := replies[0].(error); ok {
+		return nil, err
+	}
+
+	return replies[0], nil
+}
+
+// Pipeline sends the commands at once and returns their replies, the
+// error replies are Error values among them.
+func (c *Client) Pipeline(cmds [][]interface{}) ([]interface{}, error) {
+	cn, err := c.get()
+	if err != nil {
+		return nil, err
+	}
+
+	replies, err := cn.pipeline(cmds, c.timeout)
+	if err != nil {
+		cn.Close()
+		return nil, err
+	}
+
+	c.put(cn)
+
+	return replies, nil
+}
+
+// Conn is a pooled connection for commands like WATCH and MULTI that need
+// the same one.
+type Conn struct {
+	client *Client
+	cn     *conn
+	err    error
+}
+
+// Conn takes a connection out of the pool, Close puts it back.
+func (c *Client) Conn() (*Conn, error) {
+	cn, err := c.get()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Conn{client: c, cn: cn}, nil
+}
+
+// Do is Client.Do on the connection.
+func (c *Conn) Do(args ...interface{}) (interface{}, error) {
+	replies, err := c.Pipeline([][]interface{}{args})
+	if err != nil {
+		return nil, err
+	}
+
+	if err, ok := replies[0].(error); ok {
+		return nil, err
+	}
+
+	return replies[0], nil
+}
+
+// Pipeline is Client.Pipeline on the connection.
+func (c *Conn) Pipeline(cmds [][]interface{}) ([]interface{}, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+
+	replies, err := c.cn.pipeline(cmds, c.client.timeout)
+	if err != nil {
+		c.err = err
+		return nil, err
+	}
+
+	return replies, nil
+}
+
+// Close puts the connection back to the pool, the broken one is closed.
+func (c *Conn) Close() error {
+	if c.err != nil {
+		return c.cn.Close()
+	}
+
+	c.client.put(c.cn)
+
+	return nil
+}
+
+// Close closes the idle connections.
+func (c *Client) Close() error {
+	for {
+		select {
+		case cn := <-c.idle:
+			cn.Close()
+		default:
+			return nil
+		}
+	}
+}
+
+func (c *Client) get() (*conn, error) {
+	select {
+	case cn := <-c.idle:
+		return cn, nil
+	default:
+	}
+
+	nc, err := net.DialTimeout("tcp", c.addr, c.timeout)
+	if err != nil {
+		return nil, fmt.Errorf("resp: connecting to %s: %v", c.addr, err)
+	}
+
+	cn := &conn{Conn: nc, r: bufio.NewReader(nc), w: bufio.NewWriter(nc)}
+
+	var setup [][]interface{}
+	if c.password != "" {
+		setup = append(setup, []interface{}{"AUTH", c.password})
+	}
+	if c.db != 0 {
+		setup = append(setup, []interface{}{"SELECT", c.db})
+	}
+	if len(setup) == 0 {
+		return cn, nil
+	}
+
+	replies, err := cn.pipeline(setup, c.timeout)
+	if err == nil {
+		for _, r := range replies {
+			if e, ok := r.(error); ok {
+				err = e
+				break
+			}
+		}
+	}
+	if err != nil {
+		cn.Close()
+		return nil, fmt.Errorf("resp: setting up connection: %v", err)
+	}
+
+	return cn, nil
+}
+
+func (c *Client) put(cn *conn) {
+	select {
+	case c.idle <- cn:
+	default:
+		cn.Close()
+	}
+}
+
+func (cn *conn) pipeline(cmds [][]interface{}, timeout time.Duration) ([]interface{}, error) {
+	if timeout > 0 {
+		if err := cn.SetDeadline(time.Now().Add(timeout)); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, args := range cmds {
+		if err := writeCommand(cn.w, args); err != nil {
+			return nil, err
+		}
+	}
+	if err := cn.w.Flush(); err != nil {
+		return nil, fmt.Errorf("resp: sending commands: %v", err)
+	}
+
+	replies := make([]interface{}, len(cmds))
+	for i := range cmds {
+		r, err := readReply(cn.r)
+		if err != nil {
+			return nil, fmt.Errorf("resp: reading reply: %v", err)
+		}
+		replies[i] = r
+	}
+
+	return replies, nil
+}
+
+func writeCommand(w *bufio.Writer, args []interface{}) error {
+	w.WriteString("*" + strconv.Itoa(len(args)) + "\r\n")
+
+	for _, a := range args {
+		var b []byte
+		switch v := a.(type) {
+		case string:
+			b = []byte(v)
+		case []byte:
+			b = v
+		case int:
+			b = []byte(strconv.Itoa(v))
+		case int64:
+			b = []byte(strconv.FormatInt(v, 10))
+		default:
+			return fmt.Errorf("resp: unsupported argument type %T", a)
+		}
+
+		writeBulk(w, b)
+	}
+
+	return nil
+}
+
+func writeBulk(w *bufio.Writer, b []byte) {
+	w.WriteString("$" + strconv.Itoa(len(b)) + "\r\n")
+	w.Write(b)
+	w.WriteString("\r\n")
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return "", fmt.Errorf("malformed line %q", line)
+	}
+
+	return line[:len(line)-2], nil
+}
+
+func readReply(r *bufio.Reader) (interface{}, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return Error(line[1:]), nil
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+
+		b := make([]byte, n+2)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, err
+		}
+
+		return b[:n], nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+
+		items := make([]interface{}, n)
+		for i := range items {
+			if items[i], err = readReply(r); err != nil {
+				return nil, err
+			}
+		}
+
+		return items, nil
+	}
+
+	return nil, fmt.Errorf("unknown reply type %q", line[0])
+}
+
+// Bulks converts the array reply of bulk strings.
+func Bulks(reply interface{}) ([][]byte, error) {
+	items, ok := reply.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("resp: %T isn't an array reply", reply)
+	}
+
+	res := make([][]byte, len(items))
+	for i, item := range items {
+		b, ok := item.([]byte)
+		if !ok {
+			return nil, fmt.Errorf("resp: %T isn't a bulk string", item)
+		}
+		res[i] = b
+	}
+
+	return res, nil
+}
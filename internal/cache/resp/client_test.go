@@ -0,0 +1,123 @@
+package resp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/niklod/highload-social-network/config"
+)
+
+func newTestClient(t *testing.T) (*Client, *Server) {
+	srv, err := NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := NewClient(&config.RedisConfig{Addr: srv.Addr(), PoolSize: 2, Timeout: time.Second})
+
+	t.Cleanup(func() {
+		client.Close()
+		srv.Close()
+	})
+
+	return client, srv
+}
+
+func TestClient_Do(t *testing.T) {
+	client, _ := newTestClient(t)
+
+	reply, err := client.Do("SET", "key", "value")
+	assert.Nil(t, err)
+	assert.Equal(t, "OK", reply)
+
+	reply, err = client.Do("GET", "key")
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value"), reply)
+
+	reply, err = client.Do("GET", "missing")
+	assert.Nil(t, err)
+	assert.Nil(t, reply)
+
+	_, err = client.Do("RPUSH", "key", "item")
+	assert.Equal(t, Error("WRONGTYPE Operation against a key holding the wrong kind of value"), err)
+}
+
+func TestClient_Lists(t *testing.T) {
+	client, _ := newTestClient(t)
+
+	reply, err := client.Do("RPUSH", "list", "a", []byte("b"), 3)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3), reply)
+
+	_, err = client.Do("LTRIM", "list", 0, 1)
+	assert.Nil(t, err)
+
+	reply, err = client.Do("LRANGE", "list", 0, -1)
+	assert.Nil(t, err)
+
+	items, err := Bulks(reply)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("a"), []byte("b")}, items)
+}
+
+func TestClient_PipelineTransaction(t *testing.T) {
+	client, srv := newTestClient(t)
+
+	replies, err := client.Pipeline([][]interface{}{
+		{"MULTI"},
+		{"RPUSH", "list", "a"},
+		{"EXPIRE", "list", 10},
+		{"EXEC"},
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, []interface{}{"OK", "QUEUED", "QUEUED", []interface{}{int64(1), int64(1)}}, replies)
+
+	srv.Advance(10 * time.Second)
+
+	reply, err := client.Do("LLEN", "list")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), reply)
+}
+
+func TestConn_WatchAbortsChangedKey(t *testing.T) {
+	client, _ := newTestClient(t)
+
+	cn, err := client.Conn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cn.Close()
+
+	_, err = cn.Do("WATCH", "key")
+	assert.Nil(t, err)
+
+	// Another connection changes the key meanwhile
+	_, err = client.Do("SET", "key", "other")
+	assert.Nil(t, err)
+
+	replies, err := cn.Pipeline([][]interface{}{{"MULTI"}, {"SET", "key", "mine"}, {"EXEC"}})
+	assert.Nil(t, err)
+	assert.Nil(t, replies[2])
+
+	reply, err := client.Do("GET", "key")
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("other"), reply)
+
+	// EXEC drops the watch, the next transaction goes through
+	replies, err = cn.Pipeline([][]interface{}{{"MULTI"}, {"SET", "key", "mine"}, {"EXEC"}})
+	assert.Nil(t, err)
+	assert.Equal(t, []interface{}{"OK"}, replies[2])
+}
+
+func TestClient_ServerDown(t *testing.T) {
+	client, srv := newTestClient(t)
+	client.Close()
+	srv.Close()
+
+	_, err := client.Do("PING")
+
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,463 @@
+package resp
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Server is an in-memory Redis stand-in for the tests, with the commands
+// the cache uses.
+type Server struct {
+	ln net.Listener
+
+	mu   sync.Mutex
+	data map[string]*value
+	now  func() time.Time
+	// versions count the writes of the keys for WATCH
+	versions map[string]uint64
+
+	wg sync.WaitGroup
+}
+
+type value struct {
+	str     []byte
+	list    [][]byte
+	isList  bool
+	expires time.Time
+}
+
+// NewServer starts the server on a random local port.
+func NewServer() (*Server, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("resp: starting server: %v", err)
+	}
+
+	s := &Server{
+		ln:       ln,
+		data:     make(map[string]*value),
+		now:      time.Now,
+		versions: make(map[string]uint64),
+	}
+
+	s.wg.Add(1)
+	go s.serve()
+
+	return s, nil
+}
+
+func (s *Server) Addr() string {
+	return s.ln.Addr().String()
+}
+
+// Close stops accepting connections.
+func (s *Server) Close() error {
+	err := s.ln.Close()
+	s.wg.Wait()
+
+	return err
+}
+
+// Advance moves the clock of the server for the expiration.
+func (s *Server) Advance(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	s.now = func() time.Time { return now.Add(d) }
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+
+	for {
+		nc, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+
+		go s.handle(nc)
+	}
+}
+
+func (s *Server) handle(nc net.Conn) {
+	defer nc.Close()
+
+	r := bufio.NewReader(nc)
+	w := bufio.NewWriter(nc)
+
+	var queued [][][]byte
+	inMulti := false
+
+	// The versions of the watched keys, EXEC fails if any has changed
+	var watched map[string]uint64
+
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+
+		name := strings.ToUpper(string(args[0]))
+
+		var reply interface{}
+		switch {
+		case name == "WATCH" && !inMulti:
+			if len(args) < 2 {
+				reply = Error("ERR wrong number of arguments for 'watch' command")
+				break
+			}
+
+			if watched == nil {
+				watched = make(map[string]uint64)
+			}
+
+			s.mu.Lock()
+			for _, k := range args[1:] {
+				s.lookup(k)
+				watched[string(k)] = s.versions[string(k)]
+			}
+			s.mu.Unlock()
+
+			reply = "OK"
+		case name == "UNWATCH":
+			watched = nil
+			reply = "OK"
+		case name == "MULTI":
+			inMulti, queued = true, nil
+			reply = "OK"
+		case name == "DISCARD":
+			inMulti, queued, watched = false, nil, nil
+			reply = "OK"
+		case name == "EXEC":
+			if !inMulti {
+				reply = Error("ERR EXEC without MULTI")
+				break
+			}
+
+			s.mu.Lock()
+			if s.changed(watched) {
+				// The null array, the transaction is aborted
+				reply = nil
+			} else {
+				replies := make([]interface{}, len(queued))
+				for i, cmd := range queued {
+					replies[i] = s.exec(cmd)
+				}
+				reply = replies
+			}
+			s.mu.Unlock()
+
+			inMulti, queued, watched = false, nil, nil
+		case inMulti:
+			queued = append(queued, args)
+			reply = "QUEUED"
+		default:
+			s.mu.Lock()
+			reply = s.exec(args)
+			s.mu.Unlock()
+		}
+
+		writeReply(w, reply)
+		if r.Buffered() == 0 {
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// exec runs the command under the lock
+func (s *Server) exec(args [][]byte) interface{} {
+	name := strings.ToUpper(string(args[0]))
+	args = args[1:]
+
+	arity := map[string]int{
+		"PING": 0, "AUTH": 1, "SELECT": 1, "GET": 1, "SET": 2, "DEL": 1, "EXISTS": 1,
+		"RPUSH": 2, "LPUSH": 2, "LRANGE": 3, "LTRIM": 3, "LLEN": 1, "EXPIRE": 2, "TTL": 1,
+		"LINSERT": 4, "LREM": 3,
+	}
+	min, ok := arity[name]
+	if !ok {
+		return Error(fmt.Sprintf("ERR unknown command '%s'", name))
+	}
+	if len(args) < min {
+		return Error(fmt.Sprintf("ERR wrong number of arguments for '%s' command", name))
+	}
+
+	switch name {
+	case "SET", "RPUSH", "LPUSH", "LTRIM", "EXPIRE", "LINSERT", "LREM":
+		s.versions[string(args[0])]++
+	case "DEL":
+		for _, k := range args {
+			s.versions[string(k)]++
+		}
+	}
+
+	switch name {
+	case "PING":
+		return "PONG"
+	case "AUTH", "SELECT":
+		return "OK"
+	case "GET":
+		v, err := s.get(args[0], false)
+		if err != nil || v == nil {
+			return errOrNil(err)
+		}
+		return v.str
+	case "SET":
+		s.data[string(args[0])] = &value{str: args[1]}
+		return "OK"
+	case "DEL", "EXISTS":
+		var n int64
+		for _, k := range args {
+			if s.lookup(k) == nil {
+				continue
+			}
+			n++
+			if name == "DEL" {
+				delete(s.data, string(k))
+			}
+		}
+		return n
+	case "RPUSH", "LPUSH":
+		v, err := s.get(args[0], true)
+		if err != nil {
+			return err
+		}
+		if v == nil {
+			v = &value{isList: true}
+			s.data[string(args[0])] = v
+		}
+		for _, item := range args[1:] {
+			if name == "RPUSH" {
+				v.list = append(v.list, item)
+			} else {
+				v.list = append([][]byte{item}, v.list...)
+			}
+		}
+		return int64(len(v.list))
+	case "LRANGE", "LTRIM":
+		v, err := s.get(args[0], true)
+		if err != nil {
+			return err
+		}
+		start, err1 := strconv.Atoi(string(args[1]))
+		stop, err2 := strconv.Atoi(string(args[2]))
+		if err1 != nil || err2 != nil {
+			return Error("ERR value is not an integer or out of range")
+		}
+
+		var list [][]byte
+		if v != nil {
+			list = v.list
+		}
+		from, to := listRange(len(list), start, stop)
+
+		if name == "LTRIM" {
+			if v != nil {
+				v.list = append([][]byte{}, list[from:to]...)
+				if len(v.list) == 0 {
+					delete(s.data, string(args[0]))
+				}
+			}
+			return "OK"
+		}
+
+		items := make([]interface{}, 0, to-from)
+		for _, item := range list[from:to] {
+			items = append(items, item)
+		}
+		return items
+	case "LINSERT":
+		v, err := s.get(args[0], true)
+		if err != nil || v == nil {
+			if err != nil {
+				return err
+			}
+			return int64(0)
+		}
+		where := strings.ToUpper(string(args[1]))
+		if where != "BEFORE" && where != "AFTER" {
+			return Error("ERR syntax error")
+		}
+		for i, item := range v.list {
+			if !bytes.Equal(item, args[2]) {
+				continue
+			}
+			if where == "AFTER" {
+				i++
+			}
+			list := make([][]byte, 0, len(v.list)+1)
+			list = append(list, v.list[:i]...)
+			list = append(list, args[3])
+			v.list = append(list, v.list[i:]...)
+			return int64(len(v.list))
+		}
+		return int64(-1)
+	case "LREM":
+		v, err := s.get(args[0], true)
+		if err != nil || v == nil {
+			if err != nil {
+				return err
+			}
+			return int64(0)
+		}
+		count, err := strconv.Atoi(string(args[1]))
+		if err != nil || count < 0 {
+			// Removing from the tail isn't needed
+			return Error("ERR value is not an integer or out of range")
+		}
+		var removed int64
+		list := v.list[:0]
+		for _, item := range v.list {
+			if bytes.Equal(item, args[2]) && (count == 0 || removed < int64(count)) {
+				removed++
+				continue
+			}
+			list = append(list, item)
+		}
+		v.list = list
+		if len(v.list) == 0 {
+			delete(s.data, string(args[0]))
+		}
+		return removed
+	case "LLEN":
+		v, err := s.get(args[0], true)
+		if err != nil || v == nil {
+			if err != nil {
+				return err
+			}
+			return int64(0)
+		}
+		return int64(len(v.list))
+	case "EXPIRE":
+		sec, err := strconv.Atoi(string(args[1]))
+		if err != nil {
+			return Error("ERR value is not an integer or out of range")
+		}
+		v := s.lookup(args[0])
+		if v == nil {
+			return int64(0)
+		}
+		v.expires = s.now().Add(time.Duration(sec) * time.Second)
+		return int64(1)
+	case "TTL":
+		v := s.lookup(args[0])
+		if v == nil {
+			return int64(-2)
+		}
+		if v.expires.IsZero() {
+			return int64(-1)
+		}
+		return int64(v.expires.Sub(s.now()).Seconds())
+	}
+
+	return Error("ERR unknown command")
+}
+
+// changed tells whether any of the watched keys is written after WATCH
+func (s *Server) changed(watched map[string]uint64) bool {
+	for k, version := range watched {
+		s.lookup([]byte(k))
+		if s.versions[k] != version {
+			return true
+		}
+	}
+
+	return false
+}
+
+// lookup returns the live value of the key, dropping the expired one
+func (s *Server) lookup(k []byte) *value {
+	v, ok := s.data[string(k)]
+	if !ok {
+		return nil
+	}
+	if !v.expires.IsZero() && !s.now().Before(v.expires) {
+		delete(s.data, string(k))
+		s.versions[string(k)]++
+		return nil
+	}
+
+	return v
+}
+
+func (s *Server) get(k []byte, list bool) (*value, error) {
+	v := s.lookup(k)
+	if v != nil && v.isList != list {
+		return nil, Error("WRONGTYPE Operation against a key holding the wrong kind of value")
+	}
+
+	return v, nil
+}
+
+func errOrNil(err error) interface{} {
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// listRange converts inclusive, possibly negative, Redis indexes to slice
+// bounds
+func listRange(n, start, stop int) (int, int) {
+	if start < 0 {
+		start += n
+	}
+	if stop < 0 {
+		stop += n
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= n {
+		stop = n - 1
+	}
+	if start > stop || start >= n {
+		return 0, 0
+	}
+
+	return start, stop + 1
+}
+
+func readCommand(r *bufio.Reader) ([][]byte, error) {
+	reply, err := readReply(r)
+	if err != nil {
+		return nil, err
+	}
+
+	args, err := Bulks(reply)
+	if err != nil || len(args) == 0 {
+		return nil, fmt.Errorf("resp: malformed command")
+	}
+
+	return args, nil
+}
+
+func writeReply(w *bufio.Writer, reply interface{}) {
+	switch v := reply.(type) {
+	case nil:
+		w.WriteString("$-1\r\n")
+	case string:
+		w.WriteString("+" + v + "\r\n")
+	case Error:
+		w.WriteString("-" + string(v) + "\r\n")
+	case int64:
+		w.WriteString(":" + strconv.FormatInt(v, 10) + "\r\n")
+	case []byte:
+		writeBulk(w, v)
+	case []interface{}:
+		w.WriteString("*" + strconv.Itoa(len(v)) + "\r\n")
+		for _, item := range v {
+			writeReply(w, item)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package cluster
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+const healthCheckTimeout = 2 * time.Second
+
+type replica struct {
+	db      *sql.DB
+	healthy int32
+}
+
+func (r *replica) isHealthy() bool {
+	return atomic.LoadInt32(&r.healthy) == 1
+}
+
+func (r *replica) setHealthy(healthy bool) bool {
+	var v int32
+	if healthy {
+		v = 1
+	}
+
+	return atomic.SwapInt32(&r.healthy, v) != v
+}
+
+// Cluster sends the writes to the MySQL primary and the reads to a healthy
+// replica round robin. A write makes the reads of its client session
+// sticky to the primary for stickyTTL, so the session reads its own writes
+// despite the replication lag.
+type Cluster struct {
+	primary   *sql.DB
+	replicas  []*replica
+	next      uint32
+	stickyTTL time.Duration
+
+	now func() time.Time
+}
+
+// New creates the cluster. Replicas are considered healthy until the first
+// failed health check.
+func New(primary *sql.DB, replicas []*sql.DB, stickyTTL time.Duration) *Cluster {
+	c := &Cluster{
+		primary:   primary,
+		stickyTTL: stickyTTL,
+		now:       time.Now,
+	}
+
+	for _, db := range replicas {
+		c.replicas = append(c.replicas, &replica{db: db, healthy: 1})
+	}
+
+	return c
+}
+
+// Single returns the cluster of the primary only.
+func Single(db *sql.DB) *Cluster {
+	return New(db, nil, 0)
+}
+
+func (c *Cluster) Primary() *sql.DB {
+	return c.primary
+}
+
+// Writer returns the primary and makes the reads of the session of ctx
+// sticky to it.
+func (c *Cluster) Writer(ctx context.Context) *sql.DB {
+	if s := sessionFrom(ctx); s != nil && len(c.replicas) > 0 {
+		s.markWritten()
+	}
+
+	return c.primary
+}
+
+// Reader returns the handle to read from for the session of ctx.
+func (c *Cluster) Reader(ctx context.Context) *sql.DB {
+	if len(c.replicas) == 0 {
+		return c.primary
+	}
+	if s := sessionFrom(ctx); s != nil && s.isSticky(c.now()) {
+		return c.primary
+	}
+
+	start := atomic.AddUint32(&c.next, 1)
+	for i := 0; i < len(c.replicas); i++ {
+		r := c.replicas[(int(start)+i)%len(c.replicas)]
+		if r.isHealthy() {
+			return r.db
+		}
+	}
+
+	return c.primary
+}
+
+// RunHealthCheck pings the replicas every interval. It blocks, so run it
+// in a goroutine.
+func (c *Cluster) RunHealthCheck(interval time.Duration) {
+	if len(c.replicas) == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		c.checkHealth()
+		<-ticker.C
+	}
+}
+
+func (c *Cluster) checkHealth() {
+	for i, r := range c.replicas {
+		ctx, cancel := context.WithTimeout(context.Background(), healthCheckTimeout)
+		err := r.db.PingContext(ctx)
+		cancel()
+
+		if r.setHealthy(err == nil) {
+			if err != nil {
+				log.Printf("db replica %d is down, reading from the others: %v", i, err)
+			} else {
+				log.Printf("db replica %d is up again", i)
+			}
+		}
+	}
+}
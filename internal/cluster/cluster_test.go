@@ -0,0 +1,167 @@
+package cluster
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func newPingDB(t *testing.T) (*sql.DB, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return db, mock
+}
+
+func TestCluster_Reader_RoundRobin(t *testing.T) {
+	primary, _ := newPingDB(t)
+	first, _ := newPingDB(t)
+	second, _ := newPingDB(t)
+
+	c := New(primary, []*sql.DB{first, second}, time.Second)
+
+	got := map[*sql.DB]int{}
+	for i := 0; i < 4; i++ {
+		got[c.Reader(context.Background())]++
+	}
+
+	assert.Equal(t, map[*sql.DB]int{first: 2, second: 2}, got)
+	assert.Equal(t, primary, c.Writer(context.Background()))
+}
+
+func TestCluster_Reader_NoReplicas(t *testing.T) {
+	primary, _ := newPingDB(t)
+
+	c := Single(primary)
+	ctx := WithSession(context.Background(), time.Time{}, func() { t.Error("session marked without replicas") })
+
+	assert.Equal(t, primary, c.Writer(ctx))
+	assert.Equal(t, primary, c.Reader(ctx))
+}
+
+func TestCluster_Reader_StickyAfterWrite(t *testing.T) {
+	primary, _ := newPingDB(t)
+	replica, _ := newPingDB(t)
+
+	c := New(primary, []*sql.DB{replica}, 5*time.Second)
+
+	wrote := 0
+	ctx := WithSession(context.Background(), time.Time{}, func() { wrote++ })
+	other := WithSession(context.Background(), time.Time{}, nil)
+
+	assert.Equal(t, replica, c.Reader(ctx))
+
+	c.Writer(ctx)
+	c.Writer(ctx)
+
+	// The session reads its writes, the others read from the replica
+	assert.Equal(t, primary, c.Reader(ctx))
+	assert.Equal(t, replica, c.Reader(other))
+	assert.Equal(t, replica, c.Reader(context.Background()))
+	assert.Equal(t, 1, wrote)
+}
+
+func TestCluster_Reader_StickyUntil(t *testing.T) {
+	primary, _ := newPingDB(t)
+	replica, _ := newPingDB(t)
+
+	now := time.Now()
+	c := New(primary, []*sql.DB{replica}, 5*time.Second)
+	c.now = func() time.Time { return now }
+
+	ctx := WithSession(context.Background(), now.Add(5*time.Second), nil)
+	assert.Equal(t, primary, c.Reader(ctx))
+
+	// Once the replica caught up the reads go back to it
+	now = now.Add(5 * time.Second)
+	assert.Equal(t, replica, c.Reader(ctx))
+}
+
+func TestCluster_Middleware(t *testing.T) {
+	primary, _ := newPingDB(t)
+	replica, _ := newPingDB(t)
+
+	now := time.Now()
+	c := New(primary, []*sql.DB{replica}, 5*time.Second)
+	c.now = func() time.Time { return now }
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(c.Middleware)
+	router.POST("/", func(ctx *gin.Context) {
+		c.Writer(ctx.Request.Context())
+		ctx.Status(http.StatusNoContent)
+	})
+	router.GET("/", func(ctx *gin.Context) {
+		ctx.String(http.StatusOK, "%t", c.Reader(ctx.Request.Context()) == primary)
+	})
+
+	serve := func(method string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "/", nil)
+		for _, cookie := range cookies {
+			r.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w
+	}
+
+	assert.Equal(t, "false", serve(http.MethodGet).Body.String())
+
+	// The write is carried to the next requests, served by any replica
+	cookies := serve(http.MethodPost).Result().Cookies()
+	if assert.Len(t, cookies, 1) {
+		assert.Equal(t, StickyCookie, cookies[0].Name)
+		assert.Equal(t, 5, cookies[0].MaxAge)
+	}
+
+	assert.Equal(t, "true", serve(http.MethodGet, cookies...).Body.String())
+	assert.Empty(t, serve(http.MethodGet, cookies...).Result().Cookies())
+	assert.Equal(t, "false", serve(http.MethodGet).Body.String())
+
+	now = now.Add(5 * time.Second)
+	assert.Equal(t, "false", serve(http.MethodGet, cookies...).Body.String())
+}
+
+func TestCluster_HealthCheck_Fallback(t *testing.T) {
+	primary, _ := newPingDB(t)
+	first, firstMock := newPingDB(t)
+	second, secondMock := newPingDB(t)
+
+	c := New(primary, []*sql.DB{first, second}, time.Second)
+
+	firstMock.ExpectPing().WillReturnError(fmt.Errorf("connection refused"))
+	secondMock.ExpectPing()
+	c.checkHealth()
+
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, second, c.Reader(context.Background()))
+	}
+
+	// With no healthy replicas the primary serves the reads
+	firstMock.ExpectPing().WillReturnError(fmt.Errorf("connection refused"))
+	secondMock.ExpectPing().WillReturnError(fmt.Errorf("connection refused"))
+	c.checkHealth()
+
+	assert.Equal(t, primary, c.Reader(context.Background()))
+
+	// A recovered replica is used again
+	firstMock.ExpectPing()
+	secondMock.ExpectPing().WillReturnError(fmt.Errorf("connection refused"))
+	c.checkHealth()
+
+	assert.Equal(t, first, c.Reader(context.Background()))
+
+	assert.Nil(t, firstMock.ExpectationsWereMet())
+	assert.Nil(t, secondMock.ExpectationsWereMet())
+}
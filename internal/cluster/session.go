@@ -0,0 +1,83 @@
+package cluster
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// StickyCookie keeps the Unix milliseconds the session reads from the
+// primary until
+const StickyCookie = "db_sticky"
+
+type sessionKey struct{}
+
+// session is the read-your-writes state of a client session
+type session struct {
+	until   time.Time
+	written int32
+
+	// wrote is called on the first write of the request
+	wrote func()
+	once  sync.Once
+}
+
+func (s *session) markWritten() {
+	atomic.StoreInt32(&s.written, 1)
+
+	if s.wrote != nil {
+		s.once.Do(s.wrote)
+	}
+}
+
+func (s *session) isSticky(now time.Time) bool {
+	return atomic.LoadInt32(&s.written) == 1 || now.Before(s.until)
+}
+
+// WithSession returns ctx with a client session sticky to the primary until
+// the given time. wrote is called on the first write made with it.
+func WithSession(ctx context.Context, until time.Time, wrote func()) context.Context {
+	return context.WithValue(ctx, sessionKey{}, &session{until: until, wrote: wrote})
+}
+
+func sessionFrom(ctx context.Context) *session {
+	s, _ := ctx.Value(sessionKey{}).(*session)
+	return s
+}
+
+// Middleware puts the client session into the request context and sets
+// StickyCookie on its first write, so every app replica sees it.
+func (c *Cluster) Middleware(ctx *gin.Context) {
+	if len(c.replicas) == 0 {
+		ctx.Next()
+		return
+	}
+
+	var until time.Time
+	if cookie, err := ctx.Cookie(StickyCookie); err == nil {
+		if ms, err := strconv.ParseInt(cookie, 10, 64); err == nil {
+			until = time.Unix(0, ms*int64(time.Millisecond))
+		}
+	}
+
+	wrote := func() {
+		until := c.now().Add(c.stickyTTL)
+
+		http.SetCookie(ctx.Writer, &http.Cookie{
+			Name:     StickyCookie,
+			Value:    strconv.FormatInt(until.UnixNano()/int64(time.Millisecond), 10),
+			Path:     "/",
+			MaxAge:   int((c.stickyTTL + time.Second - 1) / time.Second),
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		})
+	}
+
+	ctx.Request = ctx.Request.WithContext(WithSession(ctx.Request.Context(), until, wrote))
+	ctx.Next()
+}
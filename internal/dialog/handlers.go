@@ -0,0 +1,270 @@
+package dialog
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/niklod/highload-social-network/internal/server"
+	"github.com/niklod/highload-social-network/internal/user"
+)
+
+type MessageSendRequest struct {
+	Body string `form:"body" json:"body" binding:"required"`
+}
+
+type MarkReadRequest struct {
+	UpToMessageID int64 `form:"upToMessageId" json:"upToMessageId"`
+}
+
+type ViewData struct {
+	AuthenticatedUser *user.User
+	Interlocutor      *user.User
+	Dialogs           []Dialog
+	Page              *MessagePage
+	Errors            []interface{}
+}
+
+type DialogHandler struct {
+	dialogService *Service
+	userService   *user.Service
+}
+
+func NewHandler(dialogService *Service, userService *user.Service) *DialogHandler {
+	return &DialogHandler{
+		dialogService: dialogService,
+		userService:   userService,
+	}
+}
+
+func (d *DialogHandler) HandleDialogs(c *gin.Context) {
+	authUser := user.AuthenticatedUser(c)
+	if authUser == nil {
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
+
+	dialogs, err := d.dialogService.Dialogs(c.Request.Context(), authUser.ID)
+	if err != nil {
+		log.Printf("dialogs page: %v", err)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	c.HTML(http.StatusOK, "dialogs", ViewData{AuthenticatedUser: authUser, Dialogs: dialogs})
+}
+
+func (d *DialogHandler) HandleDialog(c *gin.Context) {
+	authUser := user.AuthenticatedUser(c)
+	if authUser == nil {
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
+
+	interlocutor, err := d.userService.GetUserByLogin(c.Request.Context(), c.Param("login"))
+	if err != nil {
+		log.Printf("dialog page, getting user: %v", err)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	if interlocutor == nil || interlocutor.ID == authUser.ID {
+		c.Status(http.StatusNotFound)
+		return
+	}
+
+	page, err := d.dialogService.Messages(c.Request.Context(), authUser.ID, interlocutor.ID, queryInt64(c, "before"), 0)
+	if err != nil {
+		log.Printf("dialog page, getting messages: %v", err)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	if _, err := d.dialogService.MarkRead(c.Request.Context(), participant(authUser), participant(interlocutor), 0); err != nil {
+		log.Printf("dialog page, marking messages read: %v", err)
+	}
+
+	interlocutor.Sanitize()
+
+	c.HTML(http.StatusOK, "dialog", ViewData{
+		AuthenticatedUser: authUser,
+		Interlocutor:      interlocutor,
+		Page:              page,
+	})
+}
+
+func (d *DialogHandler) HandleSendMessage(c *gin.Context) {
+	authUser := user.AuthenticatedUser(c)
+	if authUser == nil {
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
+
+	interlocutor, err := d.userService.GetUserByLogin(c.Request.Context(), c.Param("login"))
+	if err != nil {
+		log.Printf("sending message, getting user: %v", err)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	if interlocutor == nil {
+		c.Status(http.StatusNotFound)
+		return
+	}
+
+	if _, err := d.dialogService.Send(c.Request.Context(), participant(authUser), participant(interlocutor), c.PostForm("body")); err != nil {
+		log.Printf("sending message: %v", err)
+	}
+
+	c.Redirect(http.StatusSeeOther, fmt.Sprintf("/dialogs/%s", interlocutor.Login))
+}
+
+func (d *DialogHandler) HandleAPIDialogs(c *gin.Context) {
+	authUser, ok := requireAPIUser(c)
+	if !ok {
+		return
+	}
+
+	dialogs, err := d.dialogService.Dialogs(c.Request.Context(), authUser.ID)
+	if err != nil {
+		log.Printf("api dialogs: %v", err)
+		server.RespondError(c, http.StatusInternalServerError, server.ErrCodeInternal, "Внутренняя ошибка сервера")
+		return
+	}
+
+	server.RespondData(c, http.StatusOK, dialogs)
+}
+
+func (d *DialogHandler) HandleAPIMessages(c *gin.Context) {
+	authUser, ok := requireAPIUser(c)
+	if !ok {
+		return
+	}
+
+	interlocutor, ok := d.apiInterlocutor(c, authUser)
+	if !ok {
+		return
+	}
+
+	page, err := d.dialogService.Messages(c.Request.Context(), authUser.ID, interlocutor.ID, queryInt64(c, "before"), int(queryInt64(c, "limit")))
+	if err != nil {
+		log.Printf("api dialog messages: %v", err)
+		server.RespondError(c, http.StatusInternalServerError, server.ErrCodeInternal, "Внутренняя ошибка сервера")
+		return
+	}
+
+	server.RespondData(c, http.StatusOK, page)
+}
+
+func (d *DialogHandler) HandleAPISendMessage(c *gin.Context) {
+	authUser, ok := requireAPIUser(c)
+	if !ok {
+		return
+	}
+
+	interlocutor, ok := d.apiInterlocutor(c, authUser)
+	if !ok {
+		return
+	}
+
+	req := &MessageSendRequest{}
+	if err := c.ShouldBind(req); err != nil {
+		server.RespondError(c, http.StatusBadRequest, server.ErrCodeBadRequest, "Некорректное тело запроса", err.Error())
+		return
+	}
+
+	msg, err := d.dialogService.Send(c.Request.Context(), participant(authUser), participant(interlocutor), req.Body)
+	if err != nil {
+		if err == ErrEmptyMessage || err == ErrMessageTooBig {
+			server.RespondError(c, http.StatusUnprocessableEntity, server.ErrCodeValidation, "Ошибка валидации", err.Error())
+			return
+		}
+
+		log.Printf("api send message: %v", err)
+		server.RespondError(c, http.StatusInternalServerError, server.ErrCodeInternal, "Внутренняя ошибка сервера")
+		return
+	}
+
+	server.RespondData(c, http.StatusCreated, msg)
+}
+
+func (d *DialogHandler) HandleAPIMarkRead(c *gin.Context) {
+	authUser, ok := requireAPIUser(c)
+	if !ok {
+		return
+	}
+
+	interlocutor, ok := d.apiInterlocutor(c, authUser)
+	if !ok {
+		return
+	}
+
+	req := &MarkReadRequest{}
+	if err := c.ShouldBind(req); err != nil {
+		server.RespondError(c, http.StatusBadRequest, server.ErrCodeBadRequest, "Некорректное тело запроса", err.Error())
+		return
+	}
+
+	receipt, err := d.dialogService.MarkRead(c.Request.Context(), participant(authUser), participant(interlocutor), req.UpToMessageID)
+	if err != nil {
+		log.Printf("api mark messages read: %v", err)
+		server.RespondError(c, http.StatusInternalServerError, server.ErrCodeInternal, "Внутренняя ошибка сервера")
+		return
+	}
+	if receipt == nil {
+		server.RespondError(c, http.StatusNotFound, server.ErrCodeNotFound, "Диалог не найден")
+		return
+	}
+
+	server.RespondData(c, http.StatusOK, receipt)
+}
+
+// apiInterlocutor loads the user from the :login path parameter, who can't
+// be the authenticated user.
+func (d *DialogHandler) apiInterlocutor(c *gin.Context, authUser *user.User) (*user.User, bool) {
+	interlocutor, err := d.userService.GetUserByLogin(c.Request.Context(), c.Param("login"))
+	if err != nil {
+		log.Printf("api dialogs, getting user by login: %v", err)
+		server.RespondError(c, http.StatusInternalServerError, server.ErrCodeInternal, "Внутренняя ошибка сервера")
+		return nil, false
+	}
+	if interlocutor == nil {
+		server.RespondError(c, http.StatusNotFound, server.ErrCodeNotFound, "Пользователь не найден")
+		return nil, false
+	}
+	if interlocutor.ID == authUser.ID {
+		server.RespondError(c, http.StatusUnprocessableEntity, server.ErrCodeValidation, "Нельзя написать самому себе")
+		return nil, false
+	}
+
+	return interlocutor, true
+}
+
+func requireAPIUser(c *gin.Context) (*user.User, bool) {
+	authUser := user.AuthenticatedUser(c)
+	if authUser == nil {
+		server.RespondError(c, http.StatusUnauthorized, server.ErrCodeUnauthorized, "Требуется авторизация")
+		return nil, false
+	}
+
+	return authUser, true
+}
+
+func participant(u *user.User) Participant {
+	return Participant{
+		ID:        u.ID,
+		Login:     u.Login,
+		FirstName: u.FirstName,
+		LastName:  u.Lastname,
+	}
+}
+
+func queryInt64(c *gin.Context, name string) int64 {
+	v, err := strconv.ParseInt(c.Query(name), 10, 64)
+	if err != nil {
+		return 0
+	}
+
+	return v
+}
@@ -0,0 +1,70 @@
+package dialog
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	idNodeBits = 10
+	idSeqBits  = 12
+	idSeqMask  = 1<<idSeqBits - 1
+)
+
+// ErrIDNodeTaken is the message ID node claimed by another live replica
+var ErrIDNodeTaken = fmt.Errorf("message ID node is taken by another replica")
+
+// idEpoch is the start of the message ID timestamps, 2021-01-01 UTC
+var idEpoch = time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC).UnixNano() / int64(time.Millisecond)
+
+// idGenerator makes time ordered message IDs of milliseconds since idEpoch,
+// node and sequence. AUTO_INCREMENT IDs would break the order of a dialog
+// moved between shards.
+type idGenerator struct {
+	mu     sync.Mutex
+	node   int64
+	lastMs int64
+	seq    int64
+	now    func() time.Time
+}
+
+// newIDGenerator makes the IDs of the node claimed by ClaimIDNode.
+func newIDGenerator(node int) *idGenerator {
+	return &idGenerator{
+		node: int64(node),
+		now:  time.Now,
+	}
+}
+
+func checkIDNode(node int) error {
+	if node < 0 || node >= 1<<idNodeBits {
+		return fmt.Errorf("message ID node %d is out of 0-%d", node, 1<<idNodeBits-1)
+	}
+
+	return nil
+}
+
+func (g *idGenerator) Next() int64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	ms := g.now().UnixNano()/int64(time.Millisecond) - idEpoch
+	if ms < g.lastMs {
+		// Clock went back, keep IDs growing
+		ms = g.lastMs
+	}
+
+	if ms == g.lastMs {
+		g.seq = (g.seq + 1) & idSeqMask
+		if g.seq == 0 {
+			ms++
+		}
+	} else {
+		g.seq = 0
+	}
+
+	g.lastMs = ms
+
+	return ms<<(idNodeBits+idSeqBits) | g.node<<idSeqBits | g.seq
+}
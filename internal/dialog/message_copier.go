@@ -0,0 +1,141 @@
+package dialog
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/niklod/highload-social-network/internal/dialog/shard"
+)
+
+const defaultCopyBatchSize = 500
+
+type messageRow struct {
+	Message
+	readAt  sql.NullTime
+	keyHash uint32
+}
+
+// messageCopier moves messages between shards during resharding
+type messageCopier struct {
+	batchSize int
+}
+
+func NewMessageCopier(batchSize int) shard.Copier {
+	if batchSize <= 0 {
+		batchSize = defaultCopyBatchSize
+	}
+
+	return &messageCopier{batchSize: batchSize}
+}
+
+// Copy upserts the messages of the range into the target shard batch by batch,
+// walking the source by (key_hash, dialog_id, id).
+func (c *messageCopier) Copy(ctx context.Context, from, to *sql.DB, r shard.Range) error {
+	lastHash, lastDialog, lastID := r.Start, 0, int64(0)
+
+	for {
+		rows, err := c.batch(ctx, from, r, lastHash, lastDialog, lastID)
+		if err != nil {
+			return err
+		}
+		if len(rows) == 0 {
+			return nil
+		}
+
+		if err := c.insert(ctx, to, rows); err != nil {
+			return err
+		}
+
+		last := rows[len(rows)-1]
+		lastHash, lastDialog, lastID = last.keyHash, last.DialogID, last.ID
+
+		if len(rows) < c.batchSize {
+			return nil
+		}
+	}
+}
+
+// Purge deletes the messages of the range from the shard.
+func (c *messageCopier) Purge(ctx context.Context, db *sql.DB, r shard.Range) error {
+	query := queryMap[purgeMessages]
+
+	for {
+		qctx, cancel := context.WithTimeout(ctx, query.Timeout)
+		res, err := db.ExecContext(qctx, query.SQL, r.Start, r.End, c.batchSize)
+		cancel()
+		if err != nil {
+			return fmt.Errorf("purging messages: %v", err)
+		}
+
+		n, err := res.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("getting affected rows: %v", err)
+		}
+		if n < int64(c.batchSize) {
+			return nil
+		}
+	}
+}
+
+func (c *messageCopier) batch(ctx context.Context, db *sql.DB, r shard.Range, lastHash uint32, lastDialog int, lastID int64) ([]messageRow, error) {
+	query := queryMap[messagesInRange]
+	ctx, cancel := context.WithTimeout(ctx, query.Timeout)
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx, query.SQL, r.Start, r.End, lastHash, lastDialog, lastID, c.batchSize)
+	if err != nil {
+		return nil, fmt.Errorf("reading messages to copy: %v", err)
+	}
+	defer rows.Close()
+
+	var batch []messageRow
+
+	for rows.Next() {
+		var row messageRow
+
+		err := rows.Scan(
+			&row.ID,
+			&row.DialogID,
+			&row.SenderID,
+			&row.Body,
+			&row.CreatedAt,
+			&row.readAt,
+			&row.keyHash,
+		)
+		if err != nil {
+			// Skipping a row here would lose it on the new shard
+			return nil, fmt.Errorf("scanning message row: %v", err)
+		}
+
+		batch = append(batch, row)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("reading messages to copy: iterating through rows: %v", err)
+	}
+
+	return batch, nil
+}
+
+func (c *messageCopier) insert(ctx context.Context, db *sql.DB, rows []messageRow) error {
+	query := queryMap[copyMessages]
+	ctx, cancel := context.WithTimeout(ctx, query.Timeout)
+	defer cancel()
+
+	placeholders := make([]string, len(rows))
+	args := make([]interface{}, 0, len(rows)*7)
+
+	for i, row := range rows {
+		placeholders[i] = "(?, ?, ?, ?, ?, ?, ?)"
+		args = append(args, row.ID, row.DialogID, row.SenderID, row.Body, row.CreatedAt, row.readAt, row.keyHash)
+	}
+
+	_, err := db.ExecContext(ctx, fmt.Sprintf(query.SQL, strings.Join(placeholders, ", ")), args...)
+	if err != nil {
+		return fmt.Errorf("copying messages: %v", err)
+	}
+
+	return nil
+}
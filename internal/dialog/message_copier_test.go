@@ -0,0 +1,154 @@
+package dialog
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/niklod/highload-social-network/internal/dialog/shard"
+)
+
+var copyColumns = []string{"id", "dialog_id", "sender_id", "body", "created_at", "read_at", "key_hash"}
+
+func Test_messageCopier_Copy(t *testing.T) {
+	from, fromMock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer from.Close()
+
+	to, toMock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer to.Close()
+
+	copier := NewMessageCopier(2)
+	r := shard.Range{Start: 100, End: 200}
+	now := time.Now()
+
+	fromMock.ExpectQuery("SELECT id").
+		WithArgs(uint32(100), uint32(200), uint32(100), 0, int64(0), 2).
+		WillReturnRows(sqlmock.NewRows(copyColumns).
+			AddRow(1, 5, 3, "first", now, nil, 150).
+			AddRow(2, 5, 7, "second", now, now, 150))
+	toMock.ExpectExec("INSERT INTO messages").
+		WithArgs(int64(1), 5, 3, "first", now, nil, uint32(150), int64(2), 5, 7, "second", now, now, uint32(150)).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+
+	// The next batch starts after the last copied row
+	fromMock.ExpectQuery("SELECT id").
+		WithArgs(uint32(100), uint32(200), uint32(150), 5, int64(2), 2).
+		WillReturnRows(sqlmock.NewRows(copyColumns).
+			AddRow(9, 6, 3, "third", now, nil, 170))
+	toMock.ExpectExec("INSERT INTO messages").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	err = copier.Copy(context.Background(), from, to, r)
+
+	assert.Nil(t, err)
+	assert.Nil(t, fromMock.ExpectationsWereMet())
+	assert.Nil(t, toMock.ExpectationsWereMet())
+}
+
+func Test_messageCopier_Purge(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	copier := NewMessageCopier(2)
+
+	mock.ExpectExec("DELETE FROM messages").WithArgs(uint32(100), uint32(200), 2).WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec("DELETE FROM messages").WithArgs(uint32(100), uint32(200), 2).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	err = copier.Purge(context.Background(), db, shard.Range{Start: 100, End: 200})
+
+	assert.Nil(t, err)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+// Reads keep working through a resharding driven by the real copier
+func Test_messageMysql_Reshard(t *testing.T) {
+	old, oldMock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer old.Close()
+
+	added, addedMock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer added.Close()
+
+	shards := map[int]*sql.DB{0: old, 1: added}
+	store := shard.NewMemoryStore()
+	router := shard.NewRouter(shards, 1, store, time.Minute)
+	target := shard.NewRing([]int{0, 1}, 1)
+	repo := NewMessageRepository(router, 0)
+
+	var key Key
+	for i := 1; ; i++ {
+		key = NewKey(i, i+1)
+		if target.Locate(shard.Hash(key.String())) == 1 {
+			break
+		}
+	}
+
+	// The resharding to the same shard records the ring, the replicas
+	// route over all the configured shards before
+	resharder := shard.NewResharder(store, shards, 1, NewMessageCopier(10), time.Nanosecond)
+	assert.Nil(t, resharder.Start(context.Background(), []int{0}, []int{0}))
+	assert.Nil(t, router.Sync(context.Background(), "replica-1"))
+
+	oldMock.ExpectQuery("SELECT id").WithArgs(1, int64(10), 5).WillReturnRows(sqlmock.NewRows(messageColumns))
+	_, err = repo.Messages(context.Background(), key, 1, 10, 5)
+	assert.Nil(t, err)
+
+	moves := shard.NewRing([]int{0}, 1).Moves(target)
+	for range moves {
+		// Both copy passes find nothing and the old shard is purged
+		oldMock.ExpectQuery("SELECT id").WillReturnRows(sqlmock.NewRows(copyColumns))
+		oldMock.ExpectQuery("SELECT id").WillReturnRows(sqlmock.NewRows(copyColumns))
+	}
+	for range moves {
+		oldMock.ExpectExec("DELETE FROM messages").WillReturnResult(sqlmock.NewResult(0, 0))
+	}
+
+	// The replica is gone, so the resharding doesn't wait for it
+	err = resharder.Start(context.Background(), nil, target.Shards())
+	assert.Nil(t, err)
+
+	assert.Nil(t, router.Sync(context.Background(), "replica-1"))
+
+	addedMock.ExpectQuery("SELECT id").WithArgs(1, int64(10), 5).WillReturnRows(sqlmock.NewRows(messageColumns))
+	_, err = repo.Messages(context.Background(), key, 1, 10, 5)
+	assert.Nil(t, err)
+
+	assert.Nil(t, oldMock.ExpectationsWereMet())
+	assert.Nil(t, addedMock.ExpectationsWereMet())
+}
+
+func Test_idGenerator_Next(t *testing.T) {
+	g := newIDGenerator(0)
+	now := time.Now()
+	g.now = func() time.Time { return now }
+
+	prev := g.Next()
+	for i := 0; i < 10000; i++ {
+		// Many IDs within one millisecond and a clock going back
+		if i == 5000 {
+			now = now.Add(-time.Second)
+		}
+
+		id := g.Next()
+		assert.True(t, id > prev)
+		prev = id
+	}
+}
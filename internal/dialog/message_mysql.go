@@ -0,0 +1,128 @@
+package dialog
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/niklod/highload-social-network/internal/dialog/shard"
+)
+
+// messageMysql keeps the messages sharded by the conversation key
+type messageMysql struct {
+	router *shard.Router
+	ids    *idGenerator
+}
+
+// NewMessageRepository makes the message IDs of the node, see ClaimIDNode.
+func NewMessageRepository(router *shard.Router, node int) messageRepository {
+	return &messageMysql{
+		router: router,
+		ids:    newIDGenerator(node),
+	}
+}
+
+func (m *messageMysql) Add(ctx context.Context, key Key, msg *Message) error {
+	query := queryMap[insertMessage]
+
+	id := m.ids.Next()
+
+	err := m.router.Write(key.String(), func(db *sql.DB, primary bool) error {
+		ctx, cancel := context.WithTimeout(ctx, query.Timeout)
+		defer cancel()
+
+		_, err := db.ExecContext(ctx, query.SQL, id, msg.DialogID, msg.SenderID, msg.Body, msg.CreatedAt, shard.Hash(key.String()))
+		if err != nil && !primary {
+			log.Printf("copying message %d of dialog %s to the new shard: %v", id, key, err)
+		}
+
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("adding message to dialog %s: %v", key, err)
+	}
+
+	msg.ID = id
+
+	return nil
+}
+
+func (m *messageMysql) Messages(ctx context.Context, key Key, dialogId int, before int64, limit int) ([]Message, error) {
+	query := queryMap[messagesByDialog]
+
+	messages := []Message{}
+
+	err := m.router.Read(key.String(), func(db *sql.DB) error {
+		ctx, cancel := context.WithTimeout(ctx, query.Timeout)
+		defer cancel()
+
+		rows, err := db.QueryContext(ctx, query.SQL, dialogId, before, limit)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var msg Message
+			var readAt sql.NullTime
+
+			err := rows.Scan(
+				&msg.ID,
+				&msg.DialogID,
+				&msg.SenderID,
+				&msg.Body,
+				&msg.CreatedAt,
+				&readAt,
+			)
+			if err != nil {
+				log.Printf("scanning message row: %v", err)
+				continue
+			}
+
+			if readAt.Valid {
+				msg.ReadAt = &readAt.Time
+			}
+
+			messages = append(messages, msg)
+		}
+
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, fmt.Errorf("getting messages of dialog %s: %v", key, err)
+	}
+
+	return messages, nil
+}
+
+func (m *messageMysql) MarkRead(ctx context.Context, key Key, dialogId, readerId int, upToId int64, at time.Time) (int, error) {
+	query := queryMap[markMessagesRead]
+
+	var n int64
+
+	err := m.router.Write(key.String(), func(db *sql.DB, primary bool) error {
+		ctx, cancel := context.WithTimeout(ctx, query.Timeout)
+		defer cancel()
+
+		res, err := db.ExecContext(ctx, query.SQL, at, dialogId, readerId, upToId)
+		if err != nil {
+			if !primary {
+				log.Printf("marking messages of dialog %s read on the new shard: %v", key, err)
+			}
+			return err
+		}
+
+		if primary {
+			n, err = res.RowsAffected()
+		}
+
+		return err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("marking messages of dialog %s read: %v", key, err)
+	}
+
+	return int(n), nil
+}
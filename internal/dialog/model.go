@@ -0,0 +1,75 @@
+package dialog
+
+import (
+	"fmt"
+	"time"
+)
+
+// Key identifies the conversation of two users regardless of who started
+// it: Low is always the smaller user ID.
+type Key struct {
+	Low  int
+	High int
+}
+
+func NewKey(a, b int) Key {
+	if a > b {
+		a, b = b, a
+	}
+
+	return Key{Low: a, High: b}
+}
+
+func (k Key) String() string {
+	return fmt.Sprintf("%d:%d", k.Low, k.High)
+}
+
+// Other returns the ID of the conversation member which is not userId.
+func (k Key) Other(userId int) int {
+	if k.Low == userId {
+		return k.High
+	}
+
+	return k.Low
+}
+
+type Participant struct {
+	ID        int    `json:"id"`
+	Login     string `json:"login"`
+	FirstName string `json:"firstName"`
+	LastName  string `json:"lastName"`
+}
+
+type Dialog struct {
+	ID            int         `json:"id"`
+	Key           Key         `json:"-"`
+	Interlocutor  Participant `json:"interlocutor"`
+	LastMessageAt *time.Time  `json:"lastMessageAt,omitempty"`
+	LastMessage   string      `json:"lastMessage"`
+	LastSenderID  int         `json:"lastSenderId,omitempty"`
+	UnreadCount   int         `json:"unreadCount"`
+}
+
+type Message struct {
+	ID        int64      `json:"id"`
+	DialogID  int        `json:"dialogId"`
+	SenderID  int        `json:"senderId"`
+	Body      string     `json:"body"`
+	CreatedAt time.Time  `json:"createdAt"`
+	ReadAt    *time.Time `json:"readAt,omitempty"`
+}
+
+// MessagePage is a page of dialog messages, newest first. NextBefore is
+// passed as "before" to get the next (older) page.
+type MessagePage struct {
+	Messages   []Message `json:"messages"`
+	NextBefore int64     `json:"nextBefore,omitempty"`
+}
+
+// ReadReceipt is pushed to the sender when the recipient reads messages.
+type ReadReceipt struct {
+	DialogID      int       `json:"dialogId"`
+	ReaderID      int       `json:"readerId"`
+	UpToMessageID int64     `json:"upToMessageId"`
+	ReadAt        time.Time `json:"readAt"`
+}
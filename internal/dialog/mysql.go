@@ -0,0 +1,163 @@
+package dialog
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+)
+
+type mysql struct {
+	db *sql.DB
+}
+
+func NewRepository(db *sql.DB) repository {
+	return &mysql{db: db}
+}
+
+func (m *mysql) GetOrCreate(ctx context.Context, key Key) (*Dialog, error) {
+	query := queryMap[getOrCreateDialog]
+	qctx, cancel := context.WithTimeout(ctx, query.Timeout)
+	defer cancel()
+
+	res, err := m.db.ExecContext(qctx, query.SQL, key.Low, key.High)
+	if err != nil {
+		return nil, fmt.Errorf("creating dialog %s: %v", key, err)
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return nil, fmt.Errorf("getting last insert id: %v", err)
+	}
+
+	members := queryMap[addDialogMembers]
+	qctx, cancel = context.WithTimeout(ctx, members.Timeout)
+	defer cancel()
+
+	_, err = m.db.ExecContext(qctx, members.SQL, id, key.Low, id, key.High)
+	if err != nil {
+		return nil, fmt.Errorf("adding dialog %s members: %v", key, err)
+	}
+
+	return &Dialog{ID: int(id), Key: key}, nil
+}
+
+func (m *mysql) GetByKey(ctx context.Context, key Key) (*Dialog, error) {
+	query := queryMap[getDialogByKey]
+	ctx, cancel := context.WithTimeout(ctx, query.Timeout)
+	defer cancel()
+
+	var d Dialog
+
+	err := m.db.QueryRowContext(ctx, query.SQL, key.Low, key.High).Scan(&d.ID, &d.Key.Low, &d.Key.High)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("getting dialog %s: %v", key, err)
+	}
+
+	return &d, nil
+}
+
+func (m *mysql) DialogsByUser(ctx context.Context, userId, limit int) ([]Dialog, error) {
+	query := queryMap[dialogsByUser]
+	ctx, cancel := context.WithTimeout(ctx, query.Timeout)
+	defer cancel()
+
+	rows, err := m.db.QueryContext(ctx, query.SQL, userId, limit)
+	if err != nil {
+		return nil, fmt.Errorf("getting dialogs of user %d: %v", userId, err)
+	}
+	defer rows.Close()
+
+	dialogs := []Dialog{}
+
+	for rows.Next() {
+		var d Dialog
+		var lastMessageAt sql.NullTime
+		var lastSenderID sql.NullInt64
+
+		err := rows.Scan(
+			&d.ID,
+			&d.Key.Low,
+			&d.Key.High,
+			&lastMessageAt,
+			&d.LastMessage,
+			&lastSenderID,
+			&d.UnreadCount,
+			&d.Interlocutor.ID,
+			&d.Interlocutor.Login,
+			&d.Interlocutor.FirstName,
+			&d.Interlocutor.LastName,
+		)
+		if err != nil {
+			log.Printf("scanning dialog row: %v", err)
+			continue
+		}
+
+		if lastMessageAt.Valid {
+			d.LastMessageAt = &lastMessageAt.Time
+		}
+		if lastSenderID.Valid {
+			d.LastSenderID = int(lastSenderID.Int64)
+		}
+
+		dialogs = append(dialogs, d)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("getting dialogs: iterating through rows: %v", err)
+	}
+
+	return dialogs, nil
+}
+
+func (m *mysql) UpdateLastMessage(ctx context.Context, msg *Message) error {
+	query := queryMap[updateLastMessage]
+	ctx, cancel := context.WithTimeout(ctx, query.Timeout)
+	defer cancel()
+
+	_, err := m.db.ExecContext(ctx, query.SQL, msg.CreatedAt, preview(msg.Body), msg.SenderID, msg.DialogID)
+	if err != nil {
+		return fmt.Errorf("updating dialog %d last message: %v", msg.DialogID, err)
+	}
+
+	return nil
+}
+
+func (m *mysql) IncrementUnread(ctx context.Context, dialogId, userId int) error {
+	query := queryMap[incrementUnread]
+	ctx, cancel := context.WithTimeout(ctx, query.Timeout)
+	defer cancel()
+
+	_, err := m.db.ExecContext(ctx, query.SQL, dialogId, userId)
+	if err != nil {
+		return fmt.Errorf("incrementing dialog %d unread counter: %v", dialogId, err)
+	}
+
+	return nil
+}
+
+func (m *mysql) MarkRead(ctx context.Context, dialogId, userId int, upToId int64, readCount int) error {
+	query := queryMap[updateMemberRead]
+	ctx, cancel := context.WithTimeout(ctx, query.Timeout)
+	defer cancel()
+
+	_, err := m.db.ExecContext(ctx, query.SQL, upToId, readCount, dialogId, userId)
+	if err != nil {
+		return fmt.Errorf("updating dialog %d read state: %v", dialogId, err)
+	}
+
+	return nil
+}
+
+// preview cuts the message to fit dialogs.last_message_body
+func preview(body string) string {
+	r := []rune(body)
+	if len(r) > 255 {
+		return string(r[:255])
+	}
+
+	return body
+}
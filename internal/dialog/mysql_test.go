@@ -0,0 +1,157 @@
+package dialog
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/niklod/highload-social-network/internal/dialog/shard"
+)
+
+var messageColumns = []string{"id", "dialog_id", "sender_id", "body", "created_at", "read_at"}
+
+func Test_mysql_GetOrCreate(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	repo := NewRepository(db)
+	key := NewKey(7, 3)
+
+	mock.ExpectExec("INSERT INTO dialogs").WithArgs(3, 7).WillReturnResult(sqlmock.NewResult(12, 1))
+	mock.ExpectExec("INSERT IGNORE INTO dialog_members").WithArgs(12, 3, 12, 7).WillReturnResult(sqlmock.NewResult(0, 2))
+
+	d, err := repo.GetOrCreate(context.Background(), key)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 12, d.ID)
+	assert.Equal(t, key, d.Key)
+}
+
+func Test_mysql_GetByKey_NoRows(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	repo := NewRepository(db)
+
+	mock.ExpectQuery("SELECT d.id").WithArgs(3, 7).WillReturnRows(sqlmock.NewRows([]string{"id", "user_one_id", "user_two_id"}))
+
+	d, err := repo.GetByKey(context.Background(), NewKey(3, 7))
+
+	assert.Nil(t, err)
+	assert.Nil(t, d)
+}
+
+func Test_mysql_DialogsByUser(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	repo := NewRepository(db)
+
+	rows := sqlmock.NewRows([]string{"id", "user_one_id", "user_two_id", "last_message_at", "last_message_body", "last_sender_id", "unread_count", "id", "login", "first_name", "last_name"})
+	rows.AddRow(1, 3, 7, time.Now(), "hi", 7, 2, 7, "login7", "First", "Last")
+	rows.AddRow(2, 3, 9, nil, "", nil, 0, 9, "login9", "First", "Last")
+
+	mock.ExpectQuery("SELECT d.id").WithArgs(3, 100).WillReturnRows(rows)
+
+	dialogs, err := repo.DialogsByUser(context.Background(), 3, 100)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(dialogs))
+	assert.Equal(t, 2, dialogs[0].UnreadCount)
+	assert.Equal(t, "login7", dialogs[0].Interlocutor.Login)
+	assert.NotNil(t, dialogs[0].LastMessageAt)
+	assert.Nil(t, dialogs[1].LastMessageAt)
+}
+
+func Test_mysql_DialogsByUser_Error(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	repo := NewRepository(db)
+	testErr := fmt.Errorf("test error")
+
+	mock.ExpectQuery("SELECT d.id").WillReturnError(testErr)
+
+	dialogs, err := repo.DialogsByUser(context.Background(), 3, 100)
+
+	assert.Nil(t, dialogs)
+	assert.Contains(t, err.Error(), testErr.Error())
+}
+
+func Test_messageMysql_Add(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	repo := NewMessageRepository(shard.NewRouter(map[int]*sql.DB{0: db}, 1, nil, 0), 0)
+	msg := &Message{DialogID: 1, SenderID: 3, Body: "hi", CreatedAt: time.Now()}
+
+	mock.ExpectExec("INSERT INTO messages").
+		WithArgs(sqlmock.AnyArg(), 1, 3, "hi", msg.CreatedAt, shard.Hash("3:7")).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	err = repo.Add(context.Background(), NewKey(3, 7), msg)
+
+	assert.Nil(t, err)
+	assert.NotZero(t, msg.ID)
+}
+
+func Test_messageMysql_Messages(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	repo := NewMessageRepository(shard.NewRouter(map[int]*sql.DB{0: db}, 1, nil, 0), 0)
+
+	rows := sqlmock.NewRows(messageColumns).
+		AddRow(5, 1, 3, "second", time.Now(), nil).
+		AddRow(4, 1, 7, "first", time.Now(), time.Now())
+
+	mock.ExpectQuery("SELECT id").WithArgs(1, int64(10), 2).WillReturnRows(rows)
+
+	messages, err := repo.Messages(context.Background(), NewKey(3, 7), 1, 10, 2)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(messages))
+	assert.Nil(t, messages[0].ReadAt)
+	assert.NotNil(t, messages[1].ReadAt)
+}
+
+func Test_messageMysql_MarkRead(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	repo := NewMessageRepository(shard.NewRouter(map[int]*sql.DB{0: db}, 1, nil, 0), 0)
+	now := time.Now()
+
+	mock.ExpectExec("UPDATE messages").WithArgs(now, 1, 3, int64(10)).WillReturnResult(sqlmock.NewResult(0, 4))
+
+	n, err := repo.MarkRead(context.Background(), NewKey(3, 7), 1, 3, 10, now)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 4, n)
+}
@@ -0,0 +1,305 @@
+package dialog
+
+import "time"
+
+const (
+	getOrCreateDialog = iota
+	addDialogMembers
+	getDialogByKey
+	dialogsByUser
+	updateLastMessage
+	incrementUnread
+	updateMemberRead
+	insertMessage
+	messagesByDialog
+	markMessagesRead
+	messagesInRange
+	copyMessages
+	purgeMessages
+	shardState
+	shardStateForUpdate
+	shardMoves
+	ackShardState
+	laggingReplicas
+	releaseIDNode
+	claimIDNode
+	markMoveDirty
+	deleteShardMoves
+	insertShardMoves
+	startResharding
+	resetMoveDirty
+	switchMove
+	bumpShardVersion
+	finishResharding
+)
+
+type Query struct {
+	SQL     string
+	Timeout time.Duration
+}
+
+var queryMap map[int]Query
+
+func init() {
+	queryMap = make(map[int]Query)
+
+	queryMap[getOrCreateDialog] = Query{
+		SQL: `INSERT INTO dialogs (user_one_id, user_two_id) VALUES (?, ?)
+				ON DUPLICATE KEY UPDATE id=LAST_INSERT_ID(id);`,
+		Timeout: 5 * time.Second,
+	}
+
+	queryMap[addDialogMembers] = Query{
+		SQL:     `INSERT IGNORE INTO dialog_members (dialog_id, user_id) VALUES (?, ?), (?, ?);`,
+		Timeout: 5 * time.Second,
+	}
+
+	queryMap[getDialogByKey] = Query{
+		SQL: `SELECT d.id
+				, d.user_one_id
+				, d.user_two_id
+			FROM dialogs d
+			WHERE d.user_one_id = ?
+			AND d.user_two_id = ?`,
+		Timeout: 5 * time.Second,
+	}
+
+	queryMap[dialogsByUser] = Query{
+		SQL: `SELECT d.id
+				, d.user_one_id
+				, d.user_two_id
+				, d.last_message_at
+				, d.last_message_body
+				, d.last_sender_id
+				, m.unread_count
+				, u.id
+				, u.login
+				, u.first_name
+				, u.last_name
+			FROM dialog_members m
+				JOIN dialogs d ON d.id = m.dialog_id
+				JOIN users u ON u.id = IF(d.user_one_id = m.user_id, d.user_two_id, d.user_one_id)
+			WHERE m.user_id = ?
+			ORDER BY d.last_message_at desc
+			LIMIT ?`,
+		Timeout: 10 * time.Second,
+	}
+
+	queryMap[updateLastMessage] = Query{
+		SQL: `UPDATE dialogs
+				SET last_message_at = ?
+				, last_message_body = ?
+				, last_sender_id = ?
+			WHERE id = ?`,
+		Timeout: 5 * time.Second,
+	}
+
+	queryMap[incrementUnread] = Query{
+		SQL: `UPDATE dialog_members
+				SET unread_count = unread_count + 1
+			WHERE dialog_id = ?
+			AND user_id = ?`,
+		Timeout: 5 * time.Second,
+	}
+
+	queryMap[updateMemberRead] = Query{
+		SQL: `UPDATE dialog_members
+				SET last_read_message_id = GREATEST(last_read_message_id, ?)
+				, unread_count = GREATEST(CAST(unread_count AS SIGNED) - ?, 0)
+			WHERE dialog_id = ?
+			AND user_id = ?`,
+		Timeout: 5 * time.Second,
+	}
+
+	queryMap[insertMessage] = Query{
+		SQL: `INSERT INTO messages (id, dialog_id, sender_id, body, created_at, key_hash)
+				VALUES (?, ?, ?, ?, ?, ?)
+				ON DUPLICATE KEY UPDATE id = id;`,
+		Timeout: 5 * time.Second,
+	}
+
+	queryMap[messagesByDialog] = Query{
+		SQL: `SELECT id
+				, dialog_id
+				, sender_id
+				, body
+				, created_at
+				, read_at
+			FROM messages
+			WHERE dialog_id = ?
+			AND id < ?
+			ORDER BY id desc
+			LIMIT ?`,
+		Timeout: 10 * time.Second,
+	}
+
+	queryMap[markMessagesRead] = Query{
+		SQL: `UPDATE messages
+				SET read_at = ?
+			WHERE dialog_id = ?
+			AND sender_id <> ?
+			AND id <= ?
+			AND read_at IS NULL`,
+		Timeout: 10 * time.Second,
+	}
+
+	queryMap[messagesInRange] = Query{
+		SQL: `SELECT id
+				, dialog_id
+				, sender_id
+				, body
+				, created_at
+				, read_at
+				, key_hash
+			FROM messages
+			WHERE key_hash BETWEEN ? AND ?
+			AND (key_hash, dialog_id, id) > (?, ?, ?)
+			ORDER BY key_hash, dialog_id, id
+			LIMIT ?`,
+		Timeout: 30 * time.Second,
+	}
+
+	// Rows placeholders are added by the copier, read_at is never cleared
+	queryMap[copyMessages] = Query{
+		SQL: `INSERT INTO messages (id, dialog_id, sender_id, body, created_at, read_at, key_hash)
+				VALUES %s
+				ON DUPLICATE KEY UPDATE read_at = COALESCE(messages.read_at, VALUES(read_at));`,
+		Timeout: 30 * time.Second,
+	}
+
+	queryMap[purgeMessages] = Query{
+		SQL: `DELETE FROM messages
+			WHERE key_hash BETWEEN ? AND ?
+			LIMIT ?`,
+		Timeout: 30 * time.Second,
+	}
+
+	queryMap[shardState] = Query{
+		SQL: `SELECT version
+				, ring
+				, target
+			FROM dialog_shard_state
+			WHERE id = 1`,
+		Timeout: 5 * time.Second,
+	}
+
+	queryMap[shardStateForUpdate] = Query{
+		SQL: `SELECT target
+			FROM dialog_shard_state
+			WHERE id = 1
+			FOR UPDATE`,
+		Timeout: 5 * time.Second,
+	}
+
+	queryMap[shardMoves] = Query{
+		SQL: `SELECT range_start
+				, range_end
+				, from_shard
+				, to_shard
+				, switched
+				, dirty
+			FROM dialog_shard_moves
+			ORDER BY range_start`,
+		Timeout: 5 * time.Second,
+	}
+
+	// seen_at is the database time, the replicas' clocks may differ
+	queryMap[ackShardState] = Query{
+		SQL: `INSERT INTO dialog_shard_replicas (replica, version, seen_at)
+				VALUES (?, ?, NOW())
+				ON DUPLICATE KEY UPDATE version = VALUES(version), seen_at = NOW();`,
+		Timeout: 5 * time.Second,
+	}
+
+	queryMap[laggingReplicas] = Query{
+		SQL: `SELECT replica
+			FROM dialog_shard_replicas
+			WHERE version < ?
+			AND seen_at > NOW() - INTERVAL ? SECOND
+			ORDER BY replica`,
+		Timeout: 5 * time.Second,
+	}
+
+	// The node of the replica gone for the timeout is free
+	queryMap[releaseIDNode] = Query{
+		SQL: `UPDATE dialog_shard_replicas
+			SET node_id = NULL
+			WHERE node_id = ?
+			AND replica <> ?
+			AND seen_at < NOW() - INTERVAL ? SECOND`,
+		Timeout: 5 * time.Second,
+	}
+
+	// The unique node_id rejects the node of another replica
+	queryMap[claimIDNode] = Query{
+		SQL: `INSERT INTO dialog_shard_replicas (replica, version, seen_at, node_id)
+				VALUES (?, 0, NOW(), ?)
+				ON DUPLICATE KEY UPDATE node_id = VALUES(node_id), seen_at = NOW();`,
+		Timeout: 5 * time.Second,
+	}
+
+	// The counter changes the row every time, so no rows affected means switched
+	queryMap[markMoveDirty] = Query{
+		SQL: `UPDATE dialog_shard_moves
+				SET dirty = dirty + 1
+			WHERE range_start = ?
+			AND range_end = ?
+			AND switched = 0`,
+		Timeout: 5 * time.Second,
+	}
+
+	queryMap[deleteShardMoves] = Query{
+		SQL:     `DELETE FROM dialog_shard_moves`,
+		Timeout: 5 * time.Second,
+	}
+
+	// Rows placeholders are added by the store
+	queryMap[insertShardMoves] = Query{
+		SQL:     `INSERT INTO dialog_shard_moves (range_start, range_end, from_shard, to_shard) VALUES %s;`,
+		Timeout: 5 * time.Second,
+	}
+
+	queryMap[startResharding] = Query{
+		SQL: `UPDATE dialog_shard_state
+				SET ring = ?
+				, target = ?
+				, version = version + 1
+			WHERE id = 1`,
+		Timeout: 5 * time.Second,
+	}
+
+	queryMap[resetMoveDirty] = Query{
+		SQL: `UPDATE dialog_shard_moves
+				SET dirty = 0
+			WHERE range_start = ?
+			AND range_end = ?`,
+		Timeout: 5 * time.Second,
+	}
+
+	queryMap[switchMove] = Query{
+		SQL: `UPDATE dialog_shard_moves
+				SET switched = 1
+			WHERE range_start = ?
+			AND range_end = ?
+			AND switched = 0
+			AND dirty = 0`,
+		Timeout: 5 * time.Second,
+	}
+
+	queryMap[bumpShardVersion] = Query{
+		SQL: `UPDATE dialog_shard_state
+				SET version = version + 1
+			WHERE id = 1`,
+		Timeout: 5 * time.Second,
+	}
+
+	queryMap[finishResharding] = Query{
+		SQL: `UPDATE dialog_shard_state
+				SET ring = target
+				, target = NULL
+				, version = version + 1
+			WHERE id = 1
+			AND target IS NOT NULL`,
+		Timeout: 5 * time.Second,
+	}
+}
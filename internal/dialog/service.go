@@ -0,0 +1,195 @@
+package dialog
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"time"
+
+	"github.com/niklod/highload-social-network/internal/notify"
+)
+
+const (
+	dialogsLimit        = 100
+	defaultMessageLimit = 50
+	maxMessageLimit     = 200
+	maxMessageLength    = 4000
+)
+
+// Events pushed to dialog members over WebSocket
+const (
+	EventMessage = "dialog.message"
+	EventRead    = "dialog.read"
+)
+
+var (
+	ErrSameUser      = fmt.Errorf("can't start a dialog with yourself")
+	ErrEmptyMessage  = fmt.Errorf("message body can't be empty")
+	ErrMessageTooBig = fmt.Errorf("message body is too long")
+)
+
+type repository interface {
+	GetOrCreate(ctx context.Context, key Key) (*Dialog, error)
+	GetByKey(ctx context.Context, key Key) (*Dialog, error)
+	DialogsByUser(ctx context.Context, userId, limit int) ([]Dialog, error)
+	UpdateLastMessage(ctx context.Context, msg *Message) error
+	IncrementUnread(ctx context.Context, dialogId, userId int) error
+	MarkRead(ctx context.Context, dialogId, userId int, upToId int64, readCount int) error
+}
+
+// messageRepository stores the messages, routed by the conversation key.
+type messageRepository interface {
+	Add(ctx context.Context, key Key, msg *Message) error
+	Messages(ctx context.Context, key Key, dialogId int, before int64, limit int) ([]Message, error)
+	MarkRead(ctx context.Context, key Key, dialogId, readerId int, upToId int64, at time.Time) (int, error)
+}
+
+type Service struct {
+	repo     repository
+	messages messageRepository
+	notifier notify.Notifier
+}
+
+func NewService(repo repository, messages messageRepository, notifier notify.Notifier) *Service {
+	return &Service{
+		repo:     repo,
+		messages: messages,
+		notifier: notifier,
+	}
+}
+
+func (s *Service) Dialogs(ctx context.Context, userId int) ([]Dialog, error) {
+	dialogs, err := s.repo.DialogsByUser(ctx, userId, dialogsLimit)
+	if err != nil {
+		return nil, fmt.Errorf("dialog.Service: %v", err)
+	}
+
+	return dialogs, nil
+}
+
+// Messages returns a page of the conversation between the user and the
+// interlocutor, newest first. Zero before means from the latest message.
+func (s *Service) Messages(ctx context.Context, userId, interlocutorId int, before int64, limit int) (*MessagePage, error) {
+	if userId == interlocutorId {
+		return nil, ErrSameUser
+	}
+
+	key := NewKey(userId, interlocutorId)
+
+	d, err := s.repo.GetByKey(ctx, key)
+	if err != nil {
+		return nil, fmt.Errorf("dialog.Service: %v", err)
+	}
+	if d == nil {
+		return &MessagePage{Messages: []Message{}}, nil
+	}
+
+	if limit <= 0 || limit > maxMessageLimit {
+		limit = defaultMessageLimit
+	}
+	if before <= 0 {
+		before = math.MaxInt64
+	}
+
+	messages, err := s.messages.Messages(ctx, key, d.ID, before, limit)
+	if err != nil {
+		return nil, fmt.Errorf("dialog.Service: %v", err)
+	}
+
+	page := &MessagePage{Messages: messages}
+	if len(messages) == limit {
+		page.NextBefore = messages[len(messages)-1].ID
+	}
+
+	return page, nil
+}
+
+// Send stores the message and pushes it to both members, so the sender's
+// other tabs and devices see it too.
+func (s *Service) Send(ctx context.Context, from, to Participant, body string) (*Message, error) {
+	if from.ID == to.ID {
+		return nil, ErrSameUser
+	}
+	if body == "" {
+		return nil, ErrEmptyMessage
+	}
+	if len([]rune(body)) > maxMessageLength {
+		return nil, ErrMessageTooBig
+	}
+
+	key := NewKey(from.ID, to.ID)
+
+	d, err := s.repo.GetOrCreate(ctx, key)
+	if err != nil {
+		return nil, fmt.Errorf("dialog.Service: %v", err)
+	}
+
+	msg := &Message{
+		DialogID:  d.ID,
+		SenderID:  from.ID,
+		Body:      body,
+		CreatedAt: time.Now().UTC().Truncate(time.Second),
+	}
+
+	if err := s.messages.Add(ctx, key, msg); err != nil {
+		return nil, fmt.Errorf("dialog.Service: %v", err)
+	}
+
+	// The message is stored, dialog list metadata is best effort
+	if err := s.repo.UpdateLastMessage(ctx, msg); err != nil {
+		log.Printf("dialog.Service - updating last message: %v", err)
+	}
+	if err := s.repo.IncrementUnread(ctx, d.ID, to.ID); err != nil {
+		log.Printf("dialog.Service - incrementing unread counter: %v", err)
+	}
+
+	s.notifier.Notify(to.Login, EventMessage, msg)
+	s.notifier.Notify(from.Login, EventMessage, msg)
+
+	return msg, nil
+}
+
+// MarkRead marks the interlocutor's messages up to upToId as read by the
+// reader and sends a read receipt to the interlocutor.
+func (s *Service) MarkRead(ctx context.Context, reader, interlocutor Participant, upToId int64) (*ReadReceipt, error) {
+	if reader.ID == interlocutor.ID {
+		return nil, ErrSameUser
+	}
+
+	key := NewKey(reader.ID, interlocutor.ID)
+
+	d, err := s.repo.GetByKey(ctx, key)
+	if err != nil {
+		return nil, fmt.Errorf("dialog.Service: %v", err)
+	}
+	if d == nil {
+		return nil, nil
+	}
+
+	if upToId <= 0 {
+		upToId = math.MaxInt64
+	}
+
+	receipt := &ReadReceipt{
+		DialogID:      d.ID,
+		ReaderID:      reader.ID,
+		UpToMessageID: upToId,
+		ReadAt:        time.Now().UTC().Truncate(time.Second),
+	}
+
+	n, err := s.messages.MarkRead(ctx, key, d.ID, reader.ID, upToId, receipt.ReadAt)
+	if err != nil {
+		return nil, fmt.Errorf("dialog.Service: %v", err)
+	}
+
+	if err := s.repo.MarkRead(ctx, d.ID, reader.ID, upToId, n); err != nil {
+		return nil, fmt.Errorf("dialog.Service: %v", err)
+	}
+
+	if n > 0 {
+		s.notifier.Notify(interlocutor.Login, EventRead, receipt)
+	}
+
+	return receipt, nil
+}
@@ -0,0 +1,106 @@
+package dialog
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/niklod/highload-social-network/internal/dialog/shard"
+)
+
+type notification struct {
+	login string
+	event string
+}
+
+type fakeNotifier struct {
+	sent []notification
+}
+
+func (f *fakeNotifier) Notify(login string, event string, data interface{}) bool {
+	f.sent = append(f.sent, notification{login: login, event: event})
+	return true
+}
+
+func TestService_Send(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	notifier := &fakeNotifier{}
+	svc := NewService(NewRepository(db), NewMessageRepository(shard.NewRouter(map[int]*sql.DB{0: db}, 1, nil, 0), 0), notifier)
+
+	from := Participant{ID: 7, Login: "sender"}
+	to := Participant{ID: 3, Login: "recipient"}
+
+	mock.ExpectExec("INSERT INTO dialogs").WithArgs(3, 7).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT IGNORE INTO dialog_members").WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec("INSERT INTO messages").WillReturnResult(sqlmock.NewResult(10, 1))
+	mock.ExpectExec("UPDATE dialogs").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE dialog_members").WithArgs(1, 3).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	msg, err := svc.Send(context.Background(), from, to, "hello")
+
+	assert.Nil(t, err)
+	assert.NotZero(t, msg.ID)
+	assert.Equal(t, 1, msg.DialogID)
+	assert.Equal(t, []notification{
+		{login: "recipient", event: EventMessage},
+		{login: "sender", event: EventMessage},
+	}, notifier.sent)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestService_Send_Validation(t *testing.T) {
+	svc := NewService(nil, nil, &fakeNotifier{})
+
+	_, err := svc.Send(context.Background(), Participant{ID: 1}, Participant{ID: 1}, "hello")
+	assert.Equal(t, ErrSameUser, err)
+
+	_, err = svc.Send(context.Background(), Participant{ID: 1}, Participant{ID: 2}, "")
+	assert.Equal(t, ErrEmptyMessage, err)
+}
+
+func TestService_Messages_NoDialog(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	svc := NewService(NewRepository(db), NewMessageRepository(shard.NewRouter(map[int]*sql.DB{0: db}, 1, nil, 0), 0), &fakeNotifier{})
+
+	mock.ExpectQuery("SELECT d.id").WithArgs(3, 7).WillReturnRows(sqlmock.NewRows([]string{"id", "user_one_id", "user_two_id"}))
+
+	page, err := svc.Messages(context.Background(), 7, 3, 0, 0)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(page.Messages))
+	assert.Equal(t, int64(0), page.NextBefore)
+}
+
+func TestService_MarkRead_NotifiesInterlocutor(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	notifier := &fakeNotifier{}
+	svc := NewService(NewRepository(db), NewMessageRepository(shard.NewRouter(map[int]*sql.DB{0: db}, 1, nil, 0), 0), notifier)
+
+	mock.ExpectQuery("SELECT d.id").WithArgs(3, 7).WillReturnRows(sqlmock.NewRows([]string{"id", "user_one_id", "user_two_id"}).AddRow(1, 3, 7))
+	mock.ExpectExec("UPDATE messages").WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec("UPDATE dialog_members").WithArgs(int64(15), 2, 1, 3).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	receipt, err := svc.MarkRead(context.Background(), Participant{ID: 3, Login: "reader"}, Participant{ID: 7, Login: "sender"}, 15)
+
+	assert.Nil(t, err)
+	assert.Equal(t, int64(15), receipt.UpToMessageID)
+	assert.Equal(t, []notification{{login: "sender", event: EventRead}}, notifier.sent)
+}
@@ -0,0 +1,221 @@
+package shard
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultWaitPoll = time.Second
+
+// Copier moves the rows of a hash range between shards. Copy is run more
+// than once per range, so it must be idempotent.
+type Copier interface {
+	Copy(ctx context.Context, from, to *sql.DB, r Range) error
+	Purge(ctx context.Context, db *sql.DB, r Range) error
+}
+
+// Resharder moves the keys to a target ring while the replicas keep
+// serving them. Each moving range is dual written, copied until a pass
+// ends without failed dual writes, then switched to the new shard. Every
+// step waits for the live replicas to route by it; Resume continues a
+// failed resharding.
+type Resharder struct {
+	store          Store
+	shards         map[int]*sql.DB
+	vnodes         int
+	copier         Copier
+	replicaTimeout time.Duration
+	poll           time.Duration
+}
+
+func NewResharder(store Store, shards map[int]*sql.DB, vnodes int, copier Copier, replicaTimeout time.Duration) *Resharder {
+	return &Resharder{
+		store:          store,
+		shards:         shards,
+		vnodes:         vnodes,
+		copier:         copier,
+		replicaTimeout: replicaTimeout,
+		poll:           defaultWaitPoll,
+	}
+}
+
+// Start reshards to the target shards. current is only needed before the
+// first ring is recorded.
+func (r *Resharder) Start(ctx context.Context, current, target []int) error {
+	s, err := r.store.Load(ctx)
+	if err != nil {
+		return fmt.Errorf("loading shard routing: %v", err)
+	}
+	if s.Target != nil {
+		return ErrReshardInProgress
+	}
+
+	ring := s.Ring
+	switch {
+	case ring == nil && current == nil:
+		return fmt.Errorf("the ring of the replicas isn't recorded yet, give the current shards")
+	case ring == nil:
+		ring = current
+	case current != nil && FormatShards(current) != FormatShards(ring):
+		return fmt.Errorf("the replicas route over shards %s, not %s", FormatShards(ring), FormatShards(current))
+	}
+
+	if err := r.checkHandles(ring, target); err != nil {
+		return err
+	}
+
+	moves := NewRing(ring, r.vnodes).Moves(NewRing(target, r.vnodes))
+	if err := r.store.Start(ctx, ring, target, moves); err != nil {
+		return fmt.Errorf("starting resharding: %v", err)
+	}
+
+	return r.Resume(ctx)
+}
+
+// Resume continues the resharding started by Start.
+func (r *Resharder) Resume(ctx context.Context) error {
+	s, err := r.store.Load(ctx)
+	if err != nil {
+		return fmt.Errorf("loading shard routing: %v", err)
+	}
+	if s.Target == nil {
+		return ErrNoReshard
+	}
+
+	if err := r.checkHandles(s.Ring, s.Target); err != nil {
+		return err
+	}
+
+	// The moving ranges are written to both shards everywhere
+	if err := r.wait(ctx, s.Version); err != nil {
+		return err
+	}
+
+	for _, rs := range s.Ranges {
+		if rs.Switched {
+			continue
+		}
+		if err := r.switchRange(ctx, rs.Move); err != nil {
+			return err
+		}
+	}
+
+	// Nobody reads the old shards before they're purged
+	if s, err = r.store.Load(ctx); err != nil {
+		return fmt.Errorf("loading shard routing: %v", err)
+	}
+	if err := r.wait(ctx, s.Version); err != nil {
+		return err
+	}
+
+	for _, rs := range s.Ranges {
+		if err := r.copier.Purge(ctx, r.shards[rs.From], rs.Range); err != nil {
+			return fmt.Errorf("purging range %d-%d from shard %d: %v", rs.Range.Start, rs.Range.End, rs.From, err)
+		}
+	}
+
+	if err := r.store.Finish(ctx); err != nil {
+		return fmt.Errorf("finishing resharding: %v", err)
+	}
+
+	return nil
+}
+
+func (r *Resharder) switchRange(ctx context.Context, m Move) error {
+	from, to := r.shards[m.From], r.shards[m.To]
+
+	if err := r.copier.Copy(ctx, from, to, m.Range); err != nil {
+		return fmt.Errorf("copying range %d-%d from shard %d to %d: %v", m.Range.Start, m.Range.End, m.From, m.To, err)
+	}
+
+	for {
+		if err := r.store.ResetDirty(ctx, m.Range); err != nil {
+			return fmt.Errorf("resetting range %d-%d: %v", m.Range.Start, m.Range.End, err)
+		}
+
+		if err := r.copier.Copy(ctx, from, to, m.Range); err != nil {
+			return fmt.Errorf("copying range %d-%d from shard %d to %d: %v", m.Range.Start, m.Range.End, m.From, m.To, err)
+		}
+
+		switched, err := r.store.Switch(ctx, m.Range)
+		if err != nil {
+			return fmt.Errorf("switching range %d-%d: %v", m.Range.Start, m.Range.End, err)
+		}
+		if switched {
+			return nil
+		}
+
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+	}
+}
+
+// wait returns once the live replicas route by the version.
+func (r *Resharder) wait(ctx context.Context, version int64) error {
+	for {
+		lagging, err := r.store.Lagging(ctx, version, r.replicaTimeout)
+		if err != nil {
+			return fmt.Errorf("checking replicas: %v", err)
+		}
+		if len(lagging) == 0 {
+			return nil
+		}
+
+		log.Printf("waiting for replicas %s to route by version %d", strings.Join(lagging, ", "), version)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(r.poll):
+		}
+	}
+}
+
+func (r *Resharder) checkHandles(ring, target []int) error {
+	for _, id := range append(append([]int(nil), ring...), target...) {
+		if _, ok := r.shards[id]; !ok {
+			return fmt.Errorf("no database handle for shard %d", id)
+		}
+	}
+
+	return nil
+}
+
+// FormatShards returns the sorted comma separated shard IDs.
+func FormatShards(ids []int) string {
+	sorted := append([]int(nil), ids...)
+	sort.Ints(sorted)
+
+	s := make([]string, len(sorted))
+	for i, id := range sorted {
+		s[i] = strconv.Itoa(id)
+	}
+
+	return strings.Join(s, ",")
+}
+
+// ParseShards reads the shard IDs of FormatShards.
+func ParseShards(s string) ([]int, error) {
+	ids := []int{}
+	for _, f := range strings.Split(s, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+
+		id, err := strconv.Atoi(f)
+		if err != nil {
+			return nil, fmt.Errorf("shard ID %q: %v", f, err)
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
@@ -0,0 +1,136 @@
+package shard
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"hash/crc32"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// Range is an inclusive range of key hashes.
+type Range struct {
+	Start uint32
+	End   uint32
+}
+
+func (r Range) Contains(h uint32) bool {
+	return h >= r.Start && h <= r.End
+}
+
+// Move is a hash range which changes its owner shard between two rings.
+type Move struct {
+	Range Range
+	From  int
+	To    int
+}
+
+type point struct {
+	hash  uint32
+	shard int
+}
+
+// Ring is a consistent hashing ring. Each shard owns vnodes points and a
+// key belongs to the first point clockwise from the key hash.
+type Ring struct {
+	points []point
+	shards []int
+}
+
+func NewRing(shards []int, vnodes int) *Ring {
+	if vnodes <= 0 {
+		vnodes = 1
+	}
+
+	r := &Ring{shards: append([]int(nil), shards...)}
+
+	for _, s := range shards {
+		for v := 0; v < vnodes; v++ {
+			r.points = append(r.points, point{
+				hash:  pointHash(s, v),
+				shard: s,
+			})
+		}
+	}
+
+	sort.Slice(r.points, func(i, j int) bool {
+		if r.points[i].hash == r.points[j].hash {
+			return r.points[i].shard < r.points[j].shard
+		}
+		return r.points[i].hash < r.points[j].hash
+	})
+
+	return r
+}
+
+// Hash is the position of the key on the ring. It is CRC32, so MySQL can
+// compute it as well.
+func Hash(key string) uint32 {
+	return crc32.ChecksumIEEE([]byte(key))
+}
+
+// pointHash spreads the shard points evenly, CRC32 of the similar point
+// names clusters.
+func pointHash(shard, vnode int) uint32 {
+	sum := md5.Sum([]byte(strconv.Itoa(shard) + "#" + strconv.Itoa(vnode)))
+	return binary.BigEndian.Uint32(sum[:4])
+}
+
+func (r *Ring) Shards() []int {
+	return append([]int(nil), r.shards...)
+}
+
+// Locate returns the shard owning the hash.
+func (r *Ring) Locate(h uint32) int {
+	i := sort.Search(len(r.points), func(i int) bool {
+		return r.points[i].hash >= h
+	})
+	if i == len(r.points) {
+		i = 0
+	}
+
+	return r.points[i].shard
+}
+
+// Moves returns the hash ranges which are owned by a different shard in
+// target, merged where adjacent.
+func (r *Ring) Moves(target *Ring) []Move {
+	bounds := make([]uint32, 0, len(r.points)+len(target.points)+1)
+	for _, p := range r.points {
+		bounds = append(bounds, p.hash)
+	}
+	for _, p := range target.points {
+		bounds = append(bounds, p.hash)
+	}
+	bounds = append(bounds, math.MaxUint32)
+
+	sort.Slice(bounds, func(i, j int) bool { return bounds[i] < bounds[j] })
+
+	var moves []Move
+	var start uint32
+
+	for i, b := range bounds {
+		if i > 0 && b == bounds[i-1] {
+			continue
+		}
+
+		// Every hash in [start, b] belongs to the same point in both rings
+		from, to := r.Locate(b), target.Locate(b)
+		if from != to {
+			n := len(moves)
+			if n > 0 && moves[n-1].From == from && moves[n-1].To == to && moves[n-1].Range.End+1 == start {
+				moves[n-1].Range.End = b
+			} else {
+				moves = append(moves, Move{Range: Range{Start: start, End: b}, From: from, To: to})
+			}
+		}
+
+		if b == math.MaxUint32 {
+			break
+		}
+		start = b + 1
+	}
+
+	return moves
+}
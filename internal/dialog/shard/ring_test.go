@@ -0,0 +1,73 @@
+package shard
+
+import (
+	"math"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRing_Locate_Distribution(t *testing.T) {
+	ring := NewRing([]int{0, 1, 2}, 64)
+	counts := make(map[int]int)
+
+	for i := 0; i < 30000; i++ {
+		counts[ring.Locate(Hash(strconv.Itoa(i)+":"+strconv.Itoa(i+1)))]++
+	}
+
+	assert.Equal(t, 3, len(counts))
+	for shard, n := range counts {
+		// Each shard gets its third of the keys give or take
+		assert.True(t, n > 6000 && n < 14000, "shard %d got %d keys", shard, n)
+	}
+}
+
+func TestRing_Locate_Wraps(t *testing.T) {
+	ring := NewRing([]int{0, 1}, 8)
+
+	assert.Equal(t, ring.Locate(0), ring.Locate(math.MaxUint32))
+}
+
+func TestRing_Moves(t *testing.T) {
+	current := NewRing([]int{0, 1, 2}, 64)
+	target := NewRing([]int{0, 1, 2, 3}, 64)
+
+	moves := current.Moves(target)
+	assert.NotEmpty(t, moves)
+
+	for _, m := range moves {
+		// Adding a shard only moves keys onto it
+		assert.Equal(t, 3, m.To)
+		assert.True(t, m.Range.Start <= m.Range.End)
+	}
+
+	for i := 0; i < 20000; i++ {
+		h := Hash(strconv.Itoa(i))
+		from, to := current.Locate(h), target.Locate(h)
+
+		var found *Move
+		for j := range moves {
+			if moves[j].Range.Contains(h) {
+				found = &moves[j]
+				break
+			}
+		}
+
+		if from == to {
+			assert.Nil(t, found, "hash %d doesn't move but is in a moving range", h)
+			continue
+		}
+
+		if assert.NotNil(t, found, "hash %d moves from %d to %d but isn't in a moving range", h, from, to) {
+			assert.Equal(t, from, found.From)
+			assert.Equal(t, to, found.To)
+		}
+	}
+}
+
+func TestRing_Moves_SameRing(t *testing.T) {
+	ring := NewRing([]int{0, 1}, 16)
+
+	assert.Empty(t, ring.Moves(NewRing([]int{1, 0}, 16)))
+}
@@ -0,0 +1,239 @@
+package shard
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	ErrReshardInProgress = fmt.Errorf("resharding is already in progress")
+	ErrNoReshard         = fmt.Errorf("no resharding in progress")
+	// ErrStaleRouting is returned by the writes of a replica which hasn't
+	// synced the routing within its max age
+	ErrStaleRouting = fmt.Errorf("shard routing is out of date")
+)
+
+const markDirtyTimeout = 5 * time.Second
+
+// migration is the state of a single moving range. Until the range is
+// switched, reads go to the source shard and writes go to both shards.
+type migration struct {
+	Move
+	switched bool
+}
+
+// Router maps keys onto shard handles, following the State of the Store
+// when there is one. Queries hold the read lock, so a routing change waits
+// for the ones in flight.
+type Router struct {
+	mu         sync.RWMutex
+	shards     map[int]*sql.DB
+	vnodes     int
+	ring       *Ring
+	migrations []migration
+	// used are the shards of the ring and the target
+	used map[int]bool
+
+	store   Store
+	version int64
+	synced  time.Time
+	// Writes fail once the routing isn't synced within maxAge
+	maxAge time.Duration
+	now    func() time.Time
+}
+
+func NewRouter(shards map[int]*sql.DB, vnodes int, store Store, maxAge time.Duration) *Router {
+	ids := make([]int, 0, len(shards))
+	dbs := make(map[int]*sql.DB, len(shards))
+
+	for id, db := range shards {
+		ids = append(ids, id)
+		dbs[id] = db
+	}
+	sort.Ints(ids)
+
+	return &Router{
+		shards: dbs,
+		vnodes: vnodes,
+		ring:   NewRing(ids, vnodes),
+		store:  store,
+		maxAge: maxAge,
+		now:    time.Now,
+	}
+}
+
+// Shard returns the ID of the shard owning the key in the current ring.
+func (r *Router) Shard(key string) int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.ring.Locate(Hash(key))
+}
+
+// Read calls fn with the shard which has the complete data of the key.
+func (r *Router) Read(key string, fn func(db *sql.DB) error) error {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	h := Hash(key)
+
+	if m, ok := r.migration(h); ok {
+		if m.switched {
+			return fn(r.shards[m.To])
+		}
+		return fn(r.shards[m.From])
+	}
+
+	return fn(r.shards[r.ring.Locate(h)])
+}
+
+// Write calls fn with every shard which has to store the write to the key.
+// A failed write to the resharding target marks the range dirty instead of
+// failing the call.
+func (r *Router) Write(key string, fn func(db *sql.DB, primary bool) error) error {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if r.store != nil && r.now().Sub(r.synced) > r.maxAge {
+		return ErrStaleRouting
+	}
+
+	h := Hash(key)
+
+	m, ok := r.migration(h)
+	if !ok {
+		return fn(r.shards[r.ring.Locate(h)], true)
+	}
+	if m.switched {
+		return fn(r.shards[m.To], true)
+	}
+
+	if err := fn(r.shards[m.From], true); err != nil {
+		return err
+	}
+
+	if err := fn(r.shards[m.To], false); err != nil {
+		return r.markDirty(m, err)
+	}
+
+	return nil
+}
+
+// Sync routes by the State of the store and acks the version routed by.
+func (r *Router) Sync(ctx context.Context, replica string) error {
+	started := r.now()
+
+	s, err := r.store.Load(ctx)
+	if err != nil {
+		return fmt.Errorf("loading shard routing: %v", err)
+	}
+
+	applyErr := r.apply(s, started)
+
+	r.mu.RLock()
+	version := r.version
+	r.mu.RUnlock()
+
+	if err := r.store.Ack(ctx, replica, version); err != nil {
+		return fmt.Errorf("acking shard routing version %d: %v", version, err)
+	}
+
+	if applyErr != nil {
+		return fmt.Errorf("applying shard routing version %d: %v", s.Version, applyErr)
+	}
+
+	return nil
+}
+
+// Run syncs the routing every interval.
+func (r *Router) Run(replica string, interval time.Duration) {
+	if r.store == nil {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		<-ticker.C
+
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		if err := r.Sync(ctx, replica); err != nil {
+			log.Printf("syncing shard routing: %v", err)
+		}
+		cancel()
+	}
+}
+
+func (r *Router) apply(s State, at time.Time) error {
+	ids := s.Ring
+	if ids == nil {
+		ids = r.ring.Shards()
+	}
+
+	used := make(map[int]bool)
+	for _, id := range append(append([]int(nil), ids...), s.Target...) {
+		if _, ok := r.shards[id]; !ok {
+			return fmt.Errorf("no database handle for shard %d", id)
+		}
+		used[id] = true
+	}
+
+	migrations := make([]migration, 0, len(s.Ranges))
+	for _, rs := range s.Ranges {
+		migrations = append(migrations, migration{Move: rs.Move, switched: rs.Switched})
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.ring = NewRing(ids, r.vnodes)
+	r.migrations = migrations
+	r.version = s.Version
+	r.synced = at
+
+	for id := range r.used {
+		if used[id] {
+			continue
+		}
+
+		// The queries in flight are done, they hold the read lock
+		if err := r.shards[id].Close(); err != nil {
+			log.Printf("closing shard %d: %v", id, err)
+		}
+		delete(r.shards, id)
+	}
+	r.used = used
+
+	return nil
+}
+
+func (r *Router) migration(h uint32) (migration, bool) {
+	for _, m := range r.migrations {
+		if m.Range.Contains(h) {
+			return m, true
+		}
+	}
+
+	return migration{}, false
+}
+
+func (r *Router) markDirty(m migration, writeErr error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), markDirtyTimeout)
+	defer cancel()
+
+	ok, err := r.store.MarkDirty(ctx, m.Range)
+	if err != nil {
+		return fmt.Errorf("write to shard %d failed: %v, marking range %d-%d to copy again: %v", m.To, writeErr, m.Range.Start, m.Range.End, err)
+	}
+	if !ok {
+		return fmt.Errorf("write to shard %d failed and range %d-%d is switched to it: %v", m.To, m.Range.Start, m.Range.End, writeErr)
+	}
+
+	return nil
+}
@@ -0,0 +1,374 @@
+package shard
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeCopier runs hooks instead of copying, the router passes real
+// go-sqlmock handles to it
+type fakeCopier struct {
+	copies []Range
+	purged []Range
+	onCopy func(pass int, r Range) error
+}
+
+func (f *fakeCopier) Copy(ctx context.Context, from, to *sql.DB, r Range) error {
+	f.copies = append(f.copies, r)
+	if f.onCopy != nil {
+		return f.onCopy(len(f.copies), r)
+	}
+	return nil
+}
+
+func (f *fakeCopier) Purge(ctx context.Context, db *sql.DB, r Range) error {
+	f.purged = append(f.purged, r)
+	return nil
+}
+
+// movingKey finds a key which moves from the current ring to the target
+func movingKey(t *testing.T, current, target *Ring) string {
+	for i := 0; i < 100000; i++ {
+		key := strconv.Itoa(i) + ":" + strconv.Itoa(i+1)
+		if current.Locate(Hash(key)) != target.Locate(Hash(key)) {
+			return key
+		}
+	}
+
+	t.Fatal("no moving key found")
+	return ""
+}
+
+// syncRouter syncs the router as a replica until the test is done
+func syncRouter(t *testing.T, router *Router, replica string) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for ctx.Err() == nil {
+			router.Sync(ctx, replica)
+			time.Sleep(time.Millisecond)
+		}
+	}()
+
+	t.Cleanup(func() {
+		cancel()
+		wg.Wait()
+	})
+}
+
+func TestRouter_Write_SingleShard(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+
+	router := NewRouter(map[int]*sql.DB{0: db}, 1, nil, 0)
+
+	mock.ExpectExec("INSERT").WillReturnResult(sqlmock.NewResult(0, 1))
+
+	calls := 0
+	err := router.Write("3:7", func(db *sql.DB, primary bool) error {
+		calls++
+		assert.True(t, primary)
+		_, err := db.Exec("INSERT INTO messages VALUES (1)")
+		return err
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, 1, calls)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestRouter_Write_StaleRouting(t *testing.T) {
+	db, _, _ := sqlmock.New()
+	defer db.Close()
+
+	store := NewMemoryStore()
+	router := NewRouter(map[int]*sql.DB{0: db}, 1, store, time.Minute)
+
+	// Not synced yet
+	err := router.Write("3:7", func(db *sql.DB, primary bool) error { return nil })
+	assert.Equal(t, ErrStaleRouting, err)
+
+	assert.Nil(t, router.Sync(context.Background(), "replica-1"))
+	assert.Nil(t, router.Write("3:7", func(db *sql.DB, primary bool) error { return nil }))
+
+	router.now = func() time.Time { return time.Now().Add(2 * time.Minute) }
+	err = router.Write("3:7", func(db *sql.DB, primary bool) error { return nil })
+	assert.Equal(t, ErrStaleRouting, err)
+}
+
+func TestResharder_Reshard(t *testing.T) {
+	old, oldMock, _ := sqlmock.New()
+	defer old.Close()
+	added, addedMock, _ := sqlmock.New()
+	defer added.Close()
+
+	shards := map[int]*sql.DB{0: old, 1: added}
+	store := NewMemoryStore()
+	router := NewRouter(shards, 16, store, time.Minute)
+	target := NewRing([]int{0, 1}, 16)
+	key := movingKey(t, NewRing([]int{0}, 16), target)
+	staying := ""
+	for i := 0; staying == ""; i++ {
+		k := "staying:" + strconv.Itoa(i)
+		if target.Locate(Hash(k)) == 0 {
+			staying = k
+		}
+	}
+
+	exec := func(q string) func(db *sql.DB, primary bool) error {
+		return func(db *sql.DB, primary bool) error {
+			_, err := db.Exec(q)
+			return err
+		}
+	}
+	query := func(q string) func(db *sql.DB) error {
+		return func(db *sql.DB) error {
+			rows, err := db.Query(q)
+			if err != nil {
+				return err
+			}
+			return rows.Close()
+		}
+	}
+
+	copier := &fakeCopier{}
+	copier.onCopy = func(pass int, r Range) error {
+		if pass != 1 || !r.Contains(Hash(key)) {
+			return nil
+		}
+
+		// While the range is copied writes go to both shards and reads
+		// are served by the old one
+		oldMock.ExpectExec("dual write").WillReturnResult(sqlmock.NewResult(0, 1))
+		addedMock.ExpectExec("dual write").WillReturnResult(sqlmock.NewResult(0, 1))
+		assert.Nil(t, router.Write(key, exec("dual write")))
+
+		oldMock.ExpectQuery("read during copy").WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		assert.Nil(t, router.Read(key, query("read during copy")))
+
+		oldMock.ExpectExec("unaffected write").WillReturnResult(sqlmock.NewResult(0, 1))
+		assert.Nil(t, router.Write(staying, exec("unaffected write")))
+
+		return nil
+	}
+
+	// The replicas route over the configured shards until the ring is
+	// recorded
+	assert.Nil(t, router.Sync(context.Background(), "replica-1"))
+	syncRouter(t, router, "replica-1")
+
+	resharder := NewResharder(store, shards, 16, copier, time.Minute)
+	resharder.poll = time.Millisecond
+
+	err := resharder.Start(context.Background(), []int{0}, []int{0, 1})
+	assert.Nil(t, err)
+
+	// Every range is copied twice and purged from the old shard
+	moves := NewRing([]int{0}, 16).Moves(target)
+	assert.Equal(t, 2*len(moves), len(copier.copies))
+	assert.Equal(t, len(moves), len(copier.purged))
+
+	assert.Nil(t, router.Sync(context.Background(), "replica-1"))
+	addedMock.ExpectQuery("read after switch").WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	assert.Nil(t, router.Read(key, query("read after switch")))
+	assert.Equal(t, 1, router.Shard(key))
+
+	assert.Nil(t, oldMock.ExpectationsWereMet())
+	assert.Nil(t, addedMock.ExpectationsWereMet())
+}
+
+func TestResharder_Reshard_DirtyRangeIsCopiedAgain(t *testing.T) {
+	old, oldMock, _ := sqlmock.New()
+	defer old.Close()
+	added, addedMock, _ := sqlmock.New()
+	defer added.Close()
+
+	shards := map[int]*sql.DB{0: old, 1: added}
+	store := NewMemoryStore()
+	router := NewRouter(shards, 1, store, time.Minute)
+	target := NewRing([]int{0, 1}, 1)
+	key := movingKey(t, NewRing([]int{0}, 1), target)
+
+	keyCopies := 0
+	copier := &fakeCopier{}
+	copier.onCopy = func(pass int, r Range) error {
+		if !r.Contains(Hash(key)) {
+			return nil
+		}
+		keyCopies++
+		if keyCopies != 2 {
+			return nil
+		}
+
+		// The copy to the new shard fails during the reconcile pass
+		oldMock.ExpectExec("INSERT").WillReturnResult(sqlmock.NewResult(0, 1))
+		addedMock.ExpectExec("INSERT").WillReturnError(fmt.Errorf("shard is down"))
+
+		err := router.Write(key, func(db *sql.DB, primary bool) error {
+			_, err := db.Exec("INSERT INTO messages VALUES (1)")
+			return err
+		})
+		assert.Nil(t, err)
+
+		return nil
+	}
+
+	assert.Nil(t, router.Sync(context.Background(), "replica-1"))
+	syncRouter(t, router, "replica-1")
+
+	resharder := NewResharder(store, shards, 1, copier, time.Minute)
+	resharder.poll = time.Millisecond
+
+	err := resharder.Start(context.Background(), []int{0}, []int{0, 1})
+
+	// The dirty range is copied once more before the switch
+	assert.Nil(t, err)
+	assert.Equal(t, 3, keyCopies)
+	assert.Equal(t, 2*len(NewRing([]int{0}, 1).Moves(target))+1, len(copier.copies))
+	assert.Nil(t, oldMock.ExpectationsWereMet())
+	assert.Nil(t, addedMock.ExpectationsWereMet())
+}
+
+func TestRouter_Write_SwitchedRange(t *testing.T) {
+	old, oldMock, _ := sqlmock.New()
+	defer old.Close()
+	added, addedMock, _ := sqlmock.New()
+	defer added.Close()
+
+	store := NewMemoryStore()
+	router := NewRouter(map[int]*sql.DB{0: old, 1: added}, 1, store, time.Minute)
+	target := NewRing([]int{0, 1}, 1)
+	key := movingKey(t, NewRing([]int{0}, 1), target)
+	moves := NewRing([]int{0}, 1).Moves(target)
+
+	assert.Nil(t, store.Start(context.Background(), []int{0}, []int{0, 1}, moves))
+	assert.Nil(t, router.Sync(context.Background(), "replica-1"))
+
+	// Another replica's resharding switches the range before the failed
+	// dual write marks it
+	for _, m := range moves {
+		store.Switch(context.Background(), m.Range)
+	}
+
+	oldMock.ExpectExec("INSERT").WillReturnResult(sqlmock.NewResult(0, 1))
+	addedMock.ExpectExec("INSERT").WillReturnError(fmt.Errorf("shard is down"))
+
+	err := router.Write(key, func(db *sql.DB, primary bool) error {
+		_, err := db.Exec("INSERT INTO messages VALUES (1)")
+		return err
+	})
+	assert.NotNil(t, err)
+}
+
+func TestResharder_Reshard_WaitsForReplicas(t *testing.T) {
+	old, _, _ := sqlmock.New()
+	defer old.Close()
+	added, _, _ := sqlmock.New()
+	defer added.Close()
+
+	shards := map[int]*sql.DB{0: old, 1: added}
+	store := NewMemoryStore()
+
+	// The replica acked the ring, but it's gone before the resharding
+	router := NewRouter(shards, 4, store, time.Minute)
+	assert.Nil(t, router.Sync(context.Background(), "replica-1"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	copier := &fakeCopier{}
+	resharder := NewResharder(store, shards, 4, copier, time.Minute)
+	resharder.poll = time.Millisecond
+
+	err := resharder.Start(ctx, []int{0}, []int{0, 1})
+
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.Empty(t, copier.copies)
+}
+
+func TestResharder_Reshard_Resume(t *testing.T) {
+	old, _, _ := sqlmock.New()
+	defer old.Close()
+	added, _, _ := sqlmock.New()
+	defer added.Close()
+
+	shards := map[int]*sql.DB{0: old, 1: added}
+	store := NewMemoryStore()
+	copyErr := fmt.Errorf("lost connection")
+
+	copier := &fakeCopier{}
+	copier.onCopy = func(pass int, r Range) error {
+		if pass == 1 {
+			return copyErr
+		}
+		return nil
+	}
+	resharder := NewResharder(store, shards, 4, copier, time.Minute)
+	resharder.poll = time.Millisecond
+
+	err := resharder.Start(context.Background(), []int{0}, []int{0, 1})
+	assert.Contains(t, err.Error(), copyErr.Error())
+
+	err = resharder.Start(context.Background(), nil, []int{0, 1})
+	assert.Equal(t, ErrReshardInProgress, err)
+
+	copier.onCopy = nil
+	assert.Nil(t, resharder.Resume(context.Background()))
+	assert.Equal(t, ErrNoReshard, resharder.Resume(context.Background()))
+
+	s, _ := store.Load(context.Background())
+	assert.Equal(t, []int{0, 1}, s.Ring)
+}
+
+func TestResharder_Reshard_MissingShard(t *testing.T) {
+	old, _, _ := sqlmock.New()
+	defer old.Close()
+
+	store := NewMemoryStore()
+	resharder := NewResharder(store, map[int]*sql.DB{0: old}, 4, &fakeCopier{}, time.Minute)
+	resharder.poll = time.Millisecond
+
+	err := resharder.Start(context.Background(), []int{0}, []int{0, 1})
+
+	assert.NotNil(t, err)
+	assert.Equal(t, ErrNoReshard, resharder.Resume(context.Background()))
+
+	// The first resharding needs the ring of the replicas
+	err = resharder.Start(context.Background(), nil, []int{0})
+	assert.NotNil(t, err)
+}
+
+func TestRouter_Sync_ClosesDroppedShard(t *testing.T) {
+	kept, _, _ := sqlmock.New()
+	defer kept.Close()
+	dropped, droppedMock, _ := sqlmock.New()
+
+	shards := map[int]*sql.DB{0: kept, 1: dropped}
+	store := NewMemoryStore()
+	router := NewRouter(shards, 4, store, time.Minute)
+	assert.Nil(t, router.Sync(context.Background(), "replica-1"))
+	syncRouter(t, router, "replica-1")
+
+	droppedMock.ExpectClose()
+
+	resharder := NewResharder(store, shards, 4, &fakeCopier{}, time.Minute)
+	resharder.poll = time.Millisecond
+
+	err := resharder.Start(context.Background(), []int{0, 1}, []int{0})
+	assert.Nil(t, err)
+
+	assert.Nil(t, router.Sync(context.Background(), "replica-1"))
+	assert.Equal(t, 0, router.Shard("3:7"))
+	assert.Nil(t, droppedMock.ExpectationsWereMet())
+}
@@ -0,0 +1,197 @@
+package shard
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// State is the routing shared by the app replicas. Ring is nil until the
+// first resharding records it; Target and Ranges are set while one runs.
+type State struct {
+	Version int64
+	Ring    []int
+	Target  []int
+	Ranges  []RangeState
+}
+
+// RangeState is a moving range of the resharding in progress.
+type RangeState struct {
+	Move
+	// Switched ranges are read and written on the new shard only
+	Switched bool
+	// Dirty ranges have to be copied again before they're switched
+	Dirty bool
+}
+
+// Store keeps the State every replica routes by.
+type Store interface {
+	Load(ctx context.Context) (State, error)
+	// Ack records the version the replica routes by, doubling as its
+	// heartbeat
+	Ack(ctx context.Context, replica string, version int64) error
+	// Lagging returns the live replicas routing by an older version
+	Lagging(ctx context.Context, version int64, timeout time.Duration) ([]string, error)
+	// MarkDirty flags the range, false means it's already switched
+	MarkDirty(ctx context.Context, r Range) (bool, error)
+
+	// Start records the resharding from ring to target
+	Start(ctx context.Context, ring, target []int, moves []Move) error
+	ResetDirty(ctx context.Context, r Range) error
+	// Switch switches the range unless it's dirty
+	Switch(ctx context.Context, r Range) (bool, error)
+	// Finish makes the target the ring once all the ranges are switched
+	Finish(ctx context.Context) error
+}
+
+type ackedReplica struct {
+	version int64
+	at      time.Time
+}
+
+type memory struct {
+	mu       sync.Mutex
+	state    State
+	replicas map[string]ackedReplica
+	now      func() time.Time
+}
+
+// NewMemoryStore returns a Store of a single process.
+func NewMemoryStore() Store {
+	return &memory{
+		replicas: make(map[string]ackedReplica),
+		now:      time.Now,
+	}
+}
+
+func (m *memory) Load(ctx context.Context) (State, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return State{
+		Version: m.state.Version,
+		Ring:    copyShards(m.state.Ring),
+		Target:  copyShards(m.state.Target),
+		Ranges:  append([]RangeState(nil), m.state.Ranges...),
+	}, nil
+}
+
+func (m *memory) Ack(ctx context.Context, replica string, version int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.replicas[replica] = ackedReplica{version: version, at: m.now()}
+
+	return nil
+}
+
+func (m *memory) Lagging(ctx context.Context, version int64, timeout time.Duration) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var lagging []string
+	for name, r := range m.replicas {
+		if r.version < version && m.now().Sub(r.at) < timeout {
+			lagging = append(lagging, name)
+		}
+	}
+	sort.Strings(lagging)
+
+	return lagging, nil
+}
+
+func (m *memory) MarkDirty(ctx context.Context, r Range) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	rs := m.rangeState(r)
+	if rs == nil || rs.Switched {
+		return false, nil
+	}
+	rs.Dirty = true
+
+	return true, nil
+}
+
+func (m *memory) Start(ctx context.Context, ring, target []int, moves []Move) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.state.Target != nil {
+		return ErrReshardInProgress
+	}
+
+	m.state.Ring = copyShards(ring)
+	m.state.Target = copyShards(target)
+	m.state.Ranges = nil
+	for _, mv := range moves {
+		m.state.Ranges = append(m.state.Ranges, RangeState{Move: mv})
+	}
+	m.state.Version++
+
+	return nil
+}
+
+func (m *memory) ResetDirty(ctx context.Context, r Range) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if rs := m.rangeState(r); rs != nil {
+		rs.Dirty = false
+	}
+
+	return nil
+}
+
+func (m *memory) Switch(ctx context.Context, r Range) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	rs := m.rangeState(r)
+	if rs == nil || rs.Dirty {
+		return false, nil
+	}
+	if !rs.Switched {
+		rs.Switched = true
+		m.state.Version++
+	}
+
+	return true, nil
+}
+
+func (m *memory) Finish(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.state.Target == nil {
+		return ErrNoReshard
+	}
+
+	m.state.Ring = m.state.Target
+	m.state.Target = nil
+	m.state.Ranges = nil
+	m.state.Version++
+
+	return nil
+}
+
+// rangeState returns the moving range, the caller holds the lock
+func (m *memory) rangeState(r Range) *RangeState {
+	for i := range m.state.Ranges {
+		if m.state.Ranges[i].Range == r {
+			return &m.state.Ranges[i]
+		}
+	}
+
+	return nil
+}
+
+// copyShards keeps nil, it's the unknown ring
+func copyShards(ids []int) []int {
+	if ids == nil {
+		return nil
+	}
+
+	return append([]int{}, ids...)
+}
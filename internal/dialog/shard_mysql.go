@@ -0,0 +1,311 @@
+package dialog
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	driver "github.com/go-sql-driver/mysql"
+
+	"github.com/niklod/highload-social-network/internal/dialog/shard"
+)
+
+// errDuplicateEntry is the MySQL error of the unique key violation
+const errDuplicateEntry = 1062
+
+// shardStateMysql keeps the shard routing in the main database.
+type shardStateMysql struct {
+	db *sql.DB
+}
+
+func NewShardStore(db *sql.DB) shard.Store {
+	return &shardStateMysql{db: db}
+}
+
+// Load reads the state and its ranges in one snapshot.
+func (m *shardStateMysql) Load(ctx context.Context) (shard.State, error) {
+	tx, err := m.db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return shard.State{}, fmt.Errorf("starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var (
+		s            shard.State
+		ring, target sql.NullString
+	)
+
+	query := queryMap[shardState]
+	qctx, cancel := context.WithTimeout(ctx, query.Timeout)
+	defer cancel()
+
+	if err := tx.QueryRowContext(qctx, query.SQL).Scan(&s.Version, &ring, &target); err != nil {
+		return shard.State{}, fmt.Errorf("getting shard state: %v", err)
+	}
+
+	if ring.Valid {
+		if s.Ring, err = shard.ParseShards(ring.String); err != nil {
+			return shard.State{}, fmt.Errorf("reading shard ring: %v", err)
+		}
+	}
+	if !target.Valid {
+		return s, nil
+	}
+	if s.Target, err = shard.ParseShards(target.String); err != nil {
+		return shard.State{}, fmt.Errorf("reading target ring: %v", err)
+	}
+
+	query = queryMap[shardMoves]
+	qctx, cancel = context.WithTimeout(ctx, query.Timeout)
+	defer cancel()
+
+	rows, err := tx.QueryContext(qctx, query.SQL)
+	if err != nil {
+		return shard.State{}, fmt.Errorf("getting shard moves: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			rs    shard.RangeState
+			dirty int
+		)
+
+		err := rows.Scan(&rs.Range.Start, &rs.Range.End, &rs.From, &rs.To, &rs.Switched, &dirty)
+		if err != nil {
+			// A range left out would be routed by the old ring
+			return shard.State{}, fmt.Errorf("scanning shard move: %v", err)
+		}
+		rs.Dirty = dirty > 0
+
+		s.Ranges = append(s.Ranges, rs)
+	}
+
+	if err := rows.Err(); err != nil {
+		return shard.State{}, fmt.Errorf("getting shard moves: iterating through rows: %v", err)
+	}
+
+	return s, nil
+}
+
+func (m *shardStateMysql) Ack(ctx context.Context, replica string, version int64) error {
+	query := queryMap[ackShardState]
+	ctx, cancel := context.WithTimeout(ctx, query.Timeout)
+	defer cancel()
+
+	if _, err := m.db.ExecContext(ctx, query.SQL, replica, version); err != nil {
+		return fmt.Errorf("acking shard state of replica %s: %v", replica, err)
+	}
+
+	return nil
+}
+
+func (m *shardStateMysql) Lagging(ctx context.Context, version int64, timeout time.Duration) ([]string, error) {
+	query := queryMap[laggingReplicas]
+	ctx, cancel := context.WithTimeout(ctx, query.Timeout)
+	defer cancel()
+
+	rows, err := m.db.QueryContext(ctx, query.SQL, version, int(math.Ceil(timeout.Seconds())))
+	if err != nil {
+		return nil, fmt.Errorf("getting lagging replicas: %v", err)
+	}
+	defer rows.Close()
+
+	var lagging []string
+
+	for rows.Next() {
+		var replica string
+		if err := rows.Scan(&replica); err != nil {
+			return nil, fmt.Errorf("scanning replica: %v", err)
+		}
+
+		lagging = append(lagging, replica)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("getting lagging replicas: iterating through rows: %v", err)
+	}
+
+	return lagging, nil
+}
+
+// ClaimIDNode takes the message ID node for the replica. It fails with
+// ErrIDNodeTaken if another replica seen within timeout holds the node.
+func ClaimIDNode(ctx context.Context, db *sql.DB, replica string, node int, timeout time.Duration) error {
+	if err := checkIDNode(node); err != nil {
+		return err
+	}
+
+	query := queryMap[releaseIDNode]
+	qctx, cancel := context.WithTimeout(ctx, query.Timeout)
+	defer cancel()
+
+	if _, err := db.ExecContext(qctx, query.SQL, node, replica, int(math.Ceil(timeout.Seconds()))); err != nil {
+		return fmt.Errorf("releasing message ID node %d: %v", node, err)
+	}
+
+	query = queryMap[claimIDNode]
+	qctx, cancel = context.WithTimeout(ctx, query.Timeout)
+	defer cancel()
+
+	if _, err := db.ExecContext(qctx, query.SQL, replica, node); err != nil {
+		if e, ok := err.(*driver.MySQLError); ok && e.Number == errDuplicateEntry {
+			return ErrIDNodeTaken
+		}
+		return fmt.Errorf("claiming message ID node %d: %v", node, err)
+	}
+
+	return nil
+}
+
+func (m *shardStateMysql) MarkDirty(ctx context.Context, r shard.Range) (bool, error) {
+	query := queryMap[markMoveDirty]
+	ctx, cancel := context.WithTimeout(ctx, query.Timeout)
+	defer cancel()
+
+	res, err := m.db.ExecContext(ctx, query.SQL, r.Start, r.End)
+	if err != nil {
+		return false, fmt.Errorf("marking range %d-%d dirty: %v", r.Start, r.End, err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("getting affected rows: %v", err)
+	}
+
+	return n > 0, nil
+}
+
+func (m *shardStateMysql) Start(ctx context.Context, ring, target []int, moves []shard.Move) error {
+	ctx, cancel := context.WithTimeout(ctx, queryMap[startResharding].Timeout)
+	defer cancel()
+
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var current sql.NullString
+
+	query := queryMap[shardStateForUpdate]
+	if err := tx.QueryRowContext(ctx, query.SQL).Scan(&current); err != nil {
+		return fmt.Errorf("getting shard state: %v", err)
+	}
+	if current.Valid {
+		return shard.ErrReshardInProgress
+	}
+
+	if _, err := tx.ExecContext(ctx, queryMap[deleteShardMoves].SQL); err != nil {
+		return fmt.Errorf("deleting shard moves: %v", err)
+	}
+
+	if len(moves) > 0 {
+		placeholders := make([]string, len(moves))
+		args := make([]interface{}, 0, len(moves)*4)
+
+		for i, mv := range moves {
+			placeholders[i] = "(?, ?, ?, ?)"
+			args = append(args, mv.Range.Start, mv.Range.End, mv.From, mv.To)
+		}
+
+		_, err := tx.ExecContext(ctx, fmt.Sprintf(queryMap[insertShardMoves].SQL, strings.Join(placeholders, ", ")), args...)
+		if err != nil {
+			return fmt.Errorf("adding shard moves: %v", err)
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, queryMap[startResharding].SQL, shard.FormatShards(ring), shard.FormatShards(target))
+	if err != nil {
+		return fmt.Errorf("updating shard state: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("committing transaction: %v", err)
+	}
+
+	return nil
+}
+
+func (m *shardStateMysql) ResetDirty(ctx context.Context, r shard.Range) error {
+	query := queryMap[resetMoveDirty]
+	ctx, cancel := context.WithTimeout(ctx, query.Timeout)
+	defer cancel()
+
+	if _, err := m.db.ExecContext(ctx, query.SQL, r.Start, r.End); err != nil {
+		return fmt.Errorf("resetting range %d-%d: %v", r.Start, r.End, err)
+	}
+
+	return nil
+}
+
+func (m *shardStateMysql) Switch(ctx context.Context, r shard.Range) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, queryMap[switchMove].Timeout)
+	defer cancel()
+
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, queryMap[switchMove].SQL, r.Start, r.End)
+	if err != nil {
+		return false, fmt.Errorf("switching range %d-%d: %v", r.Start, r.End, err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("getting affected rows: %v", err)
+	}
+	if n == 0 {
+		return false, nil
+	}
+
+	if _, err := tx.ExecContext(ctx, queryMap[bumpShardVersion].SQL); err != nil {
+		return false, fmt.Errorf("updating shard state: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("committing transaction: %v", err)
+	}
+
+	return true, nil
+}
+
+func (m *shardStateMysql) Finish(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, queryMap[finishResharding].Timeout)
+	defer cancel()
+
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, queryMap[finishResharding].SQL)
+	if err != nil {
+		return fmt.Errorf("updating shard state: %v", err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("getting affected rows: %v", err)
+	}
+	if n == 0 {
+		return shard.ErrNoReshard
+	}
+
+	if _, err := tx.ExecContext(ctx, queryMap[deleteShardMoves].SQL); err != nil {
+		return fmt.Errorf("deleting shard moves: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("committing transaction: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,143 @@
+package dialog
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	driver "github.com/go-sql-driver/mysql"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/niklod/highload-social-network/internal/dialog/shard"
+)
+
+func Test_shardStateMysql_Load(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	store := NewShardStore(db)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT version").WillReturnRows(sqlmock.NewRows([]string{"version", "ring", "target"}).AddRow(4, "0,1", "0,1,2"))
+	mock.ExpectQuery("SELECT range_start").WillReturnRows(sqlmock.NewRows([]string{"range_start", "range_end", "from_shard", "to_shard", "switched", "dirty"}).
+		AddRow(10, 20, 0, 2, true, 0).
+		AddRow(30, 40, 1, 2, false, 3))
+	mock.ExpectRollback()
+
+	s, err := store.Load(context.Background())
+
+	assert.Nil(t, err)
+	assert.Equal(t, shard.State{
+		Version: 4,
+		Ring:    []int{0, 1},
+		Target:  []int{0, 1, 2},
+		Ranges: []shard.RangeState{
+			{Move: shard.Move{Range: shard.Range{Start: 10, End: 20}, From: 0, To: 2}, Switched: true},
+			{Move: shard.Move{Range: shard.Range{Start: 30, End: 40}, From: 1, To: 2}, Dirty: true},
+		},
+	}, s)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func Test_shardStateMysql_Load_NotRecorded(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	store := NewShardStore(db)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT version").WillReturnRows(sqlmock.NewRows([]string{"version", "ring", "target"}).AddRow(0, nil, nil))
+	mock.ExpectRollback()
+
+	s, err := store.Load(context.Background())
+
+	assert.Nil(t, err)
+	assert.Equal(t, shard.State{}, s)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func Test_shardStateMysql_MarkDirty_Switched(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	store := NewShardStore(db)
+
+	mock.ExpectExec("UPDATE dialog_shard_moves").WithArgs(uint32(10), uint32(20)).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	ok, err := store.MarkDirty(context.Background(), shard.Range{Start: 10, End: 20})
+
+	assert.Nil(t, err)
+	assert.False(t, ok)
+}
+
+func Test_shardStateMysql_Switch(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	store := NewShardStore(db)
+
+	// The switch bumps the version, so the replicas ack it
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE dialog_shard_moves").WithArgs(uint32(10), uint32(20)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE dialog_shard_state").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	ok, err := store.Switch(context.Background(), shard.Range{Start: 10, End: 20})
+
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func Test_shardStateMysql_Start_InProgress(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	store := NewShardStore(db)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT target").WillReturnRows(sqlmock.NewRows([]string{"target"}).AddRow("0,1"))
+	mock.ExpectRollback()
+
+	err = store.Start(context.Background(), []int{0}, []int{0, 2}, nil)
+
+	assert.Equal(t, shard.ErrReshardInProgress, err)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestClaimIDNode(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	mock.ExpectExec("UPDATE dialog_shard_replicas").WithArgs(7, "app-1:10", 30).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO dialog_shard_replicas").WithArgs("app-1:10", 7).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	// The live replica keeps its node
+	mock.ExpectExec("UPDATE dialog_shard_replicas").WithArgs(7, "app-2:11", 30).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO dialog_shard_replicas").WithArgs("app-2:11", 7).
+		WillReturnError(&driver.MySQLError{Number: 1062, Message: "Duplicate entry '7' for key 'node_id'"})
+
+	assert.Nil(t, ClaimIDNode(context.Background(), db, "app-1:10", 7, 30*time.Second))
+	assert.Equal(t, ErrIDNodeTaken, ClaimIDNode(context.Background(), db, "app-2:11", 7, 30*time.Second))
+	assert.NotNil(t, ClaimIDNode(context.Background(), db, "app-3:12", 1024, 30*time.Second))
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
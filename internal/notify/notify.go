@@ -0,0 +1,9 @@
+// Package notify lets the services push events to online users without
+// importing websocket, which imports them.
+package notify
+
+// Notifier pushes the event to the user and reports whether they were
+// online.
+type Notifier interface {
+	Notify(login string, event string, data interface{}) bool
+}
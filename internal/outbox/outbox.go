@@ -0,0 +1,29 @@
+// Package outbox defines the feed messages written in the transactions of
+// the post changes and published by producer.Relay.
+package outbox
+
+import "time"
+
+// Message types, the feed events of the post changes
+const (
+	PostCreated = "post.created"
+	PostUpdated = "post.updated"
+	PostDeleted = "post.deleted"
+)
+
+// Message is the feed message of a post change, ID and Key are published
+// as the message ID and key.
+type Message struct {
+	ID        int64
+	Type      string
+	Key       string
+	Body      []byte
+	CreatedAt time.Time
+}
+
+// Store is the table of the messages, see post.NewRepository.
+type Store interface {
+	// ClaimOutbox passes up to limit oldest messages to publish and deletes
+	// the ones it returns. Only one relay claims at a time
+	ClaimOutbox(limit int, publish func([]Message) []int64) error
+}
@@ -0,0 +1,257 @@
+package feed
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/niklod/highload-social-network/config"
+	"github.com/niklod/highload-social-network/internal/queue"
+	"github.com/streadway/amqp"
+)
+
+// confirmsBuffer holds the late confirms until the next publish drops them
+const confirmsBuffer = 1024
+
+// errChannelFailed fails the publish on a closed or broken channel
+var errChannelFailed = errors.New("publisher channel failed")
+
+// Channels opens the AMQP channels, see amqp.Connection and Manager.
+type Channels interface {
+	Channel() (*amqp.Channel, error)
+}
+
+// Publisher publishes to the feed exchange with publisher confirms on its
+// own channel, reopened after a failure.
+type Publisher struct {
+	conn    Channels
+	cfg     *config.RabbitMQConfig
+	timeout time.Duration
+
+	mu       sync.Mutex
+	ch       *amqp.Channel
+	confirms chan amqp.Confirmation
+	// tag is the delivery tag of the last message published on the channel
+	tag uint64
+}
+
+// NewPublisher creates the publisher waiting confirmTimeout for confirms.
+func NewPublisher(conn Channels, cfg *config.RabbitMQConfig, confirmTimeout time.Duration) *Publisher {
+	return &Publisher{
+		conn:    conn,
+		cfg:     cfg,
+		timeout: confirmTimeout,
+	}
+}
+
+func (p *Publisher) open() error {
+	ch, err := p.conn.Channel()
+	if err != nil {
+		return fmt.Errorf("can't open channel: %v", err)
+	}
+
+	if err := ch.Confirm(false); err != nil {
+		ch.Close()
+		return fmt.Errorf("can't put channel in confirm mode: %v", err)
+	}
+
+	p.ch = ch
+	p.confirms = ch.NotifyPublish(make(chan amqp.Confirmation, confirmsBuffer))
+	p.tag = 0
+
+	return nil
+}
+
+// Publish sends the messages and returns the confirmed ones, matched by
+// their delivery tags.
+func (p *Publisher) Publish(msgs ...queue.Message) ([]queue.Message, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.ch == nil {
+		if err := p.open(); err != nil {
+			return nil, fmt.Errorf("feed.Publisher - %v", err)
+		}
+	}
+
+	confirmed, err := p.publish(msgs)
+	if err == errChannelFailed {
+		// The next publish opens a new one
+		p.ch.Close()
+		p.ch = nil
+	}
+	if err != nil {
+		return confirmed, fmt.Errorf("feed.Publisher - %v", err)
+	}
+
+	return confirmed, nil
+}
+
+func (p *Publisher) publish(msgs []queue.Message) ([]queue.Message, error) {
+	var publishErr error
+	first := p.tag + 1
+
+	for _, m := range msgs {
+		err := p.ch.Publish(p.cfg.FeedExchangeName, p.cfg.FeedRoutingKey, false, false, amqp.Publishing{
+			DeliveryMode: amqp.Persistent,
+			ContentType:  "application/json",
+			MessageId:    m.ID,
+			Type:         m.Type,
+			Body:         m.Body,
+		})
+		if err != nil {
+			log.Printf("feed.Publisher - publishing message id [%s]: %v", m.ID, err)
+			publishErr = errChannelFailed
+			break
+		}
+
+		p.tag++
+	}
+
+	waiting := int(p.tag + 1 - first)
+	confirmed := make([]queue.Message, 0, waiting)
+
+	timeout := time.NewTimer(p.timeout)
+	defer timeout.Stop()
+
+	for waiting > 0 {
+		select {
+		case c, ok := <-p.confirms:
+			if !ok {
+				return confirmed, errChannelFailed
+			}
+			if c.DeliveryTag < first {
+				continue
+			}
+
+			waiting--
+			if c.Ack {
+				confirmed = append(confirmed, msgs[c.DeliveryTag-first])
+			}
+		case <-timeout.C:
+			return confirmed, fmt.Errorf("%d messages not confirmed in %s", waiting, p.timeout)
+		}
+	}
+
+	return confirmed, publishErr
+}
+
+// channel is the AMQP channel of the subscriber, see amqp.Channel
+type channel interface {
+	Consume(queue, consumer string, autoAck, exclusive, noLocal, noWait bool, args amqp.Table) (<-chan amqp.Delivery, error)
+	Cancel(consumer string, noWait bool) error
+	Publish(exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error
+}
+
+// Subscriber consumes the feed queue, retrying and parking the failed
+// messages, see NewQueueChannel.
+type Subscriber struct {
+	ch  channel
+	cfg *config.RabbitMQConfig
+}
+
+func NewSubscriber(ch channel, cfg *config.RabbitMQConfig) *Subscriber {
+	return &Subscriber{
+		ch:  ch,
+		cfg: cfg,
+	}
+}
+
+func (s *Subscriber) Subscribe(consumer string) (<-chan queue.Delivery, error) {
+	msgs, err := s.ch.Consume(
+		s.cfg.FeedQueueName,
+		consumer,
+		false, // auto ack
+		false, // exclusive
+		false,
+		false,
+		nil,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("feed.Subscriber - can't consume %q: %v", s.cfg.FeedQueueName, err)
+	}
+
+	out := make(chan queue.Delivery)
+	go func() {
+		defer close(out)
+
+		for m := range msgs {
+			out <- queue.Delivery{
+				Message: queue.Message{
+					ID:   m.MessageId,
+					Type: m.Type,
+					Body: m.Body,
+				},
+				Attempt:      RetryCount(m.Headers) + 1,
+				Acknowledger: &delivery{s: s, m: m},
+			}
+		}
+	}()
+
+	return out, nil
+}
+
+func (s *Subscriber) Unsubscribe(consumer string) error {
+	if err := s.ch.Cancel(consumer, false); err != nil {
+		return fmt.Errorf("feed.Subscriber - can't cancel %q: %v", consumer, err)
+	}
+
+	return nil
+}
+
+type delivery struct {
+	s *Subscriber
+	m amqp.Delivery
+}
+
+func (d *delivery) Ack() error {
+	return d.m.Ack(false)
+}
+
+func (d *delivery) Requeue() error {
+	return d.m.Nack(false, true)
+}
+
+func (d *delivery) Retry(err error) error {
+	return d.s.retry(d.m, err, false)
+}
+
+func (d *delivery) Park(err error) error {
+	return d.s.retry(d.m, err, true)
+}
+
+// retry sends the failed message to the retry queue of the next attempt,
+// or parks it once it's out of retries.
+func (s *Subscriber) retry(m amqp.Delivery, procErr error, park bool) error {
+	attempt := RetryCount(m.Headers) + 1
+
+	exchange, key := RetryExchangeName(s.cfg), RetryQueueName(s.cfg, attempt)
+	expiration := strconv.FormatInt(RetryDelay(s.cfg, attempt).Milliseconds(), 10)
+	if park || attempt > s.cfg.MaxRetries {
+		exchange, key, expiration = DeadLetterExchangeName(s.cfg), "", ""
+		log.Printf("message id [%s] - parking after %d attempts\n", m.MessageId, attempt)
+	}
+
+	err := s.ch.Publish(exchange, key, false, false, amqp.Publishing{
+		Headers:      FailedHeaders(m.Headers, attempt, procErr),
+		ContentType:  m.ContentType,
+		DeliveryMode: amqp.Persistent,
+		Expiration:   expiration,
+		MessageId:    m.MessageId,
+		Type:         m.Type,
+		Body:         m.Body,
+	})
+	if err != nil {
+		// The queue dead letters the rejected message
+		if nackErr := m.Nack(false, false); nackErr != nil {
+			log.Printf("message id [%s] - %v\n", m.MessageId, nackErr)
+		}
+
+		return fmt.Errorf("can't send message to retry: %v", err)
+	}
+
+	return m.Ack(false)
+}
@@ -0,0 +1,430 @@
+package feed
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/niklod/highload-social-network/config"
+	"github.com/niklod/highload-social-network/internal/queue"
+	"github.com/niklod/highload-social-network/internal/queue/kafka"
+)
+
+// The headers of the feed records
+const (
+	KafkaHeaderID   = "id"
+	KafkaHeaderType = "type"
+)
+
+// KafkaPublisher appends the feed messages to the partition of their key,
+// or of their ID if they have none.
+type KafkaPublisher struct {
+	client *kafka.Client
+	cfg    *config.KafkaConfig
+}
+
+func NewKafkaPublisher(client *kafka.Client, cfg *config.KafkaConfig) *KafkaPublisher {
+	return &KafkaPublisher{
+		client: client,
+		cfg:    cfg,
+	}
+}
+
+// Publish produces one batch per partition, confirmed as a whole.
+func (p *KafkaPublisher) Publish(msgs ...queue.Message) ([]queue.Message, error) {
+	partitions, err := p.client.Partitions(p.cfg.Topic)
+	if err != nil {
+		return nil, fmt.Errorf("feed.KafkaPublisher - %v", err)
+	}
+
+	var order []int32
+	batches := make(map[int32][]queue.Message)
+
+	for _, m := range msgs {
+		key := m.Key
+		if key == "" {
+			key = m.ID
+		}
+
+		partition := kafka.Partition([]byte(key), partitions)
+		if _, ok := batches[partition]; !ok {
+			order = append(order, partition)
+		}
+		batches[partition] = append(batches[partition], m)
+	}
+
+	var publishErr error
+	confirmed := make([]queue.Message, 0, len(msgs))
+	now := time.Now()
+
+	for _, partition := range order {
+		batch := batches[partition]
+
+		records := make([]kafka.Record, len(batch))
+		for i, m := range batch {
+			records[i] = kafkaRecord(m, now)
+		}
+
+		if _, err := p.client.Produce(p.cfg.Topic, partition, records...); err != nil {
+			log.Printf("feed.KafkaPublisher - producing %d messages to partition %d: %v", len(batch), partition, err)
+			if publishErr == nil {
+				publishErr = fmt.Errorf("feed.KafkaPublisher - %v", err)
+			}
+			continue
+		}
+
+		confirmed = append(confirmed, batch...)
+	}
+
+	return confirmed, publishErr
+}
+
+func kafkaRecord(m queue.Message, at time.Time) kafka.Record {
+	r := kafka.Record{
+		Value: m.Body,
+		Headers: []kafka.Header{
+			{Key: KafkaHeaderID, Value: []byte(m.ID)},
+			{Key: KafkaHeaderType, Value: []byte(m.Type)},
+		},
+		Timestamp: at,
+	}
+	if m.Key != "" {
+		r.Key = []byte(m.Key)
+	}
+
+	return r
+}
+
+func kafkaMessage(r kafka.Record) queue.Message {
+	id, _ := r.Header(KafkaHeaderID)
+	typ, _ := r.Header(KafkaHeaderType)
+
+	return queue.Message{
+		ID:   string(id),
+		Type: string(typ),
+		Key:  string(r.Key),
+		Body: r.Value,
+	}
+}
+
+// KafkaPartitionSubscriber consumes the static cfg.Partitions of the feed
+// topic without joining the consumer group, which only keeps the offsets,
+// so partitions aren't rebalanced when a replica goes down. Each partition
+// has one message in hand at a time, retried in place until it's parked.
+type KafkaPartitionSubscriber struct {
+	client *kafka.Client
+	cfg    *config.KafkaConfig
+	retry  *config.RabbitMQConfig
+
+	msgs chan queue.Delivery
+
+	mu   sync.Mutex
+	subs map[string]chan struct{}
+	// stop stops the partition loops
+	stop  chan struct{}
+	loops sync.WaitGroup
+}
+
+func NewKafkaPartitionSubscriber(client *kafka.Client, cfg *config.KafkaConfig, retry *config.RabbitMQConfig) *KafkaPartitionSubscriber {
+	return &KafkaPartitionSubscriber{
+		client: client,
+		cfg:    cfg,
+		retry:  retry,
+		msgs:   make(chan queue.Delivery),
+		subs:   make(map[string]chan struct{}),
+	}
+}
+
+// Subscribe starts the partition loops for the first consumer.
+func (s *KafkaPartitionSubscriber) Subscribe(consumer string) (<-chan queue.Delivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.subs[consumer]; ok {
+		return nil, fmt.Errorf("feed.KafkaPartitionSubscriber - consumer %q exists", consumer)
+	}
+
+	if len(s.subs) == 0 {
+		if err := s.start(); err != nil {
+			return nil, fmt.Errorf("feed.KafkaPartitionSubscriber - %v", err)
+		}
+	}
+
+	stop := make(chan struct{})
+	s.subs[consumer] = stop
+
+	out := make(chan queue.Delivery)
+	go s.forward(out, stop)
+
+	return out, nil
+}
+
+// Unsubscribe stops the partition loops after the last consumer.
+func (s *KafkaPartitionSubscriber) Unsubscribe(consumer string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stop, ok := s.subs[consumer]
+	if !ok {
+		return fmt.Errorf("feed.KafkaPartitionSubscriber - unknown consumer %q", consumer)
+	}
+
+	delete(s.subs, consumer)
+	close(stop)
+
+	if len(s.subs) == 0 {
+		close(s.stop)
+	}
+
+	return nil
+}
+
+// start runs a loop for each partition of the replica, the lock is held
+func (s *KafkaPartitionSubscriber) start() error {
+	// Without a group every replica would consume every message
+	partitions := s.cfg.Partitions
+	if len(partitions) == 0 {
+		return fmt.Errorf("no partitions assigned to the replica, set KAFKA_FEED_PARTITIONS")
+	}
+
+	// The loops of the previous consumers may be on the way out
+	s.loops.Wait()
+
+	s.stop = make(chan struct{})
+	for _, p := range partitions {
+		s.loops.Add(1)
+		go s.consume(p, s.stop)
+	}
+
+	return nil
+}
+
+func (s *KafkaPartitionSubscriber) forward(out chan queue.Delivery, stop chan struct{}) {
+	defer close(out)
+
+	for {
+		select {
+		case d := <-s.msgs:
+			select {
+			case out <- d:
+			case <-stop:
+				d.Requeue()
+				return
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// consume delivers the partition from the committed or oldest offset
+func (s *KafkaPartitionSubscriber) consume(partition int32, stop chan struct{}) {
+	defer s.loops.Done()
+
+	offset := int64(-1)
+
+	for attempt := 0; ; {
+		var (
+			records []kafka.Record
+			err     error
+		)
+
+		if offset < 0 {
+			if offset, err = s.committed(partition); err != nil {
+				offset = -1
+			}
+		}
+		if err == nil {
+			records, err = s.client.Fetch(s.cfg.Topic, partition, offset, s.cfg.FetchWait)
+		}
+		if err == kafka.ErrOffsetOutOfRange {
+			log.Printf("feed.KafkaPartitionSubscriber - offset %d of partition %d is out of range, starting with the oldest", offset, partition)
+			offset, err = s.client.ListOffset(s.cfg.Topic, partition, kafka.OffsetOldest)
+			if err != nil {
+				offset = -1
+			}
+			continue
+		}
+		if err != nil {
+			attempt++
+			log.Printf("feed.KafkaPartitionSubscriber - reading partition %d: %v", partition, err)
+			if !sleep(ReconnectDelay(s.retry, attempt), stop) {
+				return
+			}
+			continue
+		}
+		attempt = 0
+
+		for _, r := range records {
+			if !s.process(partition, r, stop) {
+				return
+			}
+			offset = r.Offset + 1
+		}
+
+		select {
+		case <-stop:
+			return
+		default:
+		}
+	}
+}
+
+func (s *KafkaPartitionSubscriber) committed(partition int32) (int64, error) {
+	offset, err := s.client.FetchOffset(s.cfg.Group, s.cfg.Topic, partition)
+	if err != nil || offset >= 0 {
+		return offset, err
+	}
+
+	return s.client.ListOffset(s.cfg.Topic, partition, kafka.OffsetOldest)
+}
+
+// process delivers the record until it's settled, false if stopped
+func (s *KafkaPartitionSubscriber) process(partition int32, r kafka.Record, stop chan struct{}) bool {
+	msg := kafkaMessage(r)
+
+	for attempt := 1; ; {
+		settled := make(chan settlement, 1)
+		d := queue.Delivery{
+			Message:      msg,
+			Attempt:      attempt,
+			Acknowledger: &kafkaDelivery{settled: settled},
+		}
+
+		select {
+		case s.msgs <- d:
+		case <-stop:
+			return false
+		}
+
+		var st settlement
+		select {
+		case st = <-settled:
+		case <-stop:
+			return false
+		}
+
+		switch st.action {
+		case settleRequeue:
+			continue
+		case settleRetry:
+			if attempt <= s.retry.MaxRetries {
+				if !sleep(RetryDelay(s.retry, attempt), stop) {
+					return false
+				}
+				attempt++
+				continue
+			}
+			fallthrough
+		case settlePark:
+			if !s.park(r, attempt, st.err, stop) {
+				return false
+			}
+		}
+
+		if err := s.client.CommitOffset(s.cfg.Group, s.cfg.Topic, partition, r.Offset+1); err != nil {
+			// The next commit covers it
+			log.Printf("feed.KafkaPartitionSubscriber - committing offset %d of partition %d: %v", r.Offset+1, partition, err)
+		}
+
+		return true
+	}
+}
+
+// park produces the failed record to the dead topic, retrying until it
+// succeeds as the partition is blocked meanwhile
+func (s *KafkaPartitionSubscriber) park(r kafka.Record, attempt int, procErr error, stop chan struct{}) bool {
+	log.Printf("message id [%s] - parking after %d attempts\n", kafkaMessage(r).ID, attempt)
+
+	dead := kafka.Record{
+		Key:   r.Key,
+		Value: r.Value,
+		Headers: append(append([]kafka.Header(nil), r.Headers...),
+			kafka.Header{Key: HeaderRetryCount, Value: []byte(strconv.Itoa(attempt))},
+			kafka.Header{Key: HeaderError, Value: []byte(errText(procErr))}),
+		Timestamp: time.Now(),
+	}
+
+	for n := 1; ; n++ {
+		partitions, err := s.client.Partitions(s.cfg.DeadTopic)
+		if err == nil {
+			_, err = s.client.Produce(s.cfg.DeadTopic, kafka.Partition(r.Key, partitions), dead)
+		}
+		if err == nil {
+			return true
+		}
+
+		log.Printf("feed.KafkaPartitionSubscriber - parking message id [%s]: %v", kafkaMessage(r).ID, err)
+		if !sleep(ReconnectDelay(s.retry, n), stop) {
+			return false
+		}
+	}
+}
+
+func errText(err error) string {
+	if err == nil {
+		return ""
+	}
+
+	return err.Error()
+}
+
+// sleep waits for d, false if stopped
+func sleep(d time.Duration, stop chan struct{}) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-stop:
+		return false
+	}
+}
+
+type settleAction int
+
+const (
+	settleAck settleAction = iota
+	settleRequeue
+	settleRetry
+	settlePark
+)
+
+// settlement is the outcome of the delivery, see kafkaDelivery
+type settlement struct {
+	action settleAction
+	err    error
+}
+
+// kafkaDelivery passes the first settlement to the partition loop
+type kafkaDelivery struct {
+	settled chan settlement
+}
+
+func (d *kafkaDelivery) settle(action settleAction, err error) error {
+	select {
+	case d.settled <- settlement{action: action, err: err}:
+	default:
+	}
+
+	return nil
+}
+
+func (d *kafkaDelivery) Ack() error {
+	return d.settle(settleAck, nil)
+}
+
+func (d *kafkaDelivery) Requeue() error {
+	return d.settle(settleRequeue, nil)
+}
+
+func (d *kafkaDelivery) Retry(err error) error {
+	return d.settle(settleRetry, err)
+}
+
+func (d *kafkaDelivery) Park(err error) error {
+	return d.settle(settlePark, err)
+}
@@ -0,0 +1,81 @@
+//go:build kafka
+// +build kafka
+
+package feed
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/kelseyhightower/envconfig"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/niklod/highload-social-network/config"
+	"github.com/niklod/highload-social-network/internal/queue"
+	"github.com/niklod/highload-social-network/internal/queue/kafka"
+	"github.com/niklod/highload-social-network/internal/queue/queuetest"
+)
+
+// TestKafkaPartitionSubscriber_Broker runs against the broker of
+// KAFKA_BROKERS, which creates the topics on the first use:
+//
+//	go test -tags kafka -run Broker ./internal/queue/feed/
+func TestKafkaPartitionSubscriber_Broker(t *testing.T) {
+	var cfg config.KafkaConfig
+	if err := envconfig.Process("", &cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	suffix := strconv.FormatInt(time.Now().UnixNano(), 10)
+	cfg.Topic = "feed-test-" + suffix
+	cfg.DeadTopic = cfg.Topic + "-dead"
+	cfg.Group = "feed-test-" + suffix
+	cfg.FetchWait = 100 * time.Millisecond
+
+	client := kafka.NewClient(&cfg)
+	defer client.Close()
+
+	// The topic has no leader for a while after it's created
+	var partitions int
+	for start := time.Now(); ; time.Sleep(100 * time.Millisecond) {
+		n, err := client.Partitions(cfg.Topic)
+		if err == nil {
+			partitions = n
+			break
+		}
+		if time.Since(start) > 10*time.Second {
+			t.Fatal(err)
+		}
+	}
+	for p := 0; p < partitions; p++ {
+		cfg.Partitions = append(cfg.Partitions, int32(p))
+	}
+
+	msgs := []queue.Message{
+		{ID: "1", Type: "post.created", Key: "7", Body: []byte("a")},
+		{ID: "2", Type: "post.updated", Key: "7", Body: []byte("b")},
+	}
+	confirmed, err := NewKafkaPublisher(client, &cfg).Publish(msgs...)
+	assert.Nil(t, err)
+	assert.Equal(t, msgs, confirmed)
+
+	sub := NewKafkaPartitionSubscriber(client, &cfg, &config.RabbitMQConfig{})
+	deliveries, err := sub.Subscribe("feed-receiver-1")
+	assert.Nil(t, err)
+	defer sub.Unsubscribe("feed-receiver-1")
+
+	// The author's messages come in order, the offsets are committed for
+	// the group
+	for _, m := range msgs {
+		d := queuetest.Receive(t, deliveries)
+		assert.Equal(t, m, d.Message)
+		assert.Nil(t, d.Ack())
+	}
+
+	partition := kafka.Partition([]byte("7"), partitions)
+	queuetest.WaitFor(t, func() bool {
+		offset, err := client.FetchOffset(cfg.Group, cfg.Topic, partition)
+		return err == nil && offset == 2
+	})
+}
@@ -0,0 +1,231 @@
+package feed
+
+import (
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/niklod/highload-social-network/config"
+	"github.com/niklod/highload-social-network/internal/queue"
+	"github.com/niklod/highload-social-network/internal/queue/kafka"
+	"github.com/niklod/highload-social-network/internal/queue/queuetest"
+)
+
+const testPartitions = 4
+
+func newTestKafka(t *testing.T) (*kafka.Client, *kafka.Server, *config.KafkaConfig) {
+	t.Helper()
+
+	srv, err := kafka.NewServer(testPartitions)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &config.KafkaConfig{
+		Brokers:       []string{srv.Addr()},
+		Topic:         "feed",
+		DeadTopic:     "feed-dead",
+		Group:         "receivers",
+		Partitions:    []int32{0, 1, 2, 3},
+		PoolSize:      8,
+		Timeout:       time.Second,
+		FetchWait:     20 * time.Millisecond,
+		FetchMaxBytes: 1 << 20,
+	}
+
+	client := kafka.NewClient(cfg)
+	// The subscribers are unsubscribed first
+	t.Cleanup(func() {
+		client.Close()
+		srv.Close()
+	})
+
+	return client, srv, cfg
+}
+
+// otherKey returns a key of another partition than the key's one
+func otherKey(key string) string {
+	for k := 0; ; k++ {
+		other := strconv.Itoa(k)
+		if kafka.Partition([]byte(other), testPartitions) != kafka.Partition([]byte(key), testPartitions) {
+			return other
+		}
+	}
+}
+
+func TestKafkaPublisher_Publish(t *testing.T) {
+	client, srv, cfg := newTestKafka(t)
+	pub := NewKafkaPublisher(client, cfg)
+
+	msgs := []queue.Message{
+		{ID: "1", Type: "post.created", Key: "7", Body: []byte("a")},
+		{ID: "2", Type: "post.created", Key: otherKey("7"), Body: []byte("b")},
+		{ID: "3", Type: "post.updated", Key: "7", Body: []byte("c")},
+	}
+
+	confirmed, err := pub.Publish(msgs...)
+	assert.Nil(t, err)
+	assert.ElementsMatch(t, msgs, confirmed)
+
+	// The author's messages are in one partition in order
+	records := srv.Records("feed", kafka.Partition([]byte("7"), testPartitions))
+	if assert.Len(t, records, 2) {
+		assert.Equal(t, msgs[0], kafkaMessage(records[0]))
+		assert.Equal(t, msgs[2], kafkaMessage(records[1]))
+	}
+}
+
+func TestKafkaPartitionSubscriber_CommitsOffsets(t *testing.T) {
+	client, srv, cfg := newTestKafka(t)
+	pub := NewKafkaPublisher(client, cfg)
+	sub := NewKafkaPartitionSubscriber(client, cfg, &config.RabbitMQConfig{})
+
+	partition := kafka.Partition([]byte("7"), testPartitions)
+	pub.Publish(queue.Message{ID: "1", Key: "7"}, queue.Message{ID: "2", Key: "7"})
+
+	msgs, err := sub.Subscribe("feed-receiver-1")
+	assert.Nil(t, err)
+	defer sub.Unsubscribe("feed-receiver-1")
+
+	_, err = sub.Subscribe("feed-receiver-1")
+	assert.NotNil(t, err)
+
+	d := queuetest.Receive(t, msgs)
+	assert.Equal(t, "1", d.ID)
+	assert.Equal(t, 1, d.Attempt)
+
+	// The next message of the partition waits for the one in hand
+	select {
+	case d := <-msgs:
+		t.Fatalf("message %s delivered out of order", d.ID)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	assert.Nil(t, d.Ack())
+	queuetest.WaitFor(t, func() bool { return srv.Committed("receivers", "feed", partition) == 1 })
+
+	assert.Nil(t, queuetest.Receive(t, msgs).Ack())
+	queuetest.WaitFor(t, func() bool { return srv.Committed("receivers", "feed", partition) == 2 })
+
+	assert.Nil(t, sub.Unsubscribe("feed-receiver-1"))
+	for range msgs {
+	}
+
+	// The group goes on from the committed offsets
+	pub.Publish(queue.Message{ID: "3", Key: "7"})
+
+	msgs, err = sub.Subscribe("feed-receiver-2")
+	assert.Nil(t, err)
+	defer sub.Unsubscribe("feed-receiver-2")
+	assert.Equal(t, "3", queuetest.Receive(t, msgs).ID)
+}
+
+func TestKafkaPartitionSubscriber_Requeue(t *testing.T) {
+	client, srv, cfg := newTestKafka(t)
+	pub := NewKafkaPublisher(client, cfg)
+	sub := NewKafkaPartitionSubscriber(client, cfg, &config.RabbitMQConfig{})
+
+	partition := kafka.Partition([]byte("7"), testPartitions)
+	pub.Publish(queue.Message{ID: "1", Key: "7"})
+
+	msgs, err := sub.Subscribe("feed-receiver-1")
+	assert.Nil(t, err)
+	defer sub.Unsubscribe("feed-receiver-1")
+	d := queuetest.Receive(t, msgs)
+
+	// The message not processed is read again after the restart
+	assert.Nil(t, sub.Unsubscribe("feed-receiver-1"))
+	assert.Nil(t, d.Requeue())
+	for range msgs {
+	}
+	assert.Equal(t, int64(-1), srv.Committed("receivers", "feed", partition))
+
+	msgs, err = sub.Subscribe("feed-receiver-1")
+	assert.Nil(t, err)
+	defer sub.Unsubscribe("feed-receiver-1")
+	d = queuetest.Receive(t, msgs)
+	assert.Equal(t, "1", d.ID)
+	assert.Equal(t, 1, d.Attempt)
+}
+
+func TestKafkaPartitionSubscriber_Retry(t *testing.T) {
+	client, srv, cfg := newTestKafka(t)
+	pub := NewKafkaPublisher(client, cfg)
+	sub := NewKafkaPartitionSubscriber(client, cfg, &config.RabbitMQConfig{MaxRetries: 1, RetryDelay: time.Millisecond})
+
+	partition := kafka.Partition([]byte("7"), testPartitions)
+	pub.Publish(queue.Message{ID: "1", Type: "post.created", Key: "7", Body: []byte("a")}, queue.Message{ID: "2", Key: "7"})
+
+	msgs, err := sub.Subscribe("feed-receiver-1")
+	assert.Nil(t, err)
+	defer sub.Unsubscribe("feed-receiver-1")
+
+	// A retry and the parking
+	for attempt := 1; attempt <= 2; attempt++ {
+		d := queuetest.Receive(t, msgs)
+		assert.Equal(t, "1", d.ID)
+		assert.Equal(t, attempt, d.Attempt)
+		assert.Nil(t, d.Retry(fmt.Errorf("db is down")))
+	}
+
+	assert.Equal(t, "2", queuetest.Receive(t, msgs).ID)
+	queuetest.WaitFor(t, func() bool { return srv.Committed("receivers", "feed", partition) == 1 })
+
+	dead := srv.Records("feed-dead", kafka.Partition([]byte("7"), testPartitions))
+	if assert.Len(t, dead, 1) {
+		assert.Equal(t, queue.Message{ID: "1", Type: "post.created", Key: "7", Body: []byte("a")}, kafkaMessage(dead[0]))
+
+		count, _ := dead[0].Header(HeaderRetryCount)
+		assert.Equal(t, "2", string(count))
+		reason, _ := dead[0].Header(HeaderError)
+		assert.Equal(t, "db is down", string(reason))
+	}
+}
+
+func TestKafkaPartitionSubscriber_Park(t *testing.T) {
+	client, srv, cfg := newTestKafka(t)
+	pub := NewKafkaPublisher(client, cfg)
+	sub := NewKafkaPartitionSubscriber(client, cfg, &config.RabbitMQConfig{MaxRetries: 5})
+
+	partition := kafka.Partition([]byte("7"), testPartitions)
+	pub.Publish(queue.Message{ID: "1", Key: "7"})
+
+	msgs, err := sub.Subscribe("feed-receiver-1")
+	assert.Nil(t, err)
+	defer sub.Unsubscribe("feed-receiver-1")
+
+	assert.Nil(t, queuetest.Receive(t, msgs).Park(fmt.Errorf("malformed")))
+	queuetest.WaitFor(t, func() bool { return srv.Committed("receivers", "feed", partition) == 1 })
+	assert.Len(t, srv.Records("feed-dead", partition), 1)
+}
+
+func TestKafkaPartitionSubscriber_Partitions(t *testing.T) {
+	client, _, cfg := newTestKafka(t)
+	pub := NewKafkaPublisher(client, cfg)
+
+	// The replica reads its partitions only
+	mine := kafka.Partition([]byte("7"), testPartitions)
+	cfg.Partitions = []int32{mine}
+	sub := NewKafkaPartitionSubscriber(client, cfg, &config.RabbitMQConfig{})
+
+	pub.Publish(queue.Message{ID: "1", Key: otherKey("7")}, queue.Message{ID: "2", Key: "7"})
+
+	msgs, err := sub.Subscribe("feed-receiver-1")
+	assert.Nil(t, err)
+	defer sub.Unsubscribe("feed-receiver-1")
+	assert.Equal(t, "2", queuetest.Receive(t, msgs).ID)
+}
+
+func TestKafkaPartitionSubscriber_NoPartitions(t *testing.T) {
+	client, _, cfg := newTestKafka(t)
+
+	// Without a group the replica would read the partitions of the others
+	cfg.Partitions = nil
+	sub := NewKafkaPartitionSubscriber(client, cfg, &config.RabbitMQConfig{})
+
+	_, err := sub.Subscribe("feed-receiver-1")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,372 @@
+package feed
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/niklod/highload-social-network/config"
+	"github.com/streadway/amqp"
+)
+
+var (
+	// ErrNotConnected fails the publishes while the manager reconnects
+	ErrNotConnected = errors.New("feed: not connected to rabbitmq")
+	ErrClosed       = errors.New("feed: manager is closed")
+)
+
+// session is the connection and the channel of the feed queue
+type session interface {
+	Consume(queue, consumer string, autoAck, exclusive, noLocal, noWait bool, args amqp.Table) (<-chan amqp.Delivery, error)
+	Cancel(consumer string, noWait bool) error
+	Publish(exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error
+	Channel() (*amqp.Channel, error)
+	// Closed gets a value once the connection or the channel is closed
+	Closed() <-chan *amqp.Error
+	Close() error
+}
+
+type amqpSession struct {
+	conn   *amqp.Connection
+	ch     *amqp.Channel
+	closed chan *amqp.Error
+}
+
+// dialSession connects to RabbitMQ and declares the feed queue.
+func dialSession(cfg *config.RabbitMQConfig) (session, error) {
+	conn, err := amqp.Dial(cfg.ConnectionString())
+	if err != nil {
+		return nil, fmt.Errorf("can't connect to rabbitmq: %v", err)
+	}
+
+	ch, err := NewQueueChannel(conn, cfg)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	s := &amqpSession{conn: conn, ch: ch, closed: make(chan *amqp.Error, 1)}
+
+	// RabbitMQ closes the channel alone on a channel error
+	connClosed := conn.NotifyClose(make(chan *amqp.Error, 1))
+	chClosed := ch.NotifyClose(make(chan *amqp.Error, 1))
+	go func() {
+		select {
+		case err := <-connClosed:
+			s.closed <- err
+		case err := <-chClosed:
+			s.closed <- err
+		}
+	}()
+
+	return s, nil
+}
+
+func (s *amqpSession) Consume(queue, consumer string, autoAck, exclusive, noLocal, noWait bool, args amqp.Table) (<-chan amqp.Delivery, error) {
+	return s.ch.Consume(queue, consumer, autoAck, exclusive, noLocal, noWait, args)
+}
+
+func (s *amqpSession) Cancel(consumer string, noWait bool) error {
+	return s.ch.Cancel(consumer, noWait)
+}
+
+func (s *amqpSession) Publish(exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	return s.ch.Publish(exchange, key, mandatory, immediate, msg)
+}
+
+func (s *amqpSession) Channel() (*amqp.Channel, error) {
+	return s.conn.Channel()
+}
+
+func (s *amqpSession) Closed() <-chan *amqp.Error {
+	return s.closed
+}
+
+func (s *amqpSession) Close() error {
+	return s.conn.Close()
+}
+
+// subscription is a consumer resubscribed after every reconnect.
+type subscription struct {
+	queue     string
+	tag       string
+	autoAck   bool
+	exclusive bool
+	noLocal   bool
+	noWait    bool
+	args      amqp.Table
+
+	out        chan amqp.Delivery
+	forwarders int
+	cancelled  bool
+}
+
+// Manager keeps the feed's RabbitMQ connection, redialing it with back-off
+// and resubscribing the consumers, whose deliveries outlive the reconnects.
+type Manager struct {
+	cfg  *config.RabbitMQConfig
+	dial func() (session, error)
+
+	mu     sync.Mutex
+	s      session
+	subs   map[string]*subscription
+	closed bool
+	done   chan struct{}
+}
+
+// Dial connects to RabbitMQ, the first connection isn't retried.
+func Dial(cfg *config.RabbitMQConfig) (*Manager, error) {
+	m := newManager(cfg, func() (session, error) {
+		return dialSession(cfg)
+	})
+
+	if err := m.connect(); err != nil {
+		return nil, fmt.Errorf("feed.Dial - %v", err)
+	}
+
+	return m, nil
+}
+
+func newManager(cfg *config.RabbitMQConfig, dial func() (session, error)) *Manager {
+	return &Manager{
+		cfg:  cfg,
+		dial: dial,
+		subs: make(map[string]*subscription),
+		done: make(chan struct{}),
+	}
+}
+
+func (m *Manager) connect() error {
+	s, err := m.dial()
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		s.Close()
+		return ErrClosed
+	}
+
+	m.s = s
+	for _, sub := range m.subs {
+		if err := m.subscribe(s, sub); err != nil {
+			log.Printf("feed.Manager - resubscribing %q: %v", sub.tag, err)
+		}
+	}
+
+	go m.watch(s)
+
+	return nil
+}
+
+// watch reconnects once the session is closed
+func (m *Manager) watch(s session) {
+	err := <-s.Closed()
+
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return
+	}
+	m.s = nil
+	m.mu.Unlock()
+
+	s.Close()
+	log.Printf("feed.Manager - connection lost: %v, reconnecting", err)
+
+	for attempt := 1; ; attempt++ {
+		select {
+		case <-time.After(ReconnectDelay(m.cfg, attempt)):
+		case <-m.done:
+			return
+		}
+
+		if err := m.connect(); err != nil {
+			if err == ErrClosed {
+				return
+			}
+			log.Printf("feed.Manager - reconnect attempt %d: %v", attempt, err)
+			continue
+		}
+
+		log.Printf("feed.Manager - reconnected after %d attempts", attempt)
+		return
+	}
+}
+
+// ReconnectDelay is the back-off delay of the attempt, counting from one.
+func ReconnectDelay(cfg *config.RabbitMQConfig, attempt int) time.Duration {
+	delay := cfg.ReconnectDelay
+	for i := 1; i < attempt && delay < cfg.ReconnectMaxDelay; i++ {
+		delay *= 2
+	}
+
+	if delay > cfg.ReconnectMaxDelay {
+		return cfg.ReconnectMaxDelay
+	}
+
+	return delay
+}
+
+// subscribe consumes the session for the subscription, m.mu is held
+func (m *Manager) subscribe(s session, sub *subscription) error {
+	in, err := s.Consume(sub.queue, sub.tag, sub.autoAck, sub.exclusive, sub.noLocal, sub.noWait, sub.args)
+	if err != nil {
+		return fmt.Errorf("can't consume %q: %v", sub.queue, err)
+	}
+
+	sub.forwarders++
+	go m.forward(sub, in)
+
+	return nil
+}
+
+// forward passes the session's deliveries to the subscription
+func (m *Manager) forward(sub *subscription, in <-chan amqp.Delivery) {
+	for d := range in {
+		sub.out <- d
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	sub.forwarders--
+	if sub.cancelled && sub.forwarders == 0 {
+		close(sub.out)
+	}
+}
+
+// cancel closes the subscription's deliveries once forwarded, m.mu is held
+func (m *Manager) cancel(sub *subscription) {
+	sub.cancelled = true
+	if sub.forwarders == 0 {
+		close(sub.out)
+	}
+}
+
+// Consume subscribes the consumer to the queue, see amqp.Channel.Consume.
+// The consumer tag is required to resubscribe.
+func (m *Manager) Consume(queue, consumer string, autoAck, exclusive, noLocal, noWait bool, args amqp.Table) (<-chan amqp.Delivery, error) {
+	if consumer == "" {
+		return nil, fmt.Errorf("feed.Manager.Consume - consumer tag is required")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return nil, ErrClosed
+	}
+	if _, ok := m.subs[consumer]; ok {
+		return nil, fmt.Errorf("feed.Manager.Consume - consumer %q exists", consumer)
+	}
+
+	sub := &subscription{
+		queue:     queue,
+		tag:       consumer,
+		autoAck:   autoAck,
+		exclusive: exclusive,
+		noLocal:   noLocal,
+		noWait:    noWait,
+		args:      args,
+		out:       make(chan amqp.Delivery),
+	}
+
+	if m.s != nil {
+		if err := m.subscribe(m.s, sub); err != nil {
+			return nil, fmt.Errorf("feed.Manager.Consume - %v", err)
+		}
+	}
+	m.subs[consumer] = sub
+
+	return sub.out, nil
+}
+
+// Cancel unsubscribes the consumer, see amqp.Channel.Cancel.
+func (m *Manager) Cancel(consumer string, noWait bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	sub, ok := m.subs[consumer]
+	if !ok {
+		return fmt.Errorf("feed.Manager.Cancel - unknown consumer %q", consumer)
+	}
+	delete(m.subs, consumer)
+
+	m.cancel(sub)
+
+	// The consumer of the lost session is closed with it
+	if m.s != nil && sub.forwarders > 0 {
+		if err := m.s.Cancel(consumer, noWait); err != nil {
+			return fmt.Errorf("feed.Manager.Cancel - %v", err)
+		}
+	}
+
+	return nil
+}
+
+// Publish publishes on the feed channel, see amqp.Channel.Publish.
+func (m *Manager) Publish(exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	m.mu.Lock()
+	s := m.s
+	m.mu.Unlock()
+
+	if s == nil {
+		return ErrNotConnected
+	}
+
+	return s.Publish(exchange, key, mandatory, immediate, msg)
+}
+
+// Channel opens a new channel on the connection, which is closed with it.
+func (m *Manager) Channel() (*amqp.Channel, error) {
+	m.mu.Lock()
+	s := m.s
+	m.mu.Unlock()
+
+	if s == nil {
+		return nil, ErrNotConnected
+	}
+
+	return s.Channel()
+}
+
+// Connected reports whether the manager is connected now.
+func (m *Manager) Connected() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.s != nil
+}
+
+// Close closes the connection and the deliveries of the consumers.
+func (m *Manager) Close() error {
+	m.mu.Lock()
+
+	if m.closed {
+		m.mu.Unlock()
+		return nil
+	}
+	m.closed = true
+	close(m.done)
+
+	for tag, sub := range m.subs {
+		delete(m.subs, tag)
+		m.cancel(sub)
+	}
+
+	s := m.s
+	m.s = nil
+	m.mu.Unlock()
+
+	if s == nil {
+		return nil
+	}
+
+	return s.Close()
+}
@@ -0,0 +1,277 @@
+package feed
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/niklod/highload-social-network/config"
+	"github.com/niklod/highload-social-network/internal/queue/queuetest"
+)
+
+// fakeSession delivers the messages sent to its consumers until it's
+// closed, like a channel of the lost connection
+type fakeSession struct {
+	mu        sync.Mutex
+	consumers map[string]chan amqp.Delivery
+	published []amqp.Publishing
+	closed    chan *amqp.Error
+	isClosed  bool
+}
+
+func newFakeSession() *fakeSession {
+	return &fakeSession{
+		consumers: make(map[string]chan amqp.Delivery),
+		closed:    make(chan *amqp.Error, 1),
+	}
+}
+
+func (s *fakeSession) Consume(queue, consumer string, autoAck, exclusive, noLocal, noWait bool, args amqp.Table) (<-chan amqp.Delivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ch := make(chan amqp.Delivery, 10)
+	s.consumers[consumer] = ch
+
+	return ch, nil
+}
+
+func (s *fakeSession) Cancel(consumer string, noWait bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	close(s.consumers[consumer])
+	delete(s.consumers, consumer)
+
+	return nil
+}
+
+func (s *fakeSession) Publish(exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.published = append(s.published, msg)
+	return nil
+}
+
+func (s *fakeSession) Channel() (*amqp.Channel, error) {
+	return nil, fmt.Errorf("not supported")
+}
+
+func (s *fakeSession) Closed() <-chan *amqp.Error {
+	return s.closed
+}
+
+func (s *fakeSession) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.isClosed {
+		return amqp.ErrClosed
+	}
+	s.isClosed = true
+
+	for tag, ch := range s.consumers {
+		close(ch)
+		delete(s.consumers, tag)
+	}
+	s.closed <- nil
+
+	return nil
+}
+
+// lose closes the session like the broker restart does
+func (s *fakeSession) lose() {
+	s.mu.Lock()
+	for tag, ch := range s.consumers {
+		close(ch)
+		delete(s.consumers, tag)
+	}
+	s.mu.Unlock()
+
+	s.closed <- amqp.ErrClosed
+}
+
+func (s *fakeSession) deliver(consumer string, m amqp.Delivery) {
+	s.mu.Lock()
+	ch := s.consumers[consumer]
+	s.mu.Unlock()
+
+	ch <- m
+}
+
+func (s *fakeSession) subscribed(consumer string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.consumers[consumer]
+	return ok
+}
+
+// fakeDialer returns the sessions one after another, it fails while down
+type fakeDialer struct {
+	mu       sync.Mutex
+	sessions []*fakeSession
+	down     bool
+}
+
+func (d *fakeDialer) dial() (session, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.down {
+		return nil, fmt.Errorf("connection refused")
+	}
+
+	s := newFakeSession()
+	d.sessions = append(d.sessions, s)
+
+	return s, nil
+}
+
+func (d *fakeDialer) last() *fakeSession {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.sessions[len(d.sessions)-1]
+}
+
+func (d *fakeDialer) count() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return len(d.sessions)
+}
+
+func (d *fakeDialer) setDown(down bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.down = down
+}
+
+func TestManager_Reconnect(t *testing.T) {
+	d := &fakeDialer{}
+	m := newManager(&config.RabbitMQConfig{ReconnectDelay: time.Millisecond, ReconnectMaxDelay: 5 * time.Millisecond}, d.dial)
+	if err := m.connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	msgs, err := m.Consume("feed", "feed-receiver-1", false, false, false, false, nil)
+	assert.Nil(t, err)
+
+	d.last().deliver("feed-receiver-1", amqp.Delivery{MessageId: "1"})
+	assert.Equal(t, "1", (<-msgs).MessageId)
+
+	d.setDown(true)
+	d.last().lose()
+
+	queuetest.WaitFor(t, func() bool { return !m.Connected() })
+	assert.Equal(t, ErrNotConnected, m.Publish("feed", "key", false, false, amqp.Publishing{}))
+
+	// Attempts fail while the broker is down
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, 1, d.count())
+
+	d.setDown(false)
+	queuetest.WaitFor(t, m.Connected)
+
+	// The consumer is resubscribed with the same deliveries
+	assert.Equal(t, 2, d.count())
+	assert.True(t, d.last().subscribed("feed-receiver-1"))
+
+	d.last().deliver("feed-receiver-1", amqp.Delivery{MessageId: "2"})
+	assert.Equal(t, "2", (<-msgs).MessageId)
+
+	assert.Nil(t, m.Publish("feed", "key", false, false, amqp.Publishing{MessageId: "3"}))
+	assert.Equal(t, []amqp.Publishing{{MessageId: "3"}}, d.last().published)
+}
+
+func TestManager_ConsumeWhileDisconnected(t *testing.T) {
+	d := &fakeDialer{}
+	m := newManager(&config.RabbitMQConfig{ReconnectDelay: time.Millisecond, ReconnectMaxDelay: 5 * time.Millisecond}, d.dial)
+	if err := m.connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	d.setDown(true)
+	d.last().lose()
+	queuetest.WaitFor(t, func() bool { return !m.Connected() })
+
+	msgs, err := m.Consume("feed", "feed-receiver-1", false, false, false, false, nil)
+	assert.Nil(t, err)
+
+	d.setDown(false)
+	queuetest.WaitFor(t, m.Connected)
+
+	d.last().deliver("feed-receiver-1", amqp.Delivery{MessageId: "1"})
+	assert.Equal(t, "1", (<-msgs).MessageId)
+}
+
+func TestManager_Cancel(t *testing.T) {
+	d := &fakeDialer{}
+	m := newManager(&config.RabbitMQConfig{ReconnectDelay: time.Millisecond, ReconnectMaxDelay: 5 * time.Millisecond}, d.dial)
+	if err := m.connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	msgs, err := m.Consume("feed", "feed-receiver-1", false, false, false, false, nil)
+	assert.Nil(t, err)
+
+	_, err = m.Consume("feed", "feed-receiver-1", false, false, false, false, nil)
+	assert.NotNil(t, err)
+
+	// The deliveries on the way are forwarded before the close
+	d.last().deliver("feed-receiver-1", amqp.Delivery{MessageId: "1"})
+	assert.Nil(t, m.Cancel("feed-receiver-1", false))
+
+	var ids []string
+	for msg := range msgs {
+		ids = append(ids, msg.MessageId)
+	}
+
+	assert.Equal(t, []string{"1"}, ids)
+	assert.False(t, d.last().subscribed("feed-receiver-1"))
+	assert.NotNil(t, m.Cancel("feed-receiver-1", false))
+}
+
+func TestManager_Close(t *testing.T) {
+	d := &fakeDialer{}
+	m := newManager(&config.RabbitMQConfig{ReconnectDelay: time.Millisecond, ReconnectMaxDelay: 5 * time.Millisecond}, d.dial)
+	if err := m.connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	msgs, err := m.Consume("feed", "feed-receiver-1", false, false, false, false, nil)
+	assert.Nil(t, err)
+
+	assert.Nil(t, m.Close())
+
+	_, ok := <-msgs
+	assert.False(t, ok)
+
+	// Closing isn't taken for a lost connection
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, 1, d.count())
+	assert.Equal(t, ErrNotConnected, m.Publish("feed", "key", false, false, amqp.Publishing{}))
+
+	_, err = m.Consume("feed", "feed-receiver-2", false, false, false, false, nil)
+	assert.Equal(t, ErrClosed, err)
+}
+
+func TestReconnectDelay(t *testing.T) {
+	cfg := &config.RabbitMQConfig{ReconnectDelay: time.Second, ReconnectMaxDelay: 5 * time.Second}
+
+	assert.Equal(t, time.Second, ReconnectDelay(cfg, 1))
+	assert.Equal(t, 2*time.Second, ReconnectDelay(cfg, 2))
+	assert.Equal(t, 4*time.Second, ReconnectDelay(cfg, 3))
+	assert.Equal(t, 5*time.Second, ReconnectDelay(cfg, 4))
+	assert.Equal(t, 5*time.Second, ReconnectDelay(cfg, 100))
+}
@@ -0,0 +1,132 @@
+package producer
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/niklod/highload-social-network/internal/queue"
+)
+
+// SchemaVersion is the version of the feed events the app publishes.
+//
+//	1 - the bare payload, the messages published before the envelope
+//	2 - the payload in Event
+const SchemaVersion = 2
+
+// ErrNewerVersion is the event of a schema the app doesn't know yet.
+var ErrNewerVersion = errors.New("event schema version is newer than the known one")
+
+// Event is the envelope of the feed message, the post events carry their
+// outbox ID.
+type Event struct {
+	ID         string          `json:"id"`
+	Type       string          `json:"type"`
+	Version    int             `json:"version"`
+	OccurredAt time.Time       `json:"occurred_at"`
+	Payload    json.RawMessage `json:"payload"`
+}
+
+// NewEvent wraps the payload of the current schema version.
+func NewEvent(id, eventType string, occurredAt time.Time, payload []byte) Event {
+	return Event{
+		ID:         id,
+		Type:       eventType,
+		Version:    SchemaVersion,
+		OccurredAt: occurredAt.UTC(),
+		Payload:    payload,
+	}
+}
+
+// Message returns the queue message of the event.
+func (e Event) Message(key string) (queue.Message, error) {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return queue.Message{}, fmt.Errorf("marshaling event: %v", err)
+	}
+
+	return queue.Message{ID: e.ID, Type: e.Type, Key: key, Body: body}, nil
+}
+
+// upgrades bring the events of each version to the next
+var upgrades = map[int]func(e Event) (Event, error){
+	1: upgradeBare,
+}
+
+// DecodeEvent reads the event and upgrades it to SchemaVersion. Any error
+// but ErrNewerVersion means the message is malformed.
+func DecodeEvent(m queue.Message) (Event, error) {
+	var probe struct {
+		Version int             `json:"version"`
+		Payload json.RawMessage `json:"payload"`
+	}
+	if err := json.Unmarshal(m.Body, &probe); err != nil {
+		return Event{}, fmt.Errorf("can't unmarshal event: %v", err)
+	}
+
+	var e Event
+	switch {
+	case probe.Version == 0 && probe.Payload == nil:
+		e = Event{ID: m.ID, Type: m.Type, Version: 1, Payload: m.Body}
+	case probe.Version > SchemaVersion:
+		return Event{}, fmt.Errorf("version %d: %w", probe.Version, ErrNewerVersion)
+	case probe.Version < 2:
+		return Event{}, fmt.Errorf("envelope of unknown version %d", probe.Version)
+	default:
+		if err := json.Unmarshal(m.Body, &e); err != nil {
+			return Event{}, fmt.Errorf("can't unmarshal event: %v", err)
+		}
+	}
+
+	for e.Version < SchemaVersion {
+		next, err := upgrades[e.Version](e)
+		if err != nil {
+			return Event{}, fmt.Errorf("upgrading event of version %d: %v", e.Version, err)
+		}
+		e = next
+	}
+
+	if err := e.check(); err != nil {
+		return Event{}, err
+	}
+
+	return e, nil
+}
+
+// upgradeBare wraps the bare payload with the message type and ID
+func upgradeBare(e Event) (Event, error) {
+	// The first messages were all created posts
+	if e.Type == "" {
+		e.Type = PostCreated
+	}
+	e.Version = 2
+
+	return e, nil
+}
+
+func (e Event) check() error {
+	switch e.Type {
+	case PostCreated, PostUpdated, PostDeleted, FeedRebuild:
+	default:
+		return fmt.Errorf("event of unknown type %q", e.Type)
+	}
+
+	if len(e.Payload) == 0 || e.Payload[0] != '{' {
+		return fmt.Errorf("%s event payload is not an object", e.Type)
+	}
+
+	return nil
+}
+
+// newEventID returns a random ID for the events without an outbox ID
+func newEventID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("producer.newEventID: %v", err)
+	}
+
+	return hex.EncodeToString(b), nil
+}
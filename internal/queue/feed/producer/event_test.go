@@ -0,0 +1,92 @@
+package producer
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/niklod/highload-social-network/config"
+	"github.com/niklod/highload-social-network/internal/queue"
+	"github.com/niklod/highload-social-network/internal/queue/memory"
+)
+
+func TestEvent_Message(t *testing.T) {
+	at := time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC)
+
+	m, err := NewEvent("7", PostUpdated, at, []byte(`{"ID":5,"Author":{"ID":1}}`)).Message("1")
+	assert.Nil(t, err)
+	assert.Equal(t, "7", m.ID)
+	assert.Equal(t, PostUpdated, m.Type)
+	assert.Equal(t, "1", m.Key)
+	assert.JSONEq(t, `{
+		"id": "7",
+		"type": "post.updated",
+		"version": 2,
+		"occurred_at": "2020-01-01T10:00:00Z",
+		"payload": {"ID": 5, "Author": {"ID": 1}}
+	}`, string(m.Body))
+
+	e, err := DecodeEvent(m)
+	assert.Nil(t, err)
+	assert.Equal(t, NewEvent("7", PostUpdated, at, []byte(`{"ID":5,"Author":{"ID":1}}`)), e)
+}
+
+func TestDecodeEvent_Bare(t *testing.T) {
+	// The messages published before the envelope
+	e, err := DecodeEvent(queue.Message{ID: "3", Body: []byte(`{"ID":5,"Body":"Hello"}`)})
+
+	assert.Nil(t, err)
+	assert.Equal(t, Event{
+		ID:      "3",
+		Type:    PostCreated,
+		Version: SchemaVersion,
+		Payload: json.RawMessage(`{"ID":5,"Body":"Hello"}`),
+	}, e)
+
+	e, err = DecodeEvent(queue.Message{Type: PostDeleted, Body: []byte(`{"ID":5}`)})
+	assert.Nil(t, err)
+	assert.Equal(t, PostDeleted, e.Type)
+}
+
+func TestDecodeEvent_NewerVersion(t *testing.T) {
+	_, err := DecodeEvent(queue.Message{Body: []byte(`{"id":"1","type":"post.created","version":3,"payload":{"post":{}}}`)})
+
+	assert.True(t, errors.Is(err, ErrNewerVersion))
+}
+
+func TestDecodeEvent_Malformed(t *testing.T) {
+	for name, body := range map[string]string{
+		"not json":         `post`,
+		"unknown type":     `{"id":"1","type":"post.liked","version":2,"payload":{}}`,
+		"no payload":       `{"id":"1","type":"post.created","version":2}`,
+		"null payload":     `{"id":"1","type":"post.created","version":2,"payload":null}`,
+		"array payload":    `{"id":"1","type":"post.created","version":2,"payload":[1]}`,
+		"unknown envelope": `{"id":"1","type":"post.created","version":1,"payload":{}}`,
+	} {
+		_, err := DecodeEvent(queue.Message{Body: []byte(body)})
+
+		assert.NotNil(t, err, name)
+		assert.False(t, errors.Is(err, ErrNewerVersion), name)
+	}
+}
+
+func TestFeedProducer_SendFeedRebuild(t *testing.T) {
+	broker := memory.NewBroker(&config.RabbitMQConfig{})
+
+	assert.Nil(t, NewFeedProducer(broker).SendFeedRebuild(42))
+
+	msgs, _ := broker.Subscribe("feed-receiver-1")
+	d := <-msgs
+
+	e, err := DecodeEvent(d.Message)
+	assert.Nil(t, err)
+	assert.Len(t, e.ID, 32)
+	assert.Equal(t, d.ID, e.ID)
+	assert.Equal(t, FeedRebuild, e.Type)
+	assert.Equal(t, "42", d.Key)
+	assert.False(t, e.OccurredAt.IsZero())
+	assert.JSONEq(t, `{"user_id":42}`, string(e.Payload))
+}
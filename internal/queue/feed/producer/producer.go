@@ -1,40 +1,64 @@
 package producer
 
 import (
+	"encoding/json"
 	"fmt"
+	"strconv"
+	"time"
 
-	"github.com/niklod/highload-social-network/config"
-	"github.com/niklod/highload-social-network/internal/cache"
-	"github.com/streadway/amqp"
+	"github.com/niklod/highload-social-network/internal/outbox"
+	"github.com/niklod/highload-social-network/internal/queue"
 )
 
+// Feed event types, untyped bare messages are created posts
+const (
+	PostCreated = outbox.PostCreated
+	PostUpdated = outbox.PostUpdated
+	PostDeleted = outbox.PostDeleted
+	// FeedRebuild asks to rebuild the cached feed from a RebuildMessage
+	FeedRebuild = "feed.rebuild"
+)
+
+type RebuildMessage struct {
+	UserID int `json:"user_id"`
+}
+
 type FeedProducer struct {
-	ch  *amqp.Channel
-	cfg *config.RabbitMQConfig
+	pub queue.Publisher
 }
 
-func NewFeedProducer(ch *amqp.Channel, cfg *config.RabbitMQConfig, cache cache.Cache) *FeedProducer {
+func NewFeedProducer(pub queue.Publisher) *FeedProducer {
 	return &FeedProducer{
-		ch:  ch,
-		cfg: cfg,
+		pub: pub,
 	}
 }
 
-func (f *FeedProducer) SendFeedUpdateMessage(p []byte) error {
-	err := f.ch.Publish(
-		f.cfg.FeedExchangeName,
-		f.cfg.FeedRoutingKey,
-		false,
-		false,
-		amqp.Publishing{
-			DeliveryMode: amqp.Persistent,
-			ContentType:  "application/json",
-			Body:         p,
-		},
-	)
+// SendFeedMessage publishes the event of the payload with a random ID.
+func (f *FeedProducer) SendFeedMessage(msgType, key string, p []byte) error {
+	id, err := newEventID()
 	if err != nil {
-		return fmt.Errorf("producer.SendFeedUpdateMessage - can't send message to queue: %v", err)
+		return fmt.Errorf("producer.SendFeedMessage - %v", err)
+	}
+
+	msg, err := NewEvent(id, msgType, time.Now(), p).Message(key)
+	if err != nil {
+		return fmt.Errorf("producer.SendFeedMessage - %v", err)
+	}
+
+	_, err = f.pub.Publish(msg)
+	if err != nil {
+		return fmt.Errorf("producer.SendFeedMessage - can't send message to queue: %v", err)
 	}
 
 	return nil
 }
+
+// SendFeedRebuild publishes the rebuild job of the user's feed.
+func (f *FeedProducer) SendFeedRebuild(userId int) error {
+	msg, err := json.Marshal(RebuildMessage{UserID: userId})
+	if err != nil {
+		return fmt.Errorf("producer.SendFeedRebuild - can't marshal message: %v", err)
+	}
+
+	return f.SendFeedMessage(FeedRebuild, strconv.Itoa(userId), msg)
+}
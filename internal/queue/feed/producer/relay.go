@@ -0,0 +1,105 @@
+package producer
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/niklod/highload-social-network/config"
+	"github.com/niklod/highload-social-network/internal/outbox"
+	"github.com/niklod/highload-social-network/internal/queue"
+)
+
+// Relay publishes the outbox messages, deleting them once confirmed.
+// Duplicates are skipped by the receivers.
+type Relay struct {
+	pub    queue.Publisher
+	cfg    *config.OutboxConfig
+	outbox outbox.Store
+}
+
+func NewRelay(pub queue.Publisher, cfg *config.OutboxConfig, outbox outbox.Store) *Relay {
+	return &Relay{
+		pub:    pub,
+		cfg:    cfg,
+		outbox: outbox,
+	}
+}
+
+// Run relays the messages every cfg.PollInterval until the context is
+// done. It blocks, so run it in a goroutine.
+func (r *Relay) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.cfg.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		for ctx.Err() == nil {
+			n, err := r.Flush()
+			if err != nil {
+				log.Printf("producer.Relay - %v", err)
+			}
+			if err != nil || n < r.cfg.BatchSize {
+				break
+			}
+		}
+	}
+}
+
+// Flush publishes a batch and returns the number of confirmed messages.
+func (r *Relay) Flush() (int, error) {
+	var relayErr error
+	confirmed := 0
+
+	err := r.outbox.ClaimOutbox(r.cfg.BatchSize, func(msgs []outbox.Message) []int64 {
+		var acked []int64
+		acked, relayErr = r.publish(msgs)
+		confirmed = len(acked)
+
+		return acked
+	})
+	if err != nil {
+		return confirmed, fmt.Errorf("relaying outbox: %v", err)
+	}
+	if relayErr != nil {
+		return confirmed, fmt.Errorf("relaying outbox: %v", relayErr)
+	}
+
+	return confirmed, nil
+}
+
+// publish returns the IDs of the confirmed messages
+func (r *Relay) publish(msgs []outbox.Message) ([]int64, error) {
+	batch := make([]queue.Message, 0, len(msgs))
+	ids := make(map[string]int64, len(msgs))
+
+	for _, m := range msgs {
+		id := strconv.FormatInt(m.ID, 10)
+
+		msg, err := NewEvent(id, m.Type, m.CreatedAt, m.Body).Message(m.Key)
+		if err != nil {
+			// The message stays in the outbox
+			log.Printf("producer.Relay - outbox message %d: %v", m.ID, err)
+			continue
+		}
+
+		ids[id] = m.ID
+		batch = append(batch, msg)
+	}
+
+	confirmed, err := r.pub.Publish(batch...)
+
+	acked := make([]int64, len(confirmed))
+	for i, m := range confirmed {
+		acked[i] = ids[m.ID]
+	}
+
+	return acked, err
+}
@@ -27,13 +27,20 @@ func NewQueueChannel(conn *amqp.Connection, cfg *config.RabbitMQConfig) (*amqp.C
 		return nil, fmt.Errorf("feed.NewQueue - can't create exchange: %v", err)
 	}
 
+	err = declareDeadLetters(ch, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	// An existing queue declared without the argument has to be deleted
+	// once, RabbitMQ doesn't change it
 	_, err = ch.QueueDeclare(
 		cfg.FeedQueueName, // queue name
 		true,              // durable
 		false,             // auto delete
 		false,             // exclusive
 		false,             // no wait
-		nil,
+		amqp.Table{"x-dead-letter-exchange": DeadLetterExchangeName(cfg)},
 	)
 	if err != nil {
 		return nil, fmt.Errorf("feed.NewQueue - can't declare queue: %v", err)
@@ -44,7 +51,60 @@ func NewQueueChannel(conn *amqp.Connection, cfg *config.RabbitMQConfig) (*amqp.C
 		return nil, fmt.Errorf("feed.NewQueue - can't bind queue to exchange: %v", err)
 	}
 
+	err = declareRetries(ch, cfg)
+	if err != nil {
+		return nil, err
+	}
+
 	log.Printf("queue %q binded to %q exchange\n", cfg.FeedQueueName, cfg.FeedExchangeName)
 
 	return ch, nil
 }
+
+// declareDeadLetters declares the dead letter exchange and queue.
+func declareDeadLetters(ch *amqp.Channel, cfg *config.RabbitMQConfig) error {
+	err := ch.ExchangeDeclare(DeadLetterExchangeName(cfg), "fanout", true, false, false, false, nil)
+	if err != nil {
+		return fmt.Errorf("feed.NewQueue - can't create dead letter exchange: %v", err)
+	}
+
+	_, err = ch.QueueDeclare(DeadLetterQueueName(cfg), true, false, false, false, nil)
+	if err != nil {
+		return fmt.Errorf("feed.NewQueue - can't declare dead letter queue: %v", err)
+	}
+
+	err = ch.QueueBind(DeadLetterQueueName(cfg), "", DeadLetterExchangeName(cfg), false, nil)
+	if err != nil {
+		return fmt.Errorf("feed.NewQueue - can't bind dead letter queue: %v", err)
+	}
+
+	return nil
+}
+
+// declareRetries declares the retry queue of every attempt. The delay is
+// the message expiration, so changing it doesn't change the queues.
+func declareRetries(ch *amqp.Channel, cfg *config.RabbitMQConfig) error {
+	err := ch.ExchangeDeclare(RetryExchangeName(cfg), "direct", true, false, false, false, nil)
+	if err != nil {
+		return fmt.Errorf("feed.NewQueue - can't create retry exchange: %v", err)
+	}
+
+	for attempt := 1; attempt <= cfg.MaxRetries; attempt++ {
+		name := RetryQueueName(cfg, attempt)
+
+		_, err := ch.QueueDeclare(name, true, false, false, false, amqp.Table{
+			"x-dead-letter-exchange":    cfg.FeedExchangeName,
+			"x-dead-letter-routing-key": cfg.FeedRoutingKey,
+		})
+		if err != nil {
+			return fmt.Errorf("feed.NewQueue - can't declare retry queue %q: %v", name, err)
+		}
+
+		err = ch.QueueBind(name, name, RetryExchangeName(cfg), false, nil)
+		if err != nil {
+			return fmt.Errorf("feed.NewQueue - can't bind retry queue %q: %v", name, err)
+		}
+	}
+
+	return nil
+}
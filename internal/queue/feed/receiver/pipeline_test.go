@@ -0,0 +1,202 @@
+package receiver
+
+import (
+	"context"
+	"database/sql/driver"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	gorilla "github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/niklod/highload-social-network/config"
+	"github.com/niklod/highload-social-network/internal/cache"
+	"github.com/niklod/highload-social-network/internal/cluster"
+	"github.com/niklod/highload-social-network/internal/queue/feed/producer"
+	"github.com/niklod/highload-social-network/internal/queue/memory"
+	"github.com/niklod/highload-social-network/internal/queue/queuetest"
+	"github.com/niklod/highload-social-network/internal/user"
+	"github.com/niklod/highload-social-network/internal/user/post"
+	"github.com/niklod/highload-social-network/internal/websocket"
+)
+
+// captured keeps the argument the query was sent with
+type captured struct {
+	v driver.Value
+}
+
+func (c *captured) Match(v driver.Value) bool {
+	c.v = v
+	return true
+}
+
+// connectWS connects the user to the pool through a test server
+func connectWS(t *testing.T, pool *websocket.Pool, u *user.User) *gorilla.Conn {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&gorilla.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		client := websocket.NewClient(u, conn, pool)
+		pool.Register <- client
+		go client.Write()
+	}))
+	t.Cleanup(srv.Close)
+
+	conn, _, err := gorilla.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	queuetest.WaitFor(t, func() bool {
+		_, ok := pool.Client(u.Login)
+		return ok
+	})
+
+	return conn
+}
+
+// TestPipeline follows the post from the service through the outbox and
+// the memory broker to the friend's cached feed and WebSocket.
+func TestPipeline(t *testing.T) {
+	postDB, postMock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	userDB, userMock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	friend := &user.User{ID: 2, Login: "friend"}
+
+	postRepo := post.NewRepository(cluster.Single(postDB))
+	userRepo := user.NewRepository(cluster.Single(userDB))
+
+	feeds := post.NewFeedStore(cache.NewFeedCache())
+	posts := post.NewPostStore(cache.NewFeedCache())
+	feeds.Set(friend.ID, post.Refs{})
+
+	pool := websocket.NewPool()
+	go pool.Start()
+
+	rabbit := &config.RabbitMQConfig{MaxRetries: 1, RetryDelay: time.Millisecond, ShutdownTimeout: time.Second}
+	broker := memory.NewBroker(rabbit)
+
+	postService := post.NewService(postRepo, feeds, posts, nil)
+	userService := user.NewService(userRepo, nil, nil, pool, feeds)
+	relay := producer.NewRelay(broker, &config.OutboxConfig{BatchSize: 10}, postRepo)
+	receiver := NewFeedReceiver(broker, rabbit, feeds, posts, NewSeenStore(cache.NewFeedCache()), postService, userService, user.NewCelebrities(userRepo, feeds, 0), pool)
+
+	ws := connectWS(t, pool, friend)
+
+	// The post and its message are written at once
+	payload := &captured{}
+	postMock.ExpectBegin()
+	postMock.ExpectExec("INSERT INTO posts").WithArgs(1, "Hello", sqlmock.AnyArg(), sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(10, 1))
+	postMock.ExpectExec("INSERT INTO feed_outbox").WithArgs(10, producer.PostCreated, "1", payload).WillReturnResult(sqlmock.NewResult(7, 1))
+	postMock.ExpectCommit()
+
+	p := &post.Post{Body: "Hello", Author: post.Author{ID: 1, Login: "author"}}
+	assert.Nil(t, postService.Add(context.Background(), p))
+
+	// The relay publishes it, twice as if the first delete was lost
+	for i := 0; i < 2; i++ {
+		postMock.ExpectQuery("SELECT GET_LOCK").WillReturnRows(sqlmock.NewRows([]string{"lock"}).AddRow(1))
+		postMock.ExpectQuery("FROM feed_outbox").WithArgs(10).WillReturnRows(
+			sqlmock.NewRows([]string{"id", "type", "message_key", "payload", "created_at"}).AddRow(7, producer.PostCreated, "1", payload.v, time.Now()))
+		postMock.ExpectExec("DELETE FROM feed_outbox").WithArgs(int64(7)).WillReturnResult(sqlmock.NewResult(0, 1))
+		postMock.ExpectExec("DO RELEASE_LOCK").WillReturnResult(sqlmock.NewResult(0, 0))
+
+		n, err := relay.Flush()
+		assert.Nil(t, err)
+		assert.Equal(t, 1, n)
+	}
+	assert.Nil(t, postMock.ExpectationsWereMet())
+
+	userMock.ExpectQuery("SELECT u.id").WillReturnRows(
+		sqlmock.NewRows([]string{"id", "first_name", "last_name", "age", "sex", "login", "city_id", "city_name"}).
+			AddRow(friend.ID, "", "", 0, "", friend.Login, nil, nil))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- receiver.Run(ctx, 1)
+	}()
+
+	var msg websocket.MessageBody
+	ws.SetReadDeadline(time.Now().Add(time.Second))
+	assert.Nil(t, ws.ReadJSON(&msg))
+	assert.Equal(t, websocket.EventFeedPost, msg.Event)
+	assert.Equal(t, "Hello", msg.Data.(map[string]interface{})["Body"])
+
+	// The duplicate is skipped without a second push
+	queuetest.WaitFor(t, func() bool {
+		w := receiver.Workers()
+		return len(w) == 1 && w[0].Processed == 2
+	})
+	ws.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	assert.NotNil(t, ws.ReadJSON(&msg))
+
+	cancel()
+	assert.Nil(t, <-done)
+	assert.Nil(t, userMock.ExpectationsWereMet())
+
+	page, ok := feeds.Page(friend.ID, nil, 10)
+	assert.True(t, ok)
+	assert.Equal(t, post.Refs{post.RefOf(*p)}, page.Refs)
+	assert.Equal(t, "Hello", posts.Get([]int{p.ID})[p.ID].Body)
+	assert.Empty(t, broker.DeadLetters())
+}
+
+// TestFeedReceiver_processPost_Celebrity checks the post of the celebrity
+// isn't fanned out to the cached feeds, but is pushed to the subscribers
+// online.
+func TestFeedReceiver_processPost_Celebrity(t *testing.T) {
+	userDB, userMock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	friend := &user.User{ID: 2, Login: "friend"}
+
+	userRepo := user.NewRepository(cluster.Single(userDB))
+	feeds := post.NewFeedStore(cache.NewFeedCache())
+	feeds.Set(friend.ID, post.Refs{})
+
+	userMock.ExpectQuery("SELECT s.user_id").WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(1))
+	celebrities := user.NewCelebrities(userRepo, feeds, 1)
+	assert.Nil(t, celebrities.Refresh())
+
+	pool := websocket.NewPool()
+	go pool.Start()
+
+	userService := user.NewService(userRepo, nil, nil, pool, feeds)
+	f := NewFeedReceiver(newFakeSubscriber(), &config.RabbitMQConfig{}, feeds, post.NewPostStore(cache.NewFeedCache()), nil, nil, userService, celebrities, pool)
+
+	ws := connectWS(t, pool, friend)
+
+	userMock.ExpectQuery("SELECT u.id").WillReturnRows(
+		sqlmock.NewRows([]string{"id", "first_name", "last_name", "age", "sex", "login", "city_id", "city_name"}).
+			AddRow(friend.ID, "", "", 0, "", friend.Login, nil, nil))
+
+	p := post.Post{ID: 10, Body: "Hello", Author: post.Author{ID: 1, Login: "author"}, CreatedAt: time.Now()}
+	assert.Nil(t, f.processPost(producer.PostCreated, p))
+
+	var msg websocket.MessageBody
+	ws.SetReadDeadline(time.Now().Add(time.Second))
+	assert.Nil(t, ws.ReadJSON(&msg))
+	assert.Equal(t, websocket.EventFeedPost, msg.Event)
+
+	page, ok := feeds.Page(friend.ID, nil, 10)
+	assert.True(t, ok)
+	assert.Empty(t, page.Refs)
+	assert.Nil(t, userMock.ExpectationsWereMet())
+}
@@ -1,138 +1,332 @@
 package receiver
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/niklod/highload-social-network/config"
-	"github.com/niklod/highload-social-network/internal/cache"
+	"github.com/niklod/highload-social-network/internal/queue"
+	"github.com/niklod/highload-social-network/internal/queue/feed/producer"
 	"github.com/niklod/highload-social-network/internal/user"
 	"github.com/niklod/highload-social-network/internal/user/post"
 	"github.com/niklod/highload-social-network/internal/websocket"
-	"github.com/streadway/amqp"
 )
 
 type FeedReceiver struct {
-	ch          *amqp.Channel
+	sub         queue.Subscriber
 	cfg         *config.RabbitMQConfig
-	cache       cache.Cache
+	feeds       post.FeedStore
+	posts       post.PostStore
+	seen        *SeenStore
 	postService *post.Service
 	userService *user.Service
+	celebrities *user.Celebrities
 	wsPool      *websocket.Pool
+
+	// handle processes and acks the message, tests replace it
+	handle func(d queue.Delivery) error
+
+	mu      sync.Mutex
+	workers []*worker
 }
 
-func NewFeedReceiver(ch *amqp.Channel, cfg *config.RabbitMQConfig, cache cache.Cache, postService *post.Service, userService *user.Service, ws *websocket.Pool) *FeedReceiver {
-	return &FeedReceiver{
-		ch:          ch,
+func NewFeedReceiver(sub queue.Subscriber, cfg *config.RabbitMQConfig, feeds post.FeedStore, posts post.PostStore, seen *SeenStore, postService *post.Service, userService *user.Service, celebrities *user.Celebrities, ws *websocket.Pool) *FeedReceiver {
+	f := &FeedReceiver{
+		sub:         sub,
 		cfg:         cfg,
-		cache:       cache,
+		feeds:       feeds,
+		posts:       posts,
+		seen:        seen,
 		postService: postService,
 		userService: userService,
+		celebrities: celebrities,
 		wsPool:      ws,
 	}
+	f.handle = f.handleMessage
+
+	return f
 }
 
-func (f *FeedReceiver) Run() {
-	msgs, err := f.ch.Consume(
-		f.cfg.FeedQueueName,
-		"",    // consumer id will be autogenerated
-		false, // auto ack
-		false, // exclusive
-		false,
-		false,
-		nil,
-	)
-	if err != nil {
-		log.Print(err)
+// Run consumes the feed queue with n workers until ctx is done, then waits
+// up to cfg.ShutdownTimeout for them to finish the messages in hand.
+func (f *FeedReceiver) Run(ctx context.Context, n int) error {
+	workers := make([]*worker, n)
+	deliveries := make([]<-chan queue.Delivery, n)
+
+	for i := range workers {
+		workers[i] = newWorker(i + 1)
+
+		msgs, err := f.sub.Subscribe(workers[i].tag)
+		if err != nil {
+			for _, w := range workers[:i] {
+				f.sub.Unsubscribe(w.tag)
+			}
+			return fmt.Errorf("receiver.Run - %v", err)
+		}
+		deliveries[i] = msgs
 	}
 
-	stopCh := make(chan struct{})
+	f.mu.Lock()
+	f.workers = workers
+	f.mu.Unlock()
 
-	go func() {
-		for m := range msgs {
-			log.Printf("message id [%s] - received\n", m.MessageId)
+	errCh := make(chan error, n)
+	for i, w := range workers {
+		go func(w *worker, msgs <-chan queue.Delivery) {
+			errCh <- f.work(ctx, w, msgs)
+		}(w, deliveries[i])
+	}
 
-			err := f.processNewMessage(m)
-			if err != nil {
-				log.Printf("message id [%s] - %v\n", m.MessageId, err)
+	log.Printf("receiver.Run - %d workers consuming the feed queue", n)
 
-				err := m.Nack(false, true)
-				if err != nil {
-					log.Printf("message id [%s] - %v\n", m.MessageId, err)
-				}
+	var runErr error
+	var deadline <-chan time.Time
+	done := ctx.Done()
 
-				continue
+	for stopped := 0; stopped < n; {
+		select {
+		case err := <-errCh:
+			stopped++
+			if err != nil && runErr == nil {
+				runErr = err
+			}
+		case <-done:
+			// A closed done is always ready, so stop selecting on it
+			done = nil
+			timer := time.NewTimer(f.cfg.ShutdownTimeout)
+			defer timer.Stop()
+			deadline = timer.C
+		case <-deadline:
+			return fmt.Errorf("receiver.Run - %d workers not stopped in %s", n-stopped, f.cfg.ShutdownTimeout)
+		}
+	}
+
+	return runErr
+}
+
+// Workers returns the state of the workers of the last Run.
+func (f *FeedReceiver) Workers() []WorkerStatus {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	res := make([]WorkerStatus, len(f.workers))
+	for i, w := range f.workers {
+		res[i] = w.status()
+	}
+
+	return res
+}
+
+func (f *FeedReceiver) work(ctx context.Context, w *worker, msgs <-chan queue.Delivery) error {
+	defer w.set(StateStopped)
+
+	for {
+		select {
+		case <-ctx.Done():
+			f.drain(w, msgs)
+			return nil
+		case m, ok := <-msgs:
+			if !ok {
+				return fmt.Errorf("receiver.Run - worker %d: deliveries closed", w.id)
 			}
 
-			log.Printf("message id [%s] - processed\n", m.MessageId)
+			// Both cases are ready after a message in hand
+			if ctx.Err() != nil {
+				f.requeue(w, m)
+				f.drain(w, msgs)
+				return nil
+			}
 
-			err = m.Ack(false)
-			if err != nil {
-				log.Printf("message id [%s] - can't Ack message: %v\n", m.MessageId, err)
+			w.set(StateBusy)
+			if err := f.handle(m); err != nil {
+				atomic.AddUint64(&w.failed, 1)
+			} else {
+				atomic.AddUint64(&w.processed, 1)
 			}
+			w.set(StateIdle)
 		}
-	}()
+	}
+}
+
+// drain unsubscribes the worker and requeues the messages delivered before
+// that, the broker closes the deliveries then.
+func (f *FeedReceiver) drain(w *worker, msgs <-chan queue.Delivery) {
+	w.set(StateDraining)
+
+	if err := f.sub.Unsubscribe(w.tag); err != nil {
+		log.Printf("receiver.Run - worker %d: %v", w.id, err)
+		return
+	}
 
-	log.Printf("Consumer is working...")
-	<-stopCh
+	for d := range msgs {
+		f.requeue(w, d)
+	}
+}
+
+func (f *FeedReceiver) requeue(w *worker, d queue.Delivery) {
+	if err := d.Requeue(); err != nil {
+		log.Printf("message id [%s] - can't requeue message: %v\n", d.ID, err)
+		return
+	}
 
+	atomic.AddUint64(&w.requeued, 1)
 }
 
-func (f *FeedReceiver) processNewMessage(m amqp.Delivery) error {
-	var feedMsg post.Post
+// handleMessage processes the message and acks it. The failed one is
+// retried, the poison one is parked.
+func (f *FeedReceiver) handleMessage(d queue.Delivery) error {
+	log.Printf("message id [%s] - received\n", d.ID)
 
-	err := json.Unmarshal(m.Body, &feedMsg)
+	err := f.processNewMessage(d.Message)
 	if err != nil {
-		return fmt.Errorf("receiver.processNewMessage - can't unmarshal message: %v", err)
+		log.Printf("message id [%s] - %v\n", d.ID, err)
+
+		settle := d.Retry
+		if errors.As(err, &poisonError{}) {
+			settle = d.Park
+		}
+		if err := settle(err); err != nil {
+			log.Printf("message id [%s] - %v\n", d.ID, err)
+		}
+
+		return err
 	}
 
-	authorId := feedMsg.Author.ID
+	log.Printf("message id [%s] - processed\n", d.ID)
 
-	authorFriends, err := f.userService.Friends(authorId)
+	err = d.Ack()
 	if err != nil {
-		return fmt.Errorf("receiver.processNewMessage - can't get author message: %v", cache.ErrInvalidCacheItem)
+		log.Printf("message id [%s] - can't Ack message: %v\n", d.ID, err)
 	}
 
-	for _, friend := range authorFriends {
-		// Trying to find ws connection for friend
-		friendWsClient, friendWsExist := f.wsPool.Clients[friend.Login]
+	return nil
+}
 
-		// Trying to find feed data in cache
-		v, ok := f.cache.Read(friend.ID)
-		if ok {
-			oldFeed, ok := v.(post.Feed)
-			if !ok {
-				return fmt.Errorf("receiver.processNewMessage - can't cast message: %v", cache.ErrInvalidCacheItem)
-			}
+// poisonError marks a message which can never succeed, so it's parked
+// without retries
+type poisonError struct {
+	error
+}
 
-			newFeed := append(oldFeed, feedMsg)
-			newFeed.Sort()
+func poison(err error) error {
+	return poisonError{err}
+}
 
-			f.cache.Write(friend.ID, newFeed)
+// processNewMessage applies the event. Newer schema versions are retried,
+// as a newer replica may take them.
+func (f *FeedReceiver) processNewMessage(m queue.Message) error {
+	event, err := producer.DecodeEvent(m)
+	if errors.Is(err, producer.ErrNewerVersion) {
+		return fmt.Errorf("receiver.processNewMessage - %v", err)
+	}
+	if err != nil {
+		return poison(fmt.Errorf("receiver.processNewMessage - %v", err))
+	}
 
-			// Updating friend feed via WebSocket connection
-			if friendWsExist {
-				friendWsClient.SendMessage(websocket.MessageBody{Data: feedMsg})
-			}
+	if event.Type == producer.FeedRebuild {
+		return f.rebuildFeed(event)
+	}
 
-			continue
-		}
+	var feedMsg post.Post
 
-		// Cache is empty, getting data from DB
-		newFeed, err := f.postService.UserFeed(friend.ID)
-		if err != nil {
-			log.Printf("receiver.processNewMessage - can't get user feed: %v\n", err)
-			continue
-		}
+	err = json.Unmarshal(event.Payload, &feedMsg)
+	if err != nil {
+		return poison(fmt.Errorf("receiver.processNewMessage - can't unmarshal %s payload: %v", event.Type, err))
+	}
+	if feedMsg.ID == 0 || feedMsg.Author.ID == 0 {
+		return poison(fmt.Errorf("receiver.processNewMessage - %s payload has no post or author ID", event.Type))
+	}
 
-		// Update cache data
-		f.cache.Write(friend.ID, newFeed)
+	// Events sent before the outbox have no outbox ID
+	outboxId, err := strconv.ParseInt(event.ID, 10, 64)
+	if err != nil {
+		return f.processPost(event.Type, feedMsg)
+	}
 
-		// Updating friend feed via WebSocket connection
-		if friendWsExist {
-			friendWsClient.SendMessage(websocket.MessageBody{Data: feedMsg})
+	if f.seen.get(feedMsg.ID).skips(event.Type, outboxId) {
+		log.Printf("message id [%s] - skipping, post %d is processed already\n", m.ID, feedMsg.ID)
+		return nil
+	}
+
+	if err := f.processPost(event.Type, feedMsg); err != nil {
+		return err
+	}
+
+	f.seen.mark(feedMsg.ID, event.Type, outboxId)
+
+	return nil
+}
+
+// processPost applies the post change to the subscribers' feeds
+func (f *FeedReceiver) processPost(msgType string, feedMsg post.Post) error {
+	authorId := feedMsg.Author.ID
+
+	// The feeds share the cached post, its changes don't touch them
+	switch msgType {
+	case producer.PostUpdated:
+		f.posts.Put(feedMsg)
+	case producer.PostDeleted:
+		f.posts.Delete(feedMsg.ID)
+	}
+
+	// Posts of celebrities are merged into the feeds on reading, only the
+	// online subscribers are told about them
+	celebrity := f.celebrities.IsCelebrity(authorId)
+	created := msgType != producer.PostUpdated && msgType != producer.PostDeleted
+
+	// Friends and followers of the author get the post in their feeds
+	subscribers, err := f.userService.FeedSubscribers(context.Background(), authorId)
+	if err != nil {
+		return fmt.Errorf("receiver.processPost - can't get author subscribers: %v", err)
+	}
+
+	event := websocket.EventFeedPost
+	switch msgType {
+	case producer.PostUpdated:
+		event = websocket.EventFeedPostUpdated
+	case producer.PostDeleted:
+		event = websocket.EventFeedPostDeleted
+	default:
+		// An update of the post may be processed first
+		f.posts.Fill(feedMsg)
+	}
+
+	for _, friend := range subscribers {
+		if !celebrity {
+			if msgType == producer.PostDeleted {
+				f.feeds.Remove(friend.ID, feedMsg.ID)
+			} else if created {
+				// Feeds not cached are read from DB with the post
+				f.feeds.Prepend(friend.ID, post.RefOf(feedMsg))
+			}
 		}
+
+		// Updating friend feed via WebSocket connection
+		f.wsPool.Notify(friend.Login, event, feedMsg)
+	}
+
+	return nil
+}
+
+// rebuildFeed runs the rebuild job of the feed warm-up
+func (f *FeedReceiver) rebuildFeed(event producer.Event) error {
+	var msg producer.RebuildMessage
+
+	err := json.Unmarshal(event.Payload, &msg)
+	if err != nil {
+		return poison(fmt.Errorf("receiver.rebuildFeed - can't unmarshal message: %v", err))
+	}
+
+	err = f.postService.RebuildFeed(context.Background(), msg.UserID)
+	if err != nil {
+		return fmt.Errorf("receiver.rebuildFeed - can't rebuild feed of user %d: %v", msg.UserID, err)
 	}
 
 	return nil
@@ -0,0 +1,281 @@
+package receiver
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/niklod/highload-social-network/config"
+	"github.com/niklod/highload-social-network/internal/queue"
+	"github.com/niklod/highload-social-network/internal/queue/queuetest"
+)
+
+// fakeSubscriber delivers the messages sent to its consumers, Unsubscribe
+// closes the deliveries like the brokers do
+type fakeSubscriber struct {
+	mu           sync.Mutex
+	consumers    map[string]chan queue.Delivery
+	unsubscribed []string
+	subscribeErr error
+}
+
+func newFakeSubscriber() *fakeSubscriber {
+	return &fakeSubscriber{consumers: make(map[string]chan queue.Delivery)}
+}
+
+func (s *fakeSubscriber) Subscribe(consumer string) (<-chan queue.Delivery, error) {
+	if s.subscribeErr != nil {
+		return nil, s.subscribeErr
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ch := make(chan queue.Delivery, 10)
+	s.consumers[consumer] = ch
+
+	return ch, nil
+}
+
+func (s *fakeSubscriber) Unsubscribe(consumer string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.unsubscribed = append(s.unsubscribed, consumer)
+	close(s.consumers[consumer])
+
+	return nil
+}
+
+func (s *fakeSubscriber) deliver(consumer string, d queue.Delivery) {
+	s.mu.Lock()
+	ch := s.consumers[consumer]
+	s.mu.Unlock()
+
+	ch <- d
+}
+
+// fakeAcks records the settlements by message ID
+type fakeAcks struct {
+	mu       sync.Mutex
+	acked    []string
+	requeued []string
+	retried  []string
+	parked   []string
+}
+
+type fakeAck struct {
+	acks *fakeAcks
+	id   string
+}
+
+func (a fakeAck) Ack() error {
+	a.acks.mu.Lock()
+	defer a.acks.mu.Unlock()
+
+	a.acks.acked = append(a.acks.acked, a.id)
+	return nil
+}
+
+func (a fakeAck) Requeue() error {
+	a.acks.mu.Lock()
+	defer a.acks.mu.Unlock()
+
+	a.acks.requeued = append(a.acks.requeued, a.id)
+	return nil
+}
+
+func (a fakeAck) Retry(err error) error {
+	a.acks.mu.Lock()
+	defer a.acks.mu.Unlock()
+
+	a.acks.retried = append(a.acks.retried, a.id)
+	return nil
+}
+
+func (a fakeAck) Park(err error) error {
+	a.acks.mu.Lock()
+	defer a.acks.mu.Unlock()
+
+	a.acks.parked = append(a.acks.parked, a.id)
+	return nil
+}
+
+func (a *fakeAcks) delivery(id int) queue.Delivery {
+	msgId := strconv.Itoa(id)
+
+	return queue.Delivery{
+		Message:      queue.Message{ID: msgId},
+		Attempt:      1,
+		Acknowledger: fakeAck{acks: a, id: msgId},
+	}
+}
+
+func TestFeedReceiver_Run(t *testing.T) {
+	sub := newFakeSubscriber()
+	acks := &fakeAcks{}
+
+	f := NewFeedReceiver(sub, &config.RabbitMQConfig{FeedQueueName: "feed", ShutdownTimeout: time.Second}, nil, nil, nil, nil, nil, nil, nil)
+	f.handle = func(d queue.Delivery) error {
+		if d.ID == "2" {
+			return fmt.Errorf("db is down")
+		}
+		return d.Ack()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- f.Run(ctx, 2)
+	}()
+
+	queuetest.WaitFor(t, func() bool { return len(f.Workers()) == 2 })
+	sub.deliver("feed-receiver-1", acks.delivery(1))
+	sub.deliver("feed-receiver-2", acks.delivery(2))
+	sub.deliver("feed-receiver-1", acks.delivery(3))
+
+	queuetest.WaitFor(t, func() bool {
+		w := f.Workers()
+		return w[0].Processed+w[1].Failed == 3
+	})
+	cancel()
+
+	assert.Nil(t, <-done)
+	assert.ElementsMatch(t, []string{"feed-receiver-1", "feed-receiver-2"}, sub.unsubscribed)
+	assert.Equal(t, []string{"1", "3"}, acks.acked)
+
+	workers := f.Workers()
+	assert.Equal(t, StateStopped, workers[0].State)
+	assert.Equal(t, uint64(2), workers[0].Processed)
+	assert.Equal(t, uint64(1), workers[1].Failed)
+}
+
+func TestFeedReceiver_Run_RequeuesAfterShutdown(t *testing.T) {
+	sub := newFakeSubscriber()
+	acks := &fakeAcks{}
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+
+	f := NewFeedReceiver(sub, &config.RabbitMQConfig{FeedQueueName: "feed", ShutdownTimeout: time.Second}, nil, nil, nil, nil, nil, nil, nil)
+	f.handle = func(d queue.Delivery) error {
+		close(started)
+		<-release
+		return d.Ack()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- f.Run(ctx, 1)
+	}()
+
+	queuetest.WaitFor(t, func() bool { return len(f.Workers()) == 1 })
+	sub.deliver("feed-receiver-1", acks.delivery(1))
+	sub.deliver("feed-receiver-1", acks.delivery(2))
+	sub.deliver("feed-receiver-1", acks.delivery(3))
+
+	<-started
+	assert.Equal(t, StateBusy, f.Workers()[0].State)
+
+	// The message in hand is finished, the rest go back to the queue
+	cancel()
+	close(release)
+
+	assert.Nil(t, <-done)
+	assert.Equal(t, []string{"1"}, acks.acked)
+	assert.Equal(t, []string{"2", "3"}, acks.requeued)
+
+	w := f.Workers()[0]
+	assert.Equal(t, StateStopped, w.State)
+	assert.Equal(t, uint64(1), w.Processed)
+	assert.Equal(t, uint64(2), w.Requeued)
+}
+
+func TestFeedReceiver_Run_ShutdownTimeout(t *testing.T) {
+	sub := newFakeSubscriber()
+	acks := &fakeAcks{}
+
+	release := make(chan struct{})
+	defer close(release)
+
+	timeout := 50 * time.Millisecond
+	f := NewFeedReceiver(sub, &config.RabbitMQConfig{FeedQueueName: "feed", ShutdownTimeout: timeout}, nil, nil, nil, nil, nil, nil, nil)
+	f.handle = func(d queue.Delivery) error {
+		<-release
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- f.Run(ctx, 2)
+	}()
+
+	queuetest.WaitFor(t, func() bool { return len(f.Workers()) == 2 })
+	sub.deliver("feed-receiver-1", acks.delivery(1))
+	queuetest.WaitFor(t, func() bool { return f.Workers()[0].State == StateBusy })
+
+	// The idle worker stops at once, the stuck one holds Run till the deadline
+	start := time.Now()
+	cancel()
+
+	err := <-done
+	assert.EqualError(t, err, "receiver.Run - 1 workers not stopped in 50ms")
+	assert.True(t, time.Since(start) >= timeout)
+
+	workers := f.Workers()
+	assert.Equal(t, StateBusy, workers[0].State)
+	assert.Equal(t, StateStopped, workers[1].State)
+}
+
+func TestFeedReceiver_Run_SubscribeFailed(t *testing.T) {
+	sub := newFakeSubscriber()
+	sub.subscribeErr = fmt.Errorf("channel/connection is not open")
+
+	f := NewFeedReceiver(sub, &config.RabbitMQConfig{FeedQueueName: "feed", ShutdownTimeout: time.Second}, nil, nil, nil, nil, nil, nil, nil)
+
+	err := f.Run(context.Background(), 2)
+
+	assert.NotNil(t, err)
+	assert.Empty(t, f.Workers())
+}
+
+func TestFeedReceiver_Run_DeliveriesClosed(t *testing.T) {
+	sub := newFakeSubscriber()
+
+	f := NewFeedReceiver(sub, &config.RabbitMQConfig{FeedQueueName: "feed", ShutdownTimeout: time.Second}, nil, nil, nil, nil, nil, nil, nil)
+	done := make(chan error, 1)
+	go func() {
+		done <- f.Run(context.Background(), 1)
+	}()
+
+	queuetest.WaitFor(t, func() bool { return len(f.Workers()) == 1 })
+	sub.Unsubscribe("feed-receiver-1")
+
+	assert.NotNil(t, <-done)
+	assert.Equal(t, StateStopped, f.Workers()[0].State)
+}
+
+func TestFeedReceiver_handleMessage_Versions(t *testing.T) {
+	f := NewFeedReceiver(newFakeSubscriber(), &config.RabbitMQConfig{}, nil, nil, nil, nil, nil, nil, nil)
+	acks := &fakeAcks{}
+
+	// A replica of the newer version may know it, the malformed event
+	// fails on every one
+	newer := acks.delivery(1)
+	newer.Body = []byte(`{"id":"1","type":"post.created","version":99,"payload":{}}`)
+	malformed := acks.delivery(2)
+	malformed.Body = []byte(`{"id":"2","type":"post.created","version":2,"payload":{"ID":5}}`)
+
+	assert.NotNil(t, f.handleMessage(newer))
+	assert.NotNil(t, f.handleMessage(malformed))
+
+	assert.Equal(t, []string{"1"}, acks.retried)
+	assert.Equal(t, []string{"2"}, acks.parked)
+}
@@ -0,0 +1,114 @@
+package receiver
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/niklod/highload-social-network/internal/cache"
+	"github.com/niklod/highload-social-network/internal/queue/feed/producer"
+)
+
+// Seen is the processed feed messages of a post. Messages may come twice
+// or out of order after a retry.
+type Seen struct {
+	Created bool
+	// Updated is the outbox ID of the last update processed
+	Updated int64
+	Deleted bool
+}
+
+// skips reports whether the message is a duplicate or outdated.
+func (s Seen) skips(msgType string, id int64) bool {
+	if s.Deleted {
+		return true
+	}
+
+	switch msgType {
+	case producer.PostUpdated:
+		return id <= s.Updated
+	case producer.PostDeleted:
+		return false
+	}
+
+	return s.Created
+}
+
+func (s Seen) with(msgType string, id int64) Seen {
+	switch msgType {
+	case producer.PostUpdated:
+		if id > s.Updated {
+			s.Updated = id
+		}
+	case producer.PostDeleted:
+		s.Deleted = true
+	default:
+		s.Created = true
+	}
+
+	return s
+}
+
+// SeenStore keeps Seen by post ID, shared by the replicas with the redis
+// cache.
+type SeenStore struct {
+	c cache.Cache
+}
+
+func NewSeenStore(c cache.Cache) *SeenStore {
+	return &SeenStore{c: c}
+}
+
+func (s *SeenStore) get(postId int) Seen {
+	v, ok := s.c.Read(postId)
+	if !ok {
+		return Seen{}
+	}
+
+	seen, _ := v.(Seen)
+
+	return seen
+}
+
+func (s *SeenStore) mark(postId int, msgType string, id int64) {
+	s.c.Update(postId, func(v interface{}, ok bool) (interface{}, bool) {
+		seen, _ := v.(Seen)
+
+		return seen.with(msgType, id), true
+	})
+}
+
+// SeenCodec stores Seen as a "created:updated:deleted" item, see
+// cache.Codec.
+type SeenCodec struct{}
+
+func (SeenCodec) Encode(v interface{}) ([][]byte, error) {
+	s, ok := v.(Seen)
+	if !ok {
+		return nil, fmt.Errorf("receiver.SeenCodec - %T isn't seen", v)
+	}
+
+	item := strconv.FormatBool(s.Created) + ":" + strconv.FormatInt(s.Updated, 10) + ":" + strconv.FormatBool(s.Deleted)
+
+	return [][]byte{[]byte(item)}, nil
+}
+
+func (SeenCodec) Decode(items [][]byte) (interface{}, error) {
+	if len(items) != 1 {
+		return nil, fmt.Errorf("receiver.SeenCodec - %d items instead of one", len(items))
+	}
+
+	parts := strings.Split(string(items[0]), ":")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("receiver.SeenCodec - malformed item %q", items[0])
+	}
+
+	created, err1 := strconv.ParseBool(parts[0])
+	updated, err2 := strconv.ParseInt(parts[1], 10, 64)
+	deleted, err3 := strconv.ParseBool(parts[2])
+	if err1 != nil || err2 != nil || err3 != nil {
+		return nil, fmt.Errorf("receiver.SeenCodec - malformed item %q", items[0])
+	}
+
+	return Seen{Created: created, Updated: updated, Deleted: deleted}, nil
+}
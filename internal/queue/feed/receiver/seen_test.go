@@ -0,0 +1,48 @@
+package receiver
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/niklod/highload-social-network/internal/cache"
+	"github.com/niklod/highload-social-network/internal/queue/feed/producer"
+)
+
+func TestSeenStore(t *testing.T) {
+	seen := NewSeenStore(cache.NewFeedCache())
+
+	assert.False(t, seen.get(1).skips(producer.PostCreated, 10))
+
+	seen.mark(1, producer.PostCreated, 10)
+	assert.True(t, seen.get(1).skips(producer.PostCreated, 10))
+
+	// The update retried after the newer one is outdated
+	seen.mark(1, producer.PostUpdated, 12)
+	assert.True(t, seen.get(1).skips(producer.PostUpdated, 11))
+	assert.True(t, seen.get(1).skips(producer.PostUpdated, 12))
+	assert.False(t, seen.get(1).skips(producer.PostUpdated, 13))
+
+	// Nothing comes back after the delete
+	seen.mark(1, producer.PostDeleted, 14)
+	assert.True(t, seen.get(1).skips(producer.PostCreated, 10))
+	assert.True(t, seen.get(1).skips(producer.PostUpdated, 15))
+	assert.True(t, seen.get(1).skips(producer.PostDeleted, 14))
+
+	assert.False(t, seen.get(2).skips(producer.PostDeleted, 16))
+}
+
+func TestSeenCodec(t *testing.T) {
+	s := Seen{Created: true, Updated: 42}
+
+	items, err := SeenCodec{}.Encode(s)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("true:42:false")}, items)
+
+	v, err := SeenCodec{}.Decode(items)
+	assert.Nil(t, err)
+	assert.Equal(t, s, v)
+
+	_, err = SeenCodec{}.Decode([][]byte{[]byte("true:x")})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,88 @@
+package receiver
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// WorkerState is the state of the receiver worker.
+type WorkerState int32
+
+const (
+	// StateIdle is waiting for a message
+	StateIdle WorkerState = iota
+	// StateBusy is processing a message
+	StateBusy
+	// StateDraining requeues the messages received after the shutdown
+	StateDraining
+	StateStopped
+)
+
+func (s WorkerState) String() string {
+	switch s {
+	case StateIdle:
+		return "idle"
+	case StateBusy:
+		return "busy"
+	case StateDraining:
+		return "draining"
+	case StateStopped:
+		return "stopped"
+	}
+
+	return fmt.Sprintf("WorkerState(%d)", int32(s))
+}
+
+// WorkerStatus is the snapshot of the worker, see FeedReceiver.Workers.
+type WorkerStatus struct {
+	ID        int
+	State     WorkerState
+	Processed uint64
+	Failed    uint64
+	Requeued  uint64
+	// Since is when the worker got into the state
+	Since time.Time
+}
+
+func (w WorkerStatus) String() string {
+	return fmt.Sprintf("worker %d: %s for %s, %d processed, %d failed, %d requeued",
+		w.ID, w.State, time.Since(w.Since).Truncate(time.Millisecond), w.Processed, w.Failed, w.Requeued)
+}
+
+type worker struct {
+	id  int
+	tag string
+
+	state     int32
+	since     int64
+	processed uint64
+	failed    uint64
+	requeued  uint64
+}
+
+func newWorker(id int) *worker {
+	w := &worker{
+		id:  id,
+		tag: fmt.Sprintf("feed-receiver-%d", id),
+	}
+	w.set(StateIdle)
+
+	return w
+}
+
+func (w *worker) set(s WorkerState) {
+	atomic.StoreInt64(&w.since, time.Now().UnixNano())
+	atomic.StoreInt32(&w.state, int32(s))
+}
+
+func (w *worker) status() WorkerStatus {
+	return WorkerStatus{
+		ID:        w.id,
+		State:     WorkerState(atomic.LoadInt32(&w.state)),
+		Processed: atomic.LoadUint64(&w.processed),
+		Failed:    atomic.LoadUint64(&w.failed),
+		Requeued:  atomic.LoadUint64(&w.requeued),
+		Since:     time.Unix(0, atomic.LoadInt64(&w.since)),
+	}
+}
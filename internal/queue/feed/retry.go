@@ -0,0 +1,83 @@
+package feed
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/niklod/highload-social-network/config"
+	"github.com/streadway/amqp"
+)
+
+// The headers of the failed messages
+const (
+	HeaderRetryCount = "x-retry-count"
+	HeaderError      = "x-error"
+	// headerDeath is set by RabbitMQ on dead lettering
+	headerDeath = "x-death"
+)
+
+// RetryExchangeName is the exchange of the retry queues.
+func RetryExchangeName(cfg *config.RabbitMQConfig) string {
+	return cfg.FeedExchangeName + ".retry"
+}
+
+// RetryQueueName is the retry queue of the attempt, counting from one.
+func RetryQueueName(cfg *config.RabbitMQConfig, attempt int) string {
+	return cfg.FeedQueueName + ".retry." + strconv.Itoa(attempt)
+}
+
+// DeadLetterExchangeName is the exchange of the dead letter queue.
+func DeadLetterExchangeName(cfg *config.RabbitMQConfig) string {
+	return cfg.FeedExchangeName + ".dead"
+}
+
+// DeadLetterQueueName is the queue of the parked messages, see cmd/feed-dlq.
+func DeadLetterQueueName(cfg *config.RabbitMQConfig) string {
+	return cfg.FeedQueueName + ".dead"
+}
+
+// RetryDelay is the back-off delay of the attempt, counting from one.
+func RetryDelay(cfg *config.RabbitMQConfig, attempt int) time.Duration {
+	return cfg.RetryDelay << uint(attempt-1)
+}
+
+// RetryCount returns the number of the retries of the message so far.
+func RetryCount(headers amqp.Table) int {
+	switch v := headers[HeaderRetryCount].(type) {
+	case int32:
+		return int(v)
+	case int64:
+		return int(v)
+	case int:
+		return v
+	}
+
+	return 0
+}
+
+// FailedHeaders returns a copy of the headers with the attempt and error.
+func FailedHeaders(headers amqp.Table, attempt int, err error) amqp.Table {
+	res := make(amqp.Table, len(headers)+2)
+	for k, v := range headers {
+		res[k] = v
+	}
+
+	res[HeaderRetryCount] = int32(attempt)
+	res[HeaderError] = err.Error()
+
+	return res
+}
+
+// ReplayHeaders returns a copy of the headers without the retry state.
+func ReplayHeaders(headers amqp.Table) amqp.Table {
+	res := make(amqp.Table, len(headers))
+	for k, v := range headers {
+		switch k {
+		case HeaderRetryCount, HeaderError, headerDeath:
+			continue
+		}
+		res[k] = v
+	}
+
+	return res
+}
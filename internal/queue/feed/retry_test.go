@@ -0,0 +1,85 @@
+package feed
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/niklod/highload-social-network/config"
+)
+
+func TestRetryDelay_Doubles(t *testing.T) {
+	cfg := &config.RabbitMQConfig{RetryDelay: time.Second}
+
+	assert.Equal(t, time.Second, RetryDelay(cfg, 1))
+	assert.Equal(t, 2*time.Second, RetryDelay(cfg, 2))
+	assert.Equal(t, 16*time.Second, RetryDelay(cfg, 5))
+}
+
+func TestRetryCount(t *testing.T) {
+	assert.Equal(t, 0, RetryCount(nil))
+	assert.Equal(t, 3, RetryCount(amqp.Table{HeaderRetryCount: int32(3)}))
+	assert.Equal(t, 4, RetryCount(amqp.Table{HeaderRetryCount: int64(4)}))
+	assert.Equal(t, 0, RetryCount(amqp.Table{HeaderRetryCount: "5"}))
+}
+
+func TestFailedAndReplayHeaders(t *testing.T) {
+	original := amqp.Table{"trace": "abc", "x-death": []interface{}{}}
+
+	failed := FailedHeaders(original, 2, fmt.Errorf("query timeout"))
+	assert.Equal(t, amqp.Table{
+		"trace":          "abc",
+		"x-death":        []interface{}{},
+		HeaderRetryCount: int32(2),
+		HeaderError:      "query timeout",
+	}, failed)
+	assert.Equal(t, 2, RetryCount(failed))
+	assert.NotContains(t, original, HeaderRetryCount)
+
+	assert.Equal(t, amqp.Table{"trace": "abc"}, ReplayHeaders(failed))
+}
+
+// fakeChannel records the published retries
+type fakeChannel struct {
+	channel
+	published []amqp.Publishing
+	keys      []string
+}
+
+func (c *fakeChannel) Publish(exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	c.keys = append(c.keys, exchange+"/"+key)
+	c.published = append(c.published, msg)
+	return nil
+}
+
+type fakeAcknowledger struct {
+	amqp.Acknowledger
+	acked int
+}
+
+func (a *fakeAcknowledger) Ack(tag uint64, multiple bool) error {
+	a.acked++
+	return nil
+}
+
+func TestSubscriber_retry_ExpiresPerMessage(t *testing.T) {
+	cfg := &config.RabbitMQConfig{FeedQueueName: "feed", FeedExchangeName: "feedEx", RetryDelay: time.Second, MaxRetries: 2}
+	ch := &fakeChannel{}
+	acks := &fakeAcknowledger{}
+	s := NewSubscriber(ch, cfg)
+
+	m := amqp.Delivery{Acknowledger: acks, MessageId: "1", Headers: amqp.Table{HeaderRetryCount: int32(1)}}
+	assert.Nil(t, s.retry(m, fmt.Errorf("db is down"), false))
+
+	m.Headers = amqp.Table{HeaderRetryCount: int32(2)}
+	assert.Nil(t, s.retry(m, fmt.Errorf("db is down"), false))
+
+	// The delay doubles on the second attempt, the parked message doesn't expire
+	assert.Equal(t, []string{"feedEx.retry/feed.retry.2", "feedEx.dead/"}, ch.keys)
+	assert.Equal(t, "2000", ch.published[0].Expiration)
+	assert.Equal(t, "", ch.published[1].Expiration)
+	assert.Equal(t, 2, acks.acked)
+}
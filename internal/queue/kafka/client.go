@@ -0,0 +1,600 @@
+// Package kafka is a minimal Kafka client, without group membership, and
+// an in-memory test broker.
+package kafka
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/niklod/highload-social-network/config"
+)
+
+// The special offsets of ListOffset
+const (
+	OffsetNewest int64 = -1
+	OffsetOldest int64 = -2
+)
+
+// maxFrame bounds the size of the response the client reads
+const maxFrame = 64 << 20
+
+// Client sends the requests over pooled broker connections, caching the
+// partition leaders and group coordinators until they move.
+type Client struct {
+	brokers    []string
+	clientID   string
+	timeout    time.Duration
+	poolSize   int
+	fetchBytes int32
+
+	correlation int32
+
+	mu           sync.Mutex
+	pools        map[string]chan *conn
+	leaders      map[string][]string
+	coordinators map[string]string
+}
+
+type conn struct {
+	net.Conn
+	r *bufio.Reader
+	w *bufio.Writer
+}
+
+func NewClient(cfg *config.KafkaConfig) *Client {
+	poolSize := cfg.PoolSize
+	if poolSize <= 0 {
+		poolSize = 1
+	}
+
+	return &Client{
+		brokers:      cfg.Brokers,
+		clientID:     cfg.ClientID,
+		timeout:      cfg.Timeout,
+		poolSize:     poolSize,
+		fetchBytes:   int32(cfg.FetchMaxBytes),
+		pools:        make(map[string]chan *conn),
+		leaders:      make(map[string][]string),
+		coordinators: make(map[string]string),
+	}
+}
+
+// Partitions returns the number of the partitions of the topic.
+func (c *Client) Partitions(topic string) (int, error) {
+	leaders, err := c.topic(topic)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(leaders), nil
+}
+
+// Produce appends the records with acks=all and returns the first offset.
+func (c *Client) Produce(topic string, partition int32, records ...Record) (int64, error) {
+	addr, err := c.leader(topic, partition)
+	if err != nil {
+		return 0, err
+	}
+
+	var batch encoder
+	encodeBatch(&batch, 0, records)
+
+	d, err := c.request(addr, apiProduce, 0, func(e *encoder) {
+		e.nullableString("")
+		e.int16(-1) // acks of all the in-sync replicas
+		e.int32(int32(c.timeout / time.Millisecond))
+		e.arrayLen(1)
+		e.string(topic)
+		e.arrayLen(1)
+		e.int32(partition)
+		e.bytes(batch.b)
+	})
+	if err != nil {
+		c.forget(topic)
+		return 0, err
+	}
+
+	var (
+		code   int16
+		offset int64
+	)
+	for n := d.arrayLen(); n > 0; n-- {
+		d.string()
+		for p := d.arrayLen(); p > 0; p-- {
+			d.int32()
+			code = d.int16()
+			offset = d.int64()
+			d.int64()
+		}
+	}
+	if d.err != nil {
+		return 0, fmt.Errorf("kafka: reading produce response: %v", d.err)
+	}
+
+	return offset, c.checkTopic(topic, code)
+}
+
+// Fetch returns the records from the offset, waiting up to maxWait.
+func (c *Client) Fetch(topic string, partition int32, offset int64, maxWait time.Duration) ([]Record, error) {
+	addr, err := c.leader(topic, partition)
+	if err != nil {
+		return nil, err
+	}
+
+	d, err := c.request(addr, apiFetch, maxWait, func(e *encoder) {
+		e.int32(-1) // replica ID of the consumers
+		e.int32(int32(maxWait / time.Millisecond))
+		e.int32(1)
+		e.int32(c.fetchBytes)
+		e.int8(1) // read committed
+		e.arrayLen(1)
+		e.string(topic)
+		e.arrayLen(1)
+		e.int32(partition)
+		e.int64(offset)
+		e.int32(c.fetchBytes)
+	})
+	if err != nil {
+		c.forget(topic)
+		return nil, err
+	}
+
+	var (
+		code int16
+		set  []byte
+	)
+	d.int32() // throttle time
+	for n := d.arrayLen(); n > 0; n-- {
+		d.string()
+		for p := d.arrayLen(); p > 0; p-- {
+			d.int32()
+			code = d.int16()
+			d.int64() // high watermark
+			d.int64() // last stable offset
+			for a := d.arrayLen(); a > 0; a-- {
+				d.int64()
+				d.int64()
+			}
+			set = d.bytes()
+		}
+	}
+	if d.err != nil {
+		return nil, fmt.Errorf("kafka: reading fetch response: %v", d.err)
+	}
+	if err := c.checkTopic(topic, code); err != nil {
+		return nil, err
+	}
+
+	records, err := decodeBatches(set, offset)
+	if err != nil {
+		return nil, fmt.Errorf("kafka: reading records of %s/%d: %v", topic, partition, err)
+	}
+
+	return records, nil
+}
+
+// ListOffset returns the OffsetOldest or OffsetNewest of the partition.
+func (c *Client) ListOffset(topic string, partition int32, at int64) (int64, error) {
+	addr, err := c.leader(topic, partition)
+	if err != nil {
+		return 0, err
+	}
+
+	d, err := c.request(addr, apiListOffsets, 0, func(e *encoder) {
+		e.int32(-1)
+		e.arrayLen(1)
+		e.string(topic)
+		e.arrayLen(1)
+		e.int32(partition)
+		e.int64(at)
+	})
+	if err != nil {
+		c.forget(topic)
+		return 0, err
+	}
+
+	var (
+		code   int16
+		offset int64
+	)
+	for n := d.arrayLen(); n > 0; n-- {
+		d.string()
+		for p := d.arrayLen(); p > 0; p-- {
+			d.int32()
+			code = d.int16()
+			d.int64() // timestamp
+			offset = d.int64()
+		}
+	}
+	if d.err != nil {
+		return 0, fmt.Errorf("kafka: reading list offsets response: %v", d.err)
+	}
+
+	return offset, c.checkTopic(topic, code)
+}
+
+// CommitOffset saves the group's next offset of the partition as a
+// standalone commit.
+func (c *Client) CommitOffset(group, topic string, partition int32, offset int64) error {
+	addr, err := c.coordinator(group)
+	if err != nil {
+		return err
+	}
+
+	d, err := c.request(addr, apiOffsetCommit, 0, func(e *encoder) {
+		e.string(group)
+		e.int32(-1) // generation
+		e.string("")
+		e.int64(-1) // retention of the broker
+		e.arrayLen(1)
+		e.string(topic)
+		e.arrayLen(1)
+		e.int32(partition)
+		e.int64(offset)
+		e.nullableString("")
+	})
+	if err != nil {
+		c.forgetCoordinator(group)
+		return err
+	}
+
+	var code int16
+	for n := d.arrayLen(); n > 0; n-- {
+		d.string()
+		for p := d.arrayLen(); p > 0; p-- {
+			d.int32()
+			code = d.int16()
+		}
+	}
+	if d.err != nil {
+		return fmt.Errorf("kafka: reading offset commit response: %v", d.err)
+	}
+
+	return c.checkGroup(group, code)
+}
+
+// FetchOffset returns the group's committed offset, -1 if there is none.
+func (c *Client) FetchOffset(group, topic string, partition int32) (int64, error) {
+	addr, err := c.coordinator(group)
+	if err != nil {
+		return 0, err
+	}
+
+	d, err := c.request(addr, apiOffsetFetch, 0, func(e *encoder) {
+		e.string(group)
+		e.arrayLen(1)
+		e.string(topic)
+		e.arrayLen(1)
+		e.int32(partition)
+	})
+	if err != nil {
+		c.forgetCoordinator(group)
+		return 0, err
+	}
+
+	var (
+		code   int16
+		offset int64 = -1
+	)
+	for n := d.arrayLen(); n > 0; n-- {
+		d.string()
+		for p := d.arrayLen(); p > 0; p-- {
+			d.int32()
+			offset = d.int64()
+			d.string() // metadata
+			code = d.int16()
+		}
+	}
+	if d.err != nil {
+		return 0, fmt.Errorf("kafka: reading offset fetch response: %v", d.err)
+	}
+
+	return offset, c.checkGroup(group, code)
+}
+
+// Close closes the idle connections.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, idle := range c.pools {
+		for len(idle) > 0 {
+			(<-idle).Close()
+		}
+	}
+
+	return nil
+}
+
+func (c *Client) leader(topic string, partition int32) (string, error) {
+	leaders, err := c.topic(topic)
+	if err != nil {
+		return "", err
+	}
+
+	if partition < 0 || int(partition) >= len(leaders) {
+		return "", fmt.Errorf("kafka: topic %s has no partition %d", topic, partition)
+	}
+	if leaders[partition] == "" {
+		c.forget(topic)
+		return "", ErrLeaderNotAvailable
+	}
+
+	return leaders[partition], nil
+}
+
+// topic returns the partition leaders, fetching the metadata on a miss
+func (c *Client) topic(topic string) ([]string, error) {
+	c.mu.Lock()
+	leaders, ok := c.leaders[topic]
+	c.mu.Unlock()
+
+	if ok {
+		return leaders, nil
+	}
+
+	d, err := c.bootstrap(apiMetadata, func(e *encoder) {
+		e.arrayLen(1)
+		e.string(topic)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	brokers := make(map[int32]string)
+	for n := d.arrayLen(); n > 0; n-- {
+		id := d.int32()
+		host := d.string()
+		port := d.int32()
+		d.string() // rack
+
+		brokers[id] = net.JoinHostPort(host, strconv.Itoa(int(port)))
+	}
+	d.int32() // controller
+
+	var code int16
+	for n := d.arrayLen(); n > 0; n-- {
+		code = d.int16()
+		name := d.string()
+		d.bool()
+
+		partitions := d.arrayLen()
+		leaders = make([]string, partitions)
+		for p := 0; p < partitions; p++ {
+			d.int16()
+			index := d.int32()
+			leader := d.int32()
+			for r := d.arrayLen(); r > 0; r-- {
+				d.int32()
+			}
+			for r := d.arrayLen(); r > 0; r-- {
+				d.int32()
+			}
+
+			if index >= 0 && int(index) < partitions {
+				leaders[index] = brokers[leader]
+			}
+		}
+
+		if name != topic {
+			leaders = nil
+		}
+	}
+	if d.err != nil {
+		return nil, fmt.Errorf("kafka: reading metadata: %v", d.err)
+	}
+	if err := errorOf(code); err != nil {
+		return nil, fmt.Errorf("kafka: metadata of %s: %v", topic, err)
+	}
+	if len(leaders) == 0 {
+		return nil, fmt.Errorf("kafka: topic %s has no partitions", topic)
+	}
+
+	c.mu.Lock()
+	c.leaders[topic] = leaders
+	c.mu.Unlock()
+
+	return leaders, nil
+}
+
+func (c *Client) coordinator(group string) (string, error) {
+	c.mu.Lock()
+	addr, ok := c.coordinators[group]
+	c.mu.Unlock()
+
+	if ok {
+		return addr, nil
+	}
+
+	d, err := c.bootstrap(apiFindCoordinator, func(e *encoder) {
+		e.string(group)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	code := d.int16()
+	d.int32() // node
+	host := d.string()
+	port := d.int32()
+	if d.err != nil {
+		return "", fmt.Errorf("kafka: reading coordinator: %v", d.err)
+	}
+	if err := errorOf(code); err != nil {
+		return "", fmt.Errorf("kafka: coordinator of %s: %v", group, err)
+	}
+
+	addr = net.JoinHostPort(host, strconv.Itoa(int(port)))
+
+	c.mu.Lock()
+	c.coordinators[group] = addr
+	c.mu.Unlock()
+
+	return addr, nil
+}
+
+func (c *Client) forget(topic string) {
+	c.mu.Lock()
+	delete(c.leaders, topic)
+	c.mu.Unlock()
+}
+
+func (c *Client) forgetCoordinator(group string) {
+	c.mu.Lock()
+	delete(c.coordinators, group)
+	c.mu.Unlock()
+}
+
+func (c *Client) checkTopic(topic string, code int16) error {
+	err := errorOf(code)
+	if e, ok := err.(Error); ok && e.stale() {
+		c.forget(topic)
+	}
+
+	return err
+}
+
+func (c *Client) checkGroup(group string, code int16) error {
+	err := errorOf(code)
+	if e, ok := err.(Error); ok && e.stale() {
+		c.forgetCoordinator(group)
+	}
+
+	return err
+}
+
+// bootstrap sends the request to the first configured broker answering
+func (c *Client) bootstrap(apiKey int16, body func(e *encoder)) (*decoder, error) {
+	var err error
+
+	for _, addr := range c.brokers {
+		var d *decoder
+		if d, err = c.request(addr, apiKey, 0, body); err == nil {
+			return d, nil
+		}
+	}
+	if err == nil {
+		err = fmt.Errorf("kafka: no brokers configured")
+	}
+
+	return nil, err
+}
+
+// request sends the request and decodes the response, wait extends the
+// timeout of the requests the broker holds
+func (c *Client) request(addr string, apiKey int16, wait time.Duration, body func(e *encoder)) (*decoder, error) {
+	cn, err := c.get(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	header := requestHeader{
+		apiKey:        apiKey,
+		apiVersion:    apiVersions[apiKey],
+		correlationID: atomic.AddInt32(&c.correlation, 1),
+		clientID:      c.clientID,
+	}
+
+	d, err := cn.roundTrip(header, body, time.Now().Add(c.timeout+wait))
+	if err != nil {
+		cn.Close()
+		return nil, fmt.Errorf("kafka: request to %s: %v", addr, err)
+	}
+
+	c.put(addr, cn)
+
+	return d, nil
+}
+
+func (c *Client) get(addr string) (*conn, error) {
+	c.mu.Lock()
+	idle, ok := c.pools[addr]
+	if !ok {
+		idle = make(chan *conn, c.poolSize)
+		c.pools[addr] = idle
+	}
+	c.mu.Unlock()
+
+	select {
+	case cn := <-idle:
+		return cn, nil
+	default:
+	}
+
+	nc, err := net.DialTimeout("tcp", addr, c.timeout)
+	if err != nil {
+		return nil, fmt.Errorf("kafka: connecting to %s: %v", addr, err)
+	}
+
+	return &conn{Conn: nc, r: bufio.NewReader(nc), w: bufio.NewWriter(nc)}, nil
+}
+
+// put returns the connection to the pool or closes it if the pool is full
+func (c *Client) put(addr string, cn *conn) {
+	c.mu.Lock()
+	idle := c.pools[addr]
+	c.mu.Unlock()
+
+	select {
+	case idle <- cn:
+	default:
+		cn.Close()
+	}
+}
+
+func (cn *conn) roundTrip(header requestHeader, body func(e *encoder), deadline time.Time) (*decoder, error) {
+	if err := cn.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+
+	e := &encoder{b: make([]byte, 4, 64)}
+	header.encode(e)
+	body(e)
+	putInt32(e.b, int32(len(e.b)-4))
+
+	if _, err := cn.w.Write(e.b); err != nil {
+		return nil, err
+	}
+	if err := cn.w.Flush(); err != nil {
+		return nil, err
+	}
+
+	frame, err := readFrame(cn.r)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &decoder{b: frame}
+	if id := d.int32(); id != header.correlationID {
+		return nil, fmt.Errorf("response %d to request %d", id, header.correlationID)
+	}
+
+	return d, nil
+}
+
+// readFrame reads the size prefixed request or response
+func readFrame(r *bufio.Reader) ([]byte, error) {
+	var size [4]byte
+	if _, err := io.ReadFull(r, size[:]); err != nil {
+		return nil, err
+	}
+
+	n := binary.BigEndian.Uint32(size[:])
+	if n > maxFrame {
+		return nil, fmt.Errorf("frame of %d bytes is too big", n)
+	}
+
+	frame := make([]byte, n)
+	if _, err := io.ReadFull(r, frame); err != nil {
+		return nil, err
+	}
+
+	return frame, nil
+}
@@ -0,0 +1,178 @@
+package kafka
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/niklod/highload-social-network/config"
+)
+
+func newTestClient(t *testing.T, partitions int) (*Client, *Server) {
+	t.Helper()
+
+	srv, err := NewServer(partitions)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := NewClient(&config.KafkaConfig{
+		Brokers:       []string{srv.Addr()},
+		ClientID:      "test",
+		PoolSize:      2,
+		Timeout:       time.Second,
+		FetchMaxBytes: 1 << 20,
+	})
+	t.Cleanup(func() {
+		c.Close()
+		srv.Close()
+	})
+
+	return c, srv
+}
+
+func TestClient_ProduceFetch(t *testing.T) {
+	c, _ := newTestClient(t, 3)
+
+	n, err := c.Partitions("feed")
+	assert.Nil(t, err)
+	assert.Equal(t, 3, n)
+
+	at := time.Unix(1600000000, 0)
+	offset, err := c.Produce("feed", 1,
+		Record{Key: []byte("1"), Value: []byte("a"), Headers: []Header{{Key: "type", Value: []byte("post.created")}}, Timestamp: at},
+		Record{Key: []byte("1"), Value: []byte("b"), Timestamp: at.Add(time.Second)})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), offset)
+
+	offset, err = c.Produce("feed", 1, Record{Value: []byte("c")})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), offset)
+
+	records, err := c.Fetch("feed", 1, 1, 0)
+	assert.Nil(t, err)
+	if assert.Len(t, records, 2) {
+		assert.Equal(t, int64(1), records[0].Offset)
+		assert.Equal(t, []byte("b"), records[0].Value)
+		assert.Equal(t, at.Add(time.Second), records[0].Timestamp)
+		assert.Nil(t, records[1].Key)
+	}
+
+	records, err = c.Fetch("feed", 1, 0, 0)
+	assert.Nil(t, err)
+	typ, ok := records[0].Header("type")
+	assert.True(t, ok)
+	assert.Equal(t, []byte("post.created"), typ)
+
+	records, err = c.Fetch("feed", 2, 0, 0)
+	assert.Nil(t, err)
+	assert.Empty(t, records)
+
+	_, err = c.Fetch("feed", 1, 10, 0)
+	assert.Equal(t, ErrOffsetOutOfRange, err)
+
+	_, err = c.Produce("feed", 3, Record{Value: []byte("d")})
+	assert.NotNil(t, err)
+}
+
+func TestClient_Fetch_Waits(t *testing.T) {
+	c, _ := newTestClient(t, 1)
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		c.Produce("feed", 0, Record{Value: []byte("a")})
+	}()
+
+	records, err := c.Fetch("feed", 0, 0, time.Second)
+	assert.Nil(t, err)
+	assert.Len(t, records, 1)
+
+	start := time.Now()
+	records, err = c.Fetch("feed", 0, 1, 30*time.Millisecond)
+	assert.Nil(t, err)
+	assert.Empty(t, records)
+	assert.True(t, time.Since(start) >= 30*time.Millisecond)
+}
+
+func TestClient_Offsets(t *testing.T) {
+	c, srv := newTestClient(t, 2)
+
+	c.Produce("feed", 0, Record{Value: []byte("a")}, Record{Value: []byte("b")})
+
+	oldest, err := c.ListOffset("feed", 0, OffsetOldest)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), oldest)
+
+	newest, err := c.ListOffset("feed", 0, OffsetNewest)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), newest)
+
+	offset, err := c.FetchOffset("receivers", "feed", 0)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(-1), offset)
+
+	assert.Nil(t, c.CommitOffset("receivers", "feed", 0, 1))
+
+	offset, err = c.FetchOffset("receivers", "feed", 0)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), offset)
+
+	// The groups keep their own offsets
+	offset, _ = c.FetchOffset("search", "feed", 0)
+	assert.Equal(t, int64(-1), offset)
+	assert.Equal(t, int64(1), srv.Committed("receivers", "feed", 0))
+}
+
+func TestClient_BrokerDown(t *testing.T) {
+	c, srv := newTestClient(t, 1)
+
+	_, err := c.Produce("feed", 0, Record{Value: []byte("a")})
+	assert.Nil(t, err)
+
+	srv.Close()
+
+	_, err = c.Produce("feed", 0, Record{Value: []byte("b")})
+	assert.NotNil(t, err)
+	_, err = c.Partitions("feed")
+	assert.NotNil(t, err)
+}
+
+func TestDecodeBatches(t *testing.T) {
+	var e encoder
+	encodeBatch(&e, 10, []Record{{Value: []byte("a")}, {Value: []byte("b")}})
+	encodeBatch(&e, 12, []Record{{Value: []byte("c")}})
+
+	records, err := decodeBatches(e.b, 11)
+	assert.Nil(t, err)
+	if assert.Len(t, records, 2) {
+		assert.Equal(t, int64(11), records[0].Offset)
+		assert.Equal(t, int64(12), records[1].Offset)
+	}
+
+	// The batch cut by the fetch size is dropped
+	records, err = decodeBatches(e.b[:len(e.b)-3], 0)
+	assert.Nil(t, err)
+	assert.Len(t, records, 2)
+
+	e.b[len(e.b)-1] ^= 0xff
+	_, err = decodeBatches(e.b, 0)
+	assert.Equal(t, errCRC, err)
+}
+
+func TestPartition(t *testing.T) {
+	// The hashes of the Java client's tests
+	for key, hash := range map[string]int32{
+		"21":                         -973932308,
+		"foobar":                     -790332482,
+		"a-little-bit-long-string":   -985981536,
+		"a-little-bit-longer-string": -1486304829,
+		"lkjh234lh9fiuh90y23oiuhsafujhadof229phr9h19h89h8": -58897971,
+		"abc": 479470107,
+	} {
+		assert.Equal(t, hash, int32(murmur2([]byte(key))), key)
+	}
+
+	assert.Equal(t, Partition([]byte("42"), 12), Partition([]byte("42"), 12))
+	assert.True(t, Partition([]byte("foobar"), 12) < 12)
+}
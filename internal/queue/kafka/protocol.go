@@ -0,0 +1,313 @@
+package kafka
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// The APIs and versions spoken, supported by every broker since Kafka 1.0
+const (
+	apiProduce         int16 = 0
+	apiFetch           int16 = 1
+	apiListOffsets     int16 = 2
+	apiMetadata        int16 = 3
+	apiOffsetCommit    int16 = 8
+	apiOffsetFetch     int16 = 9
+	apiFindCoordinator int16 = 10
+)
+
+var apiVersions = map[int16]int16{
+	apiProduce:         3,
+	apiFetch:           4,
+	apiListOffsets:     1,
+	apiMetadata:        1,
+	apiOffsetCommit:    2,
+	apiOffsetFetch:     1,
+	apiFindCoordinator: 0,
+}
+
+// Error is the error code of the broker
+type Error int16
+
+// The error codes the client handles
+const (
+	ErrNone                    Error = 0
+	ErrOffsetOutOfRange        Error = 1
+	ErrCorruptMessage          Error = 2
+	ErrUnknownTopicOrPartition Error = 3
+	ErrLeaderNotAvailable      Error = 5
+	ErrNotLeader               Error = 6
+	ErrRequestTimedOut         Error = 7
+	ErrCoordinatorLoading      Error = 14
+	ErrCoordinatorNotAvailable Error = 15
+	ErrNotCoordinator          Error = 16
+	ErrUnsupportedVersion      Error = 35
+)
+
+var errorNames = map[Error]string{
+	ErrOffsetOutOfRange:        "offset out of range",
+	ErrCorruptMessage:          "corrupt message",
+	ErrUnknownTopicOrPartition: "unknown topic or partition",
+	ErrLeaderNotAvailable:      "leader not available",
+	ErrNotLeader:               "not leader or follower",
+	ErrRequestTimedOut:         "request timed out",
+	ErrCoordinatorLoading:      "coordinator load in progress",
+	ErrCoordinatorNotAvailable: "coordinator not available",
+	ErrNotCoordinator:          "not coordinator",
+	ErrUnsupportedVersion:      "unsupported version",
+}
+
+func (e Error) Error() string {
+	if name, ok := errorNames[e]; ok {
+		return "kafka: " + name
+	}
+
+	return fmt.Sprintf("kafka: error code %d", int16(e))
+}
+
+// stale reports whether the cached leader or coordinator has moved
+func (e Error) stale() bool {
+	switch e {
+	case ErrUnknownTopicOrPartition, ErrLeaderNotAvailable, ErrNotLeader, ErrCoordinatorNotAvailable, ErrNotCoordinator:
+		return true
+	}
+
+	return false
+}
+
+func errorOf(code int16) error {
+	if code == 0 {
+		return nil
+	}
+
+	return Error(code)
+}
+
+var errShort = errors.New("kafka: message is too short")
+
+// encoder writes the big endian primitives of the protocol
+type encoder struct {
+	b []byte
+}
+
+func (e *encoder) int8(v int8) {
+	e.b = append(e.b, byte(v))
+}
+
+func (e *encoder) int16(v int16) {
+	e.b = append(e.b, byte(v>>8), byte(v))
+}
+
+func (e *encoder) int32(v int32) {
+	e.b = append(e.b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func (e *encoder) int64(v int64) {
+	e.int32(int32(v >> 32))
+	e.int32(int32(v))
+}
+
+func (e *encoder) bool(v bool) {
+	if v {
+		e.int8(1)
+	} else {
+		e.int8(0)
+	}
+}
+
+func (e *encoder) string(s string) {
+	e.int16(int16(len(s)))
+	e.b = append(e.b, s...)
+}
+
+// nullableString writes null for the empty string
+func (e *encoder) nullableString(s string) {
+	if s == "" {
+		e.int16(-1)
+		return
+	}
+
+	e.string(s)
+}
+
+// bytes writes null for nil
+func (e *encoder) bytes(b []byte) {
+	if b == nil {
+		e.int32(-1)
+		return
+	}
+
+	e.int32(int32(len(b)))
+	e.b = append(e.b, b...)
+}
+
+func (e *encoder) arrayLen(n int) {
+	e.int32(int32(n))
+}
+
+// varint writes the zigzag varint of the record format
+func (e *encoder) varint(v int64) {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutVarint(buf[:], v)
+	e.b = append(e.b, buf[:n]...)
+}
+
+// varbytes writes varint length prefixed bytes, null for nil
+func (e *encoder) varbytes(b []byte) {
+	if b == nil {
+		e.varint(-1)
+		return
+	}
+
+	e.varint(int64(len(b)))
+	e.b = append(e.b, b...)
+}
+
+// decoder reads the primitives, after the first error reads return zeros
+type decoder struct {
+	b   []byte
+	off int
+	err error
+}
+
+func (d *decoder) take(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || len(d.b)-d.off < n {
+		d.err = errShort
+		return nil
+	}
+
+	b := d.b[d.off : d.off+n]
+	d.off += n
+
+	return b
+}
+
+func (d *decoder) remaining() int {
+	return len(d.b) - d.off
+}
+
+func (d *decoder) int8() int8 {
+	b := d.take(1)
+	if b == nil {
+		return 0
+	}
+
+	return int8(b[0])
+}
+
+func (d *decoder) int16() int16 {
+	b := d.take(2)
+	if b == nil {
+		return 0
+	}
+
+	return int16(binary.BigEndian.Uint16(b))
+}
+
+func (d *decoder) int32() int32 {
+	b := d.take(4)
+	if b == nil {
+		return 0
+	}
+
+	return int32(binary.BigEndian.Uint32(b))
+}
+
+func (d *decoder) int64() int64 {
+	b := d.take(8)
+	if b == nil {
+		return 0
+	}
+
+	return int64(binary.BigEndian.Uint64(b))
+}
+
+func (d *decoder) bool() bool {
+	return d.int8() != 0
+}
+
+// string reads the nullable string as well, null is empty
+func (d *decoder) string() string {
+	n := d.int16()
+	if n < 0 {
+		return ""
+	}
+
+	return string(d.take(int(n)))
+}
+
+func (d *decoder) bytes() []byte {
+	n := d.int32()
+	if n < 0 {
+		return nil
+	}
+
+	return d.take(int(n))
+}
+
+// arrayLen reads the array length, null is empty
+func (d *decoder) arrayLen() int {
+	n := d.int32()
+	if n < 0 {
+		return 0
+	}
+	// Every item takes a byte at least
+	if int(n) > d.remaining() && d.err == nil {
+		d.err = errShort
+		return 0
+	}
+
+	return int(n)
+}
+
+func (d *decoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+
+	v, n := binary.Varint(d.b[d.off:])
+	if n <= 0 {
+		d.err = errShort
+		return 0
+	}
+	d.off += n
+
+	return v
+}
+
+func (d *decoder) varbytes() []byte {
+	n := d.varint()
+	if n < 0 {
+		return nil
+	}
+
+	return d.take(int(n))
+}
+
+// requestHeader is the header of the request, v1
+type requestHeader struct {
+	apiKey        int16
+	apiVersion    int16
+	correlationID int32
+	clientID      string
+}
+
+func (h requestHeader) encode(e *encoder) {
+	e.int16(h.apiKey)
+	e.int16(h.apiVersion)
+	e.int32(h.correlationID)
+	e.nullableString(h.clientID)
+}
+
+func decodeRequestHeader(d *decoder) requestHeader {
+	return requestHeader{
+		apiKey:        d.int16(),
+		apiVersion:    d.int16(),
+		correlationID: d.int32(),
+		clientID:      d.string(),
+	}
+}
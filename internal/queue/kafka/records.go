@@ -0,0 +1,233 @@
+package kafka
+
+import (
+	"errors"
+	"hash/crc32"
+	"time"
+)
+
+// Record is the message of the partition log
+type Record struct {
+	// Offset is set by the broker
+	Offset    int64
+	Key       []byte
+	Value     []byte
+	Headers   []Header
+	Timestamp time.Time
+}
+
+type Header struct {
+	Key   string
+	Value []byte
+}
+
+// Header returns the value of the first header with the key
+func (r Record) Header(key string) ([]byte, bool) {
+	for _, h := range r.Headers {
+		if h.Key == key {
+			return h.Value, true
+		}
+	}
+
+	return nil, false
+}
+
+const (
+	batchMagic = 2
+	// The attributes bits of the batch
+	attrCompression = 0x07
+	attrControl     = 0x20
+)
+
+var (
+	castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+	errCRC         = errors.New("kafka: record batch crc mismatch")
+	errMagic       = errors.New("kafka: record batch magic is not 2")
+	errCompression = errors.New("kafka: compressed record batches are not supported")
+)
+
+// encodeBatch writes the records as an uncompressed v2 batch at baseOffset
+func encodeBatch(e *encoder, baseOffset int64, records []Record) {
+	first, max := records[0].Timestamp, records[0].Timestamp
+	for _, r := range records {
+		if r.Timestamp.Before(first) {
+			first = r.Timestamp
+		}
+		if r.Timestamp.After(max) {
+			max = r.Timestamp
+		}
+	}
+
+	start := len(e.b)
+	e.int64(baseOffset)
+	e.int32(0) // batch length, set below
+	e.int32(-1)
+	e.int8(batchMagic)
+	e.int32(0) // crc, set below
+
+	crcFrom := len(e.b)
+	e.int16(0)
+	e.int32(int32(len(records) - 1))
+	e.int64(millis(first))
+	e.int64(millis(max))
+	e.int64(-1) // producer ID
+	e.int16(-1) // producer epoch
+	e.int32(-1) // base sequence
+	e.arrayLen(len(records))
+
+	for i, r := range records {
+		var rec encoder
+		rec.int8(0)
+		rec.varint(millis(r.Timestamp) - millis(first))
+		rec.varint(int64(i))
+		rec.varbytes(r.Key)
+		rec.varbytes(r.Value)
+		rec.varint(int64(len(r.Headers)))
+		for _, h := range r.Headers {
+			rec.varbytes([]byte(h.Key))
+			rec.varbytes(h.Value)
+		}
+
+		e.varint(int64(len(rec.b)))
+		e.b = append(e.b, rec.b...)
+	}
+
+	b := e.b[start:]
+	putInt32(b[8:], int32(len(b)-12))
+	putInt32(b[17:], int32(crc32.Checksum(e.b[crcFrom:], castagnoli)))
+}
+
+// decodeBatches reads the records from the offset on, dropping a last
+// batch cut by the fetch size limit.
+func decodeBatches(b []byte, from int64) ([]Record, error) {
+	var records []Record
+
+	for len(b) >= 12 {
+		d := &decoder{b: b}
+		baseOffset := d.int64()
+		length := int(d.int32())
+		if len(b) < 12+length {
+			break
+		}
+
+		batch := &decoder{b: b[12 : 12+length]}
+		b = b[12+length:]
+
+		batch.int32() // partition leader epoch
+		if batch.int8() != batchMagic {
+			return nil, errMagic
+		}
+		crc := uint32(batch.int32())
+		if batch.err == nil && crc32.Checksum(batch.b[batch.off:], castagnoli) != crc {
+			return nil, errCRC
+		}
+
+		attributes := batch.int16()
+		batch.int32() // last offset delta
+		firstTimestamp := batch.int64()
+		batch.int64()
+		batch.int64()
+		batch.int16()
+		batch.int32()
+		count := batch.arrayLen()
+
+		if attributes&attrCompression != 0 {
+			return nil, errCompression
+		}
+
+		for i := 0; i < count; i++ {
+			rec := &decoder{b: batch.take(int(batch.varint()))}
+			if batch.err != nil {
+				return nil, batch.err
+			}
+
+			rec.int8()
+			timestampDelta := rec.varint()
+			r := Record{
+				Offset:    baseOffset + rec.varint(),
+				Key:       rec.varbytes(),
+				Value:     rec.varbytes(),
+				Timestamp: fromMillis(firstTimestamp + timestampDelta),
+			}
+			for n := rec.varint(); n > 0; n-- {
+				r.Headers = append(r.Headers, Header{Key: string(rec.varbytes()), Value: rec.varbytes()})
+			}
+			if rec.err != nil {
+				return nil, rec.err
+			}
+
+			if attributes&attrControl == 0 && r.Offset >= from {
+				records = append(records, r)
+			}
+		}
+
+		if batch.err != nil {
+			return nil, batch.err
+		}
+	}
+
+	return records, nil
+}
+
+func putInt32(b []byte, v int32) {
+	b[0], b[1], b[2], b[3] = byte(v>>24), byte(v>>16), byte(v>>8), byte(v)
+}
+
+func millis(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+func fromMillis(ms int64) time.Time {
+	return time.Unix(0, ms*int64(time.Millisecond))
+}
+
+// Partition returns the partition of the key as the Java client's default
+// partitioner picks it.
+func Partition(key []byte, partitions int) int32 {
+	return int32(murmur2(key)&0x7fffffff) % int32(partitions)
+}
+
+// murmur2 is the hash of the Java client's default partitioner
+func murmur2(data []byte) uint32 {
+	const (
+		seed = 0x9747b28c
+		m    = 0x5bd1e995
+		r    = 24
+	)
+
+	length := len(data)
+	h := uint32(seed) ^ uint32(length)
+
+	for i := 0; i+4 <= length; i += 4 {
+		k := uint32(data[i]) | uint32(data[i+1])<<8 | uint32(data[i+2])<<16 | uint32(data[i+3])<<24
+		k *= m
+		k ^= k >> r
+		k *= m
+		h *= m
+		h ^= k
+	}
+
+	tail := data[length&^3:]
+	switch len(tail) {
+	case 3:
+		h ^= uint32(tail[2]) << 16
+		fallthrough
+	case 2:
+		h ^= uint32(tail[1]) << 8
+		fallthrough
+	case 1:
+		h ^= uint32(tail[0])
+		h *= m
+	}
+
+	h ^= h >> 13
+	h *= m
+	h ^= h >> 15
+
+	return h
+}
@@ -0,0 +1,520 @@
+package kafka
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Server is an in-memory single Kafka broker for the tests, creating the
+// topics on first use.
+type Server struct {
+	ln         net.Listener
+	partitions int
+
+	mu      sync.Mutex
+	topics  map[string][][]Record
+	offsets map[groupPartition]int64
+	// appended is closed and replaced on every produce to wake the fetches
+	appended chan struct{}
+	conns    map[net.Conn]struct{}
+	closed   chan struct{}
+
+	wg sync.WaitGroup
+}
+
+type groupPartition struct {
+	group     string
+	topic     string
+	partition int32
+}
+
+// NewServer starts the broker on a random local port.
+func NewServer(partitions int) (*Server, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("kafka: starting server: %v", err)
+	}
+
+	s := &Server{
+		ln:         ln,
+		partitions: partitions,
+		topics:     make(map[string][][]Record),
+		offsets:    make(map[groupPartition]int64),
+		appended:   make(chan struct{}),
+		conns:      make(map[net.Conn]struct{}),
+		closed:     make(chan struct{}),
+	}
+
+	s.wg.Add(1)
+	go s.serve()
+
+	return s, nil
+}
+
+func (s *Server) Addr() string {
+	return s.ln.Addr().String()
+}
+
+// Close stops the broker and closes the connections.
+func (s *Server) Close() error {
+	err := s.ln.Close()
+
+	s.mu.Lock()
+	select {
+	case <-s.closed:
+	default:
+		close(s.closed)
+	}
+	for c := range s.conns {
+		c.Close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+
+	return err
+}
+
+// Records returns the records of the partition.
+func (s *Server) Records(topic string, partition int32) []Record {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	log := s.topic(topic)
+	if int(partition) >= len(log) {
+		return nil
+	}
+
+	return append([]Record(nil), log[partition]...)
+}
+
+// Committed returns the group's committed offset, -1 if there is none.
+func (s *Server) Committed(group, topic string, partition int32) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	offset, ok := s.offsets[groupPartition{group, topic, partition}]
+	if !ok {
+		return -1
+	}
+
+	return offset
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+
+	for {
+		c, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+
+		s.mu.Lock()
+		select {
+		case <-s.closed:
+			s.mu.Unlock()
+			c.Close()
+			return
+		default:
+		}
+		s.conns[c] = struct{}{}
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go s.handle(c)
+	}
+}
+
+// handle answers the requests of the connection, closing it on an
+// unknown API or version like the brokers do
+func (s *Server) handle(c net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, c)
+		s.mu.Unlock()
+
+		c.Close()
+	}()
+
+	r := bufio.NewReader(c)
+	w := bufio.NewWriter(c)
+
+	for {
+		frame, err := readFrame(r)
+		if err != nil {
+			return
+		}
+
+		d := &decoder{b: frame}
+		header := decodeRequestHeader(d)
+		if version, ok := apiVersions[header.apiKey]; !ok || version != header.apiVersion {
+			return
+		}
+
+		e := &encoder{b: make([]byte, 4, 64)}
+		e.int32(header.correlationID)
+
+		switch header.apiKey {
+		case apiProduce:
+			s.produce(d, e)
+		case apiFetch:
+			s.fetch(d, e)
+		case apiListOffsets:
+			s.listOffsets(d, e)
+		case apiMetadata:
+			s.metadata(d, e)
+		case apiOffsetCommit:
+			s.offsetCommit(d, e)
+		case apiOffsetFetch:
+			s.offsetFetch(d, e)
+		case apiFindCoordinator:
+			s.findCoordinator(d, e)
+		}
+		if d.err != nil {
+			return
+		}
+
+		putInt32(e.b, int32(len(e.b)-4))
+		if _, err := w.Write(e.b); err != nil {
+			return
+		}
+		if err := w.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// topic returns the partitions of the topic, creating it, the lock is held
+func (s *Server) topic(name string) [][]Record {
+	log, ok := s.topics[name]
+	if !ok {
+		log = make([][]Record, s.partitions)
+		s.topics[name] = log
+	}
+
+	return log
+}
+
+func (s *Server) produce(d *decoder, e *encoder) {
+	d.string() // transactional ID
+	d.int16()
+	d.int32()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	topics := d.arrayLen()
+	e.arrayLen(topics)
+	for ; topics > 0; topics-- {
+		name := d.string()
+		log := s.topic(name)
+
+		e.string(name)
+		partitions := d.arrayLen()
+		e.arrayLen(partitions)
+		for ; partitions > 0; partitions-- {
+			partition := d.int32()
+			set := d.bytes()
+
+			e.int32(partition)
+			if int(partition) >= len(log) || partition < 0 {
+				e.int16(int16(ErrUnknownTopicOrPartition))
+				e.int64(-1)
+				e.int64(-1)
+				continue
+			}
+
+			records, err := decodeBatches(set, 0)
+			if err != nil {
+				e.int16(int16(ErrCorruptMessage))
+				e.int64(-1)
+				e.int64(-1)
+				continue
+			}
+
+			base := int64(len(log[partition]))
+			for i, r := range records {
+				r.Offset = base + int64(i)
+				log[partition] = append(log[partition], r)
+			}
+
+			e.int16(0)
+			e.int64(base)
+			e.int64(-1)
+		}
+	}
+	e.int32(0) // throttle time
+
+	close(s.appended)
+	s.appended = make(chan struct{})
+}
+
+type fetchPartition struct {
+	partition int32
+	offset    int64
+}
+
+type fetchTopic struct {
+	name       string
+	partitions []fetchPartition
+}
+
+func (s *Server) fetch(d *decoder, e *encoder) {
+	d.int32()
+	maxWait := time.Duration(d.int32()) * time.Millisecond
+	d.int32()
+	d.int32()
+	d.int8()
+
+	var topics []fetchTopic
+	for n := d.arrayLen(); n > 0; n-- {
+		t := fetchTopic{name: d.string()}
+		for p := d.arrayLen(); p > 0; p-- {
+			t.partitions = append(t.partitions, fetchPartition{partition: d.int32(), offset: d.int64()})
+			d.int32()
+		}
+		topics = append(topics, t)
+	}
+	if d.err != nil {
+		return
+	}
+
+	// Nothing to return yet, wait for a produce
+	s.mu.Lock()
+	if !s.available(topics) && maxWait > 0 {
+		appended := s.appended
+		s.mu.Unlock()
+
+		timer := time.NewTimer(maxWait)
+		select {
+		case <-appended:
+		case <-timer.C:
+		case <-s.closed:
+		}
+		timer.Stop()
+
+		s.mu.Lock()
+	}
+	defer s.mu.Unlock()
+
+	e.int32(0) // throttle time
+	e.arrayLen(len(topics))
+	for _, t := range topics {
+		log := s.topic(t.name)
+
+		e.string(t.name)
+		e.arrayLen(len(t.partitions))
+		for _, p := range t.partitions {
+			e.int32(p.partition)
+			if int(p.partition) >= len(log) || p.partition < 0 {
+				e.int16(int16(ErrUnknownTopicOrPartition))
+				e.int64(-1)
+				e.int64(-1)
+				e.arrayLen(0)
+				e.bytes(nil)
+				continue
+			}
+
+			records := log[p.partition]
+			end := int64(len(records))
+			if p.offset < 0 || p.offset > end {
+				e.int16(int16(ErrOffsetOutOfRange))
+				e.int64(end)
+				e.int64(end)
+				e.arrayLen(0)
+				e.bytes(nil)
+				continue
+			}
+
+			e.int16(0)
+			e.int64(end)
+			e.int64(end)
+			e.arrayLen(0)
+
+			if p.offset == end {
+				e.bytes([]byte{})
+				continue
+			}
+
+			var set encoder
+			encodeBatch(&set, p.offset, records[p.offset:])
+			e.bytes(set.b)
+		}
+	}
+}
+
+// available reports whether a partition has records from the offset, the
+// lock is held
+func (s *Server) available(topics []fetchTopic) bool {
+	for _, t := range topics {
+		log := s.topic(t.name)
+		for _, p := range t.partitions {
+			if int(p.partition) < len(log) && p.partition >= 0 && p.offset != int64(len(log[p.partition])) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func (s *Server) listOffsets(d *decoder, e *encoder) {
+	d.int32()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	topics := d.arrayLen()
+	e.arrayLen(topics)
+	for ; topics > 0; topics-- {
+		name := d.string()
+		log := s.topic(name)
+
+		e.string(name)
+		partitions := d.arrayLen()
+		e.arrayLen(partitions)
+		for ; partitions > 0; partitions-- {
+			partition := d.int32()
+			at := d.int64()
+
+			e.int32(partition)
+			if int(partition) >= len(log) || partition < 0 {
+				e.int16(int16(ErrUnknownTopicOrPartition))
+				e.int64(-1)
+				e.int64(-1)
+				continue
+			}
+
+			offset := int64(0)
+			if at == OffsetNewest {
+				offset = int64(len(log[partition]))
+			}
+
+			e.int16(0)
+			e.int64(-1)
+			e.int64(offset)
+		}
+	}
+}
+
+func (s *Server) metadata(d *decoder, e *encoder) {
+	var names []string
+	for n := d.arrayLen(); n > 0; n-- {
+		names = append(names, d.string())
+	}
+
+	host, port := s.hostPort()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e.arrayLen(1)
+	e.int32(0)
+	e.string(host)
+	e.int32(port)
+	e.nullableString("")
+	e.int32(0) // controller
+
+	e.arrayLen(len(names))
+	for _, name := range names {
+		log := s.topic(name)
+
+		e.int16(0)
+		e.string(name)
+		e.bool(false)
+		e.arrayLen(len(log))
+		for p := range log {
+			e.int16(0)
+			e.int32(int32(p))
+			e.int32(0) // leader
+			e.arrayLen(1)
+			e.int32(0)
+			e.arrayLen(1)
+			e.int32(0)
+		}
+	}
+}
+
+func (s *Server) offsetCommit(d *decoder, e *encoder) {
+	group := d.string()
+	d.int32()
+	d.string()
+	d.int64()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	topics := d.arrayLen()
+	e.arrayLen(topics)
+	for ; topics > 0; topics-- {
+		name := d.string()
+
+		e.string(name)
+		partitions := d.arrayLen()
+		e.arrayLen(partitions)
+		for ; partitions > 0; partitions-- {
+			partition := d.int32()
+			offset := d.int64()
+			d.string()
+
+			s.offsets[groupPartition{group, name, partition}] = offset
+
+			e.int32(partition)
+			e.int16(0)
+		}
+	}
+}
+
+func (s *Server) offsetFetch(d *decoder, e *encoder) {
+	group := d.string()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	topics := d.arrayLen()
+	e.arrayLen(topics)
+	for ; topics > 0; topics-- {
+		name := d.string()
+
+		e.string(name)
+		partitions := d.arrayLen()
+		e.arrayLen(partitions)
+		for ; partitions > 0; partitions-- {
+			partition := d.int32()
+
+			offset, ok := s.offsets[groupPartition{group, name, partition}]
+			if !ok {
+				offset = -1
+			}
+
+			e.int32(partition)
+			e.int64(offset)
+			e.nullableString("")
+			e.int16(0)
+		}
+	}
+}
+
+func (s *Server) findCoordinator(d *decoder, e *encoder) {
+	d.string()
+
+	host, port := s.hostPort()
+
+	e.int16(0)
+	e.int32(0)
+	e.string(host)
+	e.int32(port)
+}
+
+func (s *Server) hostPort() (string, int32) {
+	host, port, _ := net.SplitHostPort(s.Addr())
+	p, _ := strconv.Atoi(port)
+
+	return host, int32(p)
+}
@@ -0,0 +1,174 @@
+// Package memory is the in-process feed queue, see queue.BackendMemory.
+package memory
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/niklod/highload-social-network/config"
+	"github.com/niklod/highload-social-network/internal/queue"
+)
+
+// QueueSize is the number of messages the broker holds
+const QueueSize = 10000
+
+var ErrFull = errors.New("memory: queue is full")
+
+// DeadLetter is the message parked by the consumer.
+type DeadLetter struct {
+	queue.Message
+	Attempt int
+	Err     string
+}
+
+type entry struct {
+	msg     queue.Message
+	attempt int
+}
+
+// Broker is the queue of a single app replica, losing its messages on
+// restart. It retries like the RabbitMQ subscriber.
+type Broker struct {
+	cfg  *config.RabbitMQConfig
+	msgs chan entry
+
+	mu   sync.Mutex
+	subs map[string]chan struct{}
+	dead []DeadLetter
+}
+
+func NewBroker(cfg *config.RabbitMQConfig) *Broker {
+	return &Broker{
+		cfg:  cfg,
+		msgs: make(chan entry, QueueSize),
+		subs: make(map[string]chan struct{}),
+	}
+}
+
+// Publish queues the messages unless the queue is full.
+func (b *Broker) Publish(msgs ...queue.Message) ([]queue.Message, error) {
+	for i, m := range msgs {
+		if err := b.push(entry{msg: m, attempt: 1}); err != nil {
+			return msgs[:i], err
+		}
+	}
+
+	return msgs, nil
+}
+
+func (b *Broker) push(e entry) error {
+	select {
+	case b.msgs <- e:
+		return nil
+	default:
+		return ErrFull
+	}
+}
+
+// Subscribe delivers the messages to the consumer one at a time.
+func (b *Broker) Subscribe(consumer string) (<-chan queue.Delivery, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.subs[consumer]; ok {
+		return nil, fmt.Errorf("memory.Broker - consumer %q exists", consumer)
+	}
+
+	stop := make(chan struct{})
+	b.subs[consumer] = stop
+
+	out := make(chan queue.Delivery)
+	go b.deliver(out, stop)
+
+	return out, nil
+}
+
+func (b *Broker) deliver(out chan queue.Delivery, stop chan struct{}) {
+	defer close(out)
+
+	for {
+		select {
+		case e := <-b.msgs:
+			d := queue.Delivery{
+				Message:      e.msg,
+				Attempt:      e.attempt,
+				Acknowledger: &delivery{b: b, e: e},
+			}
+
+			select {
+			case out <- d:
+			case <-stop:
+				b.push(e)
+				return
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+func (b *Broker) Unsubscribe(consumer string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	stop, ok := b.subs[consumer]
+	if !ok {
+		return fmt.Errorf("memory.Broker - unknown consumer %q", consumer)
+	}
+
+	delete(b.subs, consumer)
+	close(stop)
+
+	return nil
+}
+
+// DeadLetters returns the parked messages.
+func (b *Broker) DeadLetters() []DeadLetter {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return append([]DeadLetter(nil), b.dead...)
+}
+
+func (b *Broker) park(e entry, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.dead = append(b.dead, DeadLetter{Message: e.msg, Attempt: e.attempt, Err: err.Error()})
+}
+
+type delivery struct {
+	b *Broker
+	e entry
+}
+
+func (d *delivery) Ack() error {
+	return nil
+}
+
+func (d *delivery) Requeue() error {
+	return d.b.push(d.e)
+}
+
+func (d *delivery) Retry(err error) error {
+	if d.e.attempt > d.b.cfg.MaxRetries {
+		return d.Park(err)
+	}
+
+	next := entry{msg: d.e.msg, attempt: d.e.attempt + 1}
+	time.AfterFunc(d.b.cfg.RetryDelay<<uint(d.e.attempt-1), func() {
+		if err := d.b.push(next); err != nil {
+			d.b.park(next, err)
+		}
+	})
+
+	return nil
+}
+
+func (d *delivery) Park(err error) error {
+	d.b.park(d.e, err)
+
+	return nil
+}
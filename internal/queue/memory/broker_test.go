@@ -0,0 +1,99 @@
+package memory
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/niklod/highload-social-network/config"
+	"github.com/niklod/highload-social-network/internal/queue"
+	"github.com/niklod/highload-social-network/internal/queue/queuetest"
+)
+
+func TestBroker_PublishSubscribe(t *testing.T) {
+	b := NewBroker(&config.RabbitMQConfig{})
+
+	confirmed, err := b.Publish(queue.Message{ID: "1", Type: "post.created"}, queue.Message{ID: "2"})
+	assert.Nil(t, err)
+	assert.Len(t, confirmed, 2)
+
+	msgs, err := b.Subscribe("feed-receiver-1")
+	assert.Nil(t, err)
+
+	_, err = b.Subscribe("feed-receiver-1")
+	assert.NotNil(t, err)
+
+	d := queuetest.Receive(t, msgs)
+	assert.Equal(t, queue.Message{ID: "1", Type: "post.created"}, d.Message)
+	assert.Equal(t, 1, d.Attempt)
+	assert.Nil(t, d.Ack())
+
+	assert.Equal(t, "2", queuetest.Receive(t, msgs).ID)
+}
+
+func TestBroker_Retry(t *testing.T) {
+	b := NewBroker(&config.RabbitMQConfig{MaxRetries: 2, RetryDelay: time.Millisecond})
+
+	b.Publish(queue.Message{ID: "1"})
+	msgs, _ := b.Subscribe("feed-receiver-1")
+
+	// Two retries and the parking
+	for attempt := 1; attempt <= 3; attempt++ {
+		d := queuetest.Receive(t, msgs)
+		assert.Equal(t, attempt, d.Attempt)
+		assert.Nil(t, d.Retry(fmt.Errorf("db is down")))
+	}
+
+	select {
+	case d := <-msgs:
+		t.Fatalf("parked message %s delivered", d.ID)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	assert.Equal(t, []DeadLetter{{Message: queue.Message{ID: "1"}, Attempt: 3, Err: "db is down"}}, b.DeadLetters())
+}
+
+func TestBroker_Park(t *testing.T) {
+	b := NewBroker(&config.RabbitMQConfig{MaxRetries: 5})
+
+	b.Publish(queue.Message{ID: "1"})
+	msgs, _ := b.Subscribe("feed-receiver-1")
+
+	assert.Nil(t, queuetest.Receive(t, msgs).Park(fmt.Errorf("malformed")))
+	assert.Len(t, b.DeadLetters(), 1)
+}
+
+func TestBroker_Unsubscribe(t *testing.T) {
+	b := NewBroker(&config.RabbitMQConfig{})
+
+	msgs, _ := b.Subscribe("feed-receiver-1")
+	b.Publish(queue.Message{ID: "1"})
+
+	// The message held for the consumer goes back to the queue
+	time.Sleep(10 * time.Millisecond)
+	assert.Nil(t, b.Unsubscribe("feed-receiver-1"))
+
+	for range msgs {
+	}
+	assert.NotNil(t, b.Unsubscribe("feed-receiver-1"))
+
+	other, _ := b.Subscribe("feed-receiver-2")
+	d := queuetest.Receive(t, other)
+	assert.Equal(t, "1", d.ID)
+
+	// Requeued messages keep the attempt
+	assert.Nil(t, d.Requeue())
+	assert.Equal(t, 1, queuetest.Receive(t, other).Attempt)
+}
+
+func TestBroker_Full(t *testing.T) {
+	b := NewBroker(&config.RabbitMQConfig{})
+
+	msgs := make([]queue.Message, QueueSize+1)
+	confirmed, err := b.Publish(msgs...)
+
+	assert.Equal(t, ErrFull, err)
+	assert.Len(t, confirmed, QueueSize)
+}
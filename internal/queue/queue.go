@@ -0,0 +1,54 @@
+// Package queue defines the broker of the feed messages, implemented by
+// the feed and memory packages.
+package queue
+
+// Broker backends, see config.FeedConfig.QueueBackend
+const (
+	BackendRabbitMQ = "rabbitmq"
+	BackendMemory   = "memory"
+	BackendKafka    = "kafka"
+)
+
+// Message is the message of the feed queue. The optional ID is used to
+// skip duplicates, the optional Key to keep order in partitioned backends.
+type Message struct {
+	ID   string
+	Type string
+	Key  string
+	Body []byte
+}
+
+// Publisher publishes the messages to the feed queue.
+type Publisher interface {
+	// Publish returns the messages confirmed by the broker, the rest
+	// should be published again.
+	Publish(msgs ...Message) ([]Message, error)
+}
+
+// Subscriber delivers each message of the feed queue to one consumer.
+type Subscriber interface {
+	// Subscribe delivers the messages until the consumer unsubscribes
+	Subscribe(consumer string) (<-chan Delivery, error)
+	// Unsubscribe stops the delivery and closes the deliveries
+	Unsubscribe(consumer string) error
+}
+
+// Delivery is a received message, settled with the Acknowledger.
+type Delivery struct {
+	Message
+	// Attempt counts the deliveries of the message from one
+	Attempt int
+	Acknowledger
+}
+
+// Acknowledger settles the delivery.
+type Acknowledger interface {
+	// Ack removes the processed message from the queue
+	Ack() error
+	// Requeue returns the message not processed to the queue
+	Requeue() error
+	// Retry redelivers the failed message after the back-off delay
+	Retry(err error) error
+	// Park moves the poison message to the dead letters
+	Park(err error) error
+}
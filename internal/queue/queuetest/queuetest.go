@@ -0,0 +1,42 @@
+// Package queuetest has the polling helpers of the broker tests.
+package queuetest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/niklod/highload-social-network/internal/queue"
+)
+
+// Timeout bounds the waits of the helpers
+const Timeout = time.Second
+
+// WaitFor polls the condition until it's met, failing the test after
+// Timeout.
+func WaitFor(t testing.TB, cond func() bool) {
+	t.Helper()
+
+	for start := time.Now(); !cond(); time.Sleep(time.Millisecond) {
+		if time.Since(start) > Timeout {
+			t.Fatal("condition not met in time")
+		}
+	}
+}
+
+// Receive returns the next delivery, failing the test if there's none in
+// Timeout.
+func Receive(t testing.TB, msgs <-chan queue.Delivery) queue.Delivery {
+	t.Helper()
+
+	select {
+	case d, ok := <-msgs:
+		if !ok {
+			t.Fatal("deliveries closed")
+		}
+		return d
+	case <-time.After(Timeout):
+		t.Fatal("no delivery")
+	}
+
+	return queue.Delivery{}
+}
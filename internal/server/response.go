@@ -0,0 +1,42 @@
+package server
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+// Error codes returned in the "error.code" field of API responses.
+const (
+	ErrCodeBadRequest   = "bad_request"
+	ErrCodeValidation   = "validation_failed"
+	ErrCodeUnauthorized = "unauthorized"
+	ErrCodeForbidden    = "forbidden"
+	ErrCodeNotFound     = "not_found"
+	ErrCodeConflict     = "conflict"
+	ErrCodeInternal     = "internal_error"
+)
+
+// Envelope is the body of every JSON API response: either Data or Error is set.
+type Envelope struct {
+	Data  interface{}  `json:"data,omitempty"`
+	Error *ErrorDetail `json:"error,omitempty"`
+}
+
+type ErrorDetail struct {
+	Code    string   `json:"code"`
+	Message string   `json:"message"`
+	Details []string `json:"details,omitempty"`
+}
+
+func RespondData(c *gin.Context, status int, data interface{}) {
+	c.JSON(status, Envelope{Data: data})
+}
+
+func RespondError(c *gin.Context, status int, code, message string, details ...string) {
+	c.AbortWithStatusJSON(status, Envelope{
+		Error: &ErrorDetail{
+			Code:    code,
+			Message: message,
+			Details: details,
+		},
+	})
+}
@@ -0,0 +1,186 @@
+package session
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/niklod/highload-social-network/config"
+)
+
+const (
+	StoreMemory = "memory"
+	StoreMySQL  = "mysql"
+
+	// touchInterval limits how often LastSeenAt is written back to the store
+	touchInterval = time.Minute
+)
+
+var (
+	ErrSessionNotFound = fmt.Errorf("session not found")
+	ErrSessionExpired  = fmt.Errorf("session expired")
+)
+
+type Store interface {
+	Create(s *Session) error
+	Get(id string) (*Session, error)
+	Touch(id string, at time.Time) error
+	Delete(id string) error
+	DeleteByUser(userId int) error
+	ListByUser(userId int) ([]Session, error)
+	DeleteExpired(now, idleSince time.Time) (int, error)
+}
+
+type Manager struct {
+	store           Store
+	idleTimeout     time.Duration
+	absoluteTimeout time.Duration
+	now             func() time.Time
+}
+
+func NewManager(cfg *config.SessionConfig, store Store) *Manager {
+	return &Manager{
+		store:           store,
+		idleTimeout:     cfg.IdleTimeout,
+		absoluteTimeout: cfg.AbsoluteTimeout,
+		now:             time.Now,
+	}
+}
+
+// Start creates a new session for the user and returns it together with
+// the token which should be handed to the client.
+func (m *Manager) Start(userId int, userAgent, ip string) (*Session, string, error) {
+	token, err := newToken()
+	if err != nil {
+		return nil, "", err
+	}
+
+	now := m.now().UTC()
+
+	s := &Session{
+		ID:         idFromToken(token),
+		UserID:     userId,
+		CreatedAt:  now,
+		LastSeenAt: now,
+		ExpiresAt:  now.Add(m.absoluteTimeout),
+		UserAgent:  truncate(userAgent, 255),
+		IP:         truncate(ip, 45),
+	}
+
+	if err := m.store.Create(s); err != nil {
+		return nil, "", fmt.Errorf("session.Start: %v", err)
+	}
+
+	return s, token, nil
+}
+
+// Resolve returns the live session for the client token, enforcing idle
+// and absolute expiry.
+func (m *Manager) Resolve(token string) (*Session, error) {
+	id := idFromToken(token)
+
+	s, err := m.store.Get(id)
+	if err != nil {
+		return nil, fmt.Errorf("session.Resolve: %v", err)
+	}
+	if s == nil {
+		return nil, ErrSessionNotFound
+	}
+
+	now := m.now().UTC()
+
+	if !now.Before(s.ExpiresAt) || !now.Before(s.LastSeenAt.Add(m.idleTimeout)) {
+		if err := m.store.Delete(id); err != nil {
+			log.Printf("session.Resolve - deleting expired session: %v", err)
+		}
+		return nil, ErrSessionExpired
+	}
+
+	if now.Sub(s.LastSeenAt) >= touchInterval {
+		if err := m.store.Touch(id, now); err != nil {
+			log.Printf("session.Resolve - touching session: %v", err)
+		}
+		s.LastSeenAt = now
+	}
+
+	return s, nil
+}
+
+// End deletes the session of the client token.
+func (m *Manager) End(token string) error {
+	return m.Delete(idFromToken(token))
+}
+
+// Delete removes a session by its ID.
+func (m *Manager) Delete(id string) error {
+	if err := m.store.Delete(id); err != nil {
+		return fmt.Errorf("session.Delete: %v", err)
+	}
+
+	return nil
+}
+
+// Get returns a session by its ID or nil if there is no such session.
+func (m *Manager) Get(id string) (*Session, error) {
+	s, err := m.store.Get(id)
+	if err != nil {
+		return nil, fmt.Errorf("session.Get: %v", err)
+	}
+
+	return s, nil
+}
+
+// EndAll logs the user out everywhere.
+func (m *Manager) EndAll(userId int) error {
+	if err := m.store.DeleteByUser(userId); err != nil {
+		return fmt.Errorf("session.EndAll: %v", err)
+	}
+
+	return nil
+}
+
+// List returns the active sessions of the user.
+func (m *Manager) List(userId int) ([]Session, error) {
+	sessions, err := m.store.ListByUser(userId)
+	if err != nil {
+		return nil, fmt.Errorf("session.List: %v", err)
+	}
+
+	now := m.now().UTC()
+	active := make([]Session, 0, len(sessions))
+
+	for _, s := range sessions {
+		if now.Before(s.ExpiresAt) && now.Before(s.LastSeenAt.Add(m.idleTimeout)) {
+			active = append(active, s)
+		}
+	}
+
+	return active, nil
+}
+
+// RunCleanup periodically removes expired sessions from the store.
+func (m *Manager) RunCleanup(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		now := m.now().UTC()
+
+		n, err := m.store.DeleteExpired(now, now.Add(-m.idleTimeout))
+		if err != nil {
+			log.Printf("session.RunCleanup: %v", err)
+			continue
+		}
+		if n > 0 {
+			log.Printf("session.RunCleanup - removed %d expired sessions", n)
+		}
+	}
+}
+
+func truncate(s string, max int) string {
+	if len(s) > max {
+		return s[:max]
+	}
+
+	return s
+}
@@ -0,0 +1,107 @@
+package session
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/niklod/highload-social-network/config"
+)
+
+var testConfig = &config.SessionConfig{
+	IdleTimeout:     time.Hour,
+	AbsoluteTimeout: 24 * time.Hour,
+}
+
+func TestManager_StartResolve(t *testing.T) {
+	m := NewManager(testConfig, NewMemoryStore())
+
+	s, token, err := m.Start(1, "test-agent", "127.0.0.1")
+	assert.Nil(t, err)
+	assert.NotEqual(t, token, s.ID)
+
+	resolved, err := m.Resolve(token)
+	assert.Nil(t, err)
+	assert.Equal(t, s.ID, resolved.ID)
+	assert.Equal(t, 1, resolved.UserID)
+}
+
+func TestManager_Resolve_UnknownToken(t *testing.T) {
+	m := NewManager(testConfig, NewMemoryStore())
+
+	_, err := m.Resolve("unknown")
+	assert.Equal(t, ErrSessionNotFound, err)
+}
+
+func TestManager_Resolve_IdleExpiry(t *testing.T) {
+	m := NewManager(testConfig, NewMemoryStore())
+
+	_, token, err := m.Start(1, "", "")
+	assert.Nil(t, err)
+
+	m.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+
+	_, err = m.Resolve(token)
+	assert.Equal(t, ErrSessionExpired, err)
+
+	// Expired session is removed from the store
+	m.now = time.Now
+	_, err = m.Resolve(token)
+	assert.Equal(t, ErrSessionNotFound, err)
+}
+
+func TestManager_Resolve_AbsoluteExpiry(t *testing.T) {
+	m := NewManager(testConfig, NewMemoryStore())
+
+	_, token, err := m.Start(1, "", "")
+	assert.Nil(t, err)
+
+	// Keep the session active, but past its absolute lifetime
+	for i := 1; i <= 25; i++ {
+		offset := time.Duration(i) * 59 * time.Minute
+		m.now = func() time.Time { return time.Now().Add(offset) }
+
+		if _, err = m.Resolve(token); err != nil {
+			break
+		}
+	}
+
+	assert.Equal(t, ErrSessionExpired, err)
+}
+
+func TestManager_EndAll(t *testing.T) {
+	m := NewManager(testConfig, NewMemoryStore())
+
+	_, first, err := m.Start(1, "", "")
+	assert.Nil(t, err)
+	_, second, err := m.Start(1, "", "")
+	assert.Nil(t, err)
+	_, other, err := m.Start(2, "", "")
+	assert.Nil(t, err)
+
+	sessions, err := m.List(1)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(sessions))
+
+	assert.Nil(t, m.EndAll(1))
+
+	_, err = m.Resolve(first)
+	assert.Equal(t, ErrSessionNotFound, err)
+	_, err = m.Resolve(second)
+	assert.Equal(t, ErrSessionNotFound, err)
+	_, err = m.Resolve(other)
+	assert.Nil(t, err)
+}
+
+func TestManager_End(t *testing.T) {
+	m := NewManager(testConfig, NewMemoryStore())
+
+	_, token, err := m.Start(1, "", "")
+	assert.Nil(t, err)
+
+	assert.Nil(t, m.End(token))
+
+	_, err = m.Resolve(token)
+	assert.Equal(t, ErrSessionNotFound, err)
+}
@@ -0,0 +1,111 @@
+package session
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+type memory struct {
+	mu       sync.RWMutex
+	sessions map[string]Session
+}
+
+func NewMemoryStore() Store {
+	return &memory{
+		sessions: make(map[string]Session),
+	}
+}
+
+func (m *memory) Create(s *Session) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sessions[s.ID] = *s
+
+	return nil
+}
+
+func (m *memory) Get(id string) (*Session, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	s, ok := m.sessions[id]
+	if !ok {
+		return nil, nil
+	}
+
+	return &s, nil
+}
+
+func (m *memory) Touch(id string, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.sessions[id]
+	if !ok {
+		return nil
+	}
+
+	s.LastSeenAt = at
+	m.sessions[id] = s
+
+	return nil
+}
+
+func (m *memory) Delete(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.sessions, id)
+
+	return nil
+}
+
+func (m *memory) DeleteByUser(userId int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, s := range m.sessions {
+		if s.UserID == userId {
+			delete(m.sessions, id)
+		}
+	}
+
+	return nil
+}
+
+func (m *memory) ListByUser(userId int) ([]Session, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	sessions := []Session{}
+
+	for _, s := range m.sessions {
+		if s.UserID == userId {
+			sessions = append(sessions, s)
+		}
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastSeenAt.After(sessions[j].LastSeenAt)
+	})
+
+	return sessions, nil
+}
+
+func (m *memory) DeleteExpired(now, idleSince time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	deleted := 0
+
+	for id, s := range m.sessions {
+		if !now.Before(s.ExpiresAt) || s.LastSeenAt.Before(idleSince) {
+			delete(m.sessions, id)
+			deleted++
+		}
+	}
+
+	return deleted, nil
+}
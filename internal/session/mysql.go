@@ -0,0 +1,161 @@
+package session
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+)
+
+type mysql struct {
+	db *sql.DB
+}
+
+func NewRepository(db *sql.DB) Store {
+	return &mysql{db: db}
+}
+
+func (m *mysql) Create(s *Session) error {
+	query := queryMap[createSession]
+	ctx, cancel := context.WithTimeout(context.Background(), query.Timeout)
+	defer cancel()
+
+	_, err := m.db.ExecContext(ctx, query.SQL,
+		s.ID,
+		s.UserID,
+		s.CreatedAt,
+		s.LastSeenAt,
+		s.ExpiresAt,
+		s.UserAgent,
+		s.IP,
+	)
+	if err != nil {
+		return fmt.Errorf("creating session: %v", err)
+	}
+
+	return nil
+}
+
+func (m *mysql) Get(id string) (*Session, error) {
+	query := queryMap[getSession]
+	ctx, cancel := context.WithTimeout(context.Background(), query.Timeout)
+	defer cancel()
+
+	var s Session
+
+	err := m.db.QueryRowContext(ctx, query.SQL, id).Scan(
+		&s.ID,
+		&s.UserID,
+		&s.CreatedAt,
+		&s.LastSeenAt,
+		&s.ExpiresAt,
+		&s.UserAgent,
+		&s.IP,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("getting session: %v", err)
+	}
+
+	return &s, nil
+}
+
+func (m *mysql) Touch(id string, at time.Time) error {
+	query := queryMap[touchSession]
+	ctx, cancel := context.WithTimeout(context.Background(), query.Timeout)
+	defer cancel()
+
+	_, err := m.db.ExecContext(ctx, query.SQL, at, id)
+	if err != nil {
+		return fmt.Errorf("touching session: %v", err)
+	}
+
+	return nil
+}
+
+func (m *mysql) Delete(id string) error {
+	query := queryMap[deleteSession]
+	ctx, cancel := context.WithTimeout(context.Background(), query.Timeout)
+	defer cancel()
+
+	_, err := m.db.ExecContext(ctx, query.SQL, id)
+	if err != nil {
+		return fmt.Errorf("deleting session: %v", err)
+	}
+
+	return nil
+}
+
+func (m *mysql) DeleteByUser(userId int) error {
+	query := queryMap[deleteUserSessions]
+	ctx, cancel := context.WithTimeout(context.Background(), query.Timeout)
+	defer cancel()
+
+	_, err := m.db.ExecContext(ctx, query.SQL, userId)
+	if err != nil {
+		return fmt.Errorf("deleting sessions of user %d: %v", userId, err)
+	}
+
+	return nil
+}
+
+func (m *mysql) ListByUser(userId int) ([]Session, error) {
+	query := queryMap[listUserSessions]
+	ctx, cancel := context.WithTimeout(context.Background(), query.Timeout)
+	defer cancel()
+
+	rows, err := m.db.QueryContext(ctx, query.SQL, userId)
+	if err != nil {
+		return nil, fmt.Errorf("listing sessions of user %d: %v", userId, err)
+	}
+	defer rows.Close()
+
+	sessions := []Session{}
+
+	for rows.Next() {
+		var s Session
+
+		err := rows.Scan(
+			&s.ID,
+			&s.UserID,
+			&s.CreatedAt,
+			&s.LastSeenAt,
+			&s.ExpiresAt,
+			&s.UserAgent,
+			&s.IP,
+		)
+		if err != nil {
+			log.Printf("scanning session row: %v", err)
+			continue
+		}
+
+		sessions = append(sessions, s)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("listing sessions: iterating through rows: %v", err)
+	}
+
+	return sessions, nil
+}
+
+func (m *mysql) DeleteExpired(now, idleSince time.Time) (int, error) {
+	query := queryMap[deleteExpiredSessions]
+	ctx, cancel := context.WithTimeout(context.Background(), query.Timeout)
+	defer cancel()
+
+	res, err := m.db.ExecContext(ctx, query.SQL, now, idleSince)
+	if err != nil {
+		return 0, fmt.Errorf("deleting expired sessions: %v", err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("getting affected rows: %v", err)
+	}
+
+	return int(n), nil
+}
@@ -0,0 +1,122 @@
+package session
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+var sessionColumns = []string{"id", "user_id", "created_at", "last_seen_at", "expires_at", "user_agent", "ip"}
+
+func Test_mysql_Create(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	repo := NewRepository(db)
+	now := time.Now()
+	s := &Session{ID: "id", UserID: 1, CreatedAt: now, LastSeenAt: now, ExpiresAt: now, UserAgent: "ua", IP: "ip"}
+
+	mock.ExpectExec("INSERT INTO sessions").
+		WithArgs(s.ID, s.UserID, s.CreatedAt, s.LastSeenAt, s.ExpiresAt, s.UserAgent, s.IP).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	assert.Nil(t, repo.Create(s))
+}
+
+func Test_mysql_Get(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	repo := NewRepository(db)
+	now := time.Now()
+
+	rows := sqlmock.NewRows(sessionColumns).AddRow("id", 1, now, now, now, "ua", "ip")
+	mock.ExpectQuery("SELECT id").WithArgs("id").WillReturnRows(rows)
+
+	s, err := repo.Get("id")
+
+	assert.Nil(t, err)
+	assert.Equal(t, 1, s.UserID)
+}
+
+func Test_mysql_Get_NoRows(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	repo := NewRepository(db)
+
+	mock.ExpectQuery("SELECT id").WithArgs("id").WillReturnRows(sqlmock.NewRows(sessionColumns))
+
+	s, err := repo.Get("id")
+
+	assert.Nil(t, err)
+	assert.Nil(t, s)
+}
+
+func Test_mysql_ListByUser(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	repo := NewRepository(db)
+	now := time.Now()
+
+	rows := sqlmock.NewRows(sessionColumns).
+		AddRow("first", 1, now, now, now, "ua", "ip").
+		AddRow("second", 1, now, now, now, "ua", "ip")
+	mock.ExpectQuery("SELECT id").WithArgs(1).WillReturnRows(rows)
+
+	sessions, err := repo.ListByUser(1)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(sessions))
+}
+
+func Test_mysql_DeleteByUser_Error(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	repo := NewRepository(db)
+	testErr := fmt.Errorf("test error")
+
+	mock.ExpectExec("DELETE FROM sessions").WithArgs(1).WillReturnError(testErr)
+
+	err = repo.DeleteByUser(1)
+
+	assert.Contains(t, err.Error(), testErr.Error())
+}
+
+func Test_mysql_DeleteExpired(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	repo := NewRepository(db)
+	now := time.Now()
+
+	mock.ExpectExec("DELETE FROM sessions").WithArgs(now, now).WillReturnResult(sqlmock.NewResult(0, 4))
+
+	n, err := repo.DeleteExpired(now, now)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 4, n)
+}
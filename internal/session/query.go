@@ -0,0 +1,77 @@
+package session
+
+import "time"
+
+const (
+	createSession = iota
+	getSession
+	touchSession
+	deleteSession
+	deleteUserSessions
+	listUserSessions
+	deleteExpiredSessions
+)
+
+type Query struct {
+	SQL     string
+	Timeout time.Duration
+}
+
+var queryMap map[int]Query
+
+func init() {
+	queryMap = make(map[int]Query)
+
+	queryMap[createSession] = Query{
+		SQL: `INSERT INTO sessions (id, user_id, created_at, last_seen_at, expires_at, user_agent, ip)
+				VALUES (?, ?, ?, ?, ?, ?, ?);`,
+		Timeout: 5 * time.Second,
+	}
+
+	queryMap[getSession] = Query{
+		SQL: `SELECT id
+				, user_id
+				, created_at
+				, last_seen_at
+				, expires_at
+				, user_agent
+				, ip
+			FROM sessions
+			WHERE id = ?`,
+		Timeout: 2 * time.Second,
+	}
+
+	queryMap[touchSession] = Query{
+		SQL:     `UPDATE sessions SET last_seen_at = ? WHERE id = ?`,
+		Timeout: 2 * time.Second,
+	}
+
+	queryMap[deleteSession] = Query{
+		SQL:     `DELETE FROM sessions WHERE id = ?`,
+		Timeout: 5 * time.Second,
+	}
+
+	queryMap[deleteUserSessions] = Query{
+		SQL:     `DELETE FROM sessions WHERE user_id = ?`,
+		Timeout: 10 * time.Second,
+	}
+
+	queryMap[listUserSessions] = Query{
+		SQL: `SELECT id
+				, user_id
+				, created_at
+				, last_seen_at
+				, expires_at
+				, user_agent
+				, ip
+			FROM sessions
+			WHERE user_id = ?
+			ORDER BY last_seen_at desc`,
+		Timeout: 5 * time.Second,
+	}
+
+	queryMap[deleteExpiredSessions] = Query{
+		SQL:     `DELETE FROM sessions WHERE expires_at <= ? OR last_seen_at < ?`,
+		Timeout: time.Minute,
+	}
+}
@@ -0,0 +1,431 @@
+package user
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/niklod/highload-social-network/internal/server"
+	"github.com/niklod/highload-social-network/internal/user/post"
+)
+
+func (u *UserHandler) HandleAPIRegistrate(c *gin.Context) {
+	req := &UserCreateRequest{}
+	if err := c.ShouldBind(req); err != nil {
+		server.RespondError(c, http.StatusBadRequest, server.ErrCodeBadRequest, "Некорректное тело запроса", err.Error())
+		return
+	}
+
+	if err := req.Validate(); err != nil {
+		server.RespondError(c, http.StatusUnprocessableEntity, server.ErrCodeValidation, "Ошибка валидации", validationDetails(err)...)
+		return
+	}
+
+	user, err := u.userService.Create(c.Request.Context(), req.ConverIntoUser())
+	if err != nil {
+		if errors.Is(err, ErrUserAlreadyExist) {
+			server.RespondError(c, http.StatusConflict, server.ErrCodeConflict, "Пользователь с таким логином уже существует")
+			return
+		}
+
+		log.Printf("api registrate, creating user: %v", err)
+		server.RespondError(c, http.StatusInternalServerError, server.ErrCodeInternal, "Внутренняя ошибка сервера")
+		return
+	}
+
+	server.RespondData(c, http.StatusCreated, NewUserResponse(user))
+}
+
+func (u *UserHandler) HandleAPILogin(c *gin.Context) {
+	req := &UserLoginRequest{}
+	if err := c.ShouldBind(req); err != nil {
+		server.RespondError(c, http.StatusBadRequest, server.ErrCodeBadRequest, "Некорректное тело запроса", err.Error())
+		return
+	}
+
+	if err := req.Validate(); err != nil {
+		server.RespondError(c, http.StatusUnprocessableEntity, server.ErrCodeValidation, "Ошибка валидации", validationDetails(err)...)
+		return
+	}
+
+	user, err := u.userService.GetUserByLogin(c.Request.Context(), req.Login)
+	if err != nil {
+		log.Printf("api login, getting user: %v", err)
+		server.RespondError(c, http.StatusInternalServerError, server.ErrCodeInternal, "Внутренняя ошибка сервера")
+		return
+	}
+
+	if user == nil || !u.userService.CheckPasswordsEquality(req.Password, user.Password) {
+		server.RespondError(c, http.StatusUnauthorized, server.ErrCodeUnauthorized, "Указан неверный логин или пароль")
+		return
+	}
+
+	if err := u.startSession(c, user); err != nil {
+		log.Printf("api login: %v", err)
+		server.RespondError(c, http.StatusInternalServerError, server.ErrCodeInternal, "Внутренняя ошибка сервера")
+		return
+	}
+
+	server.RespondData(c, http.StatusOK, NewUserResponse(user))
+}
+
+func (u *UserHandler) HandleAPILogout(c *gin.Context) {
+	if err := u.endSession(c); err != nil {
+		log.Printf("api logout: %v", err)
+		server.RespondError(c, http.StatusInternalServerError, server.ErrCodeInternal, "Внутренняя ошибка сервера")
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (u *UserHandler) HandleAPIMe(c *gin.Context) {
+	authUser, ok := requireAPIUser(c)
+	if !ok {
+		return
+	}
+
+	server.RespondData(c, http.StatusOK, NewUserResponse(authUser))
+}
+
+func (u *UserHandler) HandleAPIUsersList(c *gin.Context) {
+	req := UserSearchRequest{}
+	if err := c.ShouldBindQuery(&req); err != nil {
+		server.RespondError(c, http.StatusBadRequest, server.ErrCodeBadRequest, "Некорректные параметры запроса", err.Error())
+		return
+	}
+
+	users, err := u.userService.UsersByFirstAndLastName(c.Request.Context(), req.FirstName, req.LastName)
+	if err != nil {
+		log.Printf("api users list: %v", err)
+		server.RespondError(c, http.StatusInternalServerError, server.ErrCodeInternal, "Внутренняя ошибка сервера")
+		return
+	}
+
+	server.RespondData(c, http.StatusOK, NewUserListResponse(users))
+}
+
+func (u *UserHandler) HandleAPIUserDetail(c *gin.Context) {
+	user, ok := u.apiUserByLogin(c)
+	if !ok {
+		return
+	}
+
+	interests, err := u.interestService.InterestsByUserId(user.ID)
+	if err != nil {
+		log.Printf("api user detail, getting interests: %v", err)
+		server.RespondError(c, http.StatusInternalServerError, server.ErrCodeInternal, "Внутренняя ошибка сервера")
+		return
+	}
+	user.Interests = interests
+
+	resp := ProfileResponse{UserResponse: NewUserResponse(user)}
+
+	resp.Followers, resp.Following, err = u.userService.FollowCounts(c.Request.Context(), user.ID)
+	if err != nil {
+		log.Printf("api user detail, getting follow counts: %v", err)
+		server.RespondError(c, http.StatusInternalServerError, server.ErrCodeInternal, "Внутренняя ошибка сервера")
+		return
+	}
+
+	if authUser := getUser(c); authUser != nil && authUser.ID != user.ID {
+		resp.ViewerFollows, err = u.userService.IsFollowing(c.Request.Context(), authUser.ID, user.ID)
+		if err != nil {
+			log.Printf("api user detail, checking follow: %v", err)
+			server.RespondError(c, http.StatusInternalServerError, server.ErrCodeInternal, "Внутренняя ошибка сервера")
+			return
+		}
+	}
+
+	server.RespondData(c, http.StatusOK, resp)
+}
+
+func (u *UserHandler) HandleAPIUserFriends(c *gin.Context) {
+	user, ok := u.apiUserByLogin(c)
+	if !ok {
+		return
+	}
+
+	friends, err := u.userService.Friends(c.Request.Context(), user.ID)
+	if err != nil {
+		log.Printf("api user friends: %v", err)
+		server.RespondError(c, http.StatusInternalServerError, server.ErrCodeInternal, "Внутренняя ошибка сервера")
+		return
+	}
+
+	server.RespondData(c, http.StatusOK, NewUserListResponse(friends))
+}
+
+// HandleAPIAddFriend sends a friend request to the user, see
+// HandleAPIFriendRequests.
+func (u *UserHandler) HandleAPIAddFriend(c *gin.Context) {
+	authUser, ok := requireAPIUser(c)
+	if !ok {
+		return
+	}
+
+	user, ok := u.apiUserByLogin(c)
+	if !ok {
+		return
+	}
+
+	r, err := u.userService.SendFriendRequest(c.Request.Context(), authUser, user)
+	if err != nil {
+		respondFriendRequestError(c, "api send friend request", err)
+		return
+	}
+
+	// The request of the other user is accepted right away
+	if r.Status == FriendRequestAccepted {
+		server.RespondData(c, http.StatusCreated, NewFriendRequestResponse(r))
+		return
+	}
+
+	server.RespondData(c, http.StatusAccepted, NewFriendRequestResponse(r))
+}
+
+func (u *UserHandler) HandleAPIDeleteFriend(c *gin.Context) {
+	authUser, ok := requireAPIUser(c)
+	if !ok {
+		return
+	}
+
+	user, ok := u.apiUserByLogin(c)
+	if !ok {
+		return
+	}
+
+	if err := u.userService.DeleteFriend(c.Request.Context(), authUser.ID, user.ID); err != nil {
+		log.Printf("api delete friend: %v", err)
+		server.RespondError(c, http.StatusInternalServerError, server.ErrCodeInternal, "Внутренняя ошибка сервера")
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (u *UserHandler) HandleAPIUserPosts(c *gin.Context) {
+	user, ok := u.apiUserByLogin(c)
+	if !ok {
+		return
+	}
+
+	cursor, limit, ok := apiPageRequest(c)
+	if !ok {
+		return
+	}
+
+	page, err := u.postService.PostsByUserId(c.Request.Context(), user.ID, viewerID(getUser(c)), cursor, limit)
+	if err != nil {
+		log.Printf("api user posts: %v", err)
+		server.RespondError(c, http.StatusInternalServerError, server.ErrCodeInternal, "Внутренняя ошибка сервера")
+		return
+	}
+
+	server.RespondData(c, http.StatusOK, post.NewPostPageResponse(page))
+}
+
+func (u *UserHandler) HandleAPIAddPost(c *gin.Context) {
+	authUser, ok := requireAPIUser(c)
+	if !ok {
+		return
+	}
+
+	req := &post.PostCreateRequest{}
+	if err := c.ShouldBind(req); err != nil {
+		server.RespondError(c, http.StatusBadRequest, server.ErrCodeBadRequest, "Некорректное тело запроса", err.Error())
+		return
+	}
+
+	if err := req.Validate(); err != nil {
+		server.RespondError(c, http.StatusUnprocessableEntity, server.ErrCodeValidation, "Ошибка валидации", validationDetails(err)...)
+		return
+	}
+
+	p := &post.Post{
+		Body: req.Body,
+		Author: post.Author{
+			ID:        authUser.ID,
+			FirstName: authUser.FirstName,
+			LastName:  authUser.Lastname,
+			Login:     authUser.Login,
+		},
+	}
+
+	if err := u.postService.Add(c.Request.Context(), p); err != nil {
+		log.Printf("api add post: %v", err)
+		server.RespondError(c, http.StatusInternalServerError, server.ErrCodeInternal, "Внутренняя ошибка сервера")
+		return
+	}
+
+	server.RespondData(c, http.StatusCreated, post.NewPostResponse(*p))
+}
+
+func (u *UserHandler) HandleAPIUpdatePost(c *gin.Context) {
+	authUser, ok := requireAPIUser(c)
+	if !ok {
+		return
+	}
+
+	postId, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		server.RespondError(c, http.StatusNotFound, server.ErrCodeNotFound, "Пост не найден")
+		return
+	}
+
+	req := &post.PostUpdateRequest{}
+	if err := c.ShouldBind(req); err != nil {
+		server.RespondError(c, http.StatusBadRequest, server.ErrCodeBadRequest, "Некорректное тело запроса", err.Error())
+		return
+	}
+
+	if err := req.Validate(); err != nil {
+		server.RespondError(c, http.StatusUnprocessableEntity, server.ErrCodeValidation, "Ошибка валидации", validationDetails(err)...)
+		return
+	}
+
+	p, err := u.postService.Update(c.Request.Context(), authUser.ID, postId, req.Body)
+	if err != nil {
+		respondPostError(c, "api update post", err)
+		return
+	}
+
+	server.RespondData(c, http.StatusOK, post.NewPostResponse(*p))
+}
+
+func (u *UserHandler) HandleAPIDeletePost(c *gin.Context) {
+	authUser, ok := requireAPIUser(c)
+	if !ok {
+		return
+	}
+
+	postId, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		server.RespondError(c, http.StatusNotFound, server.ErrCodeNotFound, "Пост не найден")
+		return
+	}
+
+	if err := u.postService.Delete(c.Request.Context(), authUser.ID, postId); err != nil {
+		respondPostError(c, "api delete post", err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func respondPostError(c *gin.Context, op string, err error) {
+	switch err {
+	case post.ErrPostNotFound:
+		server.RespondError(c, http.StatusNotFound, server.ErrCodeNotFound, "Пост не найден")
+	case post.ErrNotAuthor:
+		server.RespondError(c, http.StatusForbidden, server.ErrCodeForbidden, "Изменять пост может только автор")
+	default:
+		log.Printf("%s: %v", op, err)
+		server.RespondError(c, http.StatusInternalServerError, server.ErrCodeInternal, "Внутренняя ошибка сервера")
+	}
+}
+
+func (u *UserHandler) HandleAPIFeed(c *gin.Context) {
+	authUser, ok := requireAPIUser(c)
+	if !ok {
+		return
+	}
+
+	cursor, limit, ok := apiPageRequest(c)
+	if !ok {
+		return
+	}
+
+	page, err := u.postService.UserFeed(c.Request.Context(), authUser.ID, cursor, limit)
+	if err != nil {
+		log.Printf("api feed: %v", err)
+		server.RespondError(c, http.StatusInternalServerError, server.ErrCodeInternal, "Внутренняя ошибка сервера")
+		return
+	}
+
+	server.RespondData(c, http.StatusOK, post.NewPostPageResponse(page))
+}
+
+func (u *UserHandler) HandleAPICitys(c *gin.Context) {
+	citys, err := u.cityService.List()
+	if err != nil {
+		log.Printf("api citys list: %v", err)
+		server.RespondError(c, http.StatusInternalServerError, server.ErrCodeInternal, "Внутренняя ошибка сервера")
+		return
+	}
+
+	server.RespondData(c, http.StatusOK, citys)
+}
+
+func (u *UserHandler) HandleAPIInterests(c *gin.Context) {
+	interests, err := u.interestService.Interests()
+	if err != nil {
+		log.Printf("api interests list: %v", err)
+		server.RespondError(c, http.StatusInternalServerError, server.ErrCodeInternal, "Внутренняя ошибка сервера")
+		return
+	}
+
+	server.RespondData(c, http.StatusOK, interests)
+}
+
+// apiUserByLogin loads the user from the :login path parameter and writes
+// an error response when it can't be found.
+func (u *UserHandler) apiUserByLogin(c *gin.Context) (*User, bool) {
+	user, err := u.userService.GetUserByLogin(c.Request.Context(), c.Param("login"))
+	if err != nil {
+		log.Printf("api, getting user by login: %v", err)
+		server.RespondError(c, http.StatusInternalServerError, server.ErrCodeInternal, "Внутренняя ошибка сервера")
+		return nil, false
+	}
+	if user == nil {
+		server.RespondError(c, http.StatusNotFound, server.ErrCodeNotFound, "Пользователь не найден")
+		return nil, false
+	}
+
+	user.Sanitize()
+
+	return user, true
+}
+
+func requireAPIUser(c *gin.Context) (*User, bool) {
+	user := getUser(c)
+	if user == nil {
+		server.RespondError(c, http.StatusUnauthorized, server.ErrCodeUnauthorized, "Требуется авторизация")
+		return nil, false
+	}
+
+	return user, true
+}
+
+// pageRequest reads the cursor and limit query parameters of the post
+// lists. Zero limit means the default page size.
+func pageRequest(c *gin.Context) (*post.Cursor, int, error) {
+	cursor, err := post.ParseCursor(c.Query("cursor"))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	limit := 0
+	if v := c.Query("limit"); v != "" {
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit < 0 {
+			return nil, 0, fmt.Errorf("invalid limit")
+		}
+	}
+
+	return cursor, limit, nil
+}
+
+func apiPageRequest(c *gin.Context) (*post.Cursor, int, bool) {
+	cursor, limit, err := pageRequest(c)
+	if err != nil {
+		server.RespondError(c, http.StatusBadRequest, server.ErrCodeBadRequest, "Некорректные параметры страницы", err.Error())
+		return nil, 0, false
+	}
+
+	return cursor, limit, true
+}
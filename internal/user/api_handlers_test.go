@@ -0,0 +1,178 @@
+package user
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/sessions"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/niklod/highload-social-network/config"
+	"github.com/niklod/highload-social-network/internal/auth"
+	"github.com/niklod/highload-social-network/internal/cache"
+	"github.com/niklod/highload-social-network/internal/cluster"
+	"github.com/niklod/highload-social-network/internal/server"
+	"github.com/niklod/highload-social-network/internal/session"
+	"github.com/niklod/highload-social-network/internal/user/city"
+	"github.com/niklod/highload-social-network/internal/user/interest"
+	"github.com/niklod/highload-social-network/internal/user/post"
+)
+
+func newTestAPIRouter(db *sql.DB) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+
+	citySvc := city.NewService(city.NewRepository(db))
+	interestSvc := interest.NewService(interest.NewRepository(db))
+	feeds := post.NewFeedStore(cache.NewFeedCache())
+	userSvc := NewService(NewRepository(cluster.Single(db)), citySvc, interestSvc, nil, feeds)
+	postSvc := post.NewService(post.NewRepository(cluster.Single(db)), feeds, post.NewPostStore(cache.NewFeedCache()), nil)
+
+	tokenManager := auth.NewManager(&config.TokenConfig{
+		SecretKey:  "test",
+		AccessTTL:  time.Minute,
+		RefreshTTL: time.Hour,
+	}, auth.NewRepository(db))
+
+	sessionManager := session.NewManager(&config.SessionConfig{
+		IdleTimeout:     time.Hour,
+		AbsoluteTimeout: 24 * time.Hour,
+	}, session.NewMemoryStore())
+
+	h := NewHandler(userSvc, citySvc, postSvc, sessions.NewCookieStore([]byte("test")), interestSvc, sessionManager, tokenManager)
+
+	engine := gin.New()
+	api := engine.Group("/api/v1")
+	api.Use(h.AuthMiddleware)
+	api.GET("/users", h.HandleAPIUsersList)
+	api.GET("/users/:login", h.HandleAPIUserDetail)
+	api.GET("/feed", h.HandleAPIFeed)
+
+	return engine
+}
+
+func TestUserHandler_HandleAPIUsersList(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := newTestAPIRouter(db)
+
+	rows := sqlmock.NewRows([]string{"id", "first_name", "last_name", "age", "sex", "login", "city_id", "city_name"})
+	rows.AddRow(1, "Ivan", "Ivanov", 20, "Мужчина", "ivanov", 1, "Москва")
+
+	mock.ExpectQuery("SELECT u.id").WithArgs("Iv%", "Iv%").WillReturnRows(rows)
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/users?firstName=Iv&lastName=Iv", nil)
+	router.ServeHTTP(w, req)
+
+	var body struct {
+		Data []UserResponse `json:"data"`
+	}
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, 1, len(body.Data))
+	assert.Equal(t, "ivanov", body.Data[0].Login)
+	assert.Equal(t, "Москва", body.Data[0].City)
+}
+
+func TestUserHandler_HandleAPIUserDetail_NotFound(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := newTestAPIRouter(db)
+
+	rows := sqlmock.NewRows([]string{"id", "first_name", "last_name", "age", "sex", "login", "city_id", "city_name", "password"})
+	mock.ExpectQuery("SELECT u.id").WithArgs("unknown").WillReturnRows(rows)
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/users/unknown", nil)
+	router.ServeHTTP(w, req)
+
+	var body server.Envelope
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.NotNil(t, body.Error)
+	assert.Equal(t, server.ErrCodeNotFound, body.Error.Code)
+}
+
+func TestUserHandler_HandleAPIFeed_Unauthorized(t *testing.T) {
+	db, _, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := newTestAPIRouter(db)
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/feed", nil)
+	router.ServeHTTP(w, req)
+
+	var body server.Envelope
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, server.ErrCodeUnauthorized, body.Error.Code)
+}
+
+func TestUserHandler_AuthMiddleware_InvalidBearerToken(t *testing.T) {
+	db, _, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := newTestAPIRouter(db)
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/feed", nil)
+	req.Header.Set("Authorization", "Bearer not.a.token")
+	router.ServeHTTP(w, req)
+
+	var body server.Envelope
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, server.ErrCodeUnauthorized, body.Error.Code)
+}
+
+func Test_bearerToken_QueryParamOnFeedSocketOnly(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	engine := gin.New()
+	token := func(c *gin.Context) {
+		c.String(http.StatusOK, bearerToken(c))
+	}
+	engine.GET(FeedSocketRoute, token)
+	engine.GET("/api/v1/feed", token)
+
+	tests := []struct {
+		name   string
+		url    string
+		header string
+		want   string
+	}{
+		{"feed socket query param", "/ws/feed/ivanov?access_token=abc", "", "abc"},
+		{"api query param", "/api/v1/feed?access_token=abc", "", ""},
+		{"api header", "/api/v1/feed", "Bearer abc", "abc"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, tt.url, nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			engine.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.want, w.Body.String())
+		})
+	}
+}
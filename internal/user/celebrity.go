@@ -0,0 +1,126 @@
+package user
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/niklod/highload-social-network/internal/user/post"
+)
+
+// Celebrities keeps the IDs of the users with at least threshold friends
+// and followers. Their posts aren't fanned out to the cached feeds but
+// merged in from DB on reading, see post.Service.UserFeed. The cached feeds
+// of the subscribers of a user who leaves the set are dropped, as they lack
+// the user's posts.
+type Celebrities struct {
+	repo      repository
+	feeds     post.FeedStore
+	threshold int
+
+	mu  sync.RWMutex
+	ids []int
+	set map[int]bool
+
+	// left are the users who left the set with their subscribers' feeds
+	// still to drop
+	left []int
+}
+
+// NewCelebrities creates the empty set, threshold <= 0 turns it off.
+func NewCelebrities(repo repository, feeds post.FeedStore, threshold int) *Celebrities {
+	return &Celebrities{
+		repo:      repo,
+		feeds:     feeds,
+		threshold: threshold,
+		set:       make(map[int]bool),
+	}
+}
+
+// Refresh reads the set from DB.
+func (c *Celebrities) Refresh() error {
+	if c.threshold <= 0 {
+		return nil
+	}
+
+	ids, err := c.repo.Celebrities(context.Background(), c.threshold)
+	if err != nil {
+		return fmt.Errorf("user.Celebrities: %v", err)
+	}
+
+	set := make(map[int]bool, len(ids))
+	for _, id := range ids {
+		set[id] = true
+	}
+
+	// The set is changed first, so the feeds read again have the posts
+	c.mu.Lock()
+	for _, id := range c.ids {
+		if !set[id] {
+			c.left = append(c.left, id)
+		}
+	}
+	c.ids = ids
+	c.set = set
+	left := c.left
+	c.left = nil
+	c.mu.Unlock()
+
+	return c.dropFeeds(left)
+}
+
+// dropFeeds drops the cached feeds of the users' subscribers, the failed
+// ones are retried on the next refresh.
+func (c *Celebrities) dropFeeds(userIds []int) error {
+	for i, id := range userIds {
+		subscribers, err := c.repo.FeedSubscribers(context.Background(), id)
+		if err != nil {
+			c.mu.Lock()
+			c.left = append(c.left, userIds[i:]...)
+			c.mu.Unlock()
+
+			return fmt.Errorf("user.Celebrities: %v", err)
+		}
+
+		for _, u := range subscribers {
+			c.feeds.Invalidate(u.ID)
+		}
+	}
+
+	return nil
+}
+
+// Run refreshes the set every interval. It blocks, so run it in a
+// goroutine.
+func (c *Celebrities) Run(interval time.Duration) {
+	if c.threshold <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := c.Refresh(); err != nil {
+			log.Printf("refreshing celebrities: %v", err)
+		}
+		<-ticker.C
+	}
+}
+
+func (c *Celebrities) IsCelebrity(userId int) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.set[userId]
+}
+
+// Celebrities returns the IDs of the celebrities, read only.
+func (c *Celebrities) Celebrities() []int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.ids
+}
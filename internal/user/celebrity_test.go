@@ -0,0 +1,70 @@
+package user
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/niklod/highload-social-network/internal/cache"
+	"github.com/niklod/highload-social-network/internal/cluster"
+	"github.com/niklod/highload-social-network/internal/user/post"
+)
+
+func TestCelebrities_Refresh(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	celebrities := NewCelebrities(NewRepository(cluster.Single(db)), nil, 1000)
+
+	mock.ExpectQuery("SELECT s.user_id").WithArgs(1000).WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(7).AddRow(9))
+
+	assert.False(t, celebrities.IsCelebrity(7))
+	assert.Nil(t, celebrities.Refresh())
+	assert.True(t, celebrities.IsCelebrity(7))
+	assert.False(t, celebrities.IsCelebrity(8))
+	assert.Equal(t, []int{7, 9}, celebrities.Celebrities())
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestCelebrities_Disabled(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	celebrities := NewCelebrities(NewRepository(cluster.Single(db)), nil, 0)
+
+	assert.Nil(t, celebrities.Refresh())
+	assert.Empty(t, celebrities.Celebrities())
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestCelebrities_Refresh_DropsFeedsOfLeft(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	feeds := post.NewFeedStore(cache.NewFeedCache())
+	celebrities := NewCelebrities(NewRepository(cluster.Single(db)), feeds, 1000)
+
+	feeds.Set(3, post.Refs{{ID: 1, AuthorID: 2}})
+	feeds.Set(4, post.Refs{{ID: 1, AuthorID: 2}})
+
+	mock.ExpectQuery("SELECT s.user_id").WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(7).AddRow(9))
+	assert.Nil(t, celebrities.Refresh())
+
+	// The feeds of 7's subscribers were cached without the posts of 7
+	mock.ExpectQuery("SELECT s.user_id").WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(9))
+	mock.ExpectQuery("SELECT u.id").WithArgs(7, 7).WillReturnRows(
+		sqlmock.NewRows([]string{"id", "first_name", "last_name", "age", "sex", "login", "city_id", "city_name"}).
+			AddRow(3, "", "", 0, "", "login3", nil, nil))
+	assert.Nil(t, celebrities.Refresh())
+
+	_, cached := feeds.Size(3)
+	assert.False(t, cached)
+	_, cached = feeds.Size(4)
+	assert.True(t, cached)
+	assert.False(t, celebrities.IsCelebrity(7))
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
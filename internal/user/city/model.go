@@ -1,7 +1,7 @@
 package city
 
 type City struct {
-	ID            int    `db:"id"`
-	Name          string `db:"city_name"`
-	CreatedByUser bool   `db:"created_by_user"`
+	ID            int    `db:"id" json:"id"`
+	Name          string `db:"city_name" json:"name"`
+	CreatedByUser bool   `db:"created_by_user" json:"createdByUser"`
 }
@@ -2,6 +2,7 @@ package user
 
 import (
 	"strings"
+	"time"
 
 	"github.com/go-playground/validator/v10"
 
@@ -10,14 +11,14 @@ import (
 )
 
 type UserCreateRequest struct {
-	Login     string `form:"inputLogin" validate:"required,min=5,max=20"`
-	Password  string `form:"inputPassword" validate:"required,min=6,max=40"`
-	FirstName string `form:"inputName" validate:"required,max=50"`
-	LastName  string `form:"inputLastName" validate:"required,max=50"`
-	Age       int    `form:"inputAge" validate:"gte=0,lte=120"`
-	Sex       string `form:"inputSex" validate:""`
-	City      string `form:"inputCity" validate:""`
-	Interests string `form:"inputInterests" validate:""`
+	Login     string `form:"inputLogin" json:"login" validate:"required,min=5,max=20"`
+	Password  string `form:"inputPassword" json:"password" validate:"required,min=6,max=40"`
+	FirstName string `form:"inputName" json:"firstName" validate:"required,max=50"`
+	LastName  string `form:"inputLastName" json:"lastName" validate:"required,max=50"`
+	Age       int    `form:"inputAge" json:"age" validate:"gte=0,lte=120"`
+	Sex       string `form:"inputSex" json:"sex" validate:""`
+	City      string `form:"inputCity" json:"city" validate:""`
+	Interests string `form:"inputInterests" json:"interests" validate:""`
 }
 
 func (u *UserCreateRequest) Validate() error {
@@ -47,8 +48,8 @@ func (u *UserCreateRequest) ConverIntoUser() *User {
 }
 
 type UserLoginRequest struct {
-	Login    string `form:"inputLogin" validate:"required"`
-	Password string `form:"inputPassword" validate:"required"`
+	Login    string `form:"inputLogin" json:"login" validate:"required"`
+	Password string `form:"inputPassword" json:"password" validate:"required"`
 }
 
 func (u *UserLoginRequest) Validate() error {
@@ -56,6 +57,24 @@ func (u *UserLoginRequest) Validate() error {
 	return validate.Struct(u)
 }
 
+type TokenRefreshRequest struct {
+	RefreshToken string `form:"refreshToken" json:"refreshToken" validate:"required"`
+}
+
+func (t *TokenRefreshRequest) Validate() error {
+	validate := validator.New()
+	return validate.Struct(t)
+}
+
+type TokenRevokeRequest struct {
+	Token string `form:"token" json:"token" validate:"required"`
+}
+
+func (t *TokenRevokeRequest) Validate() error {
+	validate := validator.New()
+	return validate.Struct(t)
+}
+
 type fieldError struct {
 	err validator.FieldError
 }
@@ -72,3 +91,96 @@ type UserSearchRequest struct {
 	FirstName string `form:"firstName"`
 	LastName  string `form:"lastName"`
 }
+
+type UserResponse struct {
+	ID        int      `json:"id"`
+	Login     string   `json:"login"`
+	FirstName string   `json:"firstName"`
+	LastName  string   `json:"lastName"`
+	Age       int      `json:"age"`
+	Sex       string   `json:"sex"`
+	City      string   `json:"city"`
+	Interests []string `json:"interests,omitempty"`
+}
+
+func NewUserResponse(u *User) UserResponse {
+	resp := UserResponse{
+		ID:        u.ID,
+		Login:     u.Login,
+		FirstName: u.FirstName,
+		LastName:  u.Lastname,
+		Age:       u.Age,
+		Sex:       u.Sex,
+		City:      u.City.Name,
+	}
+
+	for _, i := range u.Interests {
+		resp.Interests = append(resp.Interests, i.Name)
+	}
+
+	return resp
+}
+
+// ProfileResponse is the user with the follow counters, ViewerFollows tells
+// whether the authenticated user follows the user.
+type ProfileResponse struct {
+	UserResponse
+	Followers     int  `json:"followers"`
+	Following     int  `json:"following"`
+	ViewerFollows bool `json:"viewerFollows"`
+}
+
+func NewUserListResponse(users []User) []UserResponse {
+	resp := make([]UserResponse, 0, len(users))
+
+	for i := range users {
+		resp = append(resp, NewUserResponse(&users[i]))
+	}
+
+	return resp
+}
+
+type FriendRequestResponse struct {
+	ID        int                 `json:"id"`
+	From      UserResponse        `json:"from"`
+	To        UserResponse        `json:"to"`
+	Status    FriendRequestStatus `json:"status"`
+	CreatedAt time.Time           `json:"createdAt"`
+	UpdatedAt time.Time           `json:"updatedAt"`
+}
+
+func NewFriendRequestResponse(r *FriendRequest) FriendRequestResponse {
+	return FriendRequestResponse{
+		ID:        r.ID,
+		From:      NewUserResponse(&r.From),
+		To:        NewUserResponse(&r.To),
+		Status:    r.Status,
+		CreatedAt: r.CreatedAt,
+		UpdatedAt: r.UpdatedAt,
+	}
+}
+
+func NewFriendRequestListResponse(requests []FriendRequest) []FriendRequestResponse {
+	resp := make([]FriendRequestResponse, 0, len(requests))
+
+	for i := range requests {
+		resp = append(resp, NewFriendRequestResponse(&requests[i]))
+	}
+
+	return resp
+}
+
+func validationDetails(err error) []string {
+	var details []string
+
+	validationErrors, ok := err.(validator.ValidationErrors)
+	if !ok {
+		return []string{err.Error()}
+	}
+
+	for _, e := range validationErrors {
+		details = append(details, fieldError{err: e}.String())
+	}
+
+	return details
+}
@@ -0,0 +1,141 @@
+package user
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/niklod/highload-social-network/config"
+	"github.com/niklod/highload-social-network/internal/server"
+)
+
+func (u *UserHandler) HandleFollow(c *gin.Context) {
+	u.changeFollow(c, true)
+}
+
+func (u *UserHandler) HandleUnfollow(c *gin.Context) {
+	u.changeFollow(c, false)
+}
+
+func (u *UserHandler) changeFollow(c *gin.Context, follow bool) {
+	authUser := getUser(c)
+	if authUser == nil {
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
+
+	user, err := u.userService.GetUserByLogin(c.Request.Context(), c.Param("login"))
+	if err != nil {
+		log.Printf("find user by login: %v", err)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		c.Status(http.StatusNotFound)
+		return
+	}
+
+	msg := fmt.Sprintf("Вы подписались на пользователя %s %s", user.FirstName, user.Lastname)
+	if follow {
+		err = u.userService.Follow(c.Request.Context(), authUser.ID, user.ID)
+	} else {
+		msg = fmt.Sprintf("Вы отписались от пользователя %s %s", user.FirstName, user.Lastname)
+		err = u.userService.Unfollow(c.Request.Context(), authUser.ID, user.ID)
+	}
+	if err == ErrFollowSelf {
+		c.Status(http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("changing follow: %v", err)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	session, err := u.sessionStore.Get(c.Request, config.SessionName)
+	if err != nil {
+		log.Printf("get session user handler: %v", err)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	session.AddFlash(msg)
+	err = session.Save(c.Request, c.Writer)
+	if err != nil {
+		log.Printf("save session with flashes: %v", err)
+	}
+
+	c.Redirect(http.StatusSeeOther, fmt.Sprintf("/user/%s", user.Login))
+}
+
+func (u *UserHandler) HandleAPIFollowers(c *gin.Context) {
+	u.apiFollowList(c, "api followers", u.userService.Followers)
+}
+
+func (u *UserHandler) HandleAPIFollowing(c *gin.Context) {
+	u.apiFollowList(c, "api following", u.userService.Following)
+}
+
+func (u *UserHandler) apiFollowList(c *gin.Context, op string, list func(ctx context.Context, userId int) ([]User, error)) {
+	user, ok := u.apiUserByLogin(c)
+	if !ok {
+		return
+	}
+
+	users, err := list(c.Request.Context(), user.ID)
+	if err != nil {
+		log.Printf("%s: %v", op, err)
+		server.RespondError(c, http.StatusInternalServerError, server.ErrCodeInternal, "Внутренняя ошибка сервера")
+		return
+	}
+
+	server.RespondData(c, http.StatusOK, NewUserListResponse(users))
+}
+
+// HandleAPIFollow subscribes the authenticated user to the user's posts.
+func (u *UserHandler) HandleAPIFollow(c *gin.Context) {
+	authUser, ok := requireAPIUser(c)
+	if !ok {
+		return
+	}
+
+	user, ok := u.apiUserByLogin(c)
+	if !ok {
+		return
+	}
+
+	err := u.userService.Follow(c.Request.Context(), authUser.ID, user.ID)
+	if err == ErrFollowSelf {
+		server.RespondError(c, http.StatusUnprocessableEntity, server.ErrCodeValidation, "Нельзя подписаться на самого себя")
+		return
+	}
+	if err != nil {
+		log.Printf("api follow: %v", err)
+		server.RespondError(c, http.StatusInternalServerError, server.ErrCodeInternal, "Внутренняя ошибка сервера")
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (u *UserHandler) HandleAPIUnfollow(c *gin.Context) {
+	authUser, ok := requireAPIUser(c)
+	if !ok {
+		return
+	}
+
+	user, ok := u.apiUserByLogin(c)
+	if !ok {
+		return
+	}
+
+	if err := u.userService.Unfollow(c.Request.Context(), authUser.ID, user.ID); err != nil {
+		log.Printf("api unfollow: %v", err)
+		server.RespondError(c, http.StatusInternalServerError, server.ErrCodeInternal, "Внутренняя ошибка сервера")
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
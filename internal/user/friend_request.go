@@ -0,0 +1,48 @@
+package user
+
+import "time"
+
+type FriendRequestStatus string
+
+const (
+	FriendRequestSent      FriendRequestStatus = "sent"
+	FriendRequestAccepted  FriendRequestStatus = "accepted"
+	FriendRequestDeclined  FriendRequestStatus = "declined"
+	FriendRequestCancelled FriendRequestStatus = "cancelled"
+)
+
+// friendRequestTransitions are the allowed status changes. An accepted
+// request is reopened only after the friendship is over.
+var friendRequestTransitions = map[FriendRequestStatus][]FriendRequestStatus{
+	FriendRequestSent:      {FriendRequestAccepted, FriendRequestDeclined, FriendRequestCancelled},
+	FriendRequestAccepted:  {FriendRequestSent},
+	FriendRequestDeclined:  {FriendRequestSent},
+	FriendRequestCancelled: {FriendRequestSent},
+}
+
+// CanMove tells whether a request with the status can be moved to the next
+// one.
+func (s FriendRequestStatus) CanMove(next FriendRequestStatus) bool {
+	for _, allowed := range friendRequestTransitions[s] {
+		if allowed == next {
+			return true
+		}
+	}
+
+	return false
+}
+
+// FriendRequest is the From user's request to become friends with To.
+// Each ordered pair of users has one request, sending it again reuses it.
+type FriendRequest struct {
+	ID        int
+	From      User
+	To        User
+	Status    FriendRequestStatus
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+func (r *FriendRequest) Pending() bool {
+	return r.Status == FriendRequestSent
+}
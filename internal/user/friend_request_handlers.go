@@ -0,0 +1,169 @@
+package user
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/niklod/highload-social-network/internal/server"
+)
+
+type friendRequestAction func(ctx context.Context, userId, requestId int) (*FriendRequest, error)
+
+func (u *UserHandler) HandleFriendRequests(c *gin.Context) {
+	authUser := getUser(c)
+	if authUser == nil {
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
+
+	incoming, err := u.userService.IncomingFriendRequests(c.Request.Context(), authUser.ID)
+	if err != nil {
+		log.Printf("friend requests page, getting incoming: %v", err)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	outgoing, err := u.userService.OutgoingFriendRequests(c.Request.Context(), authUser.ID)
+	if err != nil {
+		log.Printf("friend requests page, getting outgoing: %v", err)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	c.HTML(http.StatusOK, "friend_requests", ViewData{
+		AuthenticatedUser: authUser,
+		IncomingRequests:  incoming,
+		OutgoingRequests:  outgoing,
+	})
+}
+
+func (u *UserHandler) HandleAcceptFriendRequest(c *gin.Context) {
+	u.changeFriendRequest(c, "accepting friend request", u.userService.AcceptFriendRequest)
+}
+
+func (u *UserHandler) HandleDeclineFriendRequest(c *gin.Context) {
+	u.changeFriendRequest(c, "declining friend request", u.userService.DeclineFriendRequest)
+}
+
+func (u *UserHandler) HandleCancelFriendRequest(c *gin.Context) {
+	u.changeFriendRequest(c, "cancelling friend request", u.userService.CancelFriendRequest)
+}
+
+// changeFriendRequest runs the action on the request from the :id parameter
+// and goes back to the page the form was sent from.
+func (u *UserHandler) changeFriendRequest(c *gin.Context, op string, action friendRequestAction) {
+	authUser := getUser(c)
+	if authUser == nil {
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
+
+	requestId, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.Status(http.StatusNotFound)
+		return
+	}
+
+	_, err = action(c.Request.Context(), authUser.ID, requestId)
+	switch err {
+	case nil, ErrFriendRequestClosed:
+	case ErrFriendRequestNotFound:
+		c.Status(http.StatusNotFound)
+		return
+	default:
+		log.Printf("%s: %v", op, err)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	back := c.Request.Referer()
+	if back == "" {
+		back = "/friend_requests"
+	}
+
+	c.Redirect(http.StatusSeeOther, back)
+}
+
+// HandleAPIFriendRequests lists the pending requests of the authenticated
+// user, incoming by default or outgoing with direction=outgoing.
+func (u *UserHandler) HandleAPIFriendRequests(c *gin.Context) {
+	authUser, ok := requireAPIUser(c)
+	if !ok {
+		return
+	}
+
+	var requests []FriendRequest
+	var err error
+
+	switch c.DefaultQuery("direction", "incoming") {
+	case "incoming":
+		requests, err = u.userService.IncomingFriendRequests(c.Request.Context(), authUser.ID)
+	case "outgoing":
+		requests, err = u.userService.OutgoingFriendRequests(c.Request.Context(), authUser.ID)
+	default:
+		server.RespondError(c, http.StatusBadRequest, server.ErrCodeBadRequest, "Некорректное направление заявок")
+		return
+	}
+	if err != nil {
+		log.Printf("api friend requests: %v", err)
+		server.RespondError(c, http.StatusInternalServerError, server.ErrCodeInternal, "Внутренняя ошибка сервера")
+		return
+	}
+
+	server.RespondData(c, http.StatusOK, NewFriendRequestListResponse(requests))
+}
+
+func (u *UserHandler) HandleAPIAcceptFriendRequest(c *gin.Context) {
+	u.apiChangeFriendRequest(c, "api accept friend request", u.userService.AcceptFriendRequest)
+}
+
+func (u *UserHandler) HandleAPIDeclineFriendRequest(c *gin.Context) {
+	u.apiChangeFriendRequest(c, "api decline friend request", u.userService.DeclineFriendRequest)
+}
+
+func (u *UserHandler) HandleAPICancelFriendRequest(c *gin.Context) {
+	u.apiChangeFriendRequest(c, "api cancel friend request", u.userService.CancelFriendRequest)
+}
+
+func (u *UserHandler) apiChangeFriendRequest(c *gin.Context, op string, action friendRequestAction) {
+	authUser, ok := requireAPIUser(c)
+	if !ok {
+		return
+	}
+
+	requestId, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		server.RespondError(c, http.StatusNotFound, server.ErrCodeNotFound, "Заявка не найдена")
+		return
+	}
+
+	r, err := action(c.Request.Context(), authUser.ID, requestId)
+	if err != nil {
+		respondFriendRequestError(c, op, err)
+		return
+	}
+
+	server.RespondData(c, http.StatusOK, NewFriendRequestResponse(r))
+}
+
+func respondFriendRequestError(c *gin.Context, op string, err error) {
+	switch err {
+	case ErrFriendRequestToSelf:
+		server.RespondError(c, http.StatusUnprocessableEntity, server.ErrCodeValidation, "Нельзя добавить в друзья самого себя")
+	case ErrAlreadyFriends:
+		server.RespondError(c, http.StatusConflict, server.ErrCodeConflict, "Пользователи уже друзья")
+	case ErrFriendRequestExists:
+		server.RespondError(c, http.StatusConflict, server.ErrCodeConflict, "Заявка в друзья уже отправлена")
+	case ErrFriendRequestNotFound:
+		server.RespondError(c, http.StatusNotFound, server.ErrCodeNotFound, "Заявка не найдена")
+	case ErrFriendRequestClosed:
+		server.RespondError(c, http.StatusConflict, server.ErrCodeConflict, "Заявка уже закрыта")
+	default:
+		log.Printf("%s: %v", op, err)
+		server.RespondError(c, http.StatusInternalServerError, server.ErrCodeInternal, "Внутренняя ошибка сервера")
+	}
+}
@@ -1,16 +1,22 @@
 package user
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/go-playground/validator/v10"
 	"github.com/gorilla/sessions"
 
 	"github.com/niklod/highload-social-network/config"
+	"github.com/niklod/highload-social-network/internal/auth"
+	"github.com/niklod/highload-social-network/internal/server"
+	"github.com/niklod/highload-social-network/internal/session"
 	"github.com/niklod/highload-social-network/internal/user/city"
 	"github.com/niklod/highload-social-network/internal/user/interest"
 	"github.com/niklod/highload-social-network/internal/user/post"
@@ -18,6 +24,20 @@ import (
 
 const (
 	userSessionKey = "user"
+
+	// sessionTokenKey holds the server side session token in the cookie
+	sessionTokenKey = "session_token"
+
+	// currentSessionKey holds the ID of the resolved session in gin.Context
+	currentSessionKey = "session_id"
+
+	// accessTokenQueryParam lets clients that can't set headers (browser
+	// WebSocket API) pass the access token in the URL. It's taken on
+	// FeedSocketRoute only, the URLs end up in logs.
+	accessTokenQueryParam = "access_token"
+
+	// FeedSocketRoute is the WebSocket handshake of the feed updates
+	FeedSocketRoute = "/ws/feed/:login"
 )
 
 type ViewData struct {
@@ -28,7 +48,16 @@ type ViewData struct {
 	User              *User
 	AuthenticatedUser *User
 	UsersAreFriends   bool
+	FriendRequest     *FriendRequest
+	IncomingRequests  []FriendRequest
+	OutgoingRequests  []FriendRequest
+	FollowersCount    int
+	FollowingCount    int
+	IsFollowing       bool
 	Feed              post.Feed
+	NextCursor        string
+	Sessions          []session.Session
+	CurrentSessionID  string
 }
 
 type UserHandler struct {
@@ -37,6 +66,8 @@ type UserHandler struct {
 	interestService *interest.Service
 	postService     *post.Service
 	sessionStore    *sessions.CookieStore
+	sessionManager  *session.Manager
+	tokenManager    *auth.Manager
 }
 
 func NewHandler(
@@ -45,6 +76,8 @@ func NewHandler(
 	postService *post.Service,
 	sessionStore *sessions.CookieStore,
 	interestService *interest.Service,
+	sessionManager *session.Manager,
+	tokenManager *auth.Manager,
 ) *UserHandler {
 	return &UserHandler{
 		userService:     userService,
@@ -52,6 +85,8 @@ func NewHandler(
 		postService:     postService,
 		sessionStore:    sessionStore,
 		interestService: interestService,
+		sessionManager:  sessionManager,
+		tokenManager:    tokenManager,
 	}
 }
 
@@ -125,7 +160,7 @@ func (u *UserHandler) HandleUserRegistrateSubmit(c *gin.Context) {
 		return
 	}
 
-	_, err = u.userService.Create(req.ConverIntoUser())
+	_, err = u.userService.Create(c.Request.Context(), req.ConverIntoUser())
 	if err != nil {
 		if errors.Is(err, ErrUserAlreadyExist) {
 			session.AddFlash("Пользователь с таким логином уже существует")
@@ -156,17 +191,8 @@ func (u *UserHandler) HandleUserRegistrateSubmit(c *gin.Context) {
 }
 
 func (u *UserHandler) HandleUserLogout(c *gin.Context) {
-	session, err := u.sessionStore.Get(c.Request, config.SessionName)
-	if err != nil {
-		log.Printf("get session user handler: %v", err)
-		c.Status(http.StatusInternalServerError)
-		return
-	}
-
-	session.Options.MaxAge = -1
-
-	if err := session.Save(c.Request, c.Writer); err != nil {
-		log.Printf("saving session: %v", err)
+	if err := u.endSession(c); err != nil {
+		log.Printf("logout: %v", err)
 		c.Status(http.StatusInternalServerError)
 		return
 	}
@@ -220,7 +246,7 @@ func (u *UserHandler) HandleUserLoginSubmit(c *gin.Context) {
 		return
 	}
 
-	user, err := u.userService.GetUserByLogin(req.Login)
+	user, err := u.userService.GetUserByLogin(c.Request.Context(), req.Login)
 	if err != nil {
 		log.Printf("get user by id handler: %v", err)
 		c.Status(http.StatusInternalServerError)
@@ -233,16 +259,8 @@ func (u *UserHandler) HandleUserLoginSubmit(c *gin.Context) {
 		return
 	}
 
-	session, err := u.sessionStore.Get(c.Request, config.SessionName)
-	if err != nil {
-		log.Printf("get session user handler: %v", err)
-		c.Status(http.StatusInternalServerError)
-		return
-	}
-
-	session.Values[userSessionKey] = *user
-	if err := session.Save(c.Request, c.Writer); err != nil {
-		log.Printf("saving session: %v", err)
+	if err := u.startSession(c, user); err != nil {
+		log.Printf("login: %v", err)
 		c.Status(http.StatusInternalServerError)
 		return
 	}
@@ -261,7 +279,7 @@ func (u *UserHandler) HandleUserDetail(c *gin.Context) {
 		return
 	}
 
-	user, err := u.userService.GetUserByLogin(userLogin)
+	user, err := u.userService.GetUserByLogin(c.Request.Context(), userLogin)
 	if err != nil {
 		log.Printf("user detail, getting user: %v", err)
 		c.Status(http.StatusInternalServerError)
@@ -272,7 +290,7 @@ func (u *UserHandler) HandleUserDetail(c *gin.Context) {
 		return
 	}
 
-	userFriends, err := u.userService.Friends(user.ID)
+	userFriends, err := u.userService.Friends(c.Request.Context(), user.ID)
 	if err != nil {
 		log.Printf("user detail, getting friends: %v", err)
 		c.Status(http.StatusInternalServerError)
@@ -288,18 +306,24 @@ func (u *UserHandler) HandleUserDetail(c *gin.Context) {
 	}
 	user.Interests = userInterests
 
-	userPosts, err := u.postService.PostsByUserId(user.ID)
+	cursor, limit, err := pageRequest(c)
+	if err != nil {
+		c.Status(http.StatusBadRequest)
+		return
+	}
+
+	userPosts, err := u.postService.PostsByUserId(c.Request.Context(), user.ID, viewerID(authUser), cursor, limit)
 	if err != nil {
 		log.Printf("user detail, getting posts: %v", err)
 		c.Status(http.StatusInternalServerError)
 		return
 	}
-	user.Posts = userPosts
+	user.Posts = userPosts.Posts
 
 	user.Sanitize()
 
 	if authUser != nil {
-		authUserFriends, err := u.userService.Friends(authUser.ID)
+		authUserFriends, err := u.userService.Friends(c.Request.Context(), authUser.ID)
 		if err != nil {
 			log.Printf("user detail, getting friends: %v", err)
 			c.Status(http.StatusInternalServerError)
@@ -313,6 +337,32 @@ func (u *UserHandler) HandleUserDetail(c *gin.Context) {
 		User:              user,
 		AuthenticatedUser: authUser,
 		UsersAreFriends:   u.userService.IsUsersAreFriends(authUser, user),
+		NextCursor:        userPosts.Next.Encode(),
+	}
+
+	if authUser != nil && authUser.ID != user.ID && !data.UsersAreFriends {
+		data.FriendRequest, err = u.userService.PendingFriendRequest(c.Request.Context(), authUser.ID, user.ID)
+		if err != nil {
+			log.Printf("user detail, getting friend request: %v", err)
+			c.Status(http.StatusInternalServerError)
+			return
+		}
+	}
+
+	data.FollowersCount, data.FollowingCount, err = u.userService.FollowCounts(c.Request.Context(), user.ID)
+	if err != nil {
+		log.Printf("user detail, getting follow counts: %v", err)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	if authUser != nil && authUser.ID != user.ID {
+		data.IsFollowing, err = u.userService.IsFollowing(c.Request.Context(), authUser.ID, user.ID)
+		if err != nil {
+			log.Printf("user detail, checking follow: %v", err)
+			c.Status(http.StatusInternalServerError)
+			return
+		}
 	}
 
 	err = session.Save(c.Request, c.Writer)
@@ -323,31 +373,50 @@ func (u *UserHandler) HandleUserDetail(c *gin.Context) {
 	c.HTML(http.StatusOK, "user_detail", data)
 }
 
+// HandleAddFriend sends a friend request, the users become friends once it
+// is accepted.
 func (u *UserHandler) HandleAddFriend(c *gin.Context) {
 	authUser := getUser(c)
 	userLogin := c.Param("login")
 
 	if authUser == nil {
-		c.Redirect(http.StatusUnauthorized, "login")
+		c.Redirect(http.StatusFound, "/login")
 		return
 	}
 
-	user, err := u.userService.GetUserByLogin(userLogin)
+	user, err := u.userService.GetUserByLogin(c.Request.Context(), userLogin)
 	if err != nil {
 		log.Printf("find user by login: %v", err)
 		c.Status(http.StatusInternalServerError)
 		return
 	}
+	if user == nil {
+		c.Status(http.StatusNotFound)
+		return
+	}
 
-	err = u.userService.AddFriend(authUser.ID, user.ID)
-	if err != nil {
-		log.Printf("addint to friends: %v", err)
+	var msg string
+
+	r, err := u.userService.SendFriendRequest(c.Request.Context(), authUser, user)
+	switch err {
+	case nil:
+		msg = fmt.Sprintf("Заявка в друзья пользователю %s %s отправлена", user.FirstName, user.Lastname)
+		if r.Status == FriendRequestAccepted {
+			msg = fmt.Sprintf("Пользователь %s %s успешно добавлен в друзья", user.FirstName, user.Lastname)
+		}
+	case ErrFriendRequestExists:
+		msg = "Заявка в друзья уже отправлена"
+	case ErrAlreadyFriends:
+		msg = "Пользователи уже друзья"
+	case ErrFriendRequestToSelf:
+		c.Status(http.StatusBadRequest)
+		return
+	default:
+		log.Printf("sending friend request: %v", err)
 		c.Status(http.StatusInternalServerError)
 		return
 	}
 
-	msg := fmt.Sprintf("Пользователь %s %s успешно добавлен в друзья", user.FirstName, user.Lastname)
-
 	session, err := u.sessionStore.Get(c.Request, config.SessionName)
 	if err != nil {
 		log.Printf("get session user handler: %v", err)
@@ -374,14 +443,14 @@ func (u *UserHandler) HandleDeleteFriend(c *gin.Context) {
 		return
 	}
 
-	user, err := u.userService.GetUserByLogin(userLogin)
+	user, err := u.userService.GetUserByLogin(c.Request.Context(), userLogin)
 	if err != nil {
 		log.Printf("get user by login in handler: %v", err)
 		c.Status(http.StatusInternalServerError)
 		return
 	}
 
-	err = u.userService.DeleteFriend(authUser.ID, user.ID)
+	err = u.userService.DeleteFriend(c.Request.Context(), authUser.ID, user.ID)
 	if err != nil {
 		log.Printf("delete friend in handler: %v", err)
 		c.Status(http.StatusInternalServerError)
@@ -428,7 +497,7 @@ func (u *UserHandler) HandleAddPost(c *gin.Context) {
 		},
 	}
 
-	err := u.postService.Add(post)
+	err := u.postService.Add(c.Request.Context(), post)
 	if err != nil {
 		log.Printf("addint post: %v", err)
 		c.Status(http.StatusInternalServerError)
@@ -452,6 +521,50 @@ func (u *UserHandler) HandleAddPost(c *gin.Context) {
 	c.Redirect(http.StatusSeeOther, redirectLocation)
 }
 
+func (u *UserHandler) HandleEditPost(c *gin.Context) {
+	u.changePost(c, "editing post", func(ctx context.Context, authorId, postId int) error {
+		_, err := u.postService.Update(ctx, authorId, postId, c.PostForm("post"))
+		return err
+	})
+}
+
+func (u *UserHandler) HandleDeletePost(c *gin.Context) {
+	u.changePost(c, "deleting post", u.postService.Delete)
+}
+
+// changePost runs the change of the authenticated user's post from the
+// :id parameter and redirects back to the user page.
+func (u *UserHandler) changePost(c *gin.Context, op string, change func(ctx context.Context, authorId, postId int) error) {
+	authUser := getUser(c)
+	if authUser == nil {
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
+
+	postId, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.Status(http.StatusNotFound)
+		return
+	}
+
+	err = change(c.Request.Context(), authUser.ID, postId)
+	switch err {
+	case nil:
+	case post.ErrPostNotFound:
+		c.Status(http.StatusNotFound)
+		return
+	case post.ErrNotAuthor:
+		c.Status(http.StatusForbidden)
+		return
+	default:
+		log.Printf("%s: %v", op, err)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	c.Redirect(http.StatusSeeOther, fmt.Sprintf("/user/%s", authUser.Login))
+}
+
 func (u *UserHandler) HandleFeed(c *gin.Context) {
 	authUser := getUser(c)
 
@@ -467,7 +580,13 @@ func (u *UserHandler) HandleFeed(c *gin.Context) {
 		return
 	}
 
-	feed, err := u.postService.UserFeed(authUser.ID)
+	cursor, limit, err := pageRequest(c)
+	if err != nil {
+		c.Status(http.StatusBadRequest)
+		return
+	}
+
+	feed, err := u.postService.UserFeed(c.Request.Context(), authUser.ID, cursor, limit)
 	if err != nil {
 		log.Printf("feed page, getting user feed: %v", err)
 		c.Status(http.StatusInternalServerError)
@@ -477,7 +596,8 @@ func (u *UserHandler) HandleFeed(c *gin.Context) {
 	data := ViewData{
 		Messages:          session.Flashes(),
 		AuthenticatedUser: authUser,
-		Feed:              feed,
+		Feed:              feed.Posts,
+		NextCursor:        feed.Next.Encode(),
 	}
 
 	err = session.Save(c.Request, c.Writer)
@@ -499,7 +619,7 @@ func (u *UserHandler) HandleUsersList(c *gin.Context) {
 		return
 	}
 
-	users, err := u.userService.userRepo.GetByFirstAndLastName(req.FirstName, req.LastName)
+	users, err := u.userService.userRepo.GetByFirstAndLastName(c.Request.Context(), req.FirstName, req.LastName)
 	if err != nil {
 		log.Printf("gettings user list in handler: %v", err)
 		c.Status(http.StatusInternalServerError)
@@ -513,16 +633,139 @@ func (u *UserHandler) HandleUsersList(c *gin.Context) {
 }
 
 func (u *UserHandler) AuthMiddleware(c *gin.Context) {
-	session, err := u.sessionStore.Get(c.Request, config.SessionName)
+	if token := bearerToken(c); token != "" {
+		u.authenticateToken(c, token)
+		return
+	}
+
+	cookie, err := u.sessionStore.Get(c.Request, config.SessionName)
 	if err != nil {
 		c.AbortWithStatus(http.StatusInternalServerError)
 		return
 	}
 
-	user, exist := session.Values[userSessionKey]
-	if exist && session.Options.MaxAge > 0 {
-		c.Set(userSessionKey, user)
+	token, ok := cookie.Values[sessionTokenKey].(string)
+	if !ok || token == "" || cookie.Options.MaxAge <= 0 {
+		return
+	}
+
+	s, err := u.sessionManager.Resolve(token)
+	if err != nil {
+		if !errors.Is(err, session.ErrSessionNotFound) && !errors.Is(err, session.ErrSessionExpired) {
+			log.Printf("auth middleware, resolving session: %v", err)
+		}
+		return
+	}
+
+	user, err := u.userService.GetUserByID(c.Request.Context(), s.UserID)
+	if err != nil {
+		log.Printf("auth middleware, getting session user: %v", err)
+		return
+	}
+
+	c.Set(userSessionKey, *user)
+	c.Set(currentSessionKey, s.ID)
+}
+
+// startSession creates a server side session for the user and stores its
+// token in the cookie.
+func (u *UserHandler) startSession(c *gin.Context, user *User) error {
+	cookie, err := u.sessionStore.Get(c.Request, config.SessionName)
+	if err != nil {
+		return fmt.Errorf("getting cookie session: %v", err)
+	}
+
+	_, token, err := u.sessionManager.Start(user.ID, c.Request.UserAgent(), c.ClientIP())
+	if err != nil {
+		return err
+	}
+
+	// Cookies issued before server side sessions carried the whole user
+	delete(cookie.Values, userSessionKey)
+	cookie.Values[sessionTokenKey] = token
+
+	if err := cookie.Save(c.Request, c.Writer); err != nil {
+		return fmt.Errorf("saving cookie session: %v", err)
+	}
+
+	return nil
+}
+
+// endSession deletes the server side session of the request and expires
+// the cookie.
+func (u *UserHandler) endSession(c *gin.Context) error {
+	cookie, err := u.sessionStore.Get(c.Request, config.SessionName)
+	if err != nil {
+		return fmt.Errorf("getting cookie session: %v", err)
+	}
+
+	if token, ok := cookie.Values[sessionTokenKey].(string); ok && token != "" {
+		if err := u.sessionManager.End(token); err != nil {
+			return err
+		}
+	}
+
+	cookie.Options.MaxAge = -1
+
+	if err := cookie.Save(c.Request, c.Writer); err != nil {
+		return fmt.Errorf("saving cookie session: %v", err)
+	}
+
+	return nil
+}
+
+func (u *UserHandler) authenticateToken(c *gin.Context, token string) {
+	claims, err := u.tokenManager.Authenticate(token)
+	if err != nil {
+		if auth.IsInvalidToken(err) {
+			server.RespondError(c, http.StatusUnauthorized, server.ErrCodeUnauthorized, "Недействительный токен доступа")
+			return
+		}
+
+		log.Printf("auth middleware, checking token: %v", err)
+		server.RespondError(c, http.StatusInternalServerError, server.ErrCodeInternal, "Внутренняя ошибка сервера")
+		return
+	}
+
+	user, err := u.userService.GetUserByLogin(c.Request.Context(), claims.Login)
+	if err != nil {
+		log.Printf("auth middleware, getting token user: %v", err)
+		server.RespondError(c, http.StatusInternalServerError, server.ErrCodeInternal, "Внутренняя ошибка сервера")
+		return
+	}
+	if user == nil || user.ID != claims.UserID {
+		server.RespondError(c, http.StatusUnauthorized, server.ErrCodeUnauthorized, "Недействительный токен доступа")
+		return
+	}
+
+	c.Set(userSessionKey, *user)
+}
+
+func bearerToken(c *gin.Context) string {
+	header := c.GetHeader("Authorization")
+	if len(header) > 7 && strings.EqualFold(header[:7], "Bearer ") {
+		return strings.TrimSpace(header[7:])
+	}
+
+	if c.FullPath() != FeedSocketRoute {
+		return ""
 	}
+
+	return c.Query(accessTokenQueryParam)
+}
+
+// AuthenticatedUser returns the user authenticated by AuthMiddleware or nil.
+func AuthenticatedUser(c *gin.Context) *User {
+	return getUser(c)
+}
+
+// viewerID returns the ID of the authenticated user, zero for anonymous
+func viewerID(u *User) int {
+	if u == nil {
+		return 0
+	}
+
+	return u.ID
 }
 
 func getUser(c *gin.Context) *User {
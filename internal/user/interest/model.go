@@ -1,6 +1,6 @@
 package interest
 
 type Interest struct {
-	ID   int
-	Name string
+	ID   int    `json:"id"`
+	Name string `json:"name"`
 }
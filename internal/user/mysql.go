@@ -5,25 +5,27 @@ import (
 	"database/sql"
 	"fmt"
 	"log"
+	"time"
 
+	"github.com/niklod/highload-social-network/internal/cluster"
 	"github.com/niklod/highload-social-network/internal/user/city"
 )
 
 type mysql struct {
-	db *sql.DB
+	db *cluster.Cluster
 }
 
-func NewRepository(db *sql.DB) repository {
+func NewRepository(db *cluster.Cluster) repository {
 	return &mysql{db: db}
 }
 
-func (m *mysql) Create(user *User) (*User, error) {
+func (m *mysql) Create(ctx context.Context, user *User) (*User, error) {
 	query := queryMap[createQuery]
 
-	ctx, cancel := context.WithTimeout(context.Background(), query.Timeout)
+	ctx, cancel := context.WithTimeout(ctx, query.Timeout)
 	defer cancel()
 
-	res, err := m.db.ExecContext(ctx, query.SQL,
+	res, err := m.db.Writer(ctx).ExecContext(ctx, query.SQL,
 		user.FirstName,
 		user.Lastname,
 		user.Age,
@@ -46,13 +48,13 @@ func (m *mysql) Create(user *User) (*User, error) {
 	return user, nil
 }
 
-func (m *mysql) List() ([]User, error) {
+func (m *mysql) List(ctx context.Context) ([]User, error) {
 	query := queryMap[listQuery]
 
-	ctx, cancel := context.WithTimeout(context.Background(), query.Timeout)
+	ctx, cancel := context.WithTimeout(ctx, query.Timeout)
 	defer cancel()
 
-	rows, err := m.db.QueryContext(ctx, query.SQL)
+	rows, err := m.db.Reader(ctx).QueryContext(ctx, query.SQL)
 	if err != nil {
 		return nil, fmt.Errorf("list users: %v", err)
 	}
@@ -97,17 +99,17 @@ func (m *mysql) List() ([]User, error) {
 	return users, nil
 }
 
-func (m *mysql) GetByID(id int) (*User, error) {
+func (m *mysql) GetByID(ctx context.Context, id int) (*User, error) {
 	query := queryMap[getByID]
 
-	ctx, cancel := context.WithTimeout(context.Background(), query.Timeout)
+	ctx, cancel := context.WithTimeout(ctx, query.Timeout)
 	defer cancel()
 
 	var user User
 	var cityName sql.NullString
 	var cityID sql.NullInt64
 
-	row := m.db.QueryRowContext(ctx, query.SQL, id)
+	row := m.db.Reader(ctx).QueryRowContext(ctx, query.SQL, id)
 	err := row.Scan(
 		&user.ID,
 		&user.FirstName,
@@ -121,7 +123,7 @@ func (m *mysql) GetByID(id int) (*User, error) {
 	)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return nil, fmt.Errorf("user id %d not found: %v", id, err)
+			return nil, fmt.Errorf("user id %d not found: %w", id, err)
 		}
 		return nil, fmt.Errorf("get user by id: scanning user sql row: %v", err)
 	}
@@ -136,10 +138,10 @@ func (m *mysql) GetByID(id int) (*User, error) {
 	return &user, nil
 }
 
-func (m *mysql) GetByFirstAndLastName(firstname, lastname string) ([]User, error) {
+func (m *mysql) GetByFirstAndLastName(ctx context.Context, firstname, lastname string) ([]User, error) {
 	query := queryMap[GetByFirstAndLastName]
 
-	ctx, cancel := context.WithTimeout(context.Background(), query.Timeout)
+	ctx, cancel := context.WithTimeout(ctx, query.Timeout)
 	defer cancel()
 
 	var cityName sql.NullString
@@ -150,7 +152,7 @@ func (m *mysql) GetByFirstAndLastName(firstname, lastname string) ([]User, error
 	firstNameQuery := firstname + "%"
 	lastNameQuery := lastname + "%"
 
-	rows, err := m.db.QueryContext(ctx, query.SQL, lastNameQuery, firstNameQuery)
+	rows, err := m.db.Reader(ctx).QueryContext(ctx, query.SQL, lastNameQuery, firstNameQuery)
 	if err != nil {
 		return nil, err
 	}
@@ -190,17 +192,17 @@ func (m *mysql) GetByFirstAndLastName(firstname, lastname string) ([]User, error
 	return users, nil
 }
 
-func (m *mysql) GetByLogin(login string) (*User, error) {
+func (m *mysql) GetByLogin(ctx context.Context, login string) (*User, error) {
 	query := queryMap[getByLogin]
 
-	ctx, cancel := context.WithTimeout(context.Background(), query.Timeout)
+	ctx, cancel := context.WithTimeout(ctx, query.Timeout)
 	defer cancel()
 
 	var user User
 	var cityName sql.NullString
 	var cityID sql.NullInt64
 
-	row := m.db.QueryRowContext(ctx, query.SQL, login)
+	row := m.db.Reader(ctx).QueryRowContext(ctx, query.SQL, login)
 	err := row.Scan(
 		&user.ID,
 		&user.FirstName,
@@ -229,16 +231,30 @@ func (m *mysql) GetByLogin(login string) (*User, error) {
 	return &user, nil
 }
 
-func (m *mysql) AddFriend(userId int, friendId int) error {
+// AcceptFriendRequest accepts the pending request and makes the users
+// friends in one transaction, or returns ErrFriendRequestClosed.
+func (m *mysql) AcceptFriendRequest(ctx context.Context, r *FriendRequest) error {
 	query := queryMap[addFriend]
-	ctx, cancel := context.WithTimeout(context.Background(), query.Timeout)
+	ctx, cancel := context.WithTimeout(ctx, query.Timeout)
 	defer cancel()
 
+	userId, friendId := r.From.ID, r.To.ID
 	if userId == friendId {
 		return fmt.Errorf("user ID and friend ID are equal")
 	}
 
-	res, err := m.db.ExecContext(ctx, query.SQL, userId, friendId, friendId, userId)
+	tx, err := m.db.Writer(ctx).BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	err = changeStatus(ctx, tx, r.ID, FriendRequestSent, FriendRequestAccepted)
+	if err != nil {
+		return err
+	}
+
+	res, err := tx.ExecContext(ctx, query.SQL, userId, friendId, friendId, userId)
 	if err != nil {
 		return fmt.Errorf("adding friend with ID %d to user ID %d: %v", friendId, userId, err)
 	}
@@ -252,19 +268,24 @@ func (m *mysql) AddFriend(userId int, friendId int) error {
 		return fmt.Errorf("affected rows equal 0: %v", err)
 	}
 
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("committing transaction: %v", err)
+	}
+
 	return nil
 }
 
-func (m *mysql) DeleteFriend(userId int, friendId int) error {
+func (m *mysql) DeleteFriend(ctx context.Context, userId int, friendId int) error {
 	query := queryMap[deleteFriend]
-	ctx, cancel := context.WithTimeout(context.Background(), query.Timeout)
+	ctx, cancel := context.WithTimeout(ctx, query.Timeout)
 	defer cancel()
 
 	if userId == friendId {
 		return fmt.Errorf("user ID and friend ID are equal")
 	}
 
-	res, err := m.db.ExecContext(ctx, query.SQL, userId, friendId, friendId, userId)
+	res, err := m.db.Writer(ctx).
+		ExecContext(ctx, query.SQL, userId, friendId, friendId, userId)
 	if err != nil {
 		return fmt.Errorf("deleting friend with ID %d from user ID %d: %v", friendId, userId, err)
 	}
@@ -281,48 +302,395 @@ func (m *mysql) DeleteFriend(userId int, friendId int) error {
 	return nil
 }
 
-func (m *mysql) Friends(userId int) ([]User, error) {
+func (m *mysql) Friends(ctx context.Context, userId int) ([]User, error) {
 	query := queryMap[getFriends]
-	ctx, cancel := context.WithTimeout(context.Background(), query.Timeout)
+	ctx, cancel := context.WithTimeout(ctx, query.Timeout)
 	defer cancel()
 
-	rows, err := m.db.QueryContext(ctx, query.SQL, userId)
+	rows, err := m.db.Reader(ctx).QueryContext(ctx, query.SQL, userId)
 	if err != nil {
 		return nil, fmt.Errorf("getting friends: %v", err)
 	}
 	defer rows.Close()
 
-	friends := []User{}
+	return scanUsers(rows, "friends"), nil
+}
+
+// scanUsers reads the userListSelect rows, skipping the ones that fail.
+func scanUsers(rows *sql.Rows, what string) []User {
+	users := []User{}
 
 	for rows.Next() {
-		var friend User
+		var u User
 		var cityID sql.NullInt64
 		var cityName sql.NullString
 
 		err := rows.Scan(
-			&friend.ID,
-			&friend.FirstName,
-			&friend.Lastname,
-			&friend.Age,
-			&friend.Sex,
-			&friend.Login,
+			&u.ID,
+			&u.FirstName,
+			&u.Lastname,
+			&u.Age,
+			&u.Sex,
+			&u.Login,
 			&cityID,
 			&cityName,
 		)
 		if err != nil {
-			log.Printf("scanning friends: %v", err)
+			log.Printf("scanning %s: %v", what, err)
 			continue
 		}
 
-		friend.City = city.City{}
+		u.City = city.City{}
 
 		if cityName.Valid && cityID.Valid {
-			friend.City.Name = cityName.String
-			friend.City.ID = int(cityID.Int64)
+			u.City.Name = cityName.String
+			u.City.ID = int(cityID.Int64)
+		}
+
+		users = append(users, u)
+	}
+
+	return users
+}
+
+func (m *mysql) AreFriends(ctx context.Context, userId, friendId int) (bool, error) {
+	query := queryMap[areFriends]
+	ctx, cancel := context.WithTimeout(ctx, query.Timeout)
+	defer cancel()
+
+	var count int
+
+	err := m.db.Reader(ctx).QueryRowContext(ctx, query.SQL, userId, friendId).Scan(&count)
+	if err != nil {
+		return false, fmt.Errorf("checking friendship of users %d and %d: %v", userId, friendId, err)
+	}
+
+	return count > 0, nil
+}
+
+// SaveFriendRequest sends the request, reopening a closed one.
+func (m *mysql) SaveFriendRequest(ctx context.Context, r *FriendRequest) error {
+	query := queryMap[saveFriendRequest]
+	ctx, cancel := context.WithTimeout(ctx, query.Timeout)
+	defer cancel()
+
+	res, err := m.db.Writer(ctx).
+		ExecContext(ctx, query.SQL, r.From.ID, r.To.ID)
+	if err != nil {
+		return fmt.Errorf("sending friend request from user %d to user %d: %v", r.From.ID, r.To.ID, err)
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("getting last insert id: %v", err)
+	}
+
+	r.ID = int(id)
+	r.Status = FriendRequestSent
+
+	return nil
+}
+
+// FriendRequest returns the request from one user to the other, or nil if
+// there is none.
+func (m *mysql) FriendRequest(ctx context.Context, fromId, toId int) (*FriendRequest, error) {
+	query := queryMap[getFriendRequest]
+	ctx, cancel := context.WithTimeout(ctx, query.Timeout)
+	defer cancel()
+
+	r, err := scanFriendRequest(m.db.Reader(ctx).
+		QueryRowContext(ctx, query.SQL, fromId, toId))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("getting friend request from user %d to user %d: %v", fromId, toId, err)
+	}
+
+	return r, nil
+}
+
+// FriendRequestByID returns the request or nil if there is none.
+func (m *mysql) FriendRequestByID(ctx context.Context, id int) (*FriendRequest, error) {
+	query := queryMap[getFriendRequestByID]
+	ctx, cancel := context.WithTimeout(ctx, query.Timeout)
+	defer cancel()
+
+	// Read from the primary, the request is about to be changed
+	r, err := scanFriendRequest(m.db.Primary().QueryRowContext(ctx, query.SQL, id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("getting friend request %d: %v", id, err)
+	}
+
+	return r, nil
+}
+
+// CloseFriendRequest declines or cancels the pending request. It returns
+// ErrFriendRequestClosed if the request isn't pending anymore.
+func (m *mysql) CloseFriendRequest(ctx context.Context, r *FriendRequest, status FriendRequestStatus) error {
+	ctx, cancel := context.WithTimeout(ctx, queryMap[updateFriendRequestStatus].Timeout)
+	defer cancel()
+
+	db := m.db.Writer(ctx)
+
+	return changeStatus(ctx, db, r.ID, FriendRequestSent, status)
+}
+
+// FriendRequests returns the pending requests sent to the user if incoming
+// is set, or sent by the user otherwise.
+func (m *mysql) FriendRequests(ctx context.Context, userId int, incoming bool, limit int) ([]FriendRequest, error) {
+	query := queryMap[outgoingFriendRequests]
+	if incoming {
+		query = queryMap[incomingFriendRequests]
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, query.Timeout)
+	defer cancel()
+
+	rows, err := m.db.Reader(ctx).QueryContext(ctx, query.SQL, userId, limit)
+	if err != nil {
+		return nil, fmt.Errorf("getting friend requests of user %d: %v", userId, err)
+	}
+	defer rows.Close()
+
+	requests := []FriendRequest{}
+
+	for rows.Next() {
+		r, err := scanFriendRequest(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scanning friend request: %v", err)
+		}
+
+		requests = append(requests, *r)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating through rows: %v", err)
+	}
+
+	return requests, nil
+}
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// changeStatus moves the request only from the given status, so of two
+// concurrent changes the first one wins
+func changeStatus(ctx context.Context, db execer, id int, from, to FriendRequestStatus) error {
+	res, err := db.ExecContext(ctx, queryMap[updateFriendRequestStatus].SQL, to, id, from)
+	if err != nil {
+		return fmt.Errorf("changing friend request %d status to %s: %v", id, to, err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("getting afected rows: %v", err)
+	}
+	if n == 0 {
+		return ErrFriendRequestClosed
+	}
+
+	return nil
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanFriendRequest(row rowScanner) (*FriendRequest, error) {
+	var r FriendRequest
+
+	err := row.Scan(
+		&r.ID,
+		&r.Status,
+		&r.CreatedAt,
+		&r.UpdatedAt,
+		&r.From.ID,
+		&r.From.Login,
+		&r.From.FirstName,
+		&r.From.Lastname,
+		&r.To.ID,
+		&r.To.Login,
+		&r.To.FirstName,
+		&r.To.Lastname,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &r, nil
+}
+
+// Follow subscribes the follower to the followee's posts idempotently.
+func (m *mysql) Follow(ctx context.Context, followerId, followeeId int) error {
+	query := queryMap[follow]
+	ctx, cancel := context.WithTimeout(ctx, query.Timeout)
+	defer cancel()
+
+	_, err := m.db.Writer(ctx).
+		ExecContext(ctx, query.SQL, followerId, followeeId)
+	if err != nil {
+		return fmt.Errorf("following user %d by user %d: %v", followeeId, followerId, err)
+	}
+
+	return nil
+}
+
+func (m *mysql) Unfollow(ctx context.Context, followerId, followeeId int) error {
+	query := queryMap[unfollow]
+	ctx, cancel := context.WithTimeout(ctx, query.Timeout)
+	defer cancel()
+
+	_, err := m.db.Writer(ctx).
+		ExecContext(ctx, query.SQL, followerId, followeeId)
+	if err != nil {
+		return fmt.Errorf("unfollowing user %d by user %d: %v", followeeId, followerId, err)
+	}
+
+	return nil
+}
+
+func (m *mysql) IsFollowing(ctx context.Context, followerId, followeeId int) (bool, error) {
+	query := queryMap[isFollowing]
+	ctx, cancel := context.WithTimeout(ctx, query.Timeout)
+	defer cancel()
+
+	var count int
+
+	err := m.db.Reader(ctx).QueryRowContext(ctx, query.SQL, followerId, followeeId).Scan(&count)
+	if err != nil {
+		return false, fmt.Errorf("checking user %d follows user %d: %v", followerId, followeeId, err)
+	}
+
+	return count > 0, nil
+}
+
+// FollowCounts returns the number of the user's followers and the users
+// the user follows.
+func (m *mysql) FollowCounts(ctx context.Context, userId int) (int, int, error) {
+	query := queryMap[followCounts]
+	ctx, cancel := context.WithTimeout(ctx, query.Timeout)
+	defer cancel()
+
+	var followers, following int
+
+	err := m.db.Reader(ctx).QueryRowContext(ctx, query.SQL, userId, userId).Scan(&followers, &following)
+	if err != nil {
+		return 0, 0, fmt.Errorf("counting follows of user %d: %v", userId, err)
+	}
+
+	return followers, following, nil
+}
+
+func (m *mysql) Followers(ctx context.Context, userId int) ([]User, error) {
+	return m.users(ctx, queryMap[getFollowers], "followers", userId)
+}
+
+func (m *mysql) Following(ctx context.Context, userId int) ([]User, error) {
+	return m.users(ctx, queryMap[getFollowing], "following", userId)
+}
+
+// FeedSubscribers returns the friends and followers of the user, once each.
+func (m *mysql) FeedSubscribers(ctx context.Context, userId int) ([]User, error) {
+	return m.users(ctx, queryMap[getFeedSubscribers], "feed subscribers", userId, userId)
+}
+
+// Celebrities returns the IDs of the users with at least minSubscribers
+// friends and followers. It scans all the follows and friends.
+func (m *mysql) Celebrities(ctx context.Context, minSubscribers int) ([]int, error) {
+	query := queryMap[getCelebrities]
+	ctx, cancel := context.WithTimeout(ctx, query.Timeout)
+	defer cancel()
+
+	rows, err := m.db.Reader(ctx).QueryContext(ctx, query.SQL, minSubscribers)
+	if err != nil {
+		return nil, fmt.Errorf("getting celebrities: %v", err)
+	}
+	defer rows.Close()
+
+	ids := []int{}
+
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("scanning celebrity: %v", err)
 		}
 
-		friends = append(friends, friend)
+		ids = append(ids, id)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating through celebrities: %v", err)
+	}
+
+	return ids, nil
+}
+
+// ActiveUsers returns up to limit ids of the users seen since the time,
+// which are greater than afterId, in order.
+func (m *mysql) ActiveUsers(ctx context.Context, since time.Time, afterId, limit int) ([]int, error) {
+	query := queryMap[getActiveUsers]
+	ctx, cancel := context.WithTimeout(ctx, query.Timeout)
+	defer cancel()
+
+	rows, err := m.db.Reader(ctx).QueryContext(ctx, query.SQL, since, afterId, limit)
+	if err != nil {
+		return nil, fmt.Errorf("getting active users: %v", err)
 	}
+	defer rows.Close()
+
+	ids := []int{}
+
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("scanning active user: %v", err)
+		}
 
-	return friends, nil
+		ids = append(ids, id)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating through active users: %v", err)
+	}
+
+	return ids, nil
+}
+
+func (m *mysql) CountActiveUsers(ctx context.Context, since time.Time) (int, error) {
+	query := queryMap[countActiveUsers]
+	ctx, cancel := context.WithTimeout(ctx, query.Timeout)
+	defer cancel()
+
+	var count int
+
+	err := m.db.Reader(ctx).QueryRowContext(ctx, query.SQL, since).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("counting active users: %v", err)
+	}
+
+	return count, nil
+}
+
+func (m *mysql) users(ctx context.Context, query Query, what string, args ...interface{}) ([]User, error) {
+	ctx, cancel := context.WithTimeout(ctx, query.Timeout)
+	defer cancel()
+
+	rows, err := m.db.Reader(ctx).QueryContext(ctx, query.SQL, args...)
+	if err != nil {
+		return nil, fmt.Errorf("getting %s: %v", what, err)
+	}
+	defer rows.Close()
+
+	users := scanUsers(rows, what)
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating through %s: %v", what, err)
+	}
+
+	return users, nil
 }
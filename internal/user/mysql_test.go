@@ -1,13 +1,17 @@
 package user
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/stretchr/testify/assert"
+
+	"github.com/niklod/highload-social-network/internal/cluster"
 )
 
 func Test_mysql_Create(t *testing.T) {
@@ -15,7 +19,7 @@ func Test_mysql_Create(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	repo := NewRepository(db)
+	repo := NewRepository(cluster.Single(db))
 
 	mock.ExpectExec("INSERT INTO users").WillReturnResult(sqlmock.NewResult(10, 1))
 
@@ -26,7 +30,7 @@ func Test_mysql_Create(t *testing.T) {
 		Sex:       "Мужчина",
 	}
 
-	res, err := repo.Create(testUser)
+	res, err := repo.Create(context.Background(), testUser)
 	if err != nil {
 		t.Error(err)
 	}
@@ -40,7 +44,7 @@ func Test_mysql_Create_WrongCityID(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	repo := NewRepository(db)
+	repo := NewRepository(cluster.Single(db))
 
 	dbError := fmt.Errorf("FK constraint error")
 
@@ -53,7 +57,7 @@ func Test_mysql_Create_WrongCityID(t *testing.T) {
 		Sex:       "Мужчина",
 	}
 
-	res, err := repo.Create(testUser)
+	res, err := repo.Create(context.Background(), testUser)
 
 	assert.NotNil(t, err)
 	assert.Nil(t, res)
@@ -67,12 +71,12 @@ func Test_mysql_List(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	repo := NewRepository(db)
+	repo := NewRepository(cluster.Single(db))
 	rows := sqlmock.NewRows([]string{"id", "first_name", "last_name", "age", "sex", "login", "city_id", "city_name"})
 	rows.AddRow(1, "TestFirst", "TestLast", 12, "Мужчина", "TestLogin", 1, "TestCity")
 
 	mock.ExpectQuery("ELECT u.id").WillReturnRows(rows)
-	users, err := repo.List()
+	users, err := repo.List(context.Background())
 
 	assert.NoError(t, err)
 	assert.Equal(t, 1, len(users))
@@ -83,12 +87,12 @@ func Test_mysql_GetByID(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	repo := NewRepository(db)
+	repo := NewRepository(cluster.Single(db))
 	rows := sqlmock.NewRows([]string{"id", "first_name", "last_name", "age", "sex", "login", "city_id", "city_name", "password"})
 	rows.AddRow(1, "TestFirst", "TestLast", 12, "Мужчина", "TestLogin", 1, "TestCity", "TestPassword")
 
 	mock.ExpectQuery("SELECT u.id").WithArgs(1).WillReturnRows(rows)
-	user, err := repo.GetByID(1)
+	user, err := repo.GetByID(context.Background(), 1)
 
 	assert.NoError(t, err)
 	assert.Equal(t, 1, user.ID)
@@ -99,11 +103,11 @@ func Test_mysql_GetByID_ErrNoRows(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	repo := NewRepository(db)
+	repo := NewRepository(cluster.Single(db))
 	rows := sqlmock.NewRows([]string{"id", "first_name", "last_name", "age", "sex", "login", "city_id", "city_name", "password"})
 
 	mock.ExpectQuery("SELECT u.id").WithArgs(1).WillReturnRows(rows)
-	_, err = repo.GetByID(1)
+	_, err = repo.GetByID(context.Background(), 1)
 
 	assert.NotNil(t, err)
 	if !strings.Contains(err.Error(), sql.ErrNoRows.Error()) {
@@ -116,12 +120,12 @@ func Test_mysql_GetByID_EmptyCityData(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	repo := NewRepository(db)
+	repo := NewRepository(cluster.Single(db))
 	rows := sqlmock.NewRows([]string{"id", "first_name", "last_name", "age", "sex", "login", "city_id", "city_name", "password"})
 	rows.AddRow(1, "TestFirst", "TestLast", 12, "Мужчина", "TestLogin", nil, nil, "testPasswrod")
 
 	mock.ExpectQuery("SELECT u.id").WithArgs(1).WillReturnRows(rows)
-	res, err := repo.GetByID(1)
+	res, err := repo.GetByID(context.Background(), 1)
 
 	assert.Nil(t, err)
 	assert.NotNil(t, res.City)
@@ -132,7 +136,7 @@ func Test_mysql_GetByLogin(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	repo := NewRepository(db)
+	repo := NewRepository(cluster.Single(db))
 	testLogin := "TestLogin"
 
 	rows := sqlmock.NewRows([]string{"id", "first_name", "last_name", "age", "sex", "login", "city_id", "city_name", "password"})
@@ -140,7 +144,7 @@ func Test_mysql_GetByLogin(t *testing.T) {
 
 	mock.ExpectQuery("SELECT u.id").WithArgs(testLogin).WillReturnRows(rows)
 
-	res, err := repo.GetByLogin(testLogin)
+	res, err := repo.GetByLogin(context.Background(), testLogin)
 
 	assert.Nil(t, err)
 	assert.Equal(t, res.Login, testLogin)
@@ -151,84 +155,106 @@ func Test_mysql_GetByLogin_ErrNoRows(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	repo := NewRepository(db)
+	repo := NewRepository(cluster.Single(db))
 	testLogin := "TestLogin"
 
 	rows := sqlmock.NewRows([]string{"id", "first_name", "last_name", "age", "sex", "login", "city_id", "city_name", "password"})
 
 	mock.ExpectQuery("SELECT u.id").WithArgs(testLogin).WillReturnRows(rows)
 
-	user, err := repo.GetByLogin(testLogin)
+	user, err := repo.GetByLogin(context.Background(), testLogin)
 
 	assert.Nil(t, err)
 	assert.Nil(t, user)
 }
 
-func Test_mysql_AddFriend(t *testing.T) {
+func Test_mysql_AcceptFriendRequest(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	if err != nil {
 		t.Fatal(err)
 	}
-	repo := NewRepository(db)
+	repo := NewRepository(cluster.Single(db))
 	testUserId := 1
 	testFriendId := 2
 
-	res := sqlmock.NewResult(1, 2)
-	mock.ExpectExec("INSERT INTO friends ").WithArgs(testUserId, testFriendId, testFriendId, testUserId).WillReturnResult(res)
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE friend_requests").WithArgs(FriendRequestAccepted, 5, FriendRequestSent).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO friends ").WithArgs(testUserId, testFriendId, testFriendId, testUserId).WillReturnResult(sqlmock.NewResult(1, 2))
+	mock.ExpectCommit()
 
-	err = repo.AddFriend(testUserId, testFriendId)
+	err = repo.AcceptFriendRequest(context.Background(), &FriendRequest{ID: 5, From: User{ID: testUserId}, To: User{ID: testFriendId}})
 
 	assert.Nil(t, err)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func Test_mysql_AcceptFriendRequest_Closed(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := NewRepository(cluster.Single(db))
+
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE friend_requests").WithArgs(FriendRequestAccepted, 5, FriendRequestSent).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+
+	err = repo.AcceptFriendRequest(context.Background(), &FriendRequest{ID: 5, From: User{ID: 1}, To: User{ID: 2}})
+
+	assert.Equal(t, ErrFriendRequestClosed, err)
+	assert.Nil(t, mock.ExpectationsWereMet())
 }
 
-func Test_mysql_AddFriend_zeroLinesAdded(t *testing.T) {
+func Test_mysql_AcceptFriendRequest_zeroLinesAdded(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	if err != nil {
 		t.Fatal(err)
 	}
-	repo := NewRepository(db)
+	repo := NewRepository(cluster.Single(db))
 	testUserId := 1
 	testFriendId := 2
-	expectedError := fmt.Errorf("test error")
 
-	res := sqlmock.NewResult(0, 0)
-	mock.ExpectExec("INSERT INTO friends ").WithArgs(testUserId, testFriendId).WillReturnResult(res)
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE friend_requests").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO friends ").WithArgs(testUserId, testFriendId, testFriendId, testUserId).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
 
-	err = repo.AddFriend(testUserId, testFriendId)
+	err = repo.AcceptFriendRequest(context.Background(), &FriendRequest{ID: 5, From: User{ID: testUserId}, To: User{ID: testFriendId}})
 
 	assert.NotNil(t, err)
-	assert.Error(t, err, expectedError)
+	assert.Nil(t, mock.ExpectationsWereMet())
 }
 
-func Test_mysql_AddFriend_DatabaseError(t *testing.T) {
+func Test_mysql_AcceptFriendRequest_DatabaseError(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	if err != nil {
 		t.Fatal(err)
 	}
-	repo := NewRepository(db)
+	repo := NewRepository(cluster.Single(db))
 	testUserId := 1
 	testFriendId := 2
 	expectedError := fmt.Errorf("test error")
 
-	mock.ExpectExec("INSERT INTO friends ").WithArgs(testUserId, testFriendId).WillReturnError(expectedError)
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE friend_requests").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO friends ").WithArgs(testUserId, testFriendId, testFriendId, testUserId).WillReturnError(expectedError)
+	mock.ExpectRollback()
 
-	err = repo.AddFriend(testUserId, testFriendId)
+	err = repo.AcceptFriendRequest(context.Background(), &FriendRequest{ID: 5, From: User{ID: testUserId}, To: User{ID: testFriendId}})
 
 	assert.NotNil(t, err)
-	assert.Error(t, err, expectedError)
+	assert.Nil(t, mock.ExpectationsWereMet())
 }
 
-func Test_mysql_AddFriend_SameIDs(t *testing.T) {
+func Test_mysql_AcceptFriendRequest_SameIDs(t *testing.T) {
 	db, _, err := sqlmock.New()
 	if err != nil {
 		t.Fatal(err)
 	}
-	repo := NewRepository(db)
-	testUserId := 1
-	testFriendId := 1
+	repo := NewRepository(cluster.Single(db))
 	expectedError := fmt.Errorf("user ID and friend ID are equal")
 
-	err = repo.AddFriend(testUserId, testFriendId)
+	err = repo.AcceptFriendRequest(context.Background(), &FriendRequest{ID: 5, From: User{ID: 1}, To: User{ID: 1}})
 
 	assert.NotNil(t, err)
 	assert.Error(t, err, expectedError)
@@ -239,14 +265,14 @@ func Test_mysql_DeleteFriend(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	repo := NewRepository(db)
+	repo := NewRepository(cluster.Single(db))
 	testUserId := 1
 	testFriendId := 2
 
 	res := sqlmock.NewResult(1, 1)
 	mock.ExpectExec("DELETE FROM friends ").WithArgs(testUserId, testFriendId, testFriendId, testUserId).WillReturnResult(res)
 
-	err = repo.DeleteFriend(testUserId, testFriendId)
+	err = repo.DeleteFriend(context.Background(), testUserId, testFriendId)
 
 	assert.Nil(t, err)
 }
@@ -256,7 +282,7 @@ func Test_mysql_DeleteFriend_zeroLinesAdded(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	repo := NewRepository(db)
+	repo := NewRepository(cluster.Single(db))
 	testUserId := 1
 	testFriendId := 2
 	expectedError := fmt.Errorf("test error")
@@ -264,7 +290,7 @@ func Test_mysql_DeleteFriend_zeroLinesAdded(t *testing.T) {
 	res := sqlmock.NewResult(0, 0)
 	mock.ExpectExec("DELETE FROM friends ").WithArgs(testUserId, testFriendId).WillReturnResult(res)
 
-	err = repo.DeleteFriend(testUserId, testFriendId)
+	err = repo.DeleteFriend(context.Background(), testUserId, testFriendId)
 
 	assert.NotNil(t, err)
 	assert.Error(t, err, expectedError)
@@ -275,14 +301,14 @@ func Test_mysql_DeleteFriend_DatabaseError(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	repo := NewRepository(db)
+	repo := NewRepository(cluster.Single(db))
 	testUserId := 1
 	testFriendId := 2
 	expectedError := fmt.Errorf("test error")
 
 	mock.ExpectExec("DELETE FROM friends ").WithArgs(testUserId, testFriendId).WillReturnError(expectedError)
 
-	err = repo.DeleteFriend(testUserId, testFriendId)
+	err = repo.DeleteFriend(context.Background(), testUserId, testFriendId)
 
 	assert.NotNil(t, err)
 	assert.Error(t, err, expectedError)
@@ -293,7 +319,7 @@ func Test_mysql_Friends_OneRow(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	repo := NewRepository(db)
+	repo := NewRepository(cluster.Single(db))
 	testUserId := 1
 
 	rows := sqlmock.NewRows([]string{"id", "first_name", "last_name", "age", "sex", "login", "city_id", "city_name"})
@@ -301,7 +327,7 @@ func Test_mysql_Friends_OneRow(t *testing.T) {
 
 	mock.ExpectQuery("SELECT u.id").WithArgs(testUserId).WillReturnRows(rows)
 
-	friends, err := repo.Friends(testUserId)
+	friends, err := repo.Friends(context.Background(), testUserId)
 
 	assert.Nil(t, err)
 	assert.Equal(t, 1, len(friends))
@@ -313,7 +339,7 @@ func Test_mysql_Friends_TwoRows(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	repo := NewRepository(db)
+	repo := NewRepository(cluster.Single(db))
 	testUserId := 1
 
 	rows := sqlmock.NewRows([]string{"id", "first_name", "last_name", "age", "sex", "login", "city_id", "city_name"})
@@ -322,7 +348,7 @@ func Test_mysql_Friends_TwoRows(t *testing.T) {
 
 	mock.ExpectQuery("SELECT u.id").WithArgs(testUserId).WillReturnRows(rows)
 
-	friends, err := repo.Friends(testUserId)
+	friends, err := repo.Friends(context.Background(), testUserId)
 
 	assert.Nil(t, err)
 	assert.Equal(t, 2, len(friends))
@@ -334,14 +360,14 @@ func Test_mysql_Friends_ZeroRows(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	repo := NewRepository(db)
+	repo := NewRepository(cluster.Single(db))
 	testUserId := 1
 
 	rows := sqlmock.NewRows([]string{"id", "first_name", "last_name", "age", "sex", "login", "city_id", "city_name"})
 
 	mock.ExpectQuery("SELECT u.id").WithArgs(testUserId).WillReturnRows(rows)
 
-	friends, err := repo.Friends(testUserId)
+	friends, err := repo.Friends(context.Background(), testUserId)
 
 	assert.Nil(t, err)
 	assert.NotNil(t, friends)
@@ -354,13 +380,13 @@ func Test_mysql_Friends_Error(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	repo := NewRepository(db)
+	repo := NewRepository(cluster.Single(db))
 	testUserId := 1
 	testError := fmt.Errorf("test error")
 
 	mock.ExpectQuery("SELECT u.id").WithArgs(testUserId).WillReturnError(testError)
 
-	friends, err := repo.Friends(testUserId)
+	friends, err := repo.Friends(context.Background(), testUserId)
 
 	assert.Nil(t, friends)
 	assert.Error(t, err, testError)
@@ -377,13 +403,13 @@ func Test_mysql_GetByFirstAndLastName(t *testing.T) {
 	testLastNameArg := "TestLastName" + "%"
 	testLastName := "TestLastName"
 
-	repo := NewRepository(db)
+	repo := NewRepository(cluster.Single(db))
 
 	rows := sqlmock.NewRows([]string{"id", "first_name", "last_name", "age", "sex", "login", "city_id", "city_name"})
 	rows.AddRow(1, "TestFirst", "TestLast", 12, "Мужчина", "TestLogin", 1, "TestCity")
 
 	mock.ExpectQuery("SELECT u.id").WithArgs(testLastNameArg, testFirstNameArg).WillReturnRows(rows)
-	users, err := repo.GetByFirstAndLastName(testFirstName, testLastName)
+	users, err := repo.GetByFirstAndLastName(context.Background(), testFirstName, testLastName)
 
 	assert.NoError(t, err)
 	assert.Equal(t, 1, len(users))
@@ -400,12 +426,12 @@ func Test_mysql_GetByFirstAndLastName_NoRows(t *testing.T) {
 	testLastNameArg := "TestLastName" + "%"
 	testLastName := "TestLastName"
 
-	repo := NewRepository(db)
+	repo := NewRepository(cluster.Single(db))
 
 	rows := sqlmock.NewRows([]string{"id", "first_name", "last_name", "age", "sex", "login", "city_id", "city_name"})
 
 	mock.ExpectQuery("SELECT u.id").WithArgs(testFirstNameArg, testLastNameArg).WillReturnRows(rows)
-	users, err := repo.GetByFirstAndLastName(testLastName, testFirstName)
+	users, err := repo.GetByFirstAndLastName(context.Background(), testLastName, testFirstName)
 
 	assert.NoError(t, err)
 	assert.Equal(t, 0, len(users))
@@ -424,11 +450,126 @@ func Test_mysql_GetByFirstAndLastName_Error(t *testing.T) {
 	testLastNameArg := "TestLastName" + "%"
 	testLastName := "TestLastName"
 
-	repo := NewRepository(db)
+	repo := NewRepository(cluster.Single(db))
 
 	mock.ExpectQuery("SELECT u.id").WithArgs(testFirstNameArg, testLastNameArg).WillReturnError(testError)
-	_, err = repo.GetByFirstAndLastName(testLastName, testFirstName)
+	_, err = repo.GetByFirstAndLastName(context.Background(), testLastName, testFirstName)
 
 	assert.NotNil(t, err)
 	assert.Error(t, err, testError)
 }
+
+func Test_mysql_ReadsFromReplica_StickyAfterWrite(t *testing.T) {
+	primary, primaryMock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	replica, replicaMock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := NewRepository(cluster.New(primary, []*sql.DB{replica}, time.Minute))
+	columns := []string{"id", "first_name", "last_name", "age", "sex", "login", "city_id", "city_name"}
+
+	replicaMock.ExpectQuery("SELECT").WithArgs(1).WillReturnRows(sqlmock.NewRows(columns))
+	primaryMock.ExpectBegin()
+	primaryMock.ExpectExec("UPDATE friend_requests").WithArgs(FriendRequestAccepted, 5, FriendRequestSent).WillReturnResult(sqlmock.NewResult(0, 1))
+	primaryMock.ExpectExec("INSERT INTO friends").WithArgs(1, 2, 2, 1).WillReturnResult(sqlmock.NewResult(0, 2))
+	primaryMock.ExpectCommit()
+	primaryMock.ExpectQuery("SELECT").WithArgs(1).WillReturnRows(sqlmock.NewRows(columns).AddRow(2, "First", "Last", 20, "Мужчина", "friend", 1, "Москва"))
+	replicaMock.ExpectQuery("SELECT").WithArgs(3).WillReturnRows(sqlmock.NewRows(columns))
+
+	session := cluster.WithSession(context.Background(), time.Time{}, nil)
+
+	_, err = repo.Friends(session, 1)
+	assert.Nil(t, err)
+
+	assert.Nil(t, repo.AcceptFriendRequest(session, &FriendRequest{ID: 5, From: User{ID: 1}, To: User{ID: 2}}))
+
+	// The session which has just added a friend sees it even if the replica
+	// lags, the other sessions read from the replica
+	friends, err := repo.Friends(session, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(friends))
+
+	_, err = repo.Friends(context.Background(), 3)
+	assert.Nil(t, err)
+
+	assert.Nil(t, primaryMock.ExpectationsWereMet())
+	assert.Nil(t, replicaMock.ExpectationsWereMet())
+}
+
+func Test_mysql_Follow(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := NewRepository(cluster.Single(db))
+
+	mock.ExpectExec("INSERT IGNORE INTO follows").WithArgs(1, 2).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	err = repo.Follow(context.Background(), 1, 2)
+
+	assert.Nil(t, err)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func Test_mysql_FollowCounts(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := NewRepository(cluster.Single(db))
+
+	mock.ExpectQuery("SELECT \\(SELECT COUNT").WithArgs(1, 1).WillReturnRows(sqlmock.NewRows([]string{"followers", "following"}).AddRow(3, 5))
+
+	followers, following, err := repo.FollowCounts(context.Background(), 1)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 3, followers)
+	assert.Equal(t, 5, following)
+}
+
+func Test_mysql_FeedSubscribers(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := NewRepository(cluster.Single(db))
+	columns := []string{"id", "first_name", "last_name", "age", "sex", "login", "city_id", "city_name"}
+
+	rows := sqlmock.NewRows(columns).
+		AddRow(2, "Friend", "Last", 20, "Мужчина", "friend", 1, "Москва").
+		AddRow(3, "Follower", "Last", 30, "Женщина", "follower", nil, nil)
+	mock.ExpectQuery("SELECT u.id(.|\n)*UNION").WithArgs(1, 1).WillReturnRows(rows)
+
+	users, err := repo.FeedSubscribers(context.Background(), 1)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(users))
+	assert.Equal(t, "follower", users[1].Login)
+	assert.Equal(t, 0, users[1].City.ID)
+}
+
+func Test_mysql_ActiveUsers(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := NewRepository(cluster.Single(db))
+	since := time.Now().Add(-time.Hour)
+
+	mock.ExpectQuery("SELECT COUNT\\(DISTINCT user_id\\) FROM sessions").WithArgs(since).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+	mock.ExpectQuery("SELECT DISTINCT user_id").WithArgs(since, 4, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(7).AddRow(9))
+
+	count, err := repo.CountActiveUsers(context.Background(), since)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, count)
+
+	ids, err := repo.ActiveUsers(context.Background(), since, 4, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, []int{7, 9}, ids)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,78 @@
+package post
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RefsCodec stores the feed as "id:authorId:unixTime" items, see
+// cache.Codec.
+type RefsCodec struct{}
+
+func (RefsCodec) Encode(v interface{}) ([][]byte, error) {
+	refs, ok := v.(Refs)
+	if !ok {
+		return nil, fmt.Errorf("post.RefsCodec - %T isn't refs", v)
+	}
+
+	items := make([][]byte, len(refs))
+	for i, r := range refs {
+		items[i] = []byte(strconv.Itoa(r.ID) + ":" + strconv.Itoa(r.AuthorID) + ":" + strconv.FormatInt(r.CreatedAt.Unix(), 10))
+	}
+
+	return items, nil
+}
+
+func (RefsCodec) Decode(items [][]byte) (interface{}, error) {
+	refs := make(Refs, len(items))
+	for i, b := range items {
+		parts := strings.Split(string(b), ":")
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("post.RefsCodec - malformed ref %q", b)
+		}
+
+		id, err1 := strconv.Atoi(parts[0])
+		authorId, err2 := strconv.Atoi(parts[1])
+		sec, err3 := strconv.ParseInt(parts[2], 10, 64)
+		if err1 != nil || err2 != nil || err3 != nil {
+			return nil, fmt.Errorf("post.RefsCodec - malformed ref %q", b)
+		}
+
+		refs[i] = Ref{ID: id, AuthorID: authorId, CreatedAt: time.Unix(sec, 0).UTC()}
+	}
+
+	return refs, nil
+}
+
+// PostCodec stores the post as a single JSON item, see cache.Codec.
+type PostCodec struct{}
+
+func (PostCodec) Encode(v interface{}) ([][]byte, error) {
+	p, ok := v.(Post)
+	if !ok {
+		return nil, fmt.Errorf("post.PostCodec - %T isn't a post", v)
+	}
+
+	b, err := p.AsByteJSON()
+	if err != nil {
+		return nil, fmt.Errorf("post.PostCodec - marshaling post %d: %v", p.ID, err)
+	}
+
+	return [][]byte{b}, nil
+}
+
+func (PostCodec) Decode(items [][]byte) (interface{}, error) {
+	if len(items) != 1 {
+		return nil, fmt.Errorf("post.PostCodec - %d items instead of one", len(items))
+	}
+
+	var p Post
+	if err := json.Unmarshal(items[0], &p); err != nil {
+		return nil, fmt.Errorf("post.PostCodec - unmarshaling post: %v", err)
+	}
+
+	return p, nil
+}
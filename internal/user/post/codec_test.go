@@ -0,0 +1,44 @@
+package post
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRefsCodec_RoundTrip(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Second)
+	refs := Refs{{ID: 2, AuthorID: 7, CreatedAt: now}, {ID: 1, AuthorID: 8, CreatedAt: now.Add(-time.Second)}}
+
+	items, err := RefsCodec{}.Encode(refs)
+	assert.Nil(t, err)
+	assert.Equal(t, "2:7:"+strconv.FormatInt(now.Unix(), 10), string(items[0]))
+
+	v, err := RefsCodec{}.Decode(items)
+	assert.Nil(t, err)
+	assert.Equal(t, refs, v)
+
+	_, err = RefsCodec{}.Decode([][]byte{[]byte("2:7")})
+	assert.NotNil(t, err)
+}
+
+func TestPostCodec_RoundTrip(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Second)
+	p := Post{ID: 2, CreatedAt: now, UpdatedAt: now, Body: "body", Author: Author{ID: 7, Login: "login"}, CommentsCount: 3}
+
+	items, err := PostCodec{}.Encode(p)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(items))
+
+	v, err := PostCodec{}.Decode(items)
+	assert.Nil(t, err)
+
+	// Counters aren't cached
+	p.CommentsCount = 0
+	assert.Equal(t, p, v)
+
+	_, err = PostCodec{}.Encode(Feed{p})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,353 @@
+package comment
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/niklod/highload-social-network/internal/server"
+	"github.com/niklod/highload-social-network/internal/user"
+	"github.com/niklod/highload-social-network/internal/user/post"
+)
+
+type CommentRequest struct {
+	Body     string `form:"body" json:"body" binding:"required"`
+	ParentID int    `form:"parentId" json:"parentId"`
+}
+
+type CommentUpdateRequest struct {
+	Body string `form:"body" json:"body" binding:"required"`
+}
+
+type ViewData struct {
+	AuthenticatedUser *user.User
+	Post              *post.Post
+	Comments          []commentView
+	Errors            []interface{}
+}
+
+// commentView carries the page data the nested comment templates need.
+type commentView struct {
+	*Comment
+	PostURL  string
+	Own      bool
+	CanReply bool
+	Thread   []commentView
+}
+
+type CommentHandler struct {
+	commentService *Service
+	postService    *post.Service
+}
+
+func NewHandler(commentService *Service, postService *post.Service) *CommentHandler {
+	return &CommentHandler{
+		commentService: commentService,
+		postService:    postService,
+	}
+}
+
+func (h *CommentHandler) HandlePost(c *gin.Context) {
+	authUser := user.AuthenticatedUser(c)
+
+	p, ok := h.pagePost(c, authUser)
+	if !ok {
+		return
+	}
+
+	comments, err := h.commentService.Comments(c.Request.Context(), p.ID)
+	if err != nil {
+		log.Printf("post page, getting comments: %v", err)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	c.HTML(http.StatusOK, "post", ViewData{
+		AuthenticatedUser: authUser,
+		Post:              p,
+		Comments:          newCommentViews(comments, postURL(p), authUser),
+	})
+}
+
+func (h *CommentHandler) HandleAddComment(c *gin.Context) {
+	authUser := user.AuthenticatedUser(c)
+	if authUser == nil {
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
+
+	p, ok := h.pagePost(c, authUser)
+	if !ok {
+		return
+	}
+
+	parentId, _ := strconv.Atoi(c.PostForm("parentId"))
+
+	_, err := h.commentService.Add(c.Request.Context(), p.ID, parentId, author(authUser), c.PostForm("body"))
+	switch err {
+	case nil, ErrEmptyComment, ErrCommentTooBig:
+	case ErrPostNotFound, ErrParentNotFound:
+		c.Status(http.StatusNotFound)
+		return
+	default:
+		log.Printf("adding comment: %v", err)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	c.Redirect(http.StatusSeeOther, postURL(p))
+}
+
+func (h *CommentHandler) HandleEditComment(c *gin.Context) {
+	h.changeComment(c, "editing comment", func(ctx context.Context, authorId, commentId int) error {
+		_, err := h.commentService.Update(ctx, authorId, commentId, c.PostForm("body"))
+		if err == ErrEmptyComment || err == ErrCommentTooBig {
+			return nil
+		}
+		return err
+	})
+}
+
+func (h *CommentHandler) HandleDeleteComment(c *gin.Context) {
+	h.changeComment(c, "deleting comment", h.commentService.Delete)
+}
+
+// changeComment runs the change of the authenticated user's comment from the
+// :commentId parameter and redirects back to the post page.
+func (h *CommentHandler) changeComment(c *gin.Context, op string, change func(ctx context.Context, authorId, commentId int) error) {
+	authUser := user.AuthenticatedUser(c)
+	if authUser == nil {
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
+
+	commentId, err := strconv.Atoi(c.Param("commentId"))
+	if err != nil {
+		c.Status(http.StatusNotFound)
+		return
+	}
+
+	err = change(c.Request.Context(), authUser.ID, commentId)
+	switch err {
+	case nil:
+	case ErrCommentNotFound:
+		c.Status(http.StatusNotFound)
+		return
+	case ErrNotAuthor:
+		c.Status(http.StatusForbidden)
+		return
+	default:
+		log.Printf("%s: %v", op, err)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	c.Redirect(http.StatusSeeOther, fmt.Sprintf("/user/%s/posts/%s", c.Param("login"), c.Param("id")))
+}
+
+// pagePost loads the post from the :id parameter written by the :login user.
+func (h *CommentHandler) pagePost(c *gin.Context, authUser *user.User) (*post.Post, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.Status(http.StatusNotFound)
+		return nil, false
+	}
+
+	viewerId := 0
+	if authUser != nil {
+		viewerId = authUser.ID
+	}
+
+	p, err := h.postService.Post(c.Request.Context(), id, viewerId)
+	if err != nil {
+		log.Printf("post page, getting post: %v", err)
+		c.Status(http.StatusInternalServerError)
+		return nil, false
+	}
+	if p == nil || p.Author.Login != c.Param("login") {
+		c.Status(http.StatusNotFound)
+		return nil, false
+	}
+
+	return p, true
+}
+
+func (h *CommentHandler) HandleAPIComments(c *gin.Context) {
+	p, ok := h.apiPost(c)
+	if !ok {
+		return
+	}
+
+	comments, err := h.commentService.Comments(c.Request.Context(), p.ID)
+	if err != nil {
+		log.Printf("api comments: %v", err)
+		server.RespondError(c, http.StatusInternalServerError, server.ErrCodeInternal, "Внутренняя ошибка сервера")
+		return
+	}
+
+	server.RespondData(c, http.StatusOK, comments)
+}
+
+func (h *CommentHandler) HandleAPIAddComment(c *gin.Context) {
+	authUser, ok := requireAPIUser(c)
+	if !ok {
+		return
+	}
+
+	p, ok := h.apiPost(c)
+	if !ok {
+		return
+	}
+
+	req := &CommentRequest{}
+	if err := c.ShouldBind(req); err != nil {
+		server.RespondError(c, http.StatusBadRequest, server.ErrCodeBadRequest, "Некорректное тело запроса", err.Error())
+		return
+	}
+
+	comment, err := h.commentService.Add(c.Request.Context(), p.ID, req.ParentID, author(authUser), req.Body)
+	if err != nil {
+		respondCommentError(c, "api add comment", err)
+		return
+	}
+
+	server.RespondData(c, http.StatusCreated, comment)
+}
+
+func (h *CommentHandler) HandleAPIUpdateComment(c *gin.Context) {
+	authUser, ok := requireAPIUser(c)
+	if !ok {
+		return
+	}
+
+	commentId, ok := apiCommentID(c)
+	if !ok {
+		return
+	}
+
+	req := &CommentUpdateRequest{}
+	if err := c.ShouldBind(req); err != nil {
+		server.RespondError(c, http.StatusBadRequest, server.ErrCodeBadRequest, "Некорректное тело запроса", err.Error())
+		return
+	}
+
+	comment, err := h.commentService.Update(c.Request.Context(), authUser.ID, commentId, req.Body)
+	if err != nil {
+		respondCommentError(c, "api update comment", err)
+		return
+	}
+
+	server.RespondData(c, http.StatusOK, comment)
+}
+
+func (h *CommentHandler) HandleAPIDeleteComment(c *gin.Context) {
+	authUser, ok := requireAPIUser(c)
+	if !ok {
+		return
+	}
+
+	commentId, ok := apiCommentID(c)
+	if !ok {
+		return
+	}
+
+	if err := h.commentService.Delete(c.Request.Context(), authUser.ID, commentId); err != nil {
+		respondCommentError(c, "api delete comment", err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// apiPost loads the post from the :id path parameter.
+func (h *CommentHandler) apiPost(c *gin.Context) (*post.Post, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil || id <= 0 {
+		server.RespondError(c, http.StatusNotFound, server.ErrCodeNotFound, "Пост не найден")
+		return nil, false
+	}
+
+	p, err := h.postService.Post(c.Request.Context(), id, 0)
+	if err != nil {
+		log.Printf("api comments, getting post: %v", err)
+		server.RespondError(c, http.StatusInternalServerError, server.ErrCodeInternal, "Внутренняя ошибка сервера")
+		return nil, false
+	}
+	if p == nil {
+		server.RespondError(c, http.StatusNotFound, server.ErrCodeNotFound, "Пост не найден")
+		return nil, false
+	}
+
+	return p, true
+}
+
+func apiCommentID(c *gin.Context) (int, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil || id <= 0 {
+		server.RespondError(c, http.StatusNotFound, server.ErrCodeNotFound, "Комментарий не найден")
+		return 0, false
+	}
+
+	return id, true
+}
+
+func respondCommentError(c *gin.Context, op string, err error) {
+	switch err {
+	case ErrEmptyComment, ErrCommentTooBig:
+		server.RespondError(c, http.StatusUnprocessableEntity, server.ErrCodeValidation, "Ошибка валидации", err.Error())
+	case ErrPostNotFound:
+		server.RespondError(c, http.StatusNotFound, server.ErrCodeNotFound, "Пост не найден")
+	case ErrCommentNotFound:
+		server.RespondError(c, http.StatusNotFound, server.ErrCodeNotFound, "Комментарий не найден")
+	case ErrParentNotFound:
+		server.RespondError(c, http.StatusUnprocessableEntity, server.ErrCodeValidation, "Ошибка валидации", err.Error())
+	case ErrNotAuthor:
+		server.RespondError(c, http.StatusForbidden, server.ErrCodeForbidden, "Изменять комментарий может только автор")
+	default:
+		log.Printf("%s: %v", op, err)
+		server.RespondError(c, http.StatusInternalServerError, server.ErrCodeInternal, "Внутренняя ошибка сервера")
+	}
+}
+
+func requireAPIUser(c *gin.Context) (*user.User, bool) {
+	authUser := user.AuthenticatedUser(c)
+	if authUser == nil {
+		server.RespondError(c, http.StatusUnauthorized, server.ErrCodeUnauthorized, "Требуется авторизация")
+		return nil, false
+	}
+
+	return authUser, true
+}
+
+func newCommentViews(comments []*Comment, url string, authUser *user.User) []commentView {
+	views := make([]commentView, 0, len(comments))
+
+	for _, c := range comments {
+		views = append(views, commentView{
+			Comment:  c,
+			PostURL:  url,
+			Own:      authUser != nil && !c.Deleted && c.Author.ID == authUser.ID,
+			CanReply: authUser != nil && !c.Deleted,
+			Thread:   newCommentViews(c.Replies, url, authUser),
+		})
+	}
+
+	return views
+}
+
+func postURL(p *post.Post) string {
+	return fmt.Sprintf("/user/%s/posts/%d", p.Author.Login, p.ID)
+}
+
+func author(u *user.User) Author {
+	return Author{
+		ID:        u.ID,
+		Login:     u.Login,
+		FirstName: u.FirstName,
+		LastName:  u.Lastname,
+	}
+}
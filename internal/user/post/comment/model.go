@@ -0,0 +1,70 @@
+package comment
+
+import "time"
+
+type Author struct {
+	ID        int    `json:"id"`
+	Login     string `json:"login"`
+	FirstName string `json:"firstName"`
+	LastName  string `json:"lastName"`
+}
+
+// Comment is a comment on a post or a reply to another comment of the same
+// post, ParentID is zero for top level comments.
+type Comment struct {
+	ID        int        `json:"id"`
+	PostID    int        `json:"postId"`
+	ParentID  int        `json:"parentId,omitempty"`
+	Author    Author     `json:"author"`
+	Body      string     `json:"body"`
+	CreatedAt time.Time  `json:"createdAt"`
+	UpdatedAt time.Time  `json:"updatedAt"`
+	Deleted   bool       `json:"deleted"`
+	Replies   []*Comment `json:"replies"`
+}
+
+// Tree builds the threads of the comments ordered by creation time. Deleted
+// comments stay in place while they have replies, their body is hidden.
+func Tree(comments []Comment) []*Comment {
+	nodes := make(map[int]*Comment, len(comments))
+	for i := range comments {
+		c := comments[i]
+		c.Replies = []*Comment{}
+		if c.Deleted {
+			c.Body = ""
+		}
+		nodes[c.ID] = &c
+	}
+
+	roots := []*Comment{}
+
+	for i := range comments {
+		c := nodes[comments[i].ID]
+
+		parent, ok := nodes[c.ParentID]
+		if c.ParentID == 0 || !ok {
+			roots = append(roots, c)
+			continue
+		}
+
+		parent.Replies = append(parent.Replies, c)
+	}
+
+	return prune(roots)
+}
+
+// prune drops deleted comments which have no replies left
+func prune(comments []*Comment) []*Comment {
+	res := comments[:0]
+
+	for _, c := range comments {
+		c.Replies = prune(c.Replies)
+		if c.Deleted && len(c.Replies) == 0 {
+			continue
+		}
+
+		res = append(res, c)
+	}
+
+	return res
+}
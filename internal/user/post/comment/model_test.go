@@ -0,0 +1,33 @@
+package comment
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTree(t *testing.T) {
+	comments := []Comment{
+		{ID: 1, Body: "first"},
+		{ID: 2, ParentID: 1, Body: "reply", Deleted: true},
+		{ID: 3, ParentID: 2, Body: "reply to deleted"},
+		{ID: 4, Body: "deleted leaf", Deleted: true},
+		{ID: 5, ParentID: 1, Body: "second reply"},
+		{ID: 6, Body: "last"},
+	}
+
+	tree := Tree(comments)
+
+	assert.Equal(t, 2, len(tree))
+	assert.Equal(t, 1, tree[0].ID)
+	assert.Equal(t, 6, tree[1].ID)
+
+	// The deleted reply keeps its own reply but not the body
+	replies := tree[0].Replies
+	assert.Equal(t, 2, len(replies))
+	assert.Equal(t, 2, replies[0].ID)
+	assert.Empty(t, replies[0].Body)
+	assert.Equal(t, 3, replies[0].Replies[0].ID)
+	assert.Equal(t, 5, replies[1].ID)
+	assert.NotNil(t, tree[1].Replies)
+}
@@ -0,0 +1,151 @@
+package comment
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/niklod/highload-social-network/internal/cluster"
+)
+
+type mysql struct {
+	db *cluster.Cluster
+}
+
+func NewRepository(db *cluster.Cluster) repository {
+	return &mysql{db: db}
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanComment(row scanner) (Comment, error) {
+	var c Comment
+	var parentID sql.NullInt64
+
+	err := row.Scan(
+		&c.ID,
+		&c.PostID,
+		&parentID,
+		&c.Body,
+		&c.CreatedAt,
+		&c.UpdatedAt,
+		&c.Deleted,
+		&c.Author.ID,
+		&c.Author.Login,
+		&c.Author.FirstName,
+		&c.Author.LastName,
+	)
+	c.ParentID = int(parentID.Int64)
+
+	return c, err
+}
+
+func (m *mysql) Add(ctx context.Context, c *Comment) error {
+	query := queryMap[insertComment]
+	ctx, cancel := context.WithTimeout(ctx, query.Timeout)
+	defer cancel()
+
+	var parentID sql.NullInt64
+	if c.ParentID > 0 {
+		parentID = sql.NullInt64{Int64: int64(c.ParentID), Valid: true}
+	}
+
+	res, err := m.db.Writer(ctx).ExecContext(ctx, query.SQL, c.PostID, c.Author.ID, parentID, c.Body)
+	if err != nil {
+		return fmt.Errorf("adding comment to post %d: %v", c.PostID, err)
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("getting last insert id: %v", err)
+	}
+
+	c.ID = int(id)
+
+	return nil
+}
+
+// GetByID returns the comment, deleted ones included, or nil if there is
+// no such comment.
+func (m *mysql) GetByID(ctx context.Context, id int) (*Comment, error) {
+	query := queryMap[getComment]
+	ctx, cancel := context.WithTimeout(ctx, query.Timeout)
+	defer cancel()
+
+	// Read from the primary, the comment is about to be changed or replied
+	c, err := scanComment(m.db.Primary().QueryRowContext(ctx, query.SQL, id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("getting comment %d: %v", id, err)
+	}
+
+	return &c, nil
+}
+
+func (m *mysql) CommentsByPost(ctx context.Context, postId, limit int) ([]Comment, error) {
+	query := queryMap[commentsByPost]
+	ctx, cancel := context.WithTimeout(ctx, query.Timeout)
+	defer cancel()
+
+	rows, err := m.db.Reader(ctx).QueryContext(ctx, query.SQL, postId, limit)
+	if err != nil {
+		return nil, fmt.Errorf("getting comments of post %d: %v", postId, err)
+	}
+	defer rows.Close()
+
+	comments := []Comment{}
+
+	for rows.Next() {
+		c, err := scanComment(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scanning comment: %v", err)
+		}
+
+		comments = append(comments, c)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating through comments: %v", err)
+	}
+
+	return comments, nil
+}
+
+func (m *mysql) Update(ctx context.Context, c *Comment) error {
+	query := queryMap[updateComment]
+	ctx, cancel := context.WithTimeout(ctx, query.Timeout)
+	defer cancel()
+
+	// No affected rows check, MySQL doesn't count a row with the same body
+	_, err := m.db.Writer(ctx).ExecContext(ctx, query.SQL, c.Body, c.ID, c.Author.ID)
+	if err != nil {
+		return fmt.Errorf("updating comment %d: %v", c.ID, err)
+	}
+
+	return nil
+}
+
+func (m *mysql) Delete(ctx context.Context, c *Comment) error {
+	query := queryMap[deleteComment]
+	ctx, cancel := context.WithTimeout(ctx, query.Timeout)
+	defer cancel()
+
+	res, err := m.db.Writer(ctx).ExecContext(ctx, query.SQL, c.ID, c.Author.ID)
+	if err != nil {
+		return fmt.Errorf("deleting comment %d: %v", c.ID, err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("getting affected rows: %v", err)
+	}
+	if n == 0 {
+		return ErrCommentNotFound
+	}
+
+	return nil
+}
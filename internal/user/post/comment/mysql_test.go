@@ -0,0 +1,99 @@
+package comment
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/niklod/highload-social-network/internal/cluster"
+)
+
+var commentColumns = []string{"id", "post_id", "parent_id", "body", "created_at", "updated_at", "deleted", "id", "login", "first_name", "last_name"}
+
+func Test_mysql_Add_Reply(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := NewRepository(cluster.Single(db))
+
+	mock.ExpectExec("INSERT INTO comments").WithArgs(5, 22, int64(3), "reply").WillReturnResult(sqlmock.NewResult(9, 1))
+
+	c := &Comment{PostID: 5, ParentID: 3, Author: Author{ID: 22}, Body: "reply"}
+	err = repo.Add(context.Background(), c)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 9, c.ID)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func Test_mysql_Add_TopLevel(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := NewRepository(cluster.Single(db))
+
+	mock.ExpectExec("INSERT INTO comments").WithArgs(5, 22, nil, "hello").WillReturnResult(sqlmock.NewResult(1, 1))
+
+	err = repo.Add(context.Background(), &Comment{PostID: 5, Author: Author{ID: 22}, Body: "hello"})
+
+	assert.Nil(t, err)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func Test_mysql_CommentsByPost(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := NewRepository(cluster.Single(db))
+
+	now := time.Now()
+	rows := sqlmock.NewRows(commentColumns).
+		AddRow(1, 5, nil, "first", now, now, false, 22, "login", "First", "Last").
+		AddRow(2, 5, 1, "", now, now, true, 23, "other", "Other", "Last")
+
+	mock.ExpectQuery("SELECT c.id").WithArgs(5, 100).WillReturnRows(rows)
+
+	res, err := repo.CommentsByPost(context.Background(), 5, 100)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(res))
+	assert.Equal(t, 0, res[0].ParentID)
+	assert.Equal(t, "login", res[0].Author.Login)
+	assert.Equal(t, 1, res[1].ParentID)
+	assert.True(t, res[1].Deleted)
+}
+
+func Test_mysql_GetByID_NoRows(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := NewRepository(cluster.Single(db))
+
+	mock.ExpectQuery("SELECT c.id").WithArgs(7).WillReturnRows(sqlmock.NewRows(commentColumns))
+
+	res, err := repo.GetByID(context.Background(), 7)
+
+	assert.Nil(t, err)
+	assert.Nil(t, res)
+}
+
+func Test_mysql_Delete_AlreadyDeleted(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := NewRepository(cluster.Single(db))
+
+	mock.ExpectExec("UPDATE comments").WithArgs(7, 22).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	err = repo.Delete(context.Background(), &Comment{ID: 7, PostID: 5, Author: Author{ID: 22}})
+
+	assert.Equal(t, ErrCommentNotFound, err)
+}
@@ -0,0 +1,84 @@
+package comment
+
+import "time"
+
+const (
+	insertComment = iota
+	getComment
+	commentsByPost
+	updateComment
+	deleteComment
+)
+
+type Query struct {
+	SQL     string
+	Timeout time.Duration
+}
+
+var queryMap map[int]Query
+
+func init() {
+	queryMap = make(map[int]Query)
+
+	queryMap[insertComment] = Query{
+		SQL: `INSERT INTO comments (post_id, user_id, parent_id, body)
+				VALUES (?, ?, ?, ?);`,
+		Timeout: 5 * time.Second,
+	}
+
+	queryMap[getComment] = Query{
+		SQL: `SELECT c.id
+				, c.post_id
+				, c.parent_id
+				, c.body
+				, c.created_at
+				, c.updated_at
+				, c.deleted_at IS NOT NULL
+				, u.id
+				, u.login
+				, u.first_name
+				, u.last_name
+			FROM comments c
+				JOIN users u ON u.id = c.user_id
+			WHERE c.id = ?`,
+		Timeout: 5 * time.Second,
+	}
+
+	queryMap[commentsByPost] = Query{
+		SQL: `SELECT c.id
+				, c.post_id
+				, c.parent_id
+				, c.body
+				, c.created_at
+				, c.updated_at
+				, c.deleted_at IS NOT NULL
+				, u.id
+				, u.login
+				, u.first_name
+				, u.last_name
+			FROM comments c
+				JOIN users u ON u.id = c.user_id
+			WHERE c.post_id = ?
+			ORDER BY c.created_at, c.id
+			LIMIT ?`,
+		Timeout: 10 * time.Second,
+	}
+
+	queryMap[updateComment] = Query{
+		SQL: `UPDATE comments
+				SET body = ?
+			WHERE id = ?
+			AND user_id = ?
+			AND deleted_at IS NULL`,
+		Timeout: 5 * time.Second,
+	}
+
+	queryMap[deleteComment] = Query{
+		SQL: `UPDATE comments
+				SET deleted_at = CURRENT_TIMESTAMP
+			WHERE id = ?
+			AND user_id = ?
+			AND deleted_at IS NULL`,
+		Timeout: 5 * time.Second,
+	}
+}
@@ -0,0 +1,184 @@
+package comment
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/niklod/highload-social-network/internal/notify"
+	"github.com/niklod/highload-social-network/internal/user/post"
+)
+
+const (
+	// maxCommentsPerPost caps the comments shown on the post page
+	maxCommentsPerPost = 1000
+	maxCommentLength   = 2000
+)
+
+// EventComment is pushed to the post author over WebSocket when somebody
+// comments the post.
+const EventComment = "post.comment"
+
+var (
+	ErrEmptyComment    = fmt.Errorf("comment body can't be empty")
+	ErrCommentTooBig   = fmt.Errorf("comment body is too long")
+	ErrCommentNotFound = fmt.Errorf("comment not found")
+	ErrParentNotFound  = fmt.Errorf("replied comment not found")
+	ErrNotAuthor       = fmt.Errorf("only the author can change the comment")
+	ErrPostNotFound    = post.ErrPostNotFound
+)
+
+type repository interface {
+	Add(ctx context.Context, c *Comment) error
+	GetByID(ctx context.Context, id int) (*Comment, error)
+	CommentsByPost(ctx context.Context, postId, limit int) ([]Comment, error)
+	Update(ctx context.Context, c *Comment) error
+	Delete(ctx context.Context, c *Comment) error
+}
+
+// posts looks the commented posts up, see post.Service
+type posts interface {
+	Post(ctx context.Context, id, viewerId int) (*post.Post, error)
+}
+
+type Service struct {
+	repo     repository
+	posts    posts
+	notifier notify.Notifier
+}
+
+func NewService(repo repository, posts posts, notifier notify.Notifier) *Service {
+	return &Service{
+		repo:     repo,
+		posts:    posts,
+		notifier: notifier,
+	}
+}
+
+// Comments returns the comment threads of the post, oldest first.
+func (s *Service) Comments(ctx context.Context, postId int) ([]*Comment, error) {
+	comments, err := s.repo.CommentsByPost(ctx, postId, maxCommentsPerPost)
+	if err != nil {
+		return nil, fmt.Errorf("comment.Service: %v", err)
+	}
+
+	return Tree(comments), nil
+}
+
+// Add comments the post, or replies to the parent comment if parentId
+// isn't zero, and notifies the post author.
+func (s *Service) Add(ctx context.Context, postId, parentId int, author Author, body string) (*Comment, error) {
+	if err := validate(body); err != nil {
+		return nil, err
+	}
+
+	p, err := s.posts.Post(ctx, postId, author.ID)
+	if err != nil {
+		return nil, fmt.Errorf("comment.Service: %v", err)
+	}
+	if p == nil {
+		return nil, ErrPostNotFound
+	}
+
+	if parentId != 0 {
+		parent, err := s.repo.GetByID(ctx, parentId)
+		if err != nil {
+			return nil, fmt.Errorf("comment.Service: %v", err)
+		}
+		if parent == nil || parent.Deleted || parent.PostID != postId {
+			return nil, ErrParentNotFound
+		}
+	}
+
+	c := &Comment{
+		PostID:   postId,
+		ParentID: parentId,
+		Author:   author,
+		Body:     body,
+		Replies:  []*Comment{},
+	}
+
+	if err := s.repo.Add(ctx, c); err != nil {
+		return nil, fmt.Errorf("comment.Service: %v", err)
+	}
+
+	// Read back for the timestamps set by DB
+	stored, err := s.repo.GetByID(ctx, c.ID)
+	if err != nil {
+		return nil, fmt.Errorf("comment.Service: %v", err)
+	}
+	if stored != nil {
+		stored.Replies = []*Comment{}
+		c = stored
+	}
+
+	if p.Author.ID != author.ID {
+		s.notifier.Notify(p.Author.Login, EventComment, c)
+	}
+
+	return c, nil
+}
+
+// Update changes the body of the author's comment.
+func (s *Service) Update(ctx context.Context, authorId, commentId int, body string) (*Comment, error) {
+	if err := validate(body); err != nil {
+		return nil, err
+	}
+
+	c, err := s.authorComment(ctx, authorId, commentId)
+	if err != nil {
+		return nil, err
+	}
+
+	c.Body = body
+
+	if err := s.repo.Update(ctx, c); err != nil {
+		return nil, fmt.Errorf("comment.Service: %v", err)
+	}
+
+	c.Replies = []*Comment{}
+
+	return c, nil
+}
+
+// Delete soft deletes the author's comment, its replies stay in the thread.
+func (s *Service) Delete(ctx context.Context, authorId, commentId int) error {
+	c, err := s.authorComment(ctx, authorId, commentId)
+	if err != nil {
+		return err
+	}
+
+	if err := s.repo.Delete(ctx, c); err != nil {
+		if err == ErrCommentNotFound {
+			return err
+		}
+		return fmt.Errorf("comment.Service: %v", err)
+	}
+
+	return nil
+}
+
+func (s *Service) authorComment(ctx context.Context, authorId, commentId int) (*Comment, error) {
+	c, err := s.repo.GetByID(ctx, commentId)
+	if err != nil {
+		return nil, fmt.Errorf("comment.Service: %v", err)
+	}
+	if c == nil || c.Deleted {
+		return nil, ErrCommentNotFound
+	}
+	if c.Author.ID != authorId {
+		return nil, ErrNotAuthor
+	}
+
+	return c, nil
+}
+
+func validate(body string) error {
+	if body == "" {
+		return ErrEmptyComment
+	}
+	if len([]rune(body)) > maxCommentLength {
+		return ErrCommentTooBig
+	}
+
+	return nil
+}
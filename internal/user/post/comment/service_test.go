@@ -0,0 +1,151 @@
+package comment
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/niklod/highload-social-network/internal/cluster"
+	"github.com/niklod/highload-social-network/internal/user/post"
+)
+
+type notification struct {
+	login string
+	event string
+}
+
+type fakeNotifier struct {
+	sent []notification
+}
+
+func (f *fakeNotifier) Notify(login string, event string, data interface{}) bool {
+	f.sent = append(f.sent, notification{login: login, event: event})
+	return true
+}
+
+type fakePosts map[int]*post.Post
+
+func (f fakePosts) Post(ctx context.Context, id, viewerId int) (*post.Post, error) {
+	return f[id], nil
+}
+
+func TestService_Add_NotifiesPostAuthor(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	notifier := &fakeNotifier{}
+	posts := fakePosts{5: {ID: 5, Author: post.Author{ID: 1, Login: "author"}}}
+	svc := NewService(NewRepository(cluster.Single(db)), posts, notifier)
+
+	now := time.Now()
+	mock.ExpectExec("INSERT INTO comments").WithArgs(5, 22, nil, "hello").WillReturnResult(sqlmock.NewResult(9, 1))
+	mock.ExpectQuery("SELECT c.id").WithArgs(9).WillReturnRows(sqlmock.NewRows(commentColumns).
+		AddRow(9, 5, nil, "hello", now, now, false, 22, "commenter", "First", "Last"))
+
+	c, err := svc.Add(context.Background(), 5, 0, Author{ID: 22, Login: "commenter"}, "hello")
+
+	assert.Nil(t, err)
+	assert.Equal(t, 9, c.ID)
+	assert.Equal(t, []notification{{login: "author", event: EventComment}}, notifier.sent)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestService_Add_OwnPostIsNotNotified(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	notifier := &fakeNotifier{}
+	posts := fakePosts{5: {ID: 5, Author: post.Author{ID: 1, Login: "author"}}}
+	svc := NewService(NewRepository(cluster.Single(db)), posts, notifier)
+
+	mock.ExpectExec("INSERT INTO comments").WillReturnResult(sqlmock.NewResult(9, 1))
+	mock.ExpectQuery("SELECT c.id").WithArgs(9).WillReturnRows(sqlmock.NewRows(commentColumns))
+
+	_, err = svc.Add(context.Background(), 5, 0, Author{ID: 1, Login: "author"}, "hello")
+
+	assert.Nil(t, err)
+	assert.Empty(t, notifier.sent)
+}
+
+func TestService_Add_ReplyToOtherPost(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	posts := fakePosts{5: {ID: 5, Author: post.Author{ID: 1, Login: "author"}}}
+	svc := NewService(NewRepository(cluster.Single(db)), posts, &fakeNotifier{})
+
+	now := time.Now()
+	mock.ExpectQuery("SELECT c.id").WithArgs(3).WillReturnRows(sqlmock.NewRows(commentColumns).
+		AddRow(3, 6, nil, "other post", now, now, false, 22, "commenter", "First", "Last"))
+
+	_, err = svc.Add(context.Background(), 5, 3, Author{ID: 22}, "reply")
+
+	assert.Equal(t, ErrParentNotFound, err)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestService_Add_Validation(t *testing.T) {
+	db, _, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	posts := fakePosts{5: {ID: 5, Author: post.Author{ID: 1, Login: "author"}}}
+	svc := NewService(NewRepository(cluster.Single(db)), posts, &fakeNotifier{})
+
+	_, err = svc.Add(context.Background(), 5, 0, Author{ID: 22}, "")
+	assert.Equal(t, ErrEmptyComment, err)
+
+	_, err = svc.Add(context.Background(), 5, 0, Author{ID: 22}, string(make([]rune, maxCommentLength+1)))
+	assert.Equal(t, ErrCommentTooBig, err)
+
+	_, err = svc.Add(context.Background(), 100, 0, Author{ID: 22}, "hello")
+	assert.Equal(t, ErrPostNotFound, err)
+}
+
+func TestService_Update_NotAuthor(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	posts := fakePosts{5: {ID: 5, Author: post.Author{ID: 1, Login: "author"}}}
+	svc := NewService(NewRepository(cluster.Single(db)), posts, &fakeNotifier{})
+
+	now := time.Now()
+	mock.ExpectQuery("SELECT c.id").WithArgs(3).WillReturnRows(sqlmock.NewRows(commentColumns).
+		AddRow(3, 5, nil, "hello", now, now, false, 22, "commenter", "First", "Last"))
+
+	_, err = svc.Update(context.Background(), 23, 3, "edited")
+
+	assert.Equal(t, ErrNotAuthor, err)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestService_Delete_Deleted(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	posts := fakePosts{5: {ID: 5, Author: post.Author{ID: 1, Login: "author"}}}
+	svc := NewService(NewRepository(cluster.Single(db)), posts, &fakeNotifier{})
+
+	now := time.Now()
+	mock.ExpectQuery("SELECT c.id").WithArgs(3).WillReturnRows(sqlmock.NewRows(commentColumns).
+		AddRow(3, 5, nil, "", now, now, true, 22, "commenter", "First", "Last"))
+
+	err = svc.Delete(context.Background(), 22, 3)
+
+	assert.Equal(t, ErrCommentNotFound, err)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
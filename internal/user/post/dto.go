@@ -0,0 +1,89 @@
+package post
+
+import (
+	"time"
+
+	"github.com/go-playground/validator/v10"
+)
+
+type PostCreateRequest struct {
+	Body string `form:"post" json:"body" validate:"required,max=5000"`
+}
+
+func (r *PostCreateRequest) Validate() error {
+	validate := validator.New()
+	return validate.Struct(r)
+}
+
+type PostUpdateRequest struct {
+	Body string `form:"post" json:"body" validate:"required,max=5000"`
+}
+
+func (r *PostUpdateRequest) Validate() error {
+	validate := validator.New()
+	return validate.Struct(r)
+}
+
+type AuthorResponse struct {
+	ID        int    `json:"id"`
+	Login     string `json:"login"`
+	FirstName string `json:"firstName"`
+	LastName  string `json:"lastName"`
+}
+
+type PostResponse struct {
+	ID            int            `json:"id"`
+	CreatedAt     time.Time      `json:"createdAt"`
+	UpdatedAt     time.Time      `json:"updatedAt"`
+	Body          string         `json:"body"`
+	Author        AuthorResponse `json:"author"`
+	CommentsCount int            `json:"commentsCount"`
+	Reactions     map[string]int `json:"reactions"`
+	// ViewerReaction is empty if the viewer hasn't reacted
+	ViewerReaction string `json:"viewerReaction,omitempty"`
+}
+
+func NewPostResponse(p Post) PostResponse {
+	reactions := p.Reactions
+	if reactions == nil {
+		reactions = map[string]int{}
+	}
+
+	return PostResponse{
+		ID:             p.ID,
+		CreatedAt:      p.CreatedAt,
+		UpdatedAt:      p.UpdatedAt,
+		Body:           p.Body,
+		CommentsCount:  p.CommentsCount,
+		Reactions:      reactions,
+		ViewerReaction: p.ViewerReaction,
+		Author: AuthorResponse{
+			ID:        p.Author.ID,
+			Login:     p.Author.Login,
+			FirstName: p.Author.FirstName,
+			LastName:  p.Author.LastName,
+		},
+	}
+}
+
+func NewPostListResponse(posts []Post) []PostResponse {
+	resp := make([]PostResponse, 0, len(posts))
+
+	for _, p := range posts {
+		resp = append(resp, NewPostResponse(p))
+	}
+
+	return resp
+}
+
+type PostPageResponse struct {
+	Posts      []PostResponse `json:"posts"`
+	NextCursor string         `json:"nextCursor,omitempty"`
+}
+
+func NewPostPageResponse(p *Page) PostPageResponse {
+	return PostPageResponse{
+		Posts:      NewPostListResponse(p.Posts),
+		NextCursor: p.Next.Encode(),
+	}
+}
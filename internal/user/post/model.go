@@ -1,11 +1,18 @@
 package post
 
 import (
+	"encoding/base64"
 	"encoding/json"
+	"fmt"
+	"math"
 	"sort"
+	"strconv"
+	"strings"
 	"time"
 )
 
+var ErrInvalidCursor = fmt.Errorf("invalid cursor")
+
 type Author struct {
 	ID        int
 	FirstName string
@@ -18,16 +25,186 @@ type Post struct {
 	UpdatedAt time.Time
 	Body      string
 	Author    Author
+	// Counters are filled on reading, cached feeds don't keep them fresh
+	CommentsCount int            `json:"-"`
+	Reactions     map[string]int `json:"-"`
+	// ViewerReaction is the reaction of the user reading the post, if any
+	ViewerReaction string `json:"-"`
+}
+
+// Size returns the approximate size of the post in bytes, for the cache
+// budget.
+func (p Post) Size() int {
+	return postSize + len(p.Body) + len(p.Author.FirstName) + len(p.Author.LastName) + len(p.Author.Login)
 }
 
 func (p Post) AsByteJSON() ([]byte, error) {
 	return json.Marshal(p)
 }
 
+// Cursor points at a post in the (created_at, id) descending order, so new
+// posts don't shift the pages.
+type Cursor struct {
+	CreatedAt time.Time
+	ID        int
+}
+
+// firstPage is the cursor before the newest post
+var firstPage = Cursor{
+	CreatedAt: time.Date(9999, 12, 31, 0, 0, 0, 0, time.UTC),
+	ID:        math.MaxInt32,
+}
+
+func CursorOf(p Post) *Cursor {
+	return &Cursor{CreatedAt: p.CreatedAt, ID: p.ID}
+}
+
+// Encode returns the opaque cursor representation for clients.
+func (c *Cursor) Encode() string {
+	if c == nil {
+		return ""
+	}
+
+	raw := strconv.FormatInt(c.CreatedAt.Unix(), 10) + ":" + strconv.Itoa(c.ID)
+
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// ParseCursor decodes the cursor made by Encode, empty string gives nil.
+func ParseCursor(s string) (*Cursor, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	parts := strings.Split(string(raw), ":")
+	if len(parts) != 2 {
+		return nil, ErrInvalidCursor
+	}
+
+	sec, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	id, err := strconv.Atoi(parts[1])
+	if err != nil || id <= 0 {
+		return nil, ErrInvalidCursor
+	}
+
+	return &Cursor{CreatedAt: time.Unix(sec, 0).UTC(), ID: id}, nil
+}
+
+// olderThan reports whether the post goes after the cursor
+func (p Post) olderThan(c Cursor) bool {
+	if p.CreatedAt.Equal(c.CreatedAt) {
+		return p.ID < c.ID
+	}
+
+	return p.CreatedAt.Before(c.CreatedAt)
+}
+
+// Page is a page of posts, Next is nil on the last one.
+type Page struct {
+	Posts Feed
+	Next  *Cursor
+}
+
 type Feed []Post
 
+// postSize is the approximate size of a post without its strings
+const postSize = 160
+
+// Size returns the approximate size of the feed in bytes, for the cache
+// budget.
+func (f Feed) Size() int {
+	n := 0
+	for _, p := range f {
+		n += p.Size()
+	}
+
+	return n
+}
+
+// Sort orders the feed by (CreatedAt, ID) descending, newest first.
 func (f Feed) Sort() {
 	sort.Slice(f, func(i, j int) bool {
-		return f[i].CreatedAt.After(f[j].CreatedAt)
+		return f[j].olderThan(Cursor{CreatedAt: f[i].CreatedAt, ID: f[i].ID})
 	})
 }
+
+// After returns up to limit posts of the sorted feed after the cursor.
+func (f Feed) After(c *Cursor, limit int) Feed {
+	start := 0
+	if c != nil {
+		start = sort.Search(len(f), func(i int) bool {
+			return f[i].olderThan(*c)
+		})
+	}
+
+	end := start + limit
+	if end > len(f) {
+		end = len(f)
+	}
+
+	return f[start:end]
+}
+
+// Replace returns a copy of the feed with the post of the same ID replaced.
+func (f Feed) Replace(p Post) (Feed, bool) {
+	for i := range f {
+		if f[i].ID == p.ID {
+			res := append(Feed{}, f...)
+			res[i] = p
+			return res, true
+		}
+	}
+
+	return f, false
+}
+
+// Remove returns a copy of the feed without the post.
+func (f Feed) Remove(id int) (Feed, bool) {
+	for i := range f {
+		if f[i].ID == id {
+			res := make(Feed, 0, len(f)-1)
+			res = append(res, f[:i]...)
+			return append(res, f[i+1:]...), true
+		}
+	}
+
+	return f, false
+}
+
+// Merge returns up to limit posts of the two sorted feeds in the feed order.
+// A post which is in both feeds is taken once, from the other feed.
+func (f Feed) Merge(other Feed, limit int) Feed {
+	res := make(Feed, 0, limit)
+
+	i, j := 0, 0
+	for len(res) < limit && (i < len(f) || j < len(other)) {
+		switch {
+		case j == len(other):
+			res = append(res, f[i])
+			i++
+		case i == len(f):
+			res = append(res, other[j])
+			j++
+		case f[i].ID == other[j].ID:
+			res = append(res, other[j])
+			i++
+			j++
+		case other[j].olderThan(*CursorOf(f[i])):
+			res = append(res, f[i])
+			i++
+		default:
+			res = append(res, other[j])
+			j++
+		}
+	}
+
+	return res
+}
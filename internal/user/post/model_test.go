@@ -0,0 +1,112 @@
+package post
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCursor_EncodeParse(t *testing.T) {
+	c := &Cursor{CreatedAt: time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC), ID: 42}
+
+	parsed, err := ParseCursor(c.Encode())
+
+	assert.Nil(t, err)
+	assert.Equal(t, c, parsed)
+}
+
+func TestParseCursor_Invalid(t *testing.T) {
+	for _, s := range []string{"not base64!", "MTIz", "YWJjOjE", "MTIzOjA"} {
+		_, err := ParseCursor(s)
+		assert.Equal(t, ErrInvalidCursor, err, s)
+	}
+
+	c, err := ParseCursor("")
+	assert.Nil(t, err)
+	assert.Nil(t, c)
+}
+
+func TestFeed_Sort_TieBreakByID(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	feed := Feed{
+		{ID: 1, CreatedAt: now},
+		{ID: 3, CreatedAt: now.Add(-time.Second)},
+		{ID: 2, CreatedAt: now},
+		{ID: 4, CreatedAt: now.Add(time.Second)},
+	}
+
+	feed.Sort()
+
+	assert.Equal(t, []int{4, 2, 1, 3}, ids(feed))
+}
+
+func TestFeed_After(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	feed := Feed{
+		{ID: 5, CreatedAt: now},
+		{ID: 4, CreatedAt: now},
+		{ID: 3, CreatedAt: now.Add(-time.Second)},
+		{ID: 2, CreatedAt: now.Add(-2 * time.Second)},
+	}
+
+	assert.Equal(t, []int{5, 4}, ids(feed.After(nil, 2)))
+	assert.Equal(t, []int{3, 2}, ids(feed.After(CursorOf(feed[1]), 2)))
+	assert.Equal(t, []int{2}, ids(feed.After(CursorOf(feed[2]), 10)))
+	assert.Equal(t, []int{}, ids(feed.After(CursorOf(feed[3]), 10)))
+}
+
+func ids(feed Feed) []int {
+	res := []int{}
+	for _, p := range feed {
+		res = append(res, p.ID)
+	}
+
+	return res
+}
+
+func TestFeed_ReplaceRemove_CopyOnWrite(t *testing.T) {
+	feed := Feed{{ID: 3, Body: "c"}, {ID: 2, Body: "b"}, {ID: 1, Body: "a"}}
+
+	replaced, ok := feed.Replace(Post{ID: 2, Body: "edited"})
+	assert.True(t, ok)
+	assert.Equal(t, "edited", replaced[1].Body)
+	assert.Equal(t, "b", feed[1].Body)
+
+	removed, ok := feed.Remove(3)
+	assert.True(t, ok)
+	assert.Equal(t, []int{2, 1}, ids(removed))
+	assert.Equal(t, []int{3, 2, 1}, ids(feed))
+
+	_, ok = feed.Remove(10)
+	assert.False(t, ok)
+}
+
+func TestFeed_Merge(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	cached := Feed{
+		{ID: 6, CreatedAt: now},
+		{ID: 4, CreatedAt: now.Add(-time.Second), Body: "cached"},
+		{ID: 2, CreatedAt: now.Add(-2 * time.Second)},
+	}
+	read := Feed{
+		{ID: 7, CreatedAt: now},
+		{ID: 5, CreatedAt: now},
+		{ID: 4, CreatedAt: now.Add(-time.Second), Body: "read"},
+		{ID: 1, CreatedAt: now.Add(-3 * time.Second)},
+	}
+
+	merged := cached.Merge(read, 10)
+
+	assert.Equal(t, []int{7, 6, 5, 4, 2, 1}, ids(merged))
+	assert.Equal(t, "read", merged[3].Body)
+
+	assert.Equal(t, []int{7, 6, 5}, ids(cached.Merge(read, 3)))
+	assert.Equal(t, []int{6, 4, 2}, ids(cached.Merge(nil, 10)))
+}
+
+func TestFeed_Size(t *testing.T) {
+	feed := Feed{{Body: "body", Author: Author{Login: "login"}}, {}}
+
+	assert.Equal(t, 2*postSize+9, feed.Size())
+}
@@ -1,28 +1,36 @@
 package post
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"log"
+	"strconv"
+	"strings"
+
+	"github.com/niklod/highload-social-network/internal/cluster"
+	"github.com/niklod/highload-social-network/internal/outbox"
 )
 
 type mysql struct {
-	db *sql.DB
+	db *cluster.Cluster
 }
 
-func NewRepository(client *sql.DB) repository {
+func NewRepository(client *cluster.Cluster) repository {
 	return &mysql{
 		db: client,
 	}
 }
 
-func (m *mysql) PostsByUserId(id int) ([]Post, error) {
+func (m *mysql) PostsByUserId(ctx context.Context, id int, after *Cursor, limit int) ([]Post, error) {
 	var posts []Post
 
-	query, ctx, cancel := GetQuery(PostsByUserId)
+	query, ctx, cancel := GetQuery(ctx, PostsByUserId)
 	defer cancel()
 
-	rows, err := m.db.QueryContext(ctx, query, id)
+	c := cursorOrFirst(after)
+
+	rows, err := m.db.Reader(ctx).QueryContext(ctx, query, id, c.CreatedAt, c.CreatedAt, c.ID, limit)
 	if err != nil {
 		return nil, fmt.Errorf("posts.PostByUserId - sending query: %v", err)
 	}
@@ -56,13 +64,28 @@ func (m *mysql) PostsByUserId(id int) ([]Post, error) {
 	return posts, nil
 }
 
-func (m *mysql) UserFeed(id int) (Feed, error) {
+// UserFeed returns the posts of the user's subscriptions after the cursor,
+// leaving out the excluded authors.
+func (m *mysql) UserFeed(ctx context.Context, id int, excluded []int, after *Cursor, limit int) (Feed, error) {
 	var feed Feed
 
-	query, ctx, cancel := GetQuery(GetUserFeedById)
+	query, ctx, cancel := GetQuery(ctx, GetUserFeedById)
 	defer cancel()
 
-	rows, err := m.db.QueryContext(ctx, query, id)
+	c := cursorOrFirst(after)
+
+	var exclude string
+	args := make([]interface{}, 0, len(excluded)+6)
+	args = append(args, id, id)
+	if len(excluded) > 0 {
+		exclude = fmt.Sprintf("AND p.user_id NOT IN (%s)", strings.TrimSuffix(strings.Repeat("?, ", len(excluded)), ", "))
+		for _, authorId := range excluded {
+			args = append(args, authorId)
+		}
+	}
+	args = append(args, c.CreatedAt, c.CreatedAt, c.ID, limit)
+
+	rows, err := m.db.Reader(ctx).QueryContext(ctx, fmt.Sprintf(query, exclude), args...)
 	if err != nil {
 		return nil, fmt.Errorf("posts.UserFeed - sending query: %v", err)
 	}
@@ -96,14 +119,455 @@ func (m *mysql) UserFeed(id int) (Feed, error) {
 	return feed, nil
 }
 
-func (m *mysql) Add(post *Post, userId int) error {
-	query, ctx, cancel := GetQuery(InsertPost)
+// CelebrityFeed returns the posts of the subscribed authors after the
+// cursor.
+func (m *mysql) CelebrityFeed(ctx context.Context, userId int, authorIds []int, after *Cursor, limit int) (Feed, error) {
+	var feed Feed
+	if len(authorIds) == 0 {
+		return feed, nil
+	}
+
+	query, ctx, cancel := GetQuery(ctx, CelebrityFeed)
 	defer cancel()
 
-	_, err := m.db.ExecContext(ctx, query, userId, post.Body)
+	c := cursorOrFirst(after)
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(authorIds)), ", ")
+	args := make([]interface{}, 0, len(authorIds)+6)
+	for _, id := range authorIds {
+		args = append(args, id)
+	}
+	args = append(args, userId, userId, c.CreatedAt, c.CreatedAt, c.ID, limit)
+
+	rows, err := m.db.Reader(ctx).QueryContext(ctx, fmt.Sprintf(query, placeholders), args...)
+	if err != nil {
+		return nil, fmt.Errorf("posts.CelebrityFeed - sending query: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		post := Post{}
+
+		err := rows.Scan(
+			&post.ID,
+			&post.CreatedAt,
+			&post.UpdatedAt,
+			&post.Body,
+			&post.Author.FirstName,
+			&post.Author.LastName,
+			&post.Author.Login,
+			&post.Author.ID,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("posts.CelebrityFeed - scanning post: %v", err)
+		}
+
+		feed = append(feed, post)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("posts.CelebrityFeed - iterating through rows: %v", err)
+	}
+
+	return feed, nil
+}
+
+// Add inserts the post and its outbox message in one transaction.
+func (m *mysql) Add(ctx context.Context, post *Post, userId int) error {
+	query, ctx, cancel := GetQuery(ctx, InsertPost)
+	defer cancel()
+
+	tx, err := m.db.Writer(ctx).BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("posts.Add - starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, query, userId, post.Body, post.CreatedAt, post.UpdatedAt)
 	if err != nil {
 		return fmt.Errorf("posts.Add - sending query: %v", err)
 	}
 
+	id, err := res.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("posts.Add - getting last insert id: %v", err)
+	}
+
+	post.ID = int(id)
+
+	if err := insertOutbox(ctx, tx, outbox.PostCreated, post, userId); err != nil {
+		return fmt.Errorf("posts.Add - %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("posts.Add - committing transaction: %v", err)
+	}
+
+	return nil
+}
+
+func cursorOrFirst(c *Cursor) Cursor {
+	if c == nil {
+		return firstPage
+	}
+
+	return *c
+}
+
+func (m *mysql) GetByID(ctx context.Context, id int) (*Post, error) {
+	query, ctx, cancel := GetQuery(ctx, GetPostById)
+	defer cancel()
+
+	post := Post{}
+
+	// Read from the primary, the post is about to be changed
+	err := m.db.Primary().QueryRowContext(ctx, query, id).Scan(
+		&post.ID,
+		&post.CreatedAt,
+		&post.UpdatedAt,
+		&post.Body,
+		&post.Author.FirstName,
+		&post.Author.LastName,
+		&post.Author.Login,
+		&post.Author.ID,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("posts.GetByID - scanning post: %v", err)
+	}
+
+	return &post, nil
+}
+
+// PostsByIds returns the posts which aren't deleted, unordered.
+func (m *mysql) PostsByIds(ctx context.Context, ids []int) (Feed, error) {
+	var posts Feed
+	if len(ids) == 0 {
+		return posts, nil
+	}
+
+	query, ctx, cancel := GetQuery(ctx, PostsByIds)
+	defer cancel()
+
+	placeholders, args := inList(ids)
+
+	rows, err := m.db.Reader(ctx).QueryContext(ctx, fmt.Sprintf(query, placeholders), args...)
+	if err != nil {
+		return nil, fmt.Errorf("posts.PostsByIds - sending query: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		post := Post{}
+
+		err := rows.Scan(
+			&post.ID,
+			&post.CreatedAt,
+			&post.UpdatedAt,
+			&post.Body,
+			&post.Author.FirstName,
+			&post.Author.LastName,
+			&post.Author.Login,
+			&post.Author.ID,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("posts.PostsByIds - scanning post: %v", err)
+		}
+
+		posts = append(posts, post)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("posts.PostsByIds - iterating through rows: %v", err)
+	}
+
+	return posts, nil
+}
+
+func (m *mysql) Update(ctx context.Context, post *Post, userId int) error {
+	query, ctx, cancel := GetQuery(ctx, UpdatePost)
+	defer cancel()
+
+	tx, err := m.db.Writer(ctx).BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("posts.Update - starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, query, post.Body, post.UpdatedAt, post.ID, userId)
+	if err != nil {
+		return fmt.Errorf("posts.Update - sending query: %v", err)
+	}
+
+	if err := insertOutbox(ctx, tx, outbox.PostUpdated, post, userId); err != nil {
+		return fmt.Errorf("posts.Update - %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("posts.Update - committing transaction: %v", err)
+	}
+
+	return nil
+}
+
+func (m *mysql) Delete(ctx context.Context, post *Post, userId int) error {
+	query, ctx, cancel := GetQuery(ctx, DeletePost)
+	defer cancel()
+
+	tx, err := m.db.Writer(ctx).BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("posts.Delete - starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, query, post.ID, userId)
+	if err != nil {
+		return fmt.Errorf("posts.Delete - sending query: %v", err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("posts.Delete - getting affected rows: %v", err)
+	}
+	if n == 0 {
+		return ErrPostNotFound
+	}
+
+	if err := insertOutbox(ctx, tx, outbox.PostDeleted, post, userId); err != nil {
+		return fmt.Errorf("posts.Delete - %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("posts.Delete - committing transaction: %v", err)
+	}
+
+	return nil
+}
+
+// insertOutbox writes the feed event of the post change keyed by author,
+// so the author's changes keep their order.
+func insertOutbox(ctx context.Context, tx *sql.Tx, msgType string, post *Post, authorId int) error {
+	payload, err := post.AsByteJSON()
+	if err != nil {
+		return fmt.Errorf("marshaling %s message: %v", msgType, err)
+	}
+
+	_, err = tx.ExecContext(ctx, queryMap[InsertOutbox].SQL, post.ID, msgType, strconv.Itoa(authorId), payload)
+	if err != nil {
+		return fmt.Errorf("inserting %s message to outbox: %v", msgType, err)
+	}
+
 	return nil
 }
+
+// ClaimOutbox passes up to limit oldest outbox messages to publish and
+// deletes the ones it returns. It holds the relay lock instead of a
+// transaction while publishing, so the relays publish in order.
+func (m *mysql) ClaimOutbox(limit int, publish func([]outbox.Message) []int64) error {
+	lock, ctx, cancel := GetQuery(context.Background(), LockOutbox)
+	defer cancel()
+
+	conn, err := m.db.Primary().Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("posts.ClaimOutbox - getting connection: %v", err)
+	}
+	defer conn.Close()
+
+	var locked sql.NullInt64
+	if err := conn.QueryRowContext(ctx, lock).Scan(&locked); err != nil {
+		return fmt.Errorf("posts.ClaimOutbox - taking relay lock: %v", err)
+	}
+	if locked.Int64 != 1 {
+		return nil
+	}
+	defer func() {
+		unlock, ctx, cancel := GetQuery(context.Background(), UnlockOutbox)
+		defer cancel()
+
+		if _, err := conn.ExecContext(ctx, unlock); err != nil {
+			log.Printf("posts.ClaimOutbox - releasing relay lock: %v", err)
+		}
+	}()
+
+	msgs, err := claimedMessages(conn, limit)
+	if err != nil || len(msgs) == 0 {
+		return err
+	}
+
+	published := publish(msgs)
+	if len(published) == 0 {
+		return nil
+	}
+
+	query, ctx, cancel := GetQuery(context.Background(), DeleteOutbox)
+	defer cancel()
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(published)), ", ")
+	args := make([]interface{}, len(published))
+	for i, id := range published {
+		args[i] = id
+	}
+
+	if _, err := conn.ExecContext(ctx, fmt.Sprintf(query, placeholders), args...); err != nil {
+		return fmt.Errorf("posts.ClaimOutbox - deleting published messages: %v", err)
+	}
+
+	return nil
+}
+
+// claimedMessages reads the oldest outbox messages
+func claimedMessages(conn *sql.Conn, limit int) ([]outbox.Message, error) {
+	query, ctx, cancel := GetQuery(context.Background(), ClaimOutbox)
+	defer cancel()
+
+	rows, err := conn.QueryContext(ctx, query, limit)
+	if err != nil {
+		return nil, fmt.Errorf("posts.ClaimOutbox - sending query: %v", err)
+	}
+	defer rows.Close()
+
+	var msgs []outbox.Message
+
+	for rows.Next() {
+		var msg outbox.Message
+		if err := rows.Scan(&msg.ID, &msg.Type, &msg.Key, &msg.Body, &msg.CreatedAt); err != nil {
+			return nil, fmt.Errorf("posts.ClaimOutbox - scanning message: %v", err)
+		}
+
+		msgs = append(msgs, msg)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("posts.ClaimOutbox - iterating through messages: %v", err)
+	}
+
+	return msgs, nil
+}
+
+// CommentCounts returns the number of comments of every post in one query.
+func (m *mysql) CommentCounts(ctx context.Context, ids []int) (map[int]int, error) {
+	counts := make(map[int]int, len(ids))
+	if len(ids) == 0 {
+		return counts, nil
+	}
+
+	query, ctx, cancel := GetQuery(ctx, CommentCounts)
+	defer cancel()
+
+	placeholders, args := inList(ids)
+
+	rows, err := m.db.Reader(ctx).QueryContext(ctx, fmt.Sprintf(query, placeholders), args...)
+	if err != nil {
+		return nil, fmt.Errorf("posts.CommentCounts - sending query: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var postId, count int
+
+		if err := rows.Scan(&postId, &count); err != nil {
+			return nil, fmt.Errorf("posts.CommentCounts - scanning row: %v", err)
+		}
+
+		counts[postId] = count
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("posts.CommentCounts - iterating through rows: %v", err)
+	}
+
+	return counts, nil
+}
+
+// ReactionCounts returns the reaction counters of every post in one query.
+func (m *mysql) ReactionCounts(ctx context.Context, ids []int) (map[int]map[string]int, error) {
+	counts := make(map[int]map[string]int, len(ids))
+	if len(ids) == 0 {
+		return counts, nil
+	}
+
+	query, ctx, cancel := GetQuery(ctx, ReactionCounts)
+	defer cancel()
+
+	placeholders, args := inList(ids)
+
+	rows, err := m.db.Reader(ctx).QueryContext(ctx, fmt.Sprintf(query, placeholders), args...)
+	if err != nil {
+		return nil, fmt.Errorf("posts.ReactionCounts - sending query: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var postId, count int
+		var kind string
+
+		if err := rows.Scan(&postId, &kind, &count); err != nil {
+			return nil, fmt.Errorf("posts.ReactionCounts - scanning row: %v", err)
+		}
+
+		// A removed reaction leaves a zero counter behind
+		if count <= 0 {
+			continue
+		}
+
+		if counts[postId] == nil {
+			counts[postId] = make(map[string]int)
+		}
+		counts[postId][kind] = count
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("posts.ReactionCounts - iterating through rows: %v", err)
+	}
+
+	return counts, nil
+}
+
+// ViewerReactions returns the user's reactions to the posts in one query.
+func (m *mysql) ViewerReactions(ctx context.Context, userId int, ids []int) (map[int]string, error) {
+	reactions := make(map[int]string)
+	if len(ids) == 0 {
+		return reactions, nil
+	}
+
+	query, ctx, cancel := GetQuery(ctx, ViewerReactions)
+	defer cancel()
+
+	placeholders, args := inList(ids)
+	args = append([]interface{}{userId}, args...)
+
+	rows, err := m.db.Reader(ctx).QueryContext(ctx, fmt.Sprintf(query, placeholders), args...)
+	if err != nil {
+		return nil, fmt.Errorf("posts.ViewerReactions - sending query: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var postId int
+		var kind string
+
+		if err := rows.Scan(&postId, &kind); err != nil {
+			return nil, fmt.Errorf("posts.ViewerReactions - scanning row: %v", err)
+		}
+
+		reactions[postId] = kind
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("posts.ViewerReactions - iterating through rows: %v", err)
+	}
+
+	return reactions, nil
+}
+
+// inList returns the IN list placeholders and arguments of the post IDs
+func inList(ids []int) (string, []interface{}) {
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+
+	return strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", "), args
+}
@@ -1,12 +1,16 @@
 package post
 
 import (
+	"context"
 	"fmt"
 	"testing"
 	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/stretchr/testify/assert"
+
+	"github.com/niklod/highload-social-network/internal/cluster"
+	"github.com/niklod/highload-social-network/internal/outbox"
 )
 
 func Test_mysql_PostsByUserId_OneRow(t *testing.T) {
@@ -14,16 +18,16 @@ func Test_mysql_PostsByUserId_OneRow(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	repo := NewRepository(db)
+	repo := NewRepository(cluster.Single(db))
 
 	userId := 22
 
 	rows := sqlmock.NewRows([]string{"id", "created_at", "updated_at", "body", "first_name", "last_name", "login", "id"})
 	rows.AddRow(1, time.Now(), time.Now(), "Test", "TestFirst", "TestLast", "Testlogin", 1)
 
-	mock.ExpectQuery("SELECT p.id").WithArgs(userId).WillReturnRows(rows)
+	mock.ExpectQuery("SELECT p.id").WithArgs(userId, firstPage.CreatedAt, firstPage.CreatedAt, firstPage.ID, 10).WillReturnRows(rows)
 
-	res, err := repo.PostsByUserId(userId)
+	res, err := repo.PostsByUserId(context.Background(), userId, nil, 10)
 
 	assert.Equal(t, 1, len(res))
 	assert.Nil(t, err)
@@ -34,7 +38,7 @@ func Test_mysql_PostsByUserId_TwoRows(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	repo := NewRepository(db)
+	repo := NewRepository(cluster.Single(db))
 
 	userId := 22
 
@@ -42,9 +46,9 @@ func Test_mysql_PostsByUserId_TwoRows(t *testing.T) {
 	rows.AddRow(1, time.Now(), time.Now(), "Test", "TestFirst", "TestLast", "TestLogin", 1)
 	rows.AddRow(1, time.Now(), time.Now(), "Test", "TestFirst", "TestLast", "TestLogin", 1)
 
-	mock.ExpectQuery("SELECT p.id").WithArgs(userId).WillReturnRows(rows)
+	mock.ExpectQuery("SELECT p.id").WithArgs(userId, firstPage.CreatedAt, firstPage.CreatedAt, firstPage.ID, 10).WillReturnRows(rows)
 
-	res, err := repo.PostsByUserId(userId)
+	res, err := repo.PostsByUserId(context.Background(), userId, nil, 10)
 
 	assert.Equal(t, 2, len(res))
 	assert.Nil(t, err)
@@ -55,13 +59,13 @@ func Test_mysql_PostsByUserId_Error(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	repo := NewRepository(db)
+	repo := NewRepository(cluster.Single(db))
 
 	sqlError := fmt.Errorf("test sql error")
 
 	mock.ExpectQuery("SELECT p.id").WillReturnError(sqlError)
 
-	res, err := repo.PostsByUserId(2)
+	res, err := repo.PostsByUserId(context.Background(), 2, nil, 10)
 
 	assert.Nil(t, res)
 	assert.Contains(t, err.Error(), sqlError.Error())
@@ -72,21 +76,46 @@ func Test_mysql_Add(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	repo := NewRepository(db)
+	repo := NewRepository(cluster.Single(db))
 
+	now := time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC)
 	post := &Post{
 		ID:        0,
-		CreatedAt: time.Time{},
-		UpdatedAt: time.Time{},
+		CreatedAt: now,
+		UpdatedAt: now,
 		Body:      "Test",
 	}
 	userId := 22
 
-	mock.ExpectExec("INSERT INTO posts").WithArgs(userId, post.Body).WillReturnResult(sqlmock.NewResult(1, 1))
+	// The times of the service are the ones of the row
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO posts").WithArgs(userId, post.Body, now, now).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO feed_outbox").WithArgs(1, outbox.PostCreated, "22", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(7, 1))
+	mock.ExpectCommit()
 
-	err = repo.Add(post, userId)
+	err = repo.Add(context.Background(), post, userId)
 
 	assert.Nil(t, err)
+	assert.Equal(t, 1, post.ID)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func Test_mysql_Add_OutboxFailed(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := NewRepository(cluster.Single(db))
+
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO posts").WithArgs(22, "Test", time.Time{}, time.Time{}).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO feed_outbox").WillReturnError(fmt.Errorf("lock wait timeout"))
+	mock.ExpectRollback()
+
+	err = repo.Add(context.Background(), &Post{Body: "Test"}, 22)
+
+	assert.NotNil(t, err)
+	assert.Nil(t, mock.ExpectationsWereMet())
 }
 
 func Test_mysql_UserFeed_OneRow(t *testing.T) {
@@ -94,16 +123,16 @@ func Test_mysql_UserFeed_OneRow(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	repo := NewRepository(db)
+	repo := NewRepository(cluster.Single(db))
 
 	userId := 22
 
 	rows := sqlmock.NewRows([]string{"id", "created_at", "updated_at", "body", "first_name", "last_name", "login", "id"})
 	rows.AddRow(1, time.Now(), time.Now(), "Test", "TestFirst", "TestLast", "Testlogin", 1)
 
-	mock.ExpectQuery("SELECT p.id").WithArgs(userId).WillReturnRows(rows)
+	mock.ExpectQuery("SELECT p.id").WithArgs(userId, userId, firstPage.CreatedAt, firstPage.CreatedAt, firstPage.ID, 10).WillReturnRows(rows)
 
-	res, err := repo.UserFeed(userId)
+	res, err := repo.UserFeed(context.Background(), userId, nil, nil, 10)
 
 	assert.Nil(t, err)
 	assert.Equal(t, 1, len(res))
@@ -114,7 +143,7 @@ func Test_mysql_UserFeed_TwoRows(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	repo := NewRepository(db)
+	repo := NewRepository(cluster.Single(db))
 
 	userId := 22
 
@@ -122,9 +151,9 @@ func Test_mysql_UserFeed_TwoRows(t *testing.T) {
 	rows.AddRow(1, time.Now(), time.Now(), "Test", "TestFirst", "TestLast", "Testlogin", 1)
 	rows.AddRow(1, time.Now(), time.Now(), "Test1", "TestFirst1", "TestLast1", "Testlogin1", 1)
 
-	mock.ExpectQuery("SELECT p.id").WithArgs(userId).WillReturnRows(rows)
+	mock.ExpectQuery("SELECT p.id").WithArgs(userId, userId, firstPage.CreatedAt, firstPage.CreatedAt, firstPage.ID, 10).WillReturnRows(rows)
 
-	res, err := repo.UserFeed(userId)
+	res, err := repo.UserFeed(context.Background(), userId, nil, nil, 10)
 
 	assert.Nil(t, err)
 	assert.Equal(t, 2, len(res))
@@ -135,15 +164,196 @@ func Test_mysql_UserFeed_Error(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	repo := NewRepository(db)
+	repo := NewRepository(cluster.Single(db))
 
 	userId := 22
 	testErr := fmt.Errorf("test user feed error")
 
-	mock.ExpectQuery("SELECT p.id").WithArgs(userId).WillReturnError(testErr)
+	mock.ExpectQuery("SELECT p.id").WithArgs(userId, userId, firstPage.CreatedAt, firstPage.CreatedAt, firstPage.ID, 10).WillReturnError(testErr)
 
-	res, err := repo.UserFeed(userId)
+	res, err := repo.UserFeed(context.Background(), userId, nil, nil, 10)
 
 	assert.Nil(t, res)
 	assert.Contains(t, err.Error(), testErr.Error())
 }
+
+func Test_mysql_UserFeed_AfterCursor(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := NewRepository(cluster.Single(db))
+
+	cursor := &Cursor{CreatedAt: time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC), ID: 40}
+	rows := sqlmock.NewRows([]string{"id", "created_at", "updated_at", "body", "first_name", "last_name", "login", "id"})
+
+	mock.ExpectQuery("SELECT p.id").WithArgs(22, 22, cursor.CreatedAt, cursor.CreatedAt, 40, 5).WillReturnRows(rows)
+
+	res, err := repo.UserFeed(context.Background(), 22, nil, cursor, 5)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(res))
+}
+
+func Test_mysql_UserFeed_ExcludesCelebrities(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := NewRepository(cluster.Single(db))
+
+	rows := sqlmock.NewRows([]string{"id", "created_at", "updated_at", "body", "first_name", "last_name", "login", "id"})
+
+	mock.ExpectQuery(`p.user_id NOT IN \(\?, \?\)`).WithArgs(22, 22, 7, 9, firstPage.CreatedAt, firstPage.CreatedAt, firstPage.ID, 10).WillReturnRows(rows)
+
+	res, err := repo.UserFeed(context.Background(), 22, []int{7, 9}, nil, 10)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(res))
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func Test_mysql_GetByID_NoRows(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := NewRepository(cluster.Single(db))
+
+	mock.ExpectQuery("SELECT p.id").WithArgs(5).WillReturnRows(sqlmock.NewRows([]string{"id", "created_at", "updated_at", "body", "first_name", "last_name", "login", "id"}))
+
+	res, err := repo.GetByID(context.Background(), 5)
+
+	assert.Nil(t, err)
+	assert.Nil(t, res)
+}
+
+func Test_mysql_Update(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := NewRepository(cluster.Single(db))
+
+	now := time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC)
+
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE posts").WithArgs("edited", now, 5, 22).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO feed_outbox").WithArgs(5, outbox.PostUpdated, "22", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(8, 1))
+	mock.ExpectCommit()
+
+	err = repo.Update(context.Background(), &Post{ID: 5, Body: "edited", UpdatedAt: now}, 22)
+
+	assert.Nil(t, err)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func Test_mysql_Delete_AlreadyDeleted(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := NewRepository(cluster.Single(db))
+
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE posts").WithArgs(5, 22).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+
+	err = repo.Delete(context.Background(), &Post{ID: 5}, 22)
+
+	assert.Equal(t, ErrPostNotFound, err)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func Test_mysql_Delete(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := NewRepository(cluster.Single(db))
+
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE posts").WithArgs(5, 22).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO feed_outbox").WithArgs(5, outbox.PostDeleted, "22", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(9, 1))
+	mock.ExpectCommit()
+
+	err = repo.Delete(context.Background(), &Post{ID: 5}, 22)
+
+	assert.Nil(t, err)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func Test_mysql_ClaimOutbox(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := NewRepository(cluster.Single(db))
+
+	at := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	rows := sqlmock.NewRows([]string{"id", "type", "message_key", "payload", "created_at"})
+	rows.AddRow(3, outbox.PostCreated, "22", []byte(`{"id":1}`), at)
+	rows.AddRow(4, outbox.PostDeleted, "22", []byte(`{"id":1}`), at)
+
+	mock.ExpectQuery("SELECT GET_LOCK").WillReturnRows(sqlmock.NewRows([]string{"lock"}).AddRow(1))
+	mock.ExpectQuery("FROM feed_outbox").WithArgs(10).WillReturnRows(rows)
+	mock.ExpectExec("DELETE FROM feed_outbox").WithArgs(int64(3)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("DO RELEASE_LOCK").WillReturnResult(sqlmock.NewResult(0, 0))
+
+	var claimed []outbox.Message
+	err = repo.ClaimOutbox(10, func(msgs []outbox.Message) []int64 {
+		claimed = msgs
+		// The second one isn't confirmed and stays in the outbox
+		return []int64{3}
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, []outbox.Message{
+		{ID: 3, Type: outbox.PostCreated, Key: "22", Body: []byte(`{"id":1}`), CreatedAt: at},
+		{ID: 4, Type: outbox.PostDeleted, Key: "22", Body: []byte(`{"id":1}`), CreatedAt: at},
+	}, claimed)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func Test_mysql_ClaimOutbox_Empty(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := NewRepository(cluster.Single(db))
+
+	mock.ExpectQuery("SELECT GET_LOCK").WillReturnRows(sqlmock.NewRows([]string{"lock"}).AddRow(1))
+	mock.ExpectQuery("FROM feed_outbox").WithArgs(10).WillReturnRows(sqlmock.NewRows([]string{"id", "type", "message_key", "payload", "created_at"}))
+	mock.ExpectExec("DO RELEASE_LOCK").WillReturnResult(sqlmock.NewResult(0, 0))
+
+	called := false
+	err = repo.ClaimOutbox(10, func(msgs []outbox.Message) []int64 {
+		called = true
+		return nil
+	})
+
+	assert.Nil(t, err)
+	assert.False(t, called)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func Test_mysql_ClaimOutbox_LockedByOtherRelay(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := NewRepository(cluster.Single(db))
+
+	mock.ExpectQuery("SELECT GET_LOCK").WillReturnRows(sqlmock.NewRows([]string{"lock"}).AddRow(0))
+
+	called := false
+	err = repo.ClaimOutbox(10, func(msgs []outbox.Message) []int64 {
+		called = true
+		return nil
+	})
+
+	assert.Nil(t, err)
+	assert.False(t, called)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
@@ -9,6 +9,19 @@ const (
 	PostsByUserId int = iota
 	InsertPost
 	GetUserFeedById
+	GetPostById
+	UpdatePost
+	DeletePost
+	CommentCounts
+	ReactionCounts
+	ViewerReactions
+	CelebrityFeed
+	PostsByIds
+	InsertOutbox
+	ClaimOutbox
+	DeleteOutbox
+	LockOutbox
+	UnlockOutbox
 )
 
 type Query struct {
@@ -16,9 +29,9 @@ type Query struct {
 	Timeout time.Duration
 }
 
-func GetQuery(queryIndex int) (string, context.Context, context.CancelFunc) {
-	context, cancel := context.WithTimeout(context.Background(), queryMap[queryIndex].Timeout)
-	return queryMap[queryIndex].SQL, context, cancel
+func GetQuery(ctx context.Context, queryIndex int) (string, context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithTimeout(ctx, queryMap[queryIndex].Timeout)
+	return queryMap[queryIndex].SQL, ctx, cancel
 }
 
 var queryMap map[int]Query
@@ -38,16 +51,20 @@ func init() {
 			  FROM posts as p
 			  LEFT JOIN users u on u.id = p.user_id
 			  WHERE p.user_id = ?
-			  ORDER BY p.created_at desc`,
+			  AND p.deleted_at IS NULL
+			  AND (p.created_at < ? OR (p.created_at = ? AND p.id < ?))
+			  ORDER BY p.created_at desc, p.id desc
+			  LIMIT ?`,
 		Timeout: time.Second * 10,
 	}
 
 	queryMap[InsertPost] = Query{
-		SQL: `INSERT INTO posts (user_id, body) 
-			  VALUES (?, ?);`,
+		SQL: `INSERT INTO posts (user_id, body, created_at, updated_at)
+			  VALUES (?, ?, ?, ?);`,
 		Timeout: time.Second * 10,
 	}
 
+	// %s excludes the celebrities, whose posts are read by CelebrityFeed
 	queryMap[GetUserFeedById] = Query{
 		SQL: `SELECT p.id
 					, p.created_at
@@ -63,9 +80,163 @@ func init() {
               	SELECT f.friend_id
               	FROM friends f
               	WHERE user_id = ?
+              	UNION
+              	SELECT fl.followee_id
+              	FROM follows fl
+              	WHERE fl.follower_id = ?
               )
-              ORDER BY p.created_at desc
-			  LIMIT 1000`,
+			  %s
+			  AND p.deleted_at IS NULL
+			  AND (p.created_at < ? OR (p.created_at = ? AND p.id < ?))
+              ORDER BY p.created_at desc, p.id desc
+			  LIMIT ?`,
 		Timeout: time.Second * 40,
 	}
+
+	// The posts of the celebrities the user subscribes to
+	queryMap[CelebrityFeed] = Query{
+		SQL: `SELECT p.id
+					, p.created_at
+					, p.updated_at
+					, p.body
+					, u.first_name
+					, u.last_name
+					, u.login
+					, u.id
+              FROM posts p
+			  LEFT JOIN users u on u.id = p.user_id
+              WHERE p.user_id IN (%s)
+              AND p.user_id IN (
+              	SELECT f.friend_id
+              	FROM friends f
+              	WHERE user_id = ?
+              	UNION
+              	SELECT fl.followee_id
+              	FROM follows fl
+              	WHERE fl.follower_id = ?
+              )
+			  AND p.deleted_at IS NULL
+			  AND (p.created_at < ? OR (p.created_at = ? AND p.id < ?))
+              ORDER BY p.created_at desc, p.id desc
+			  LIMIT ?`,
+		Timeout: time.Second * 10,
+	}
+
+	queryMap[GetPostById] = Query{
+		SQL: `SELECT p.id
+					, p.created_at
+					, p.updated_at
+					, p.body
+					, u.first_name
+					, u.last_name
+					, u.login
+					, u.id
+			  FROM posts p
+			  LEFT JOIN users u on u.id = p.user_id
+			  WHERE p.id = ?
+			  AND p.deleted_at IS NULL`,
+		Timeout: time.Second * 10,
+	}
+
+	queryMap[PostsByIds] = Query{
+		SQL: `SELECT p.id
+					, p.created_at
+					, p.updated_at
+					, p.body
+					, u.first_name
+					, u.last_name
+					, u.login
+					, u.id
+			  FROM posts p
+			  LEFT JOIN users u on u.id = p.user_id
+			  WHERE p.id IN (%s)
+			  AND p.deleted_at IS NULL`,
+		Timeout: time.Second * 10,
+	}
+
+	queryMap[UpdatePost] = Query{
+		SQL: `UPDATE posts
+			  SET body = ?
+			  , updated_at = ?
+			  WHERE id = ?
+			  AND user_id = ?
+			  AND deleted_at IS NULL`,
+		Timeout: time.Second * 10,
+	}
+
+	queryMap[DeletePost] = Query{
+		SQL: `UPDATE posts
+			  SET deleted_at = CURRENT_TIMESTAMP
+			  WHERE id = ?
+			  AND user_id = ?
+			  AND deleted_at IS NULL`,
+		Timeout: time.Second * 10,
+	}
+
+	queryMap[InsertOutbox] = Query{
+		SQL: `INSERT INTO feed_outbox (post_id, type, message_key, payload)
+			  VALUES (?, ?, ?, ?);`,
+		Timeout: time.Second * 10,
+	}
+
+	// Oldest messages first, it's run under LockOutbox
+	queryMap[ClaimOutbox] = Query{
+		SQL: `SELECT id
+					, type
+					, message_key
+					, payload
+					, created_at
+			  FROM feed_outbox
+			  ORDER BY id
+			  LIMIT ?`,
+		Timeout: time.Second * 10,
+	}
+
+	// The lock is held by the connection
+	queryMap[LockOutbox] = Query{
+		SQL:     `SELECT GET_LOCK('feed_outbox_relay', 0)`,
+		Timeout: time.Second * 10,
+	}
+
+	queryMap[UnlockOutbox] = Query{
+		SQL:     `DO RELEASE_LOCK('feed_outbox_relay')`,
+		Timeout: time.Second * 10,
+	}
+
+	// Outbox IDs placeholders are added by the repository
+	queryMap[DeleteOutbox] = Query{
+		SQL:     `DELETE FROM feed_outbox WHERE id IN (%s)`,
+		Timeout: time.Second * 10,
+	}
+
+	// Post IDs placeholders are added by the repository
+	queryMap[CommentCounts] = Query{
+		SQL: `SELECT c.post_id
+					, COUNT(*)
+			  FROM comments c
+			  WHERE c.post_id IN (%s)
+			  AND c.deleted_at IS NULL
+			  GROUP BY c.post_id`,
+		Timeout: time.Second * 10,
+	}
+
+	// Counters are split into slots, see reaction.Counter
+	queryMap[ReactionCounts] = Query{
+		SQL: `SELECT r.post_id
+					, r.reaction
+					, SUM(r.count)
+			  FROM post_reaction_counters r
+			  WHERE r.post_id IN (%s)
+			  GROUP BY r.post_id, r.reaction`,
+		Timeout: time.Second * 10,
+	}
+
+	queryMap[ViewerReactions] = Query{
+		SQL: `SELECT r.post_id
+					, r.reaction
+			  FROM post_reactions r
+			  WHERE r.user_id = ?
+			  AND r.post_id IN (%s)`,
+		Timeout: time.Second * 10,
+	}
 }
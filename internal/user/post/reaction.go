@@ -0,0 +1,52 @@
+package post
+
+// Reactions users can leave on posts, a user has at most one per post
+const (
+	ReactionLike  = "like"
+	ReactionLove  = "love"
+	ReactionLaugh = "laugh"
+	ReactionWow   = "wow"
+	ReactionSad   = "sad"
+	ReactionAngry = "angry"
+)
+
+// ReactionKinds lists the reactions in the order they are shown
+var ReactionKinds = []string{ReactionLike, ReactionLove, ReactionLaugh, ReactionWow, ReactionSad, ReactionAngry}
+
+var reactionEmoji = map[string]string{
+	ReactionLike:  "👍",
+	ReactionLove:  "❤️",
+	ReactionLaugh: "😂",
+	ReactionWow:   "😮",
+	ReactionSad:   "😢",
+	ReactionAngry: "😡",
+}
+
+func IsReaction(kind string) bool {
+	_, ok := reactionEmoji[kind]
+	return ok
+}
+
+type ReactionCount struct {
+	Kind  string
+	Emoji string
+	Count int
+	// Own is set if it's the reaction of the viewer
+	Own bool
+}
+
+// ReactionCounts returns the counters of every reaction kind for templates.
+func (p Post) ReactionCounts() []ReactionCount {
+	counts := make([]ReactionCount, 0, len(ReactionKinds))
+
+	for _, kind := range ReactionKinds {
+		counts = append(counts, ReactionCount{
+			Kind:  kind,
+			Emoji: reactionEmoji[kind],
+			Count: p.Reactions[kind],
+			Own:   p.ViewerReaction == kind,
+		})
+	}
+
+	return counts
+}
@@ -0,0 +1,109 @@
+package reaction
+
+import (
+	"log"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Change is a change of a reaction counter written to one of its slots
+type Change struct {
+	PostID int
+	Kind   string
+	Slot   int
+	Delta  int
+}
+
+type counterStore interface {
+	AddCounts(changes []Change) error
+}
+
+type counterKey struct {
+	postId int
+	kind   string
+}
+
+// Counter sums the reaction changes in memory and flushes them to a random
+// one of the slot rows of each counter, so a popular post doesn't contend
+// on a single row. Changes not flushed before exit are lost.
+type Counter struct {
+	store counterStore
+	slots int
+
+	mu      sync.Mutex
+	pending map[counterKey]int
+	slot    func(n int) int
+}
+
+func NewCounter(store counterStore, slots int) *Counter {
+	if slots <= 0 {
+		slots = 1
+	}
+
+	return &Counter{
+		store:   store,
+		slots:   slots,
+		pending: make(map[counterKey]int),
+		slot:    rand.Intn,
+	}
+}
+
+func (c *Counter) Add(postId int, kind string, delta int) {
+	c.mu.Lock()
+	c.pending[counterKey{postId: postId, kind: kind}] += delta
+	c.mu.Unlock()
+}
+
+// Flush writes the pending changes. They are kept for the next flush if
+// the write fails.
+func (c *Counter) Flush() error {
+	c.mu.Lock()
+	pending := c.pending
+	c.pending = make(map[counterKey]int)
+	c.mu.Unlock()
+
+	changes := make([]Change, 0, len(pending))
+	for k, delta := range pending {
+		// Changing a reaction back and forth cancels out
+		if delta == 0 {
+			continue
+		}
+
+		changes = append(changes, Change{PostID: k.postId, Kind: k.kind, Slot: c.slot(c.slots), Delta: delta})
+	}
+
+	// The same order of rows in every flush keeps them from deadlocking
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].PostID != changes[j].PostID {
+			return changes[i].PostID < changes[j].PostID
+		}
+		return changes[i].Kind < changes[j].Kind
+	})
+
+	if err := c.store.AddCounts(changes); err != nil {
+		c.mu.Lock()
+		for k, delta := range pending {
+			c.pending[k] += delta
+		}
+		c.mu.Unlock()
+
+		return err
+	}
+
+	return nil
+}
+
+// Run flushes the changes every interval. It blocks, so run it in a
+// goroutine.
+func (c *Counter) Run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := c.Flush(); err != nil {
+			log.Printf("flushing reaction counters: %v", err)
+		}
+	}
+}
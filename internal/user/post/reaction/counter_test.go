@@ -0,0 +1,58 @@
+package reaction
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeStore struct {
+	flushed [][]Change
+	err     error
+}
+
+func (f *fakeStore) AddCounts(changes []Change) error {
+	if f.err != nil {
+		return f.err
+	}
+
+	f.flushed = append(f.flushed, changes)
+	return nil
+}
+
+func TestCounter_Flush(t *testing.T) {
+	store := &fakeStore{}
+	counter := NewCounter(store, 4)
+	counter.slot = func(n int) int { return n - 1 }
+
+	counter.Add(2, "like", 1)
+	counter.Add(2, "like", 1)
+	counter.Add(1, "sad", 1)
+	counter.Add(1, "love", 1)
+	counter.Add(1, "love", -1)
+
+	assert.Nil(t, counter.Flush())
+	assert.Equal(t, [][]Change{{
+		{PostID: 1, Kind: "sad", Slot: 3, Delta: 1},
+		{PostID: 2, Kind: "like", Slot: 3, Delta: 2},
+	}}, store.flushed)
+
+	// Nothing is left for the next flush
+	assert.Nil(t, counter.Flush())
+	assert.Empty(t, store.flushed[1])
+}
+
+func TestCounter_Flush_KeepsChangesOnError(t *testing.T) {
+	store := &fakeStore{err: fmt.Errorf("db is down")}
+	counter := NewCounter(store, 1)
+
+	counter.Add(1, "like", 1)
+	assert.NotNil(t, counter.Flush())
+
+	counter.Add(1, "like", 1)
+	store.err = nil
+
+	assert.Nil(t, counter.Flush())
+	assert.Equal(t, [][]Change{{{PostID: 1, Kind: "like", Slot: 0, Delta: 2}}}, store.flushed)
+}
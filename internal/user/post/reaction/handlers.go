@@ -0,0 +1,137 @@
+package reaction
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/niklod/highload-social-network/internal/server"
+	"github.com/niklod/highload-social-network/internal/user"
+)
+
+type ReactionRequest struct {
+	Kind string `form:"kind" json:"kind" binding:"required"`
+}
+
+type ReactionResponse struct {
+	PostID int    `json:"postId"`
+	Kind   string `json:"kind"`
+}
+
+type ReactionHandler struct {
+	reactionService *Service
+}
+
+func NewHandler(reactionService *Service) *ReactionHandler {
+	return &ReactionHandler{
+		reactionService: reactionService,
+	}
+}
+
+// HandleReact sets or removes the reaction and redirects back.
+func (h *ReactionHandler) HandleReact(c *gin.Context) {
+	authUser := user.AuthenticatedUser(c)
+	if authUser == nil {
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
+
+	postId, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.Status(http.StatusNotFound)
+		return
+	}
+
+	if c.PostForm("remove") != "" {
+		err = h.reactionService.Unreact(c.Request.Context(), authUser.ID, postId)
+	} else {
+		err = h.reactionService.React(c.Request.Context(), authUser.ID, postId, c.PostForm("kind"))
+	}
+
+	switch err {
+	case nil:
+	case ErrUnknownReaction:
+		c.Status(http.StatusBadRequest)
+		return
+	case ErrPostNotFound:
+		c.Status(http.StatusNotFound)
+		return
+	default:
+		log.Printf("reacting to post: %v", err)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	back := c.Request.Referer()
+	if back == "" {
+		back = fmt.Sprintf("/user/%s/posts/%d", c.Param("login"), postId)
+	}
+
+	c.Redirect(http.StatusSeeOther, back)
+}
+
+func (h *ReactionHandler) HandleAPIReact(c *gin.Context) {
+	authUser, postId, ok := apiRequest(c)
+	if !ok {
+		return
+	}
+
+	req := &ReactionRequest{}
+	if err := c.ShouldBind(req); err != nil {
+		server.RespondError(c, http.StatusBadRequest, server.ErrCodeBadRequest, "Некорректное тело запроса", err.Error())
+		return
+	}
+
+	err := h.reactionService.React(c.Request.Context(), authUser.ID, postId, req.Kind)
+	switch err {
+	case nil:
+	case ErrUnknownReaction:
+		server.RespondError(c, http.StatusUnprocessableEntity, server.ErrCodeValidation, "Ошибка валидации", err.Error())
+		return
+	case ErrPostNotFound:
+		server.RespondError(c, http.StatusNotFound, server.ErrCodeNotFound, "Пост не найден")
+		return
+	default:
+		log.Printf("api react to post: %v", err)
+		server.RespondError(c, http.StatusInternalServerError, server.ErrCodeInternal, "Внутренняя ошибка сервера")
+		return
+	}
+
+	server.RespondData(c, http.StatusOK, ReactionResponse{PostID: postId, Kind: req.Kind})
+}
+
+func (h *ReactionHandler) HandleAPIUnreact(c *gin.Context) {
+	authUser, postId, ok := apiRequest(c)
+	if !ok {
+		return
+	}
+
+	if err := h.reactionService.Unreact(c.Request.Context(), authUser.ID, postId); err != nil {
+		log.Printf("api remove reaction: %v", err)
+		server.RespondError(c, http.StatusInternalServerError, server.ErrCodeInternal, "Внутренняя ошибка сервера")
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// apiRequest returns the authenticated user and the post from the :id path
+// parameter.
+func apiRequest(c *gin.Context) (*user.User, int, bool) {
+	authUser := user.AuthenticatedUser(c)
+	if authUser == nil {
+		server.RespondError(c, http.StatusUnauthorized, server.ErrCodeUnauthorized, "Требуется авторизация")
+		return nil, 0, false
+	}
+
+	postId, err := strconv.Atoi(c.Param("id"))
+	if err != nil || postId <= 0 {
+		server.RespondError(c, http.StatusNotFound, server.ErrCodeNotFound, "Пост не найден")
+		return nil, 0, false
+	}
+
+	return authUser, postId, true
+}
@@ -0,0 +1,136 @@
+package reaction
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/niklod/highload-social-network/internal/cluster"
+)
+
+type mysql struct {
+	db *cluster.Cluster
+}
+
+func NewRepository(db *cluster.Cluster) repository {
+	return &mysql{db: db}
+}
+
+// Set stores the user's reaction to the post and returns the one it
+// replaced. It inserts before reading, as locking the read of a missing
+// row takes a gap lock that deadlocks concurrent inserts.
+func (m *mysql) Set(ctx context.Context, postId, userId int, kind string) (string, error) {
+	var prev string
+
+	err := m.inTx(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, queryMap[insertReaction].SQL, postId, userId, kind)
+		if err != nil {
+			return fmt.Errorf("setting reaction to post %d: %v", postId, err)
+		}
+
+		inserted, err := res.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("setting reaction to post %d: %v", postId, err)
+		}
+		if inserted == 1 {
+			return nil
+		}
+
+		prev, err = current(ctx, tx, postId, userId)
+		if err != nil || prev == kind {
+			return err
+		}
+
+		if _, err := tx.ExecContext(ctx, queryMap[updateReaction].SQL, kind, postId, userId); err != nil {
+			return fmt.Errorf("setting reaction to post %d: %v", postId, err)
+		}
+
+		return nil
+	})
+
+	return prev, err
+}
+
+// Remove deletes the user's reaction to the post and returns it, empty if
+// the user hadn't reacted.
+func (m *mysql) Remove(ctx context.Context, postId, userId int) (string, error) {
+	var prev string
+
+	err := m.inTx(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		var err error
+
+		prev, err = current(ctx, tx, postId, userId)
+		if err != nil || prev == "" {
+			return err
+		}
+
+		query := queryMap[deleteReaction]
+		if _, err := tx.ExecContext(ctx, query.SQL, postId, userId); err != nil {
+			return fmt.Errorf("removing reaction to post %d: %v", postId, err)
+		}
+
+		return nil
+	})
+
+	return prev, err
+}
+
+// AddCounts adds the changes to the counters in one query.
+func (m *mysql) AddCounts(changes []Change) error {
+	if len(changes) == 0 {
+		return nil
+	}
+
+	query := queryMap[addCounts]
+	ctx, cancel := context.WithTimeout(context.Background(), query.Timeout)
+	defer cancel()
+
+	values := strings.TrimSuffix(strings.Repeat("(?, ?, ?, ?), ", len(changes)), ", ")
+	args := make([]interface{}, 0, 4*len(changes))
+	for _, c := range changes {
+		args = append(args, c.PostID, c.Kind, c.Slot, c.Delta)
+	}
+
+	if _, err := m.db.Writer(ctx).ExecContext(ctx, fmt.Sprintf(query.SQL, values), args...); err != nil {
+		return fmt.Errorf("adding reaction counts: %v", err)
+	}
+
+	return nil
+}
+
+// inTx runs fn in a transaction, the session's reads become sticky to the
+// primary
+func (m *mysql) inTx(ctx context.Context, fn func(ctx context.Context, tx *sql.Tx) error) error {
+	ctx, cancel := context.WithTimeout(ctx, queryMap[insertReaction].Timeout)
+	defer cancel()
+
+	tx, err := m.db.Writer(ctx).BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("starting transaction: %v", err)
+	}
+
+	if err := fn(ctx, tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("committing transaction: %v", err)
+	}
+
+	return nil
+}
+
+// current reads the user's reaction locking the row, so concurrent changes
+// count once.
+func current(ctx context.Context, tx *sql.Tx, postId, userId int) (string, error) {
+	var kind string
+
+	err := tx.QueryRowContext(ctx, queryMap[getReaction].SQL, postId, userId).Scan(&kind)
+	if err != nil && err != sql.ErrNoRows {
+		return "", fmt.Errorf("getting reaction to post %d: %v", postId, err)
+	}
+
+	return kind, nil
+}
@@ -0,0 +1,88 @@
+package reaction
+
+import (
+	"context"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/niklod/highload-social-network/internal/cluster"
+)
+
+func Test_mysql_Set_ReplacesReaction(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := NewRepository(cluster.Single(db))
+
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO post_reactions").WithArgs(5, 22, "like").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT reaction").WithArgs(5, 22).WillReturnRows(sqlmock.NewRows([]string{"reaction"}).AddRow("sad"))
+	mock.ExpectExec("UPDATE post_reactions").WithArgs("like", 5, 22).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	prev, err := repo.Set(context.Background(), 5, 22, "like")
+
+	assert.Nil(t, err)
+	assert.Equal(t, "sad", prev)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func Test_mysql_Set_NewReaction(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := NewRepository(cluster.Single(db))
+
+	// The inserted row has nothing to replace, the missing one isn't read
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO post_reactions").WithArgs(5, 22, "like").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	prev, err := repo.Set(context.Background(), 5, 22, "like")
+
+	assert.Nil(t, err)
+	assert.Empty(t, prev)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func Test_mysql_Remove_NoReaction(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := NewRepository(cluster.Single(db))
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT reaction").WithArgs(5, 22).WillReturnRows(sqlmock.NewRows([]string{"reaction"}))
+	mock.ExpectCommit()
+
+	prev, err := repo.Remove(context.Background(), 5, 22)
+
+	assert.Nil(t, err)
+	assert.Empty(t, prev)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func Test_mysql_AddCounts(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := NewRepository(cluster.Single(db))
+
+	mock.ExpectExec("INSERT INTO post_reaction_counters").
+		WithArgs(1, "sad", 0, 1, 2, "like", 3, -1).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+
+	err = repo.AddCounts([]Change{
+		{PostID: 1, Kind: "sad", Slot: 0, Delta: 1},
+		{PostID: 2, Kind: "like", Slot: 3, Delta: -1},
+	})
+
+	assert.Nil(t, err)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
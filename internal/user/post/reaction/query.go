@@ -0,0 +1,60 @@
+package reaction
+
+import "time"
+
+const (
+	getReaction = iota
+	insertReaction
+	updateReaction
+	deleteReaction
+	addCounts
+)
+
+type Query struct {
+	SQL     string
+	Timeout time.Duration
+}
+
+var queryMap map[int]Query
+
+func init() {
+	queryMap = make(map[int]Query)
+
+	queryMap[getReaction] = Query{
+		SQL: `SELECT reaction
+			FROM post_reactions
+			WHERE post_id = ?
+			AND user_id = ?
+			FOR UPDATE`,
+		Timeout: 5 * time.Second,
+	}
+
+	// Only a new row is affected, an existing one is just locked
+	queryMap[insertReaction] = Query{
+		SQL: `INSERT INTO post_reactions (post_id, user_id, reaction)
+				VALUES (?, ?, ?)
+				ON DUPLICATE KEY UPDATE reaction = reaction;`,
+		Timeout: 5 * time.Second,
+	}
+
+	queryMap[updateReaction] = Query{
+		SQL: `UPDATE post_reactions
+			SET reaction = ?
+			WHERE post_id = ?
+			AND user_id = ?`,
+		Timeout: 5 * time.Second,
+	}
+
+	queryMap[deleteReaction] = Query{
+		SQL:     `DELETE FROM post_reactions WHERE post_id = ? AND user_id = ?`,
+		Timeout: 5 * time.Second,
+	}
+
+	// Values placeholders are added by the repository
+	queryMap[addCounts] = Query{
+		SQL: `INSERT INTO post_reaction_counters (post_id, reaction, slot, count)
+				VALUES %s
+				ON DUPLICATE KEY UPDATE count = count + VALUES(count);`,
+		Timeout: 10 * time.Second,
+	}
+}
@@ -0,0 +1,87 @@
+package reaction
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/niklod/highload-social-network/internal/user/post"
+)
+
+var (
+	ErrUnknownReaction = fmt.Errorf("unknown reaction")
+	ErrPostNotFound    = post.ErrPostNotFound
+)
+
+type repository interface {
+	Set(ctx context.Context, postId, userId int, kind string) (string, error)
+	Remove(ctx context.Context, postId, userId int) (string, error)
+	AddCounts(changes []Change) error
+}
+
+// posts looks the posts up, see post.Service
+type posts interface {
+	Post(ctx context.Context, id, viewerId int) (*post.Post, error)
+}
+
+type counter interface {
+	Add(postId int, kind string, delta int)
+}
+
+type Service struct {
+	repo    repository
+	posts   posts
+	counter counter
+}
+
+func NewService(repo repository, posts posts, counter counter) *Service {
+	return &Service{
+		repo:    repo,
+		posts:   posts,
+		counter: counter,
+	}
+}
+
+// React sets the user's reaction to the post, replacing the previous one.
+// The counters are updated asynchronously, see Counter.
+func (s *Service) React(ctx context.Context, userId, postId int, kind string) error {
+	if !post.IsReaction(kind) {
+		return ErrUnknownReaction
+	}
+
+	p, err := s.posts.Post(ctx, postId, 0)
+	if err != nil {
+		return fmt.Errorf("reaction.Service: %v", err)
+	}
+	if p == nil {
+		return ErrPostNotFound
+	}
+
+	prev, err := s.repo.Set(ctx, postId, userId, kind)
+	if err != nil {
+		return fmt.Errorf("reaction.Service: %v", err)
+	}
+	if prev == kind {
+		return nil
+	}
+
+	if prev != "" {
+		s.counter.Add(postId, prev, -1)
+	}
+	s.counter.Add(postId, kind, 1)
+
+	return nil
+}
+
+// Unreact removes the user's reaction to the post, if any.
+func (s *Service) Unreact(ctx context.Context, userId, postId int) error {
+	prev, err := s.repo.Remove(ctx, postId, userId)
+	if err != nil {
+		return fmt.Errorf("reaction.Service: %v", err)
+	}
+
+	if prev != "" {
+		s.counter.Add(postId, prev, -1)
+	}
+
+	return nil
+}
@@ -0,0 +1,80 @@
+package reaction
+
+import (
+	"context"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/niklod/highload-social-network/internal/cluster"
+	"github.com/niklod/highload-social-network/internal/user/post"
+)
+
+type fakePosts map[int]*post.Post
+
+func (f fakePosts) Post(ctx context.Context, id, viewerId int) (*post.Post, error) {
+	return f[id], nil
+}
+
+func TestService_React_MovesCount(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := &fakeStore{}
+	counter := NewCounter(store, 1)
+	svc := NewService(NewRepository(cluster.Single(db)), fakePosts{5: {ID: 5}}, counter)
+
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO post_reactions").WithArgs(5, 22, post.ReactionLike).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT reaction").WithArgs(5, 22).WillReturnRows(sqlmock.NewRows([]string{"reaction"}).AddRow(post.ReactionSad))
+	mock.ExpectExec("UPDATE post_reactions").WithArgs(post.ReactionLike, 5, 22).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	err = svc.React(context.Background(), 22, 5, post.ReactionLike)
+
+	assert.Nil(t, err)
+	assert.Nil(t, counter.Flush())
+	assert.Equal(t, [][]Change{{
+		{PostID: 5, Kind: post.ReactionLike, Delta: 1},
+		{PostID: 5, Kind: post.ReactionSad, Delta: -1},
+	}}, store.flushed)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestService_React_SameReactionCountsOnce(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := &fakeStore{}
+	counter := NewCounter(store, 1)
+	svc := NewService(NewRepository(cluster.Single(db)), fakePosts{5: {ID: 5}}, counter)
+
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO post_reactions").WithArgs(5, 22, post.ReactionLike).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT reaction").WithArgs(5, 22).WillReturnRows(sqlmock.NewRows([]string{"reaction"}).AddRow(post.ReactionLike))
+	mock.ExpectCommit()
+
+	err = svc.React(context.Background(), 22, 5, post.ReactionLike)
+
+	assert.Nil(t, err)
+	assert.Nil(t, counter.Flush())
+	assert.Empty(t, store.flushed[0])
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestService_React_Validation(t *testing.T) {
+	db, _, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	svc := NewService(NewRepository(cluster.Single(db)), fakePosts{5: {ID: 5}}, NewCounter(&fakeStore{}, 1))
+
+	assert.Equal(t, ErrUnknownReaction, svc.React(context.Background(), 22, 5, "meh"))
+	assert.Equal(t, ErrPostNotFound, svc.React(context.Background(), 22, 6, post.ReactionLike))
+}
@@ -1,80 +1,345 @@
 package post
 
 import (
+	"context"
 	"fmt"
 	"time"
 
-	"github.com/niklod/highload-social-network/internal/cache"
-	"github.com/niklod/highload-social-network/internal/queue/feed/producer"
+	"github.com/niklod/highload-social-network/internal/outbox"
 )
 
 const (
-	feedLength = 1000
+	// FeedLength is the number of the newest feed posts kept in cache
+	FeedLength = 1000
+
+	DefaultPageSize = 20
+	MaxPageSize     = 100
 )
 
 var (
 	errIdLessThanZero = fmt.Errorf("id should be greated than zero")
 	errNilPost        = fmt.Errorf("post can't be nil")
 	errEmptyPostBody  = fmt.Errorf("post body can't be empty")
+
+	ErrPostNotFound = fmt.Errorf("post not found")
+	ErrNotAuthor    = fmt.Errorf("only the author can change the post")
 )
 
 type repository interface {
-	PostsByUserId(id int) ([]Post, error)
-	UserFeed(id int) (Feed, error)
-	Add(post *Post, userId int) error
+	PostsByUserId(ctx context.Context, id int, after *Cursor, limit int) ([]Post, error)
+	UserFeed(ctx context.Context, id int, excluded []int, after *Cursor, limit int) (Feed, error)
+	CelebrityFeed(ctx context.Context, userId int, authorIds []int, after *Cursor, limit int) (Feed, error)
+	PostsByIds(ctx context.Context, ids []int) (Feed, error)
+	Add(ctx context.Context, post *Post, userId int) error
+	GetByID(ctx context.Context, id int) (*Post, error)
+	Update(ctx context.Context, post *Post, userId int) error
+	Delete(ctx context.Context, post *Post, userId int) error
+	CommentCounts(ctx context.Context, ids []int) (map[int]int, error)
+	ReactionCounts(ctx context.Context, ids []int) (map[int]map[string]int, error)
+	ViewerReactions(ctx context.Context, userId int, ids []int) (map[int]string, error)
+	ClaimOutbox(limit int, publish func([]outbox.Message) []int64) error
+}
+
+// celebrities lists the authors whose posts are not fanned out to the
+// cached feeds, see user.Celebrities
+type celebrities interface {
+	Celebrities() []int
 }
 
 type Service struct {
-	repo     repository
-	cache    cache.Cache
-	producer *producer.FeedProducer
+	repo        repository
+	feeds       FeedStore
+	posts       PostStore
+	celebrities celebrities
 }
 
-func NewService(repo repository, cache cache.Cache, producer *producer.FeedProducer) *Service {
+// NewService creates the service, nil celebrities fans out every post.
+func NewService(repo repository, feeds FeedStore, posts PostStore, celebrities celebrities) *Service {
 	return &Service{
-		repo:     repo,
-		cache:    cache,
-		producer: producer,
+		repo:        repo,
+		feeds:       feeds,
+		posts:       posts,
+		celebrities: celebrities,
 	}
 }
 
-func (s *Service) UserFeed(userId int) (Feed, error) {
+// UserFeed returns a page of the user's feed after the cursor. The newest
+// FeedLength posts come from cache, the older ones and the celebrities'
+// posts from DB.
+func (s *Service) UserFeed(ctx context.Context, userId int, after *Cursor, limit int) (*Page, error) {
 	if userId <= 0 {
 		return nil, errIdLessThanZero
 	}
 
-	v, ok := s.cache.Read(userId)
-	if ok {
-		userFeed, ok := v.(Feed)
-		if !ok {
-			return nil, fmt.Errorf("post.Service: %v", cache.ErrInvalidCacheItem)
+	limit = pageSize(limit)
+	celebrities := s.celebrityIds()
+
+	// One post more tells whether there is the next page
+	posts, until, err := s.cachedPosts(ctx, userId, celebrities, after, limit+1)
+	if err != nil {
+		return nil, fmt.Errorf("post.Service: %v", err)
+	}
+
+	// The cache holds the whole feed unless it's capped
+	if len(posts) <= limit && until != nil {
+		from := after
+		if len(posts) > 0 {
+			from = CursorOf(posts[len(posts)-1])
 		}
-		if len(userFeed) > feedLength {
-			userFeed = userFeed[len(userFeed)-feedLength:]
+
+		older, err := s.repo.UserFeed(ctx, userId, celebrities, from, limit+1-len(posts))
+		if err != nil {
+			return nil, fmt.Errorf("post.Service: %v", err)
 		}
 
-		return userFeed, nil
+		posts = append(posts, older...)
 	}
 
-	userFeed, err := s.repo.UserFeed(userId)
+	celebrityPosts, err := s.repo.CelebrityFeed(ctx, userId, celebrities, after, limit+1)
 	if err != nil {
 		return nil, fmt.Errorf("post.Service: %v", err)
 	}
 
-	go s.cache.Write(userId, userFeed)
+	posts = posts.Merge(celebrityPosts, limit+1)
+
+	return s.withCounters(ctx, newPage(posts, limit), userId)
+}
+
+// celebrityIds returns the authors left out of the cached feeds.
+func (s *Service) celebrityIds() []int {
+	if s.celebrities == nil {
+		return nil
+	}
+
+	return s.celebrities.Celebrities()
+}
+
+// cachedPosts returns up to limit posts of the cached feed after the cursor
+// and FeedPage.Until, filling the cache from DB on a miss.
+func (s *Service) cachedPosts(ctx context.Context, userId int, celebrities []int, after *Cursor, limit int) (Feed, *Cursor, error) {
+	page, ok := s.feeds.Page(userId, after, limit)
+	if !ok {
+		feed, err := s.repo.UserFeed(ctx, userId, celebrities, nil, FeedLength)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		refs := RefsOf(feed)
+		s.posts.Fill(feed...)
+		s.feeds.Fill(userId, refs)
+
+		page = FeedPage{Refs: refs.After(after, limit)}
+		if len(refs) >= FeedLength {
+			page.Until = CursorOf(feed[len(feed)-1])
+		}
+	}
+
+	posts, err := s.hydrate(ctx, page.Refs)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Fill the places of the posts deleted meanwhile
+	refs := page.Refs
+	for len(posts) < limit && len(refs) > 0 && ok {
+		last := refs[len(refs)-1].cursor()
+		next, _ := s.feeds.Page(userId, &last, limit-len(posts))
+		if len(next.Refs) == 0 {
+			break
+		}
+
+		more, err := s.hydrate(ctx, next.Refs)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		posts = append(posts, more...)
+		refs = next.Refs
+	}
+
+	return posts, page.Until, nil
+}
+
+// RebuildFeed reads the newest FeedLength posts of the user's feed from DB
+// into cache, for the feed warm-up.
+func (s *Service) RebuildFeed(ctx context.Context, userId int) error {
+	if userId <= 0 {
+		return errIdLessThanZero
+	}
+
+	feed, err := s.repo.UserFeed(ctx, userId, s.celebrityIds(), nil, FeedLength)
+	if err != nil {
+		return fmt.Errorf("post.Service: %v", err)
+	}
+
+	s.posts.Fill(feed...)
+	s.feeds.Set(userId, RefsOf(feed))
+
+	return nil
+}
+
+// hydrate reads the posts of the refs from cache, the missing ones are
+// read from DB. The order of the refs is kept, deleted posts are skipped.
+func (s *Service) hydrate(ctx context.Context, refs Refs) (Feed, error) {
+	if len(refs) == 0 {
+		return nil, nil
+	}
+
+	ids := make([]int, len(refs))
+	for i, ref := range refs {
+		ids[i] = ref.ID
+	}
+
+	cached := s.posts.Get(ids)
+
+	var missing []int
+	for _, id := range ids {
+		if _, ok := cached[id]; !ok {
+			missing = append(missing, id)
+		}
+	}
+
+	if len(missing) > 0 {
+		loaded, err := s.repo.PostsByIds(ctx, missing)
+		if err != nil {
+			return nil, err
+		}
+
+		s.posts.Fill(loaded...)
+		for _, p := range loaded {
+			cached[p.ID] = p
+		}
+	}
+
+	feed := make(Feed, 0, len(refs))
+	for _, id := range ids {
+		if p, ok := cached[id]; ok {
+			feed = append(feed, p)
+		}
+	}
+
+	return feed, nil
+}
+
+// PostsByUserId returns a page of the user's posts as seen by the viewer,
+// zero viewerId is an anonymous one.
+func (s *Service) PostsByUserId(ctx context.Context, id, viewerId int, after *Cursor, limit int) (*Page, error) {
+	if id <= 0 {
+		return nil, errIdLessThanZero
+	}
+
+	limit = pageSize(limit)
+
+	posts, err := s.repo.PostsByUserId(ctx, id, after, limit+1)
+	if err != nil {
+		return nil, fmt.Errorf("post.Service: %v", err)
+	}
 
-	return userFeed, nil
+	return s.withCounters(ctx, newPage(posts, limit), viewerId)
 }
 
-func (s *Service) PostsByUserId(id int) ([]Post, error) {
+// Post returns the post as seen by the viewer or nil if there is none.
+func (s *Service) Post(ctx context.Context, id, viewerId int) (*Post, error) {
 	if id <= 0 {
 		return nil, errIdLessThanZero
 	}
 
-	return s.repo.PostsByUserId(id)
+	p, err := s.repo.GetByID(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("post.Service: %v", err)
+	}
+	if p == nil {
+		return nil, nil
+	}
+
+	posts, err := s.counted(ctx, Feed{*p}, viewerId)
+	if err != nil {
+		return nil, err
+	}
+
+	return &posts[0], nil
+}
+
+// withCounters sets the counters of the page posts.
+func (s *Service) withCounters(ctx context.Context, page *Page, viewerId int) (*Page, error) {
+	posts, err := s.counted(ctx, page.Posts, viewerId)
+	if err != nil {
+		return nil, err
+	}
+
+	page.Posts = posts
+
+	return page, nil
+}
+
+// counted returns a copy of the posts with the counters and the viewer's
+// reactions. The posts may be cached ones, so they aren't changed in place.
+func (s *Service) counted(ctx context.Context, posts Feed, viewerId int) (Feed, error) {
+	if len(posts) == 0 {
+		return posts, nil
+	}
+
+	ids := make([]int, len(posts))
+	for i, p := range posts {
+		ids[i] = p.ID
+	}
+
+	comments, err := s.repo.CommentCounts(ctx, ids)
+	if err != nil {
+		return nil, fmt.Errorf("post.Service: %v", err)
+	}
+
+	reactions, err := s.repo.ReactionCounts(ctx, ids)
+	if err != nil {
+		return nil, fmt.Errorf("post.Service: %v", err)
+	}
+
+	viewerReactions := map[int]string{}
+	if viewerId > 0 {
+		viewerReactions, err = s.repo.ViewerReactions(ctx, viewerId, ids)
+		if err != nil {
+			return nil, fmt.Errorf("post.Service: %v", err)
+		}
+	}
+
+	res := make(Feed, len(posts))
+	for i, p := range posts {
+		p.CommentsCount = comments[p.ID]
+		p.Reactions = reactions[p.ID]
+		p.ViewerReaction = viewerReactions[p.ID]
+		res[i] = p
+	}
+
+	return res, nil
+}
+
+func pageSize(limit int) int {
+	if limit <= 0 {
+		return DefaultPageSize
+	}
+	if limit > MaxPageSize {
+		return MaxPageSize
+	}
+
+	return limit
+}
+
+// newPage cuts the extra post read to tell the next page
+func newPage(posts Feed, limit int) *Page {
+	if posts == nil {
+		posts = Feed{}
+	}
+
+	if len(posts) <= limit {
+		return &Page{Posts: posts}
+	}
+
+	posts = posts[:limit]
+
+	return &Page{Posts: posts, Next: CursorOf(posts[limit-1])}
 }
 
-func (s *Service) Add(post *Post) error {
+func (s *Service) Add(ctx context.Context, post *Post) error {
 	if post == nil {
 		return errNilPost
 	}
@@ -88,22 +353,74 @@ func (s *Service) Add(post *Post) error {
 		return errEmptyPostBody
 	}
 
-	err := s.repo.Add(post, authorId)
+	// DB keeps seconds, the cached posts must give the same cursors
+	post.CreatedAt = time.Now().UTC().Truncate(time.Second)
+	post.UpdatedAt = post.CreatedAt
+
+	// The feed message is written to the outbox with the post, see
+	// producer.Relay
+	err := s.repo.Add(ctx, post, authorId)
 	if err != nil {
 		return fmt.Errorf("post.Service: %v", err)
 	}
 
-	post.CreatedAt = time.Now().UTC()
+	return nil
+}
+
+// Update changes the body of the author's post. Friends' cached feeds are
+// patched by the feed receiver.
+func (s *Service) Update(ctx context.Context, authorId, postId int, body string) (*Post, error) {
+	if body == "" {
+		return nil, errEmptyPostBody
+	}
 
-	msg, err := post.AsByteJSON()
+	post, err := s.authorPost(ctx, authorId, postId)
 	if err != nil {
-		return fmt.Errorf("post.Service - can't marshal post to []byte: %v", err)
+		return nil, err
+	}
+
+	post.Body = body
+	post.UpdatedAt = time.Now().UTC().Truncate(time.Second)
+
+	if err := s.repo.Update(ctx, post, authorId); err != nil {
+		return nil, fmt.Errorf("post.Service: %v", err)
 	}
 
-	err = s.producer.SendFeedUpdateMessage(msg)
+	return post, nil
+}
+
+// Delete soft deletes the author's post and removes it from friends' feeds.
+func (s *Service) Delete(ctx context.Context, authorId, postId int) error {
+	post, err := s.authorPost(ctx, authorId, postId)
 	if err != nil {
-		return fmt.Errorf("post.Service - can't send message to queue: %v", err)
+		return err
+	}
+
+	if err := s.repo.Delete(ctx, post, authorId); err != nil {
+		if err == ErrPostNotFound {
+			return err
+		}
+		return fmt.Errorf("post.Service: %v", err)
 	}
 
 	return nil
 }
+
+func (s *Service) authorPost(ctx context.Context, authorId, postId int) (*Post, error) {
+	if authorId <= 0 || postId <= 0 {
+		return nil, errIdLessThanZero
+	}
+
+	post, err := s.repo.GetByID(ctx, postId)
+	if err != nil {
+		return nil, fmt.Errorf("post.Service: %v", err)
+	}
+	if post == nil {
+		return nil, ErrPostNotFound
+	}
+	if post.Author.ID != authorId {
+		return nil, ErrNotAuthor
+	}
+
+	return post, nil
+}
@@ -0,0 +1,287 @@
+package post
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/niklod/highload-social-network/internal/cache"
+	"github.com/niklod/highload-social-network/internal/cluster"
+)
+
+var postColumns = []string{"id", "created_at", "updated_at", "body", "first_name", "last_name", "login", "id"}
+
+var countColumns = []string{"post_id", "count"}
+
+var reactionColumns = []string{"post_id", "reaction", "count"}
+
+var viewerReactionColumns = []string{"post_id", "reaction"}
+
+func cacheFeed(feeds FeedStore, posts PostStore, userId int, feed Feed) {
+	feeds.Set(userId, RefsOf(feed))
+	posts.Put(feed...)
+}
+
+func TestService_UserFeed_PagesThroughCache(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	feeds, posts := NewFeedStore(cache.NewFeedCache()), NewPostStore(cache.NewFeedCache())
+	cacheFeed(feeds, posts, 1, Feed{
+		{ID: 3, CreatedAt: now},
+		{ID: 2, CreatedAt: now.Add(-time.Second)},
+		{ID: 1, CreatedAt: now.Add(-2 * time.Second)},
+	})
+
+	svc := NewService(NewRepository(cluster.Single(db)), feeds, posts, nil)
+
+	mock.ExpectQuery("SELECT c.post_id").WithArgs(3, 2).WillReturnRows(sqlmock.NewRows(countColumns).AddRow(2, 4))
+	mock.ExpectQuery("SELECT r.post_id").WithArgs(3, 2).WillReturnRows(sqlmock.NewRows(reactionColumns).
+		AddRow(3, ReactionLike, 5).
+		AddRow(3, ReactionSad, 0))
+	mock.ExpectQuery("SELECT r.post_id").WithArgs(1, 3, 2).WillReturnRows(sqlmock.NewRows(viewerReactionColumns).AddRow(3, ReactionLike))
+
+	page, err := svc.UserFeed(context.Background(), 1, nil, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, []int{3, 2}, ids(page.Posts))
+	assert.Equal(t, 4, page.Posts[1].CommentsCount)
+	assert.Equal(t, map[string]int{ReactionLike: 5}, page.Posts[0].Reactions)
+	assert.Equal(t, ReactionLike, page.Posts[0].ViewerReaction)
+	assert.Empty(t, page.Posts[1].ViewerReaction)
+	assert.NotNil(t, page.Next)
+
+	// A new post doesn't shift the next page
+	cacheFeed(feeds, posts, 1, Feed{
+		{ID: 4, CreatedAt: now.Add(time.Second)},
+		{ID: 3, CreatedAt: now},
+		{ID: 2, CreatedAt: now.Add(-time.Second)},
+		{ID: 1, CreatedAt: now.Add(-2 * time.Second)},
+	})
+
+	mock.ExpectQuery("SELECT c.post_id").WithArgs(1).WillReturnRows(sqlmock.NewRows(countColumns))
+	mock.ExpectQuery("SELECT r.post_id").WithArgs(1).WillReturnRows(sqlmock.NewRows(reactionColumns))
+	mock.ExpectQuery("SELECT r.post_id").WithArgs(1, 1).WillReturnRows(sqlmock.NewRows(viewerReactionColumns))
+
+	page, err = svc.UserFeed(context.Background(), 1, page.Next, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, []int{1}, ids(page.Posts))
+	assert.Nil(t, page.Next)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestService_UserFeed_ContinuesFromDBAfterCache(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	cached := make(Feed, FeedLength)
+	for i := range cached {
+		cached[i] = Post{ID: FeedLength + 10 - i, CreatedAt: now}
+	}
+
+	feeds, posts := NewFeedStore(cache.NewFeedCache()), NewPostStore(cache.NewFeedCache())
+	cacheFeed(feeds, posts, 1, cached)
+
+	svc := NewService(NewRepository(cluster.Single(db)), feeds, posts, nil)
+	last := cached[FeedLength-1]
+
+	// Two cached posts are on the page, the rest and one extra come from DB
+	rows := sqlmock.NewRows(postColumns).
+		AddRow(5, now, now, "db", "First", "Last", "login", 2).
+		AddRow(4, now, now, "db", "First", "Last", "login", 2).
+		AddRow(3, now, now, "db", "First", "Last", "login", 2)
+	mock.ExpectQuery("SELECT p.id").WithArgs(1, 1, last.CreatedAt, last.CreatedAt, last.ID, 3).WillReturnRows(rows)
+	mock.ExpectQuery("SELECT c.post_id").WillReturnRows(sqlmock.NewRows(countColumns))
+	mock.ExpectQuery("SELECT r.post_id").WillReturnRows(sqlmock.NewRows(reactionColumns))
+	mock.ExpectQuery("SELECT r.post_id").WillReturnRows(sqlmock.NewRows(viewerReactionColumns))
+
+	page, err := svc.UserFeed(context.Background(), 1, CursorOf(cached[FeedLength-3]), 4)
+
+	assert.Nil(t, err)
+	assert.Equal(t, []int{cached[FeedLength-2].ID, last.ID, 5, 4}, ids(page.Posts))
+	assert.Equal(t, 4, page.Next.ID)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestService_PostsByUserId_LastPage(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	svc := NewService(NewRepository(cluster.Single(db)), NewFeedStore(cache.NewFeedCache()), NewPostStore(cache.NewFeedCache()), nil)
+	now := time.Now()
+
+	rows := sqlmock.NewRows(postColumns).
+		AddRow(2, now, now, "second", "First", "Last", "login", 7).
+		AddRow(1, now, now, "first", "First", "Last", "login", 7)
+	mock.ExpectQuery("SELECT p.id").WithArgs(7, firstPage.CreatedAt, firstPage.CreatedAt, firstPage.ID, 3).WillReturnRows(rows)
+	mock.ExpectQuery("SELECT c.post_id").WithArgs(2, 1).WillReturnRows(sqlmock.NewRows(countColumns).AddRow(1, 2))
+	mock.ExpectQuery("SELECT r.post_id").WithArgs(2, 1).WillReturnRows(sqlmock.NewRows(reactionColumns))
+
+	// Anonymous viewer has no reactions to read
+	page, err := svc.PostsByUserId(context.Background(), 7, 0, nil, 2)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(page.Posts))
+	assert.Equal(t, 2, page.Posts[1].CommentsCount)
+	assert.Nil(t, page.Next)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestService_Update_NotAuthor(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	svc := NewService(NewRepository(cluster.Single(db)), NewFeedStore(cache.NewFeedCache()), NewPostStore(cache.NewFeedCache()), nil)
+	now := time.Now()
+
+	rows := sqlmock.NewRows(postColumns).AddRow(5, now, now, "body", "First", "Last", "author", 7)
+	mock.ExpectQuery("SELECT p.id").WithArgs(5).WillReturnRows(rows)
+
+	_, err = svc.Update(context.Background(), 8, 5, "edited")
+
+	assert.Equal(t, ErrNotAuthor, err)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestService_Delete_NotFound(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	svc := NewService(NewRepository(cluster.Single(db)), NewFeedStore(cache.NewFeedCache()), NewPostStore(cache.NewFeedCache()), nil)
+
+	mock.ExpectQuery("SELECT p.id").WithArgs(5).WillReturnRows(sqlmock.NewRows(postColumns))
+
+	err = svc.Delete(context.Background(), 7, 5)
+
+	assert.Equal(t, ErrPostNotFound, err)
+}
+
+type fakeCelebrities []int
+
+func (f fakeCelebrities) Celebrities() []int {
+	return f
+}
+
+func TestService_UserFeed_MergesCelebrityPosts(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	feeds, posts := NewFeedStore(cache.NewFeedCache()), NewPostStore(cache.NewFeedCache())
+	cacheFeed(feeds, posts, 1, Feed{
+		{ID: 5, CreatedAt: now},
+		{ID: 3, CreatedAt: now.Add(-time.Second), Body: "stale"},
+		{ID: 1, CreatedAt: now.Add(-2 * time.Second)},
+	})
+
+	svc := NewService(NewRepository(cluster.Single(db)), feeds, posts, fakeCelebrities{9})
+
+	// The celebrity post 3 was fanned out before the author became one
+	rows := sqlmock.NewRows(postColumns).
+		AddRow(6, now, now, "celebrity", "First", "Last", "star", 9).
+		AddRow(3, now.Add(-time.Second), now, "fresh", "First", "Last", "star", 9).
+		AddRow(2, now.Add(-2*time.Second), now, "celebrity", "First", "Last", "star", 9)
+	mock.ExpectQuery("SELECT p.id").WithArgs(9, 1, 1, firstPage.CreatedAt, firstPage.CreatedAt, firstPage.ID, 5).WillReturnRows(rows)
+	mock.ExpectQuery("SELECT c.post_id").WillReturnRows(sqlmock.NewRows(countColumns))
+	mock.ExpectQuery("SELECT r.post_id").WillReturnRows(sqlmock.NewRows(reactionColumns))
+	mock.ExpectQuery("SELECT r.post_id").WillReturnRows(sqlmock.NewRows(viewerReactionColumns))
+
+	page, err := svc.UserFeed(context.Background(), 1, nil, 4)
+
+	assert.Nil(t, err)
+	assert.Equal(t, []int{6, 5, 3, 2}, ids(page.Posts))
+	assert.Equal(t, "fresh", page.Posts[2].Body)
+	assert.Equal(t, 2, page.Next.ID)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestService_UserFeed_CelebrityPostsAfterFullCache(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	cached := make(Feed, FeedLength)
+	for i := range cached {
+		cached[i] = Post{ID: FeedLength + 10 - i, CreatedAt: now.Add(-time.Duration(i) * time.Second)}
+	}
+
+	feeds, posts := NewFeedStore(cache.NewFeedCache()), NewPostStore(cache.NewFeedCache())
+	cacheFeed(feeds, posts, 1, cached)
+
+	svc := NewService(NewRepository(cluster.Single(db)), feeds, posts, fakeCelebrities{9})
+	last := cached[FeedLength-1]
+	older := last.CreatedAt.Add(-time.Second)
+	after := CursorOf(cached[FeedLength-2])
+
+	// The posts of the others older than the cache are read from DB without
+	// the celebrity ones, which are merged in
+	mock.ExpectQuery("SELECT p.id").WithArgs(1, 1, 9, last.CreatedAt, last.CreatedAt, last.ID, 3).
+		WillReturnRows(sqlmock.NewRows(postColumns).AddRow(4, older, older, "friend", "First", "Last", "friend", 2))
+	mock.ExpectQuery("SELECT p.id").WithArgs(9, 1, 1, after.CreatedAt, after.CreatedAt, after.ID, 4).
+		WillReturnRows(sqlmock.NewRows(postColumns).AddRow(3, older, older, "celebrity", "First", "Last", "star", 9))
+	mock.ExpectQuery("SELECT c.post_id").WillReturnRows(sqlmock.NewRows(countColumns))
+	mock.ExpectQuery("SELECT r.post_id").WillReturnRows(sqlmock.NewRows(reactionColumns))
+	mock.ExpectQuery("SELECT r.post_id").WillReturnRows(sqlmock.NewRows(viewerReactionColumns))
+
+	page, err := svc.UserFeed(context.Background(), 1, after, 3)
+
+	assert.Nil(t, err)
+	assert.Equal(t, []int{last.ID, 4, 3}, ids(page.Posts))
+	assert.Nil(t, page.Next)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestService_UserFeed_ReadsMissingPosts(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	feeds, posts := NewFeedStore(cache.NewFeedCache()), NewPostStore(cache.NewFeedCache())
+	feeds.Set(1, Refs{
+		{ID: 4, CreatedAt: now},
+		{ID: 3, CreatedAt: now.Add(-time.Second)},
+		{ID: 2, CreatedAt: now.Add(-2 * time.Second)},
+		{ID: 1, CreatedAt: now.Add(-3 * time.Second)},
+	})
+	posts.Put(Post{ID: 4, CreatedAt: now}, Post{ID: 1, CreatedAt: now.Add(-3 * time.Second)})
+
+	svc := NewService(NewRepository(cluster.Single(db)), feeds, posts, nil)
+
+	// Post 3 is deleted, the next ref takes its place
+	mock.ExpectQuery("SELECT p.id").WithArgs(3, 2).
+		WillReturnRows(sqlmock.NewRows(postColumns).AddRow(2, now.Add(-2*time.Second), now, "db", "First", "Last", "login", 2))
+	mock.ExpectQuery("SELECT c.post_id").WillReturnRows(sqlmock.NewRows(countColumns))
+	mock.ExpectQuery("SELECT r.post_id").WillReturnRows(sqlmock.NewRows(reactionColumns))
+	mock.ExpectQuery("SELECT r.post_id").WillReturnRows(sqlmock.NewRows(viewerReactionColumns))
+
+	page, err := svc.UserFeed(context.Background(), 1, nil, 2)
+
+	assert.Nil(t, err)
+	assert.Equal(t, []int{4, 2}, ids(page.Posts))
+	assert.Equal(t, "db", page.Posts[1].Body)
+	assert.Equal(t, 2, page.Next.ID)
+	assert.Nil(t, mock.ExpectationsWereMet())
+
+	// The read post is cached
+	assert.Contains(t, posts.Get([]int{2}), 2)
+}
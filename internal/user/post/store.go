@@ -0,0 +1,335 @@
+package post
+
+import (
+	"sort"
+	"time"
+
+	"github.com/niklod/highload-social-network/internal/cache"
+)
+
+// refSize is the approximate size of a Ref
+const refSize = 48
+
+// Ref is a post in the cached feeds, the post itself is in PostStore, so
+// editing it doesn't touch the feeds.
+type Ref struct {
+	ID        int
+	AuthorID  int
+	CreatedAt time.Time
+}
+
+func RefOf(p Post) Ref {
+	return Ref{ID: p.ID, AuthorID: p.Author.ID, CreatedAt: p.CreatedAt}
+}
+
+func (r Ref) cursor() Cursor {
+	return Cursor{CreatedAt: r.CreatedAt, ID: r.ID}
+}
+
+// Refs is a feed of references, newest first.
+type Refs []Ref
+
+func RefsOf(feed Feed) Refs {
+	refs := make(Refs, len(feed))
+	for i, p := range feed {
+		refs[i] = RefOf(p)
+	}
+
+	return refs
+}
+
+// Size returns the approximate size of the refs in bytes, for the cache
+// budget.
+func (r Refs) Size() int {
+	return len(r) * refSize
+}
+
+// After returns up to limit refs after the cursor.
+func (r Refs) After(c *Cursor, limit int) Refs {
+	start := 0
+	if c != nil {
+		start = sort.Search(len(r), func(i int) bool {
+			return r[i].olderThan(*c)
+		})
+	}
+
+	end := start + limit
+	if end > len(r) {
+		end = len(r)
+	}
+
+	return r[start:end]
+}
+
+// Insert returns a copy of the refs with the ref in order, capped at
+// FeedLength.
+func (r Refs) Insert(ref Ref) Refs {
+	i := sort.Search(len(r), func(i int) bool {
+		return r[i].olderThan(ref.cursor()) || r[i].ID == ref.ID
+	})
+	if i < len(r) && r[i].ID == ref.ID {
+		return r
+	}
+
+	res := make(Refs, 0, len(r)+1)
+	res = append(res, r[:i]...)
+	res = append(res, ref)
+	res = append(res, r[i:]...)
+
+	if len(res) > FeedLength {
+		res = res[:FeedLength]
+	}
+
+	return res
+}
+
+// Remove returns a copy of the refs without the post.
+func (r Refs) Remove(id int) (Refs, bool) {
+	for i := range r {
+		if r[i].ID == id {
+			res := make(Refs, 0, len(r)-1)
+			res = append(res, r[:i]...)
+			return append(res, r[i+1:]...), true
+		}
+	}
+
+	return r, false
+}
+
+// newerThan returns the refs newer than the newest of the other ones, all
+// of them if the other are empty.
+func (r Refs) newerThan(other Refs) Refs {
+	if len(other) == 0 {
+		return r
+	}
+
+	newest := other[0].cursor()
+	for i, ref := range r {
+		if ref.ID == newest.ID || ref.olderThan(newest) {
+			return r[:i]
+		}
+	}
+
+	return r
+}
+
+func (r Ref) olderThan(c Cursor) bool {
+	if r.CreatedAt.Equal(c.CreatedAt) {
+		return r.ID < c.ID
+	}
+
+	return r.CreatedAt.Before(c.CreatedAt)
+}
+
+// FeedPage is a page of the cached feed. Until is the oldest cached ref of
+// a feed capped at FeedLength, nil if the whole feed is cached.
+type FeedPage struct {
+	Refs  Refs
+	Until *Cursor
+}
+
+// FeedStore keeps the feeds of the users as refs, changing each atomically.
+type FeedStore interface {
+	// Page returns up to limit refs after the cursor, false on a miss.
+	Page(userId int, after *Cursor, limit int) (FeedPage, bool)
+	// Size returns the number of the cached refs, false on a miss.
+	Size(userId int) (int, bool)
+	// Set replaces the cached feed with the one read from DB, keeping the
+	// posts prepended since
+	Set(userId int, refs Refs)
+	// Fill caches the feed read from DB unless it's cached already
+	Fill(userId int, refs Refs)
+	// Prepend puts the new post into the feed if it's cached.
+	Prepend(userId int, ref Ref) bool
+	Remove(userId int, postId int)
+	Invalidate(userId int)
+}
+
+// PostStore keeps the posts of the cached feeds by ID.
+type PostStore interface {
+	// Get returns the cached posts of the IDs.
+	Get(ids []int) map[int]Post
+	Put(posts ...Post)
+	// Fill caches the posts read from DB which aren't cached already
+	Fill(posts ...Post)
+	Delete(id int)
+}
+
+type feedStore struct {
+	cache cache.Cache
+}
+
+// NewFeedStore keeps the feeds in the cache as Refs values.
+func NewFeedStore(c cache.Cache) FeedStore {
+	return &feedStore{cache: c}
+}
+
+func (f *feedStore) refs(userId int) (Refs, bool) {
+	v, ok := f.cache.Read(userId)
+	if !ok {
+		return nil, false
+	}
+
+	refs, ok := v.(Refs)
+
+	return refs, ok
+}
+
+func (f *feedStore) Page(userId int, after *Cursor, limit int) (FeedPage, bool) {
+	refs, ok := f.refs(userId)
+	if !ok {
+		return FeedPage{}, false
+	}
+
+	page := FeedPage{Refs: refs.After(after, limit)}
+	if len(refs) >= FeedLength {
+		until := refs[len(refs)-1].cursor()
+		page.Until = &until
+	}
+
+	return page, true
+}
+
+func (f *feedStore) Size(userId int) (int, bool) {
+	refs, ok := f.refs(userId)
+
+	return len(refs), ok
+}
+
+func (f *feedStore) Set(userId int, refs Refs) {
+	f.cache.Update(userId, func(v interface{}, ok bool) (interface{}, bool) {
+		cached, _ := v.(Refs)
+
+		feed := refs
+		for _, ref := range cached.newerThan(refs) {
+			feed = feed.Insert(ref)
+		}
+
+		return feed, true
+	})
+}
+
+func (f *feedStore) Fill(userId int, refs Refs) {
+	f.cache.Update(userId, func(v interface{}, ok bool) (interface{}, bool) {
+		if _, ok := v.(Refs); ok {
+			return nil, false
+		}
+
+		return refs, true
+	})
+}
+
+// Prepend inserts the single ref when the cache is a cache.ListUpdater.
+func (f *feedStore) Prepend(userId int, ref Ref) bool {
+	if lists, ok := f.cache.(cache.ListUpdater); ok {
+		return lists.InsertItem(userId, Refs{ref}, func(v interface{}) int {
+			cached, _ := v.(Refs)
+			switch {
+			case len(cached) != 1:
+				return 1
+			case cached[0].ID == ref.ID:
+				return 0
+			case cached[0].olderThan(ref.cursor()):
+				return -1
+			}
+
+			return 1
+		})
+	}
+
+	prepended := false
+
+	f.cache.Update(userId, func(v interface{}, ok bool) (interface{}, bool) {
+		refs, ok := v.(Refs)
+		if !ok {
+			return nil, false
+		}
+
+		prepended = true
+
+		return refs.Insert(ref), true
+	})
+
+	return prepended
+}
+
+func (f *feedStore) Remove(userId int, postId int) {
+	if lists, ok := f.cache.(cache.ListUpdater); ok {
+		lists.RemoveItem(userId, func(v interface{}) bool {
+			cached, _ := v.(Refs)
+			return len(cached) == 1 && cached[0].ID == postId
+		})
+		return
+	}
+
+	f.cache.Update(userId, func(v interface{}, ok bool) (interface{}, bool) {
+		refs, ok := v.(Refs)
+		if !ok {
+			return nil, false
+		}
+
+		return refs.Remove(postId)
+	})
+}
+
+func (f *feedStore) Invalidate(userId int) {
+	f.cache.Delete(userId)
+}
+
+type postStore struct {
+	cache cache.Cache
+}
+
+// NewPostStore keeps the posts in the cache as Post values.
+func NewPostStore(c cache.Cache) PostStore {
+	return &postStore{cache: c}
+}
+
+func (p *postStore) Get(ids []int) map[int]Post {
+	posts := make(map[int]Post, len(ids))
+	for _, id := range ids {
+		v, ok := p.cache.Read(id)
+		if !ok {
+			continue
+		}
+
+		if post, ok := v.(Post); ok {
+			posts[id] = post
+		}
+	}
+
+	return posts
+}
+
+// Put caches the posts without the counters, they are read on every page.
+func (p *postStore) Put(posts ...Post) {
+	for _, post := range posts {
+		p.cache.Write(post.ID, withoutCounters(post))
+	}
+}
+
+func (p *postStore) Fill(posts ...Post) {
+	for _, post := range posts {
+		post := withoutCounters(post)
+
+		p.cache.Update(post.ID, func(v interface{}, ok bool) (interface{}, bool) {
+			if _, ok := v.(Post); ok {
+				return nil, false
+			}
+
+			return post, true
+		})
+	}
+}
+
+func withoutCounters(post Post) Post {
+	post.CommentsCount = 0
+	post.Reactions = nil
+	post.ViewerReaction = ""
+
+	return post
+}
+
+func (p *postStore) Delete(id int) {
+	p.cache.Delete(id)
+}
@@ -0,0 +1,203 @@
+package post
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/niklod/highload-social-network/config"
+	"github.com/niklod/highload-social-network/internal/cache"
+	"github.com/niklod/highload-social-network/internal/cache/resp"
+)
+
+func refIds(refs Refs) []int {
+	res := make([]int, len(refs))
+	for i, r := range refs {
+		res[i] = r.ID
+	}
+
+	return res
+}
+
+func TestRefs_InsertRemove(t *testing.T) {
+	now := time.Now()
+	refs := Refs{
+		{ID: 5, CreatedAt: now},
+		{ID: 3, CreatedAt: now.Add(-time.Second)},
+	}
+
+	inserted := refs.Insert(Ref{ID: 4, CreatedAt: now.Add(-time.Second)})
+	assert.Equal(t, []int{5, 4, 3}, refIds(inserted))
+	assert.Equal(t, []int{5, 3}, refIds(refs))
+
+	// The redelivered post isn't doubled
+	assert.Equal(t, []int{5, 4, 3}, refIds(inserted.Insert(Ref{ID: 4, CreatedAt: now.Add(-time.Second)})))
+
+	removed, ok := inserted.Remove(5)
+	assert.True(t, ok)
+	assert.Equal(t, []int{4, 3}, refIds(removed))
+	assert.Equal(t, []int{5, 4, 3}, refIds(inserted))
+
+	_, ok = inserted.Remove(1)
+	assert.False(t, ok)
+}
+
+func TestRefs_Insert_CapsAtFeedLength(t *testing.T) {
+	now := time.Now()
+	refs := make(Refs, FeedLength)
+	for i := range refs {
+		refs[i] = Ref{ID: FeedLength - i, CreatedAt: now.Add(-time.Duration(i) * time.Second)}
+	}
+
+	refs = refs.Insert(Ref{ID: FeedLength + 1, CreatedAt: now.Add(time.Second)})
+
+	assert.Equal(t, FeedLength, len(refs))
+	assert.Equal(t, FeedLength+1, refs[0].ID)
+	assert.Equal(t, 2, refs[FeedLength-1].ID)
+}
+
+func TestFeedStore_Page(t *testing.T) {
+	feeds := NewFeedStore(cache.NewFeedCache())
+	now := time.Now()
+
+	_, ok := feeds.Page(1, nil, 10)
+	assert.False(t, ok)
+
+	// The post for the feed which isn't cached is read from DB later
+	assert.False(t, feeds.Prepend(1, Ref{ID: 1, CreatedAt: now}))
+
+	feeds.Set(1, Refs{
+		{ID: 2, CreatedAt: now},
+		{ID: 1, CreatedAt: now.Add(-time.Second)},
+	})
+	assert.True(t, feeds.Prepend(1, Ref{ID: 3, CreatedAt: now.Add(time.Second)}))
+	feeds.Remove(1, 2)
+
+	page, ok := feeds.Page(1, &Cursor{CreatedAt: now.Add(time.Second), ID: 3}, 10)
+	assert.True(t, ok)
+	assert.Equal(t, []int{1}, refIds(page.Refs))
+	assert.Nil(t, page.Until)
+
+	feeds.Invalidate(1)
+
+	_, ok = feeds.Size(1)
+	assert.False(t, ok)
+}
+
+func TestFeedStore_Set_KeepsNewerRefs(t *testing.T) {
+	feeds := NewFeedStore(cache.NewFeedCache())
+	now := time.Now()
+
+	feeds.Set(1, Refs{{ID: 1, CreatedAt: now}})
+	feeds.Prepend(1, Ref{ID: 3, CreatedAt: now.Add(2 * time.Second)})
+
+	// The feed read from DB before the post 3 is fanned out
+	feeds.Set(1, Refs{
+		{ID: 2, CreatedAt: now.Add(time.Second)},
+		{ID: 1, CreatedAt: now},
+	})
+
+	page, _ := feeds.Page(1, nil, 10)
+	assert.Equal(t, []int{3, 2, 1}, refIds(page.Refs))
+}
+
+func TestFeedStore_Page_UntilOfCappedFeed(t *testing.T) {
+	feeds := NewFeedStore(cache.NewFeedCache())
+	now := time.Now()
+
+	refs := make(Refs, FeedLength)
+	for i := range refs {
+		refs[i] = Ref{ID: FeedLength - i, CreatedAt: now.Add(-time.Duration(i) * time.Second)}
+	}
+	feeds.Set(1, refs)
+
+	page, ok := feeds.Page(1, nil, 2)
+	assert.True(t, ok)
+	assert.Equal(t, []int{FeedLength, FeedLength - 1}, refIds(page.Refs))
+	assert.Equal(t, &Cursor{CreatedAt: refs[FeedLength-1].CreatedAt, ID: 1}, page.Until)
+}
+
+func TestPostStore_Put_DropsCounters(t *testing.T) {
+	posts := NewPostStore(cache.NewFeedCache())
+
+	posts.Put(Post{ID: 1, Body: "body", CommentsCount: 3, Reactions: map[string]int{ReactionLike: 1}, ViewerReaction: ReactionLike})
+
+	got := posts.Get([]int{1, 2})
+	assert.Equal(t, map[int]Post{1: {ID: 1, Body: "body"}}, got)
+
+	posts.Delete(1)
+	assert.Empty(t, posts.Get([]int{1}))
+}
+
+// The receivers fan out the posts of many authors to the same feeds at
+// once, run it with -race
+func TestFeedStore_ConcurrentFanOut(t *testing.T) {
+	backends := map[string]func(t *testing.T) FeedStore{
+		"memory": func(t *testing.T) FeedStore { return NewFeedStore(cache.NewFeedCache()) },
+		"redis": func(t *testing.T) FeedStore {
+			srv, err := resp.NewServer()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			client := resp.NewClient(&config.RedisConfig{Addr: srv.Addr(), PoolSize: 8, Timeout: time.Second})
+			t.Cleanup(func() {
+				client.Close()
+				srv.Close()
+			})
+
+			return NewFeedStore(cache.NewRedis(client, RefsCodec{}, "feed:", FeedLength, time.Minute))
+		},
+	}
+
+	const (
+		receivers = 8
+		posts     = 25
+		users     = 3
+	)
+
+	for name, newStore := range backends {
+		t.Run(name, func(t *testing.T) {
+			feeds := newStore(t)
+			now := time.Now().Truncate(time.Second)
+
+			for u := 1; u <= users; u++ {
+				feeds.Set(u, Refs{})
+			}
+
+			var wg sync.WaitGroup
+			for r := 0; r < receivers; r++ {
+				wg.Add(1)
+				go func(r int) {
+					defer wg.Done()
+
+					for i := 0; i < posts; i++ {
+						id := r*posts + i + 1
+						ref := Ref{ID: id, AuthorID: r + 100, CreatedAt: now.Add(time.Duration(id) * time.Second)}
+
+						for u := 1; u <= users; u++ {
+							assert.True(t, feeds.Prepend(u, ref))
+						}
+
+						// The feed read from DB before the post is not
+						// cached over it
+						feeds.Fill(1, Refs{})
+					}
+				}(r)
+			}
+			wg.Wait()
+
+			for u := 1; u <= users; u++ {
+				page, ok := feeds.Page(u, nil, FeedLength)
+				assert.True(t, ok)
+				assert.Equal(t, receivers*posts, len(page.Refs), "user %d", u)
+
+				for i, ref := range page.Refs {
+					assert.Equal(t, receivers*posts-i, ref.ID)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,130 @@
+// Package warmup reads the feeds of the active users into cache.
+package warmup
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/niklod/highload-social-network/config"
+)
+
+const defaultBatchSize = 100
+
+// Users lists the active users, see user.Service.ActiveUsers
+type Users interface {
+	ActiveUsers(ctx context.Context, since time.Time, afterId, limit int) ([]int, error)
+	CountActiveUsers(ctx context.Context, since time.Time) (int, error)
+}
+
+// RebuildFunc rebuilds the user's feed, like post.Service.RebuildFeed or
+// producer.FeedProducer.SendFeedRebuild.
+type RebuildFunc func(userId int) error
+
+// Progress is the state of the warm-up, Total is counted on start.
+type Progress struct {
+	Total   int
+	Done    int
+	Failed  int
+	Elapsed time.Duration
+}
+
+func (p Progress) String() string {
+	percent := 100
+	if p.Total > 0 && p.Done < p.Total {
+		percent = p.Done * 100 / p.Total
+	}
+
+	rate := 0.0
+	if p.Elapsed > 0 {
+		rate = float64(p.Done) / p.Elapsed.Seconds()
+	}
+
+	return fmt.Sprintf("%d/%d feeds (%d%%), %d failed, %.1f feeds/s", p.Done, p.Total, percent, p.Failed, rate)
+}
+
+// Warmer rebuilds the feeds of the recently seen users at cfg.Rate.
+type Warmer struct {
+	users   Users
+	rebuild RebuildFunc
+	cfg     *config.FeedWarmupConfig
+	now     func() time.Time
+	report  func(Progress)
+}
+
+func New(users Users, rebuild RebuildFunc, cfg *config.FeedWarmupConfig) *Warmer {
+	return &Warmer{
+		users:   users,
+		rebuild: rebuild,
+		cfg:     cfg,
+		now:     time.Now,
+		report: func(p Progress) {
+			log.Printf("feed warm-up: %s", p)
+		},
+	}
+}
+
+// Run rebuilds the feeds, logging the progress every cfg.ProgressInterval.
+// Failed feeds are skipped, a failed user listing stops the warm-up.
+func (w *Warmer) Run() (Progress, error) {
+	start := w.now()
+	since := start.Add(-w.cfg.ActiveWithin)
+
+	total, err := w.users.CountActiveUsers(context.Background(), since)
+	if err != nil {
+		return Progress{}, fmt.Errorf("warmup: %v", err)
+	}
+
+	batchSize := w.cfg.BatchSize
+	if batchSize <= 0 {
+		batchSize = defaultBatchSize
+	}
+
+	var limit <-chan time.Time
+	if w.cfg.Rate > 0 {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / w.cfg.Rate))
+		defer ticker.Stop()
+		limit = ticker.C
+	}
+
+	p := Progress{Total: total}
+	reported := start
+	afterId := 0
+
+	for {
+		ids, err := w.users.ActiveUsers(context.Background(), since, afterId, batchSize)
+		if err != nil {
+			p.Elapsed = w.now().Sub(start)
+			return p, fmt.Errorf("warmup: %v", err)
+		}
+
+		for _, id := range ids {
+			if limit != nil {
+				<-limit
+			}
+
+			if err := w.rebuild(id); err != nil {
+				log.Printf("feed warm-up, user %d: %v", id, err)
+				p.Failed++
+			}
+			p.Done++
+		}
+
+		if len(ids) < batchSize {
+			break
+		}
+		afterId = ids[len(ids)-1]
+
+		if now := w.now(); now.Sub(reported) >= w.cfg.ProgressInterval {
+			p.Elapsed = now.Sub(start)
+			w.report(p)
+			reported = now
+		}
+	}
+
+	p.Elapsed = w.now().Sub(start)
+	w.report(p)
+
+	return p, nil
+}
@@ -0,0 +1,93 @@
+package warmup
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/niklod/highload-social-network/config"
+)
+
+type fakeUsers struct {
+	ids     []int
+	batches [][]int
+	since   time.Time
+}
+
+func (f *fakeUsers) ActiveUsers(ctx context.Context, since time.Time, afterId, limit int) ([]int, error) {
+	f.since = since
+
+	var batch []int
+	for _, id := range f.ids {
+		if id > afterId && len(batch) < limit {
+			batch = append(batch, id)
+		}
+	}
+	f.batches = append(f.batches, batch)
+
+	return batch, nil
+}
+
+func (f *fakeUsers) CountActiveUsers(ctx context.Context, since time.Time) (int, error) {
+	return len(f.ids), nil
+}
+
+func TestWarmer_Run(t *testing.T) {
+	users := &fakeUsers{ids: []int{2, 3, 5, 8, 13}}
+
+	var rebuilt []int
+	rebuild := func(userId int) error {
+		rebuilt = append(rebuilt, userId)
+		if userId == 5 {
+			return fmt.Errorf("query timeout")
+		}
+
+		return nil
+	}
+
+	var reports []Progress
+
+	w := New(users, rebuild, &config.FeedWarmupConfig{ActiveWithin: time.Hour, BatchSize: 2})
+	now := time.Now()
+	w.now = func() time.Time { return now }
+	w.report = func(p Progress) {
+		reports = append(reports, p)
+	}
+
+	p, err := w.Run()
+
+	assert.Nil(t, err)
+	assert.Equal(t, []int{2, 3, 5, 8, 13}, rebuilt)
+	assert.Equal(t, [][]int{{2, 3}, {5, 8}, {13}}, users.batches)
+	assert.Equal(t, now.Add(-time.Hour), users.since)
+	assert.Equal(t, Progress{Total: 5, Done: 5, Failed: 1}, p)
+
+	// Zero interval reports every batch
+	assert.Equal(t, []Progress{
+		{Total: 5, Done: 2},
+		{Total: 5, Done: 4, Failed: 1},
+		{Total: 5, Done: 5, Failed: 1},
+	}, reports)
+}
+
+func TestWarmer_Run_RateLimit(t *testing.T) {
+	users := &fakeUsers{ids: []int{1, 2, 3, 4, 5}}
+
+	w := New(users, func(int) error { return nil }, &config.FeedWarmupConfig{BatchSize: 10, Rate: 100})
+
+	start := time.Now()
+	p, err := w.Run()
+
+	assert.Nil(t, err)
+	assert.Equal(t, 5, p.Done)
+	assert.True(t, time.Since(start) >= 50*time.Millisecond)
+}
+
+func TestProgress_String(t *testing.T) {
+	p := Progress{Total: 200, Done: 50, Failed: 2, Elapsed: 10 * time.Second}
+
+	assert.Equal(t, "50/200 feeds (25%), 2 failed, 5.0 feeds/s", p.String())
+}
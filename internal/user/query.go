@@ -11,6 +11,23 @@ const (
 	addFriend
 	getFriends
 	deleteFriend
+	areFriends
+	saveFriendRequest
+	getFriendRequest
+	getFriendRequestByID
+	updateFriendRequestStatus
+	incomingFriendRequests
+	outgoingFriendRequests
+	follow
+	unfollow
+	isFollowing
+	followCounts
+	getFollowers
+	getFollowing
+	getFeedSubscribers
+	getCelebrities
+	getActiveUsers
+	countActiveUsers
 )
 
 type Query struct {
@@ -56,7 +73,6 @@ func init() {
 			, u.password
 				FROM users as u
 						LEFT JOIN citys as c ON u.city_id = c.id
-				ORDER BY u.first_name
 				WHERE u.id = ?`,
 		Timeout: 10 * time.Second,
 	}
@@ -119,4 +135,139 @@ func init() {
 				WHERE f.user_id = ?`,
 		Timeout: 10 * time.Second,
 	}
+	queryMap[follow] = Query{
+		SQL:     `INSERT IGNORE INTO follows (follower_id, followee_id) VALUES (?, ?)`,
+		Timeout: 10 * time.Second,
+	}
+	queryMap[unfollow] = Query{
+		SQL:     `DELETE FROM follows WHERE follower_id = ? AND followee_id = ?`,
+		Timeout: 10 * time.Second,
+	}
+	queryMap[isFollowing] = Query{
+		SQL:     `SELECT COUNT(*) FROM follows WHERE follower_id = ? AND followee_id = ?`,
+		Timeout: 10 * time.Second,
+	}
+	queryMap[followCounts] = Query{
+		SQL: `SELECT (SELECT COUNT(*) FROM follows WHERE followee_id = ?)
+					, (SELECT COUNT(*) FROM follows WHERE follower_id = ?)`,
+		Timeout: 10 * time.Second,
+	}
+	queryMap[getFollowers] = Query{
+		SQL: userListSelect + `
+				WHERE u.id IN (SELECT follower_id FROM follows WHERE followee_id = ?)`,
+		Timeout: 10 * time.Second,
+	}
+	queryMap[getFollowing] = Query{
+		SQL: userListSelect + `
+				WHERE u.id IN (SELECT followee_id FROM follows WHERE follower_id = ?)`,
+		Timeout: 10 * time.Second,
+	}
+	// The same union as post's GetUserFeedById
+	queryMap[getFeedSubscribers] = Query{
+		SQL: userListSelect + `
+				WHERE u.id IN (
+					SELECT friend_id FROM friends WHERE user_id = ?
+					UNION
+					SELECT follower_id FROM follows WHERE followee_id = ?
+				)`,
+		Timeout: 10 * time.Second,
+	}
+	// A friend who follows the user as well is counted once
+	queryMap[getCelebrities] = Query{
+		SQL: `SELECT s.user_id
+				FROM (
+					SELECT followee_id AS user_id
+					FROM follows
+					UNION ALL
+					SELECT f.user_id
+					FROM friends f
+						LEFT JOIN follows fl ON fl.followee_id = f.user_id AND fl.follower_id = f.friend_id
+					WHERE fl.follower_id IS NULL
+				) s
+				GROUP BY s.user_id
+				HAVING COUNT(*) >= ?`,
+		Timeout: 60 * time.Second,
+	}
+	// Users who have used a session lately, by id for the batches
+	queryMap[getActiveUsers] = Query{
+		SQL: `SELECT DISTINCT user_id
+				FROM sessions
+				WHERE last_seen_at >= ? AND user_id > ?
+				ORDER BY user_id
+				LIMIT ?`,
+		Timeout: 30 * time.Second,
+	}
+	queryMap[countActiveUsers] = Query{
+		SQL:     `SELECT COUNT(DISTINCT user_id) FROM sessions WHERE last_seen_at >= ?`,
+		Timeout: 30 * time.Second,
+	}
+	queryMap[areFriends] = Query{
+		SQL:     `SELECT COUNT(*) FROM friends WHERE user_id = ? AND friend_id = ?`,
+		Timeout: 10 * time.Second,
+	}
+	queryMap[saveFriendRequest] = Query{
+		SQL: `INSERT INTO friend_requests (from_user_id, to_user_id, status)
+				VALUES (?, ?, 'sent')
+				ON DUPLICATE KEY UPDATE status = 'sent', created_at = CURRENT_TIMESTAMP, id = LAST_INSERT_ID(id);`,
+		Timeout: 10 * time.Second,
+	}
+	queryMap[getFriendRequest] = Query{
+		SQL: friendRequestSelect + `
+				WHERE r.from_user_id = ?
+				AND r.to_user_id = ?`,
+		Timeout: 10 * time.Second,
+	}
+	queryMap[getFriendRequestByID] = Query{
+		SQL: friendRequestSelect + `
+				WHERE r.id = ?`,
+		Timeout: 10 * time.Second,
+	}
+	queryMap[updateFriendRequestStatus] = Query{
+		SQL:     `UPDATE friend_requests SET status = ? WHERE id = ? AND status = ?`,
+		Timeout: 10 * time.Second,
+	}
+	queryMap[incomingFriendRequests] = Query{
+		SQL: friendRequestSelect + `
+				WHERE r.to_user_id = ?
+				AND r.status = 'sent'
+				ORDER BY r.created_at DESC
+				LIMIT ?`,
+		Timeout: 10 * time.Second,
+	}
+	queryMap[outgoingFriendRequests] = Query{
+		SQL: friendRequestSelect + `
+				WHERE r.from_user_id = ?
+				AND r.status = 'sent'
+				ORDER BY r.created_at DESC
+				LIMIT ?`,
+		Timeout: 10 * time.Second,
+	}
 }
+
+const friendRequestSelect = `SELECT r.id
+					, r.status
+					, r.created_at
+					, r.updated_at
+					, f.id
+					, f.login
+					, f.first_name
+					, f.last_name
+					, t.id
+					, t.login
+					, t.first_name
+					, t.last_name
+				FROM friend_requests r
+					JOIN users f ON f.id = r.from_user_id
+					JOIN users t ON t.id = r.to_user_id`
+
+// userListSelect selects the columns scanned by scanUsers
+const userListSelect = `SELECT u.id
+					, u.first_name
+					, u.last_name
+					, u.age
+					, u.sex
+					, u.login
+					, u.city_id
+					, c.city_name
+				FROM users u
+					LEFT JOIN citys c on u.city_id = c.id`
@@ -1,45 +1,86 @@
 package user
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"time"
 
+	"github.com/niklod/highload-social-network/internal/notify"
 	"github.com/niklod/highload-social-network/internal/user/city"
 	"github.com/niklod/highload-social-network/internal/user/interest"
+	"github.com/niklod/highload-social-network/internal/user/post"
 	"golang.org/x/crypto/bcrypt"
 )
 
+const friendRequestsLimit = 100
+
+// Events pushed to the users over WebSocket. Declines aren't pushed.
+const (
+	EventFriendRequest          = "friend.request"
+	EventFriendRequestAccepted  = "friend.request.accepted"
+	EventFriendRequestCancelled = "friend.request.cancelled"
+)
+
 var (
 	ErrUserAlreadyExist = fmt.Errorf("user already exist")
+
+	ErrFriendRequestToSelf   = fmt.Errorf("can't send a friend request to yourself")
+	ErrAlreadyFriends        = fmt.Errorf("users are friends already")
+	ErrFriendRequestExists   = fmt.Errorf("friend request is sent already")
+	ErrFriendRequestNotFound = fmt.Errorf("friend request not found")
+	ErrFriendRequestClosed   = fmt.Errorf("friend request isn't pending anymore")
+
+	ErrFollowSelf = fmt.Errorf("can't follow yourself")
 )
 
 type repository interface {
-	Create(user *User) (*User, error)
-	List() ([]User, error)
-	GetByID(id int) (*User, error)
-	GetByLogin(login string) (*User, error)
-	GetByFirstAndLastName(firstname, lastname string) ([]User, error)
-	AddFriend(userId int, friendId int) error
-	DeleteFriend(userId int, friendId int) error
-	Friends(userId int) ([]User, error)
+	Create(ctx context.Context, user *User) (*User, error)
+	List(ctx context.Context) ([]User, error)
+	GetByID(ctx context.Context, id int) (*User, error)
+	GetByLogin(ctx context.Context, login string) (*User, error)
+	GetByFirstAndLastName(ctx context.Context, firstname, lastname string) ([]User, error)
+	DeleteFriend(ctx context.Context, userId int, friendId int) error
+	Friends(ctx context.Context, userId int) ([]User, error)
+	AreFriends(ctx context.Context, userId, friendId int) (bool, error)
+	SaveFriendRequest(ctx context.Context, r *FriendRequest) error
+	FriendRequest(ctx context.Context, fromId, toId int) (*FriendRequest, error)
+	FriendRequestByID(ctx context.Context, id int) (*FriendRequest, error)
+	AcceptFriendRequest(ctx context.Context, r *FriendRequest) error
+	CloseFriendRequest(ctx context.Context, r *FriendRequest, status FriendRequestStatus) error
+	FriendRequests(ctx context.Context, userId int, incoming bool, limit int) ([]FriendRequest, error)
+	Follow(ctx context.Context, followerId, followeeId int) error
+	Unfollow(ctx context.Context, followerId, followeeId int) error
+	IsFollowing(ctx context.Context, followerId, followeeId int) (bool, error)
+	FollowCounts(ctx context.Context, userId int) (int, int, error)
+	Followers(ctx context.Context, userId int) ([]User, error)
+	Following(ctx context.Context, userId int) ([]User, error)
+	FeedSubscribers(ctx context.Context, userId int) ([]User, error)
+	Celebrities(ctx context.Context, minSubscribers int) ([]int, error)
+	ActiveUsers(ctx context.Context, since time.Time, afterId, limit int) ([]int, error)
+	CountActiveUsers(ctx context.Context, since time.Time) (int, error)
 }
 
 type Service struct {
 	userRepo        repository
 	cityService     *city.Service
 	interestService *interest.Service
+	notifier        notify.Notifier
+	feeds           post.FeedStore
 }
 
-func NewService(repo repository, citySvc *city.Service, interestSvc *interest.Service) *Service {
+func NewService(repo repository, citySvc *city.Service, interestSvc *interest.Service, notifier notify.Notifier, feeds post.FeedStore) *Service {
 	return &Service{
 		userRepo:        repo,
 		cityService:     citySvc,
 		interestService: interestSvc,
+		notifier:        notifier,
+		feeds:           feeds,
 	}
 }
 
-func (s *Service) Create(user *User) (*User, error) {
-	ok, err := s.CheckUserExist(user.Login)
+func (s *Service) Create(ctx context.Context, user *User) (*User, error) {
+	ok, err := s.CheckUserExist(ctx, user.Login)
 	if err != nil {
 		return nil, err
 	}
@@ -63,7 +104,7 @@ func (s *Service) Create(user *User) (*User, error) {
 
 	user.Password = hash
 
-	updatedUser, err := s.userRepo.Create(user)
+	updatedUser, err := s.userRepo.Create(ctx, user)
 	if err != nil {
 		return nil, err
 	}
@@ -87,12 +128,12 @@ func (s *Service) Create(user *User) (*User, error) {
 	return updatedUser, nil
 }
 
-func (s *Service) Users() ([]User, error) {
-	return s.userRepo.List()
+func (s *Service) Users(ctx context.Context) ([]User, error) {
+	return s.userRepo.List(ctx)
 }
 
-func (s *Service) UsersByFirstAndLastName(firstname, lastname string) ([]User, error) {
-	return s.userRepo.GetByFirstAndLastName(firstname, lastname)
+func (s *Service) UsersByFirstAndLastName(ctx context.Context, firstname, lastname string) ([]User, error) {
+	return s.userRepo.GetByFirstAndLastName(ctx, firstname, lastname)
 }
 
 func (s *Service) CreatePassword(pass string) (string, error) {
@@ -109,8 +150,8 @@ func (s *Service) CheckPasswordsEquality(pass, hash string) bool {
 	return err == nil
 }
 
-func (s *Service) CheckUserExist(userLogin string) (bool, error) {
-	user, err := s.userRepo.GetByLogin(userLogin)
+func (s *Service) CheckUserExist(ctx context.Context, userLogin string) (bool, error) {
+	user, err := s.userRepo.GetByLogin(ctx, userLogin)
 	if err != nil {
 		return false, err
 	}
@@ -122,20 +163,260 @@ func (s *Service) CheckUserExist(userLogin string) (bool, error) {
 	return false, nil
 }
 
-func (s *Service) GetUserByLogin(userLogin string) (*User, error) {
-	return s.userRepo.GetByLogin(userLogin)
+func (s *Service) GetUserByID(ctx context.Context, id int) (*User, error) {
+	return s.userRepo.GetByID(ctx, id)
+}
+
+func (s *Service) GetUserByLogin(ctx context.Context, userLogin string) (*User, error) {
+	return s.userRepo.GetByLogin(ctx, userLogin)
+}
+
+// DeleteFriend ends the friendship and drops both users' cached feeds.
+func (s *Service) DeleteFriend(ctx context.Context, userId, friendId int) error {
+	if err := s.userRepo.DeleteFriend(ctx, userId, friendId); err != nil {
+		return err
+	}
+
+	s.feeds.Invalidate(userId)
+	s.feeds.Invalidate(friendId)
+
+	return nil
+}
+
+func (s *Service) Friends(ctx context.Context, userId int) ([]User, error) {
+	return s.userRepo.Friends(ctx, userId)
+}
+
+// Follow subscribes the follower to the followee's posts and drops the
+// follower's cached feed. Following a friend keeps the posts after
+// unfriending.
+func (s *Service) Follow(ctx context.Context, followerId, followeeId int) error {
+	if followerId == followeeId {
+		return ErrFollowSelf
+	}
+
+	if err := s.userRepo.Follow(ctx, followerId, followeeId); err != nil {
+		return err
+	}
+
+	s.feeds.Invalidate(followerId)
+
+	return nil
+}
+
+func (s *Service) Unfollow(ctx context.Context, followerId, followeeId int) error {
+	if err := s.userRepo.Unfollow(ctx, followerId, followeeId); err != nil {
+		return err
+	}
+
+	s.feeds.Invalidate(followerId)
+
+	return nil
+}
+
+func (s *Service) IsFollowing(ctx context.Context, followerId, followeeId int) (bool, error) {
+	return s.userRepo.IsFollowing(ctx, followerId, followeeId)
+}
+
+// FollowCounts returns the number of the user's followers and followings.
+func (s *Service) FollowCounts(ctx context.Context, userId int) (followers, following int, err error) {
+	return s.userRepo.FollowCounts(ctx, userId)
+}
+
+func (s *Service) Followers(ctx context.Context, userId int) ([]User, error) {
+	return s.userRepo.Followers(ctx, userId)
+}
+
+func (s *Service) Following(ctx context.Context, userId int) ([]User, error) {
+	return s.userRepo.Following(ctx, userId)
+}
+
+// FeedSubscribers returns the users who get the user's posts in their
+// feeds, the friends and the followers.
+func (s *Service) FeedSubscribers(ctx context.Context, userId int) ([]User, error) {
+	return s.userRepo.FeedSubscribers(ctx, userId)
+}
+
+// ActiveUsers returns up to limit ids of the users seen since the time,
+// greater than afterId, for the batches of the feed warm-up.
+func (s *Service) ActiveUsers(ctx context.Context, since time.Time, afterId, limit int) ([]int, error) {
+	return s.userRepo.ActiveUsers(ctx, since, afterId, limit)
+}
+
+func (s *Service) CountActiveUsers(ctx context.Context, since time.Time) (int, error) {
+	return s.userRepo.CountActiveUsers(ctx, since)
+}
+
+// SendFriendRequest asks the other user to become friends. If the other
+// user has already asked, their request is accepted instead.
+func (s *Service) SendFriendRequest(ctx context.Context, from, to *User) (*FriendRequest, error) {
+	if from.ID == to.ID {
+		return nil, ErrFriendRequestToSelf
+	}
+
+	friends, err := s.userRepo.AreFriends(ctx, from.ID, to.ID)
+	if err != nil {
+		return nil, fmt.Errorf("user.Service: %v", err)
+	}
+	if friends {
+		return nil, ErrAlreadyFriends
+	}
+
+	reverse, err := s.userRepo.FriendRequest(ctx, to.ID, from.ID)
+	if err != nil {
+		return nil, fmt.Errorf("user.Service: %v", err)
+	}
+	if reverse != nil && reverse.Pending() {
+		return s.AcceptFriendRequest(ctx, from.ID, reverse.ID)
+	}
+
+	r, err := s.userRepo.FriendRequest(ctx, from.ID, to.ID)
+	if err != nil {
+		return nil, fmt.Errorf("user.Service: %v", err)
+	}
+	if r != nil && !r.Status.CanMove(FriendRequestSent) {
+		return nil, ErrFriendRequestExists
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	r = &FriendRequest{From: brief(from), To: brief(to), CreatedAt: now, UpdatedAt: now}
+
+	if err := s.userRepo.SaveFriendRequest(ctx, r); err != nil {
+		return nil, fmt.Errorf("user.Service: %v", err)
+	}
+
+	s.notifier.Notify(to.Login, EventFriendRequest, NewFriendRequestResponse(r))
+
+	return r, nil
+}
+
+// AcceptFriendRequest lets the recipient accept the request. Both users'
+// cached feeds are dropped.
+func (s *Service) AcceptFriendRequest(ctx context.Context, userId, requestId int) (*FriendRequest, error) {
+	r, err := s.friendRequest(ctx, requestId, FriendRequestAccepted, func(r *FriendRequest) bool {
+		return r.To.ID == userId
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.userRepo.AcceptFriendRequest(ctx, r); err != nil {
+		if err == ErrFriendRequestClosed {
+			return nil, err
+		}
+		return nil, fmt.Errorf("user.Service: %v", err)
+	}
+
+	r.Status = FriendRequestAccepted
+	s.feeds.Invalidate(r.From.ID)
+	s.feeds.Invalidate(r.To.ID)
+	s.notifier.Notify(r.From.Login, EventFriendRequestAccepted, NewFriendRequestResponse(r))
+
+	return r, nil
+}
+
+// DeclineFriendRequest declines the request sent to the user.
+func (s *Service) DeclineFriendRequest(ctx context.Context, userId, requestId int) (*FriendRequest, error) {
+	return s.closeFriendRequest(ctx, requestId, FriendRequestDeclined, func(r *FriendRequest) bool {
+		return r.To.ID == userId
+	})
 }
 
-func (s *Service) AddFriend(userId, friendId int) error {
-	return s.userRepo.AddFriend(userId, friendId)
+// CancelFriendRequest cancels the request sent by the user.
+func (s *Service) CancelFriendRequest(ctx context.Context, userId, requestId int) (*FriendRequest, error) {
+	r, err := s.closeFriendRequest(ctx, requestId, FriendRequestCancelled, func(r *FriendRequest) bool {
+		return r.From.ID == userId
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.notifier.Notify(r.To.Login, EventFriendRequestCancelled, NewFriendRequestResponse(r))
+
+	return r, nil
 }
 
-func (s *Service) DeleteFriend(userId, friendId int) error {
-	return s.userRepo.DeleteFriend(userId, friendId)
+// PendingFriendRequest returns the pending request between the users in
+// either direction, or nil if there is none.
+func (s *Service) PendingFriendRequest(ctx context.Context, userId, otherId int) (*FriendRequest, error) {
+	for _, pair := range [][2]int{{userId, otherId}, {otherId, userId}} {
+		r, err := s.userRepo.FriendRequest(ctx, pair[0], pair[1])
+		if err != nil {
+			return nil, fmt.Errorf("user.Service: %v", err)
+		}
+		if r != nil && r.Pending() {
+			return r, nil
+		}
+	}
+
+	return nil, nil
 }
 
-func (s *Service) Friends(userId int) ([]User, error) {
-	return s.userRepo.Friends(userId)
+// IncomingFriendRequests returns the pending requests sent to the user,
+// newest first.
+func (s *Service) IncomingFriendRequests(ctx context.Context, userId int) ([]FriendRequest, error) {
+	requests, err := s.userRepo.FriendRequests(ctx, userId, true, friendRequestsLimit)
+	if err != nil {
+		return nil, fmt.Errorf("user.Service: %v", err)
+	}
+
+	return requests, nil
+}
+
+// OutgoingFriendRequests returns the pending requests sent by the user,
+// newest first.
+func (s *Service) OutgoingFriendRequests(ctx context.Context, userId int) ([]FriendRequest, error) {
+	requests, err := s.userRepo.FriendRequests(ctx, userId, false, friendRequestsLimit)
+	if err != nil {
+		return nil, fmt.Errorf("user.Service: %v", err)
+	}
+
+	return requests, nil
+}
+
+func (s *Service) closeFriendRequest(ctx context.Context, requestId int, status FriendRequestStatus, allowed func(r *FriendRequest) bool) (*FriendRequest, error) {
+	r, err := s.friendRequest(ctx, requestId, status, allowed)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.userRepo.CloseFriendRequest(ctx, r, status); err != nil {
+		if err == ErrFriendRequestClosed {
+			return nil, err
+		}
+		return nil, fmt.Errorf("user.Service: %v", err)
+	}
+
+	r.Status = status
+
+	return r, nil
+}
+
+// friendRequest loads the request the user may move to the status, the
+// requests of the others look missing.
+func (s *Service) friendRequest(ctx context.Context, requestId int, status FriendRequestStatus, allowed func(r *FriendRequest) bool) (*FriendRequest, error) {
+	r, err := s.userRepo.FriendRequestByID(ctx, requestId)
+	if err != nil {
+		return nil, fmt.Errorf("user.Service: %v", err)
+	}
+	if r == nil || !allowed(r) {
+		return nil, ErrFriendRequestNotFound
+	}
+	if !r.Status.CanMove(status) {
+		return nil, ErrFriendRequestClosed
+	}
+
+	return r, nil
+}
+
+// brief keeps the public part of the user for friend requests
+func brief(u *User) User {
+	return User{
+		ID:        u.ID,
+		Login:     u.Login,
+		FirstName: u.FirstName,
+		Lastname:  u.Lastname,
+	}
 }
 
 func (s *Service) IsUsersAreFriends(user, userToCheck *User) bool {
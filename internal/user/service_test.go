@@ -1,12 +1,16 @@
 package user
 
 import (
+	"context"
 	"errors"
 	"testing"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/niklod/highload-social-network/internal/cache"
+	"github.com/niklod/highload-social-network/internal/cluster"
 	"github.com/niklod/highload-social-network/internal/user/city"
 	"github.com/niklod/highload-social-network/internal/user/interest"
+	"github.com/niklod/highload-social-network/internal/user/post"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -28,14 +32,14 @@ func TestService_Create(t *testing.T) {
 		Password: "TestPassword",
 	}
 
-	repo := NewRepository(db)
+	repo := NewRepository(cluster.Single(db))
 
 	cityRepo := city.NewRepository(cityDb)
 	interestRepo := interest.NewRepository(db)
 
 	citySvc := city.NewService(cityRepo)
 	interestSvc := interest.NewService(interestRepo)
-	userSvc := NewService(repo, citySvc, interestSvc)
+	userSvc := NewService(repo, citySvc, interestSvc, nil, nil)
 
 	rows := sqlmock.NewRows([]string{"id", "first_name", "last_name", "age", "sex", "login", "city_id", "city_name", "password"})
 
@@ -43,7 +47,7 @@ func TestService_Create(t *testing.T) {
 	mock.ExpectExec("INSERT INTO users").WillReturnResult(sqlmock.NewResult(int64(testUser.ID), 1))
 	cityMock.ExpectExec("INSERT INTO citys").WithArgs(testUser.City.Name).WillReturnResult(sqlmock.NewResult(1, 1))
 
-	u, err := userSvc.Create(testUser)
+	u, err := userSvc.Create(context.Background(), testUser)
 
 	assert.Nil(t, err)
 	assert.Equal(t, u, testUser)
@@ -69,12 +73,12 @@ func TestService_Create_UserAlreadyExist(t *testing.T) {
 		Password: "TestPassword",
 	}
 
-	repo := NewRepository(db)
+	repo := NewRepository(cluster.Single(db))
 	cityRepo := city.NewRepository(db)
 	interestRepo := interest.NewRepository(db)
 	citySvc := city.NewService(cityRepo)
 	interestSvc := interest.NewService(interestRepo)
-	userSvc := NewService(repo, citySvc, interestSvc)
+	userSvc := NewService(repo, citySvc, interestSvc, nil, nil)
 	expectedErrorString := "user already exist"
 
 	rows := sqlmock.NewRows([]string{"id", "first_name", "last_name", "age", "sex", "login", "city_id", "city_name", "password"})
@@ -82,10 +86,276 @@ func TestService_Create_UserAlreadyExist(t *testing.T) {
 
 	mock.ExpectQuery("SELECT u.id").WithArgs(testUser.Login).WillReturnRows(rows)
 
-	_, err = userSvc.Create(testUser)
+	_, err = userSvc.Create(context.Background(), testUser)
 
 	assert.NotNil(t, err)
 	if !errors.Is(err, ErrUserAlreadyExist) {
 		t.Errorf("got %v; should contain %v", err.Error(), expectedErrorString)
 	}
 }
+
+type fakeNotifier struct {
+	events []string
+	logins []string
+}
+
+func (n *fakeNotifier) Notify(login string, event string, data interface{}) bool {
+	n.events = append(n.events, event)
+	n.logins = append(n.logins, login)
+	return true
+}
+
+// fakeFriendRequests keeps the friend requests in memory, the other methods
+// of the repository aren't used by the tests
+type fakeFriendRequests struct {
+	repository
+	requests []*FriendRequest
+	friends  map[[2]int]bool
+	follows  map[[2]int]bool
+}
+
+func (f *fakeFriendRequests) DeleteFriend(ctx context.Context, userId, friendId int) error {
+	delete(f.friends, [2]int{userId, friendId})
+	delete(f.friends, [2]int{friendId, userId})
+	return nil
+}
+
+func (f *fakeFriendRequests) Follow(ctx context.Context, followerId, followeeId int) error {
+	f.follows[[2]int{followerId, followeeId}] = true
+	return nil
+}
+
+func (f *fakeFriendRequests) Unfollow(ctx context.Context, followerId, followeeId int) error {
+	delete(f.follows, [2]int{followerId, followeeId})
+	return nil
+}
+
+func (f *fakeFriendRequests) AreFriends(ctx context.Context, userId, friendId int) (bool, error) {
+	return f.friends[[2]int{userId, friendId}], nil
+}
+
+func (f *fakeFriendRequests) SaveFriendRequest(ctx context.Context, r *FriendRequest) error {
+	r.Status = FriendRequestSent
+	for _, s := range f.requests {
+		if s.From.ID == r.From.ID && s.To.ID == r.To.ID {
+			r.ID = s.ID
+			*s = *r
+			return nil
+		}
+	}
+
+	r.ID = len(f.requests) + 1
+	saved := *r
+	f.requests = append(f.requests, &saved)
+
+	return nil
+}
+
+func (f *fakeFriendRequests) FriendRequest(ctx context.Context, fromId, toId int) (*FriendRequest, error) {
+	for _, r := range f.requests {
+		if r.From.ID == fromId && r.To.ID == toId {
+			c := *r
+			return &c, nil
+		}
+	}
+
+	return nil, nil
+}
+
+func (f *fakeFriendRequests) FriendRequestByID(ctx context.Context, id int) (*FriendRequest, error) {
+	if id <= 0 || id > len(f.requests) {
+		return nil, nil
+	}
+
+	c := *f.requests[id-1]
+	return &c, nil
+}
+
+func (f *fakeFriendRequests) AcceptFriendRequest(ctx context.Context, r *FriendRequest) error {
+	if err := f.CloseFriendRequest(context.Background(), r, FriendRequestAccepted); err != nil {
+		return err
+	}
+
+	f.friends[[2]int{r.From.ID, r.To.ID}] = true
+	f.friends[[2]int{r.To.ID, r.From.ID}] = true
+
+	return nil
+}
+
+func (f *fakeFriendRequests) CloseFriendRequest(ctx context.Context, r *FriendRequest, status FriendRequestStatus) error {
+	saved := f.requests[r.ID-1]
+	if saved.Status != FriendRequestSent {
+		return ErrFriendRequestClosed
+	}
+
+	saved.Status = status
+
+	return nil
+}
+
+var (
+	alice = &User{ID: 1, Login: "alice"}
+	bob   = &User{ID: 2, Login: "bob"}
+)
+
+func TestService_SendFriendRequest(t *testing.T) {
+	repo := &fakeFriendRequests{friends: make(map[[2]int]bool)}
+	n := &fakeNotifier{}
+	svc := NewService(repo, nil, nil, n, nil)
+
+	r, err := svc.SendFriendRequest(context.Background(), alice, bob)
+
+	assert.Nil(t, err)
+	assert.Equal(t, FriendRequestSent, r.Status)
+	assert.False(t, repo.friends[[2]int{1, 2}])
+	assert.Equal(t, []string{EventFriendRequest}, n.events)
+	assert.Equal(t, []string{"bob"}, n.logins)
+
+	_, err = svc.SendFriendRequest(context.Background(), alice, bob)
+	assert.Equal(t, ErrFriendRequestExists, err)
+
+	_, err = svc.SendFriendRequest(context.Background(), alice, alice)
+	assert.Equal(t, ErrFriendRequestToSelf, err)
+}
+
+func TestService_SendFriendRequest_AcceptsReverse(t *testing.T) {
+	repo := &fakeFriendRequests{friends: make(map[[2]int]bool)}
+	n := &fakeNotifier{}
+	svc := NewService(repo, nil, nil, n, post.NewFeedStore(cache.NewFeedCache()))
+
+	_, err := svc.SendFriendRequest(context.Background(), alice, bob)
+	assert.Nil(t, err)
+
+	r, err := svc.SendFriendRequest(context.Background(), bob, alice)
+
+	assert.Nil(t, err)
+	assert.Equal(t, FriendRequestAccepted, r.Status)
+	assert.Equal(t, 1, r.From.ID)
+	assert.True(t, repo.friends[[2]int{1, 2}])
+	assert.True(t, repo.friends[[2]int{2, 1}])
+	assert.Equal(t, []string{EventFriendRequest, EventFriendRequestAccepted}, n.events)
+
+	_, err = svc.SendFriendRequest(context.Background(), alice, bob)
+	assert.Equal(t, ErrAlreadyFriends, err)
+}
+
+func TestService_AcceptFriendRequest_OnlyRecipient(t *testing.T) {
+	svc := NewService(&fakeFriendRequests{friends: make(map[[2]int]bool)}, nil, nil, &fakeNotifier{}, post.NewFeedStore(cache.NewFeedCache()))
+
+	r, err := svc.SendFriendRequest(context.Background(), alice, bob)
+	assert.Nil(t, err)
+
+	_, err = svc.AcceptFriendRequest(context.Background(), alice.ID, r.ID)
+	assert.Equal(t, ErrFriendRequestNotFound, err)
+
+	_, err = svc.AcceptFriendRequest(context.Background(), bob.ID, r.ID+1)
+	assert.Equal(t, ErrFriendRequestNotFound, err)
+}
+
+func TestService_DeclineFriendRequest_ThenSendAgain(t *testing.T) {
+	n := &fakeNotifier{}
+	svc := NewService(&fakeFriendRequests{friends: make(map[[2]int]bool)}, nil, nil, n, nil)
+
+	r, err := svc.SendFriendRequest(context.Background(), alice, bob)
+	assert.Nil(t, err)
+
+	declined, err := svc.DeclineFriendRequest(context.Background(), bob.ID, r.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, FriendRequestDeclined, declined.Status)
+
+	// The declined request can't be accepted, only sent again
+	_, err = svc.AcceptFriendRequest(context.Background(), bob.ID, r.ID)
+	assert.Equal(t, ErrFriendRequestClosed, err)
+
+	again, err := svc.SendFriendRequest(context.Background(), alice, bob)
+	assert.Nil(t, err)
+	assert.Equal(t, r.ID, again.ID)
+	assert.Equal(t, FriendRequestSent, again.Status)
+	assert.Equal(t, []string{EventFriendRequest, EventFriendRequest}, n.events)
+}
+
+func TestService_CancelFriendRequest(t *testing.T) {
+	n := &fakeNotifier{}
+	svc := NewService(&fakeFriendRequests{friends: make(map[[2]int]bool)}, nil, nil, n, nil)
+
+	r, err := svc.SendFriendRequest(context.Background(), alice, bob)
+	assert.Nil(t, err)
+
+	_, err = svc.CancelFriendRequest(context.Background(), bob.ID, r.ID)
+	assert.Equal(t, ErrFriendRequestNotFound, err)
+
+	cancelled, err := svc.CancelFriendRequest(context.Background(), alice.ID, r.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, FriendRequestCancelled, cancelled.Status)
+	assert.Equal(t, EventFriendRequestCancelled, n.events[len(n.events)-1])
+	assert.Equal(t, "bob", n.logins[len(n.logins)-1])
+
+	pending, err := svc.PendingFriendRequest(context.Background(), alice.ID, bob.ID)
+	assert.Nil(t, err)
+	assert.Nil(t, pending)
+}
+
+func TestService_Follow_Self(t *testing.T) {
+	svc := NewService(&fakeFriendRequests{friends: make(map[[2]int]bool)}, nil, nil, &fakeNotifier{}, nil)
+
+	err := svc.Follow(context.Background(), alice.ID, alice.ID)
+
+	assert.Equal(t, ErrFollowSelf, err)
+}
+
+func TestService_Follow_InvalidatesFeed(t *testing.T) {
+	repo := &fakeFriendRequests{follows: make(map[[2]int]bool)}
+	feeds := post.NewFeedStore(cache.NewFeedCache())
+	svc := NewService(repo, nil, nil, &fakeNotifier{}, feeds)
+
+	// The follower's feed is read again with the followee's posts
+	feeds.Set(alice.ID, post.Refs{})
+	feeds.Set(bob.ID, post.Refs{})
+
+	assert.Nil(t, svc.Follow(context.Background(), alice.ID, bob.ID))
+	assert.True(t, repo.follows[[2]int{1, 2}])
+
+	_, cached := feeds.Size(alice.ID)
+	assert.False(t, cached)
+	_, cached = feeds.Size(bob.ID)
+	assert.True(t, cached)
+
+	feeds.Set(alice.ID, post.Refs{})
+
+	assert.Nil(t, svc.Unfollow(context.Background(), alice.ID, bob.ID))
+	assert.False(t, repo.follows[[2]int{1, 2}])
+
+	_, cached = feeds.Size(alice.ID)
+	assert.False(t, cached)
+}
+
+func TestService_Friendship_InvalidatesFeeds(t *testing.T) {
+	repo := &fakeFriendRequests{friends: make(map[[2]int]bool)}
+	feeds := post.NewFeedStore(cache.NewFeedCache())
+	svc := NewService(repo, nil, nil, &fakeNotifier{}, feeds)
+
+	r, err := svc.SendFriendRequest(context.Background(), alice, bob)
+	assert.Nil(t, err)
+
+	feeds.Set(alice.ID, post.Refs{})
+	feeds.Set(bob.ID, post.Refs{})
+
+	_, err = svc.AcceptFriendRequest(context.Background(), bob.ID, r.ID)
+	assert.Nil(t, err)
+
+	for _, id := range []int{alice.ID, bob.ID} {
+		_, cached := feeds.Size(id)
+		assert.False(t, cached, "user %d", id)
+	}
+
+	feeds.Set(alice.ID, post.Refs{})
+	feeds.Set(bob.ID, post.Refs{})
+
+	assert.Nil(t, svc.DeleteFriend(context.Background(), alice.ID, bob.ID))
+	assert.False(t, repo.friends[[2]int{1, 2}])
+
+	for _, id := range []int{alice.ID, bob.ID} {
+		_, cached := feeds.Size(id)
+		assert.False(t, cached, "user %d", id)
+	}
+}
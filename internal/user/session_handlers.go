@@ -0,0 +1,156 @@
+package user
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/niklod/highload-social-network/config"
+	"github.com/niklod/highload-social-network/internal/server"
+)
+
+func (u *UserHandler) HandleSessions(c *gin.Context) {
+	authUser := getUser(c)
+	if authUser == nil {
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
+
+	cookie, err := u.sessionStore.Get(c.Request, config.SessionName)
+	if err != nil {
+		log.Printf("sessions page, getting session: %v", err)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	sessions, err := u.sessionManager.List(authUser.ID)
+	if err != nil {
+		log.Printf("sessions page, listing sessions: %v", err)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	data := ViewData{
+		Messages:          cookie.Flashes(),
+		AuthenticatedUser: authUser,
+		Sessions:          sessions,
+		CurrentSessionID:  c.GetString(currentSessionKey),
+	}
+
+	if err := cookie.Save(c.Request, c.Writer); err != nil {
+		log.Printf("save session with flashes: %v", err)
+	}
+
+	c.HTML(http.StatusOK, "sessions", data)
+}
+
+func (u *UserHandler) HandleDeleteSession(c *gin.Context) {
+	authUser := getUser(c)
+	if authUser == nil {
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
+
+	if _, err := u.deleteUserSession(authUser, c.Param("id")); err != nil {
+		log.Printf("deleting session: %v", err)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	c.Redirect(http.StatusSeeOther, "/sessions")
+}
+
+func (u *UserHandler) HandleLogoutAll(c *gin.Context) {
+	authUser := getUser(c)
+	if authUser == nil {
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
+
+	if err := u.logoutEverywhere(c, authUser); err != nil {
+		log.Printf("logout everywhere: %v", err)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	c.Redirect(http.StatusSeeOther, "/login")
+}
+
+func (u *UserHandler) HandleAPISessions(c *gin.Context) {
+	authUser, ok := requireAPIUser(c)
+	if !ok {
+		return
+	}
+
+	sessions, err := u.sessionManager.List(authUser.ID)
+	if err != nil {
+		log.Printf("api sessions list: %v", err)
+		server.RespondError(c, http.StatusInternalServerError, server.ErrCodeInternal, "Внутренняя ошибка сервера")
+		return
+	}
+
+	server.RespondData(c, http.StatusOK, sessions)
+}
+
+func (u *UserHandler) HandleAPIDeleteSession(c *gin.Context) {
+	authUser, ok := requireAPIUser(c)
+	if !ok {
+		return
+	}
+
+	found, err := u.deleteUserSession(authUser, c.Param("id"))
+	if err != nil {
+		log.Printf("api delete session: %v", err)
+		server.RespondError(c, http.StatusInternalServerError, server.ErrCodeInternal, "Внутренняя ошибка сервера")
+		return
+	}
+	if !found {
+		server.RespondError(c, http.StatusNotFound, server.ErrCodeNotFound, "Сессия не найдена")
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (u *UserHandler) HandleAPILogoutAll(c *gin.Context) {
+	authUser, ok := requireAPIUser(c)
+	if !ok {
+		return
+	}
+
+	if err := u.logoutEverywhere(c, authUser); err != nil {
+		log.Printf("api logout everywhere: %v", err)
+		server.RespondError(c, http.StatusInternalServerError, server.ErrCodeInternal, "Внутренняя ошибка сервера")
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// deleteUserSession deletes the session only if it belongs to the user.
+func (u *UserHandler) deleteUserSession(user *User, id string) (bool, error) {
+	s, err := u.sessionManager.Get(id)
+	if err != nil {
+		return false, err
+	}
+	if s == nil || s.UserID != user.ID {
+		return false, nil
+	}
+
+	return true, u.sessionManager.Delete(id)
+}
+
+// logoutEverywhere ends all sessions of the user and revokes the refresh
+// tokens issued to API clients.
+func (u *UserHandler) logoutEverywhere(c *gin.Context, user *User) error {
+	if err := u.sessionManager.EndAll(user.ID); err != nil {
+		return err
+	}
+
+	if err := u.tokenManager.RevokeUser(user.ID); err != nil {
+		return err
+	}
+
+	return u.endSession(c)
+}
@@ -0,0 +1,118 @@
+package user
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/niklod/highload-social-network/internal/auth"
+	"github.com/niklod/highload-social-network/internal/server"
+)
+
+type TokenResponse struct {
+	*auth.Pair
+	User UserResponse `json:"user"`
+}
+
+func (u *UserHandler) HandleAPIToken(c *gin.Context) {
+	req := &UserLoginRequest{}
+	if err := c.ShouldBind(req); err != nil {
+		server.RespondError(c, http.StatusBadRequest, server.ErrCodeBadRequest, "Некорректное тело запроса", err.Error())
+		return
+	}
+
+	if err := req.Validate(); err != nil {
+		server.RespondError(c, http.StatusUnprocessableEntity, server.ErrCodeValidation, "Ошибка валидации", validationDetails(err)...)
+		return
+	}
+
+	user, err := u.userService.GetUserByLogin(c.Request.Context(), req.Login)
+	if err != nil {
+		log.Printf("api token, getting user: %v", err)
+		server.RespondError(c, http.StatusInternalServerError, server.ErrCodeInternal, "Внутренняя ошибка сервера")
+		return
+	}
+
+	if user == nil || !u.userService.CheckPasswordsEquality(req.Password, user.Password) {
+		server.RespondError(c, http.StatusUnauthorized, server.ErrCodeUnauthorized, "Указан неверный логин или пароль")
+		return
+	}
+
+	pair, err := u.tokenManager.Issue(user.ID, user.Login)
+	if err != nil {
+		log.Printf("api token, issuing tokens: %v", err)
+		server.RespondError(c, http.StatusInternalServerError, server.ErrCodeInternal, "Внутренняя ошибка сервера")
+		return
+	}
+
+	server.RespondData(c, http.StatusOK, TokenResponse{Pair: pair, User: NewUserResponse(user)})
+}
+
+func (u *UserHandler) HandleAPIRefreshToken(c *gin.Context) {
+	req := &TokenRefreshRequest{}
+	if err := c.ShouldBind(req); err != nil {
+		server.RespondError(c, http.StatusBadRequest, server.ErrCodeBadRequest, "Некорректное тело запроса", err.Error())
+		return
+	}
+
+	if err := req.Validate(); err != nil {
+		server.RespondError(c, http.StatusUnprocessableEntity, server.ErrCodeValidation, "Ошибка валидации", validationDetails(err)...)
+		return
+	}
+
+	pair, err := u.tokenManager.Refresh(req.RefreshToken)
+	if err != nil {
+		if auth.IsInvalidToken(err) {
+			server.RespondError(c, http.StatusUnauthorized, server.ErrCodeUnauthorized, "Недействительный токен обновления")
+			return
+		}
+
+		log.Printf("api refresh token: %v", err)
+		server.RespondError(c, http.StatusInternalServerError, server.ErrCodeInternal, "Внутренняя ошибка сервера")
+		return
+	}
+
+	server.RespondData(c, http.StatusOK, pair)
+}
+
+func (u *UserHandler) HandleAPIRevokeToken(c *gin.Context) {
+	req := &TokenRevokeRequest{}
+	if err := c.ShouldBind(req); err != nil {
+		server.RespondError(c, http.StatusBadRequest, server.ErrCodeBadRequest, "Некорректное тело запроса", err.Error())
+		return
+	}
+
+	if err := req.Validate(); err != nil {
+		server.RespondError(c, http.StatusUnprocessableEntity, server.ErrCodeValidation, "Ошибка валидации", validationDetails(err)...)
+		return
+	}
+
+	if err := u.tokenManager.Revoke(req.Token); err != nil {
+		if auth.IsInvalidToken(err) {
+			server.RespondError(c, http.StatusUnprocessableEntity, server.ErrCodeValidation, "Недействительный токен")
+			return
+		}
+
+		log.Printf("api revoke token: %v", err)
+		server.RespondError(c, http.StatusInternalServerError, server.ErrCodeInternal, "Внутренняя ошибка сервера")
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (u *UserHandler) HandleAPIRevokeAllTokens(c *gin.Context) {
+	authUser, ok := requireAPIUser(c)
+	if !ok {
+		return
+	}
+
+	if err := u.tokenManager.RevokeUser(authUser.ID); err != nil {
+		log.Printf("api revoke all tokens: %v", err)
+		server.RespondError(c, http.StatusInternalServerError, server.ErrCodeInternal, "Внутренняя ошибка сервера")
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
@@ -2,6 +2,7 @@ package websocket
 
 import (
 	"log"
+	"net/http"
 
 	"github.com/niklod/highload-social-network/internal/user"
 
@@ -23,7 +24,18 @@ func NewWebsocketHandler(pool *Pool, userService *user.Service) *WebsocketHandle
 func (w *WebsocketHandler) HandleWS(c *gin.Context) {
 	login := c.Param("login")
 
-	user, err := w.userService.GetUserByLogin(login)
+	// Cookie session or bearer/access_token query param, see user.AuthMiddleware
+	authUser := user.AuthenticatedUser(c)
+	if authUser == nil {
+		c.Status(http.StatusUnauthorized)
+		return
+	}
+	if authUser.Login != login {
+		c.Status(http.StatusForbidden)
+		return
+	}
+
+	user, err := w.userService.GetUserByLogin(c.Request.Context(), login)
 	if err != nil {
 		log.Printf("getting user for ws connection: %v\n", err)
 		return
@@ -39,12 +51,9 @@ func (w *WebsocketHandler) HandleWS(c *gin.Context) {
 		return
 	}
 
-	client := &Client{
-		User: user,
-		Conn: ws,
-		Pool: w.pool,
-	}
+	client := NewClient(user, ws, w.pool)
 
 	w.pool.Register <- client
+	go client.Write()
 	client.Read()
 }
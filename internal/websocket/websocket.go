@@ -3,6 +3,8 @@ package websocket
 import (
 	"log"
 	"net/http"
+	"sync"
+	"time"
 
 	"github.com/niklod/highload-social-network/internal/user"
 
@@ -10,6 +12,13 @@ import (
 	"github.com/gorilla/websocket"
 )
 
+// Events pushed to clients in MessageBody.Event
+const (
+	EventFeedPost        = "feed.post"
+	EventFeedPostUpdated = "feed.post.updated"
+	EventFeedPostDeleted = "feed.post.deleted"
+)
+
 var upgrader = websocket.Upgrader{
 	ReadBufferSize:  1024,
 	WriteBufferSize: 1024,
@@ -22,28 +31,83 @@ type Message struct {
 }
 
 type MessageBody struct {
-	Type int         `json:"type"`
-	Data interface{} `json:"data"`
+	Type  int         `json:"type"`
+	Event string      `json:"event,omitempty"`
+	Data  interface{} `json:"data"`
 }
 
+const (
+	// sendBuffer is the number of messages queued for a client before it's
+	// disconnected
+	sendBuffer = 64
+	// writeWait is the deadline of writing a message to the client
+	writeWait = 10 * time.Second
+)
+
 type Client struct {
 	User *user.User
 	Conn *websocket.Conn
 	Pool *Pool
+
+	// Only Write writes, gorilla connections allow one concurrent writer
+	send      chan MessageBody
+	done      chan struct{}
+	closeOnce sync.Once
 }
 
+func NewClient(u *user.User, conn *websocket.Conn, pool *Pool) *Client {
+	return &Client{
+		User: u,
+		Conn: conn,
+		Pool: pool,
+		send: make(chan MessageBody, sendBuffer),
+		done: make(chan struct{}),
+	}
+}
+
+// SendMessage queues the message without blocking and disconnects the
+// client whose queue is full.
 func (c *Client) SendMessage(msg MessageBody) {
-	err := c.Conn.WriteJSON(msg)
-	if err != nil {
-		log.Println(err)
-		return
+	select {
+	case c.send <- msg:
+	case <-c.done:
+	default:
+		log.Printf("websocket client %s is too slow, disconnecting", c.User.Login)
+		c.close()
 	}
 }
 
+// Write writes the queued messages until the client is disconnected. It
+// blocks, so run it in a goroutine.
+func (c *Client) Write() {
+	for {
+		select {
+		case msg := <-c.send:
+			c.Conn.SetWriteDeadline(time.Now().Add(writeWait))
+
+			if err := c.Conn.WriteJSON(msg); err != nil {
+				log.Println(err)
+				c.close()
+				return
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// close disconnects the client, Read then fails and unregisters it
+func (c *Client) close() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		c.Conn.Close()
+	})
+}
+
 func (c *Client) Read() {
 	defer func() {
 		c.Pool.Unregister <- c
-		c.Conn.Close()
+		c.close()
 	}()
 
 	for {
@@ -2,6 +2,7 @@ package websocket
 
 import (
 	"fmt"
+	"sync"
 )
 
 type Pool struct {
@@ -9,6 +10,8 @@ type Pool struct {
 	Unregister chan *Client
 	Clients    map[string]*Client
 	Messages   chan Message
+
+	mu sync.RWMutex
 }
 
 func NewPool() *Pool {
@@ -24,12 +27,19 @@ func (p *Pool) Start() {
 	for {
 		select {
 		case client := <-p.Register:
+			p.mu.Lock()
 			p.Clients[client.User.Login] = client
+			p.mu.Unlock()
 			fmt.Printf("Добавлен клиент %s\n", client.User.Login)
 			break
 
 		case client := <-p.Unregister:
-			delete(p.Clients, client.User.Login)
+			p.mu.Lock()
+			// The user may have reconnected already, keep the newer client
+			if p.Clients[client.User.Login] == client {
+				delete(p.Clients, client.User.Login)
+			}
+			p.mu.Unlock()
 			fmt.Printf("Удален клиент %s\n", client.User.Login)
 			break
 
@@ -38,3 +48,25 @@ func (p *Pool) Start() {
 		}
 	}
 }
+
+// Client returns the connected client of the user.
+func (p *Pool) Client(login string) (*Client, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	c, ok := p.Clients[login]
+	return c, ok
+}
+
+// Notify pushes an event to the user if they are connected and reports
+// whether the user was online.
+func (p *Pool) Notify(login string, event string, data interface{}) bool {
+	c, ok := p.Client(login)
+	if !ok {
+		return false
+	}
+
+	c.SendMessage(MessageBody{Event: event, Data: data})
+
+	return true
+}